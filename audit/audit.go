@@ -0,0 +1,355 @@
+// Package audit 以 JSON Lines 写入的审计日志，每行带有链接上一行的哈希，可以检测删改
+//
+// 每行的格式为：
+//
+//	{"seq":1,"time":"...","type":"query","event":{...},"prev_hash":"...","hash":"..."}
+//
+// hash 为该行 `,"hash":` 之前的全部字节的 SHA-256（十六进制），prev_hash 为上一行的 hash，第一行为空。
+// 代理重启或轮转后从最后一行继续，轮转后的文件按时间顺序拼接后仍是一条完整的链。
+//
+// 不带密钥的 SHA-256 链只能发现删改，有文件写权限的人可以改写后重新计算整条链。配置密钥后 hash 为
+// HMAC-SHA256，没有密钥无法重新计算；也可以把某一行的 seq 和 hash 记录到日志文件以外的地方，
+// 校验时作为锚点，锚点之前的内容无法在不被发现的情况下改写
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/if-nil/proxyx/rotate"
+)
+
+// hashField 每行末尾的哈希字段，哈希覆盖该字段之前的内容
+const hashField = `,"hash":"`
+
+// RecoveredType 打开时截掉了上次未写完的一行后写入的记录类型
+const RecoveredType = "audit_recovered"
+
+// Recovery 截掉未写完的一行的记录，作为 RecoveredType 记录的 event
+type Recovery struct {
+	File      string `json:"file"`      // 被截断的文件
+	Offset    int64  `json:"offset"`    // 截断位置（最后一个完整行的末尾）
+	Discarded int    `json:"discarded"` // 截掉的字节数
+	Partial   string `json:"partial"`   // 截掉的内容，最多 1KB
+}
+
+// Entry 审计日志的一行
+type Entry struct {
+	Seq      uint64          `json:"seq"`       // 序号，从 1 开始递增，重启后继续
+	Time     time.Time       `json:"time"`      // 写入时间
+	Type     string          `json:"type"`      // 事件类型，与事件的 type 字段相同（Redis 命令为 command）
+	Event    json.RawMessage `json:"event"`     // 事件
+	PrevHash string          `json:"prev_hash"` // 上一行的哈希
+	Hash     string          `json:"hash"`      // 本行的哈希
+}
+
+// Writer 审计日志，Write 线程安全
+type Writer struct {
+	key []byte // HMAC 密钥，为空时使用 SHA-256
+
+	mu     sync.Mutex
+	file   *rotate.Writer
+	seq    uint64
+	prev   string
+	closed bool
+}
+
+// NewWriter 打开审计日志，从已有的最后一行继续哈希链，key 不为空时 hash 为 HMAC-SHA256
+// 上次进程崩溃时最后一行可能只写入了一部分，打开时截掉这部分并写入一条 RecoveredType 记录，
+// 之后的记录接在最后一个完整行之后，整条链仍可校验
+func NewWriter(config rotate.Config, key []byte) (*Writer, error) {
+	name := lastFile(config.Path)
+	var recovery *Recovery
+	if name != "" && !strings.HasSuffix(name, ".gz") {
+		var err error
+		if recovery, err = repairTail(name); err != nil {
+			return nil, fmt.Errorf("audit: repair %s: %w", name, err)
+		}
+	}
+	last, err := lastEntry(name)
+	if err != nil {
+		return nil, fmt.Errorf("audit: read last entry: %w", err)
+	}
+	file, err := rotate.New(config, nil)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{file: file, key: key}
+	if last != nil {
+		w.seq, w.prev = last.Seq, last.Hash
+	}
+	if recovery != nil {
+		log.Printf("[Audit] Discarded %d bytes of an incomplete entry at the end of %s", recovery.Discarded, recovery.File)
+		if err := w.Write(RecoveredType, recovery); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Write 写入一个事件
+func (w *Writer) Write(eventType string, event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	line, err := json.Marshal(struct {
+		Seq      uint64          `json:"seq"`
+		Time     time.Time       `json:"time"`
+		Type     string          `json:"type"`
+		Event    json.RawMessage `json:"event"`
+		PrevHash string          `json:"prev_hash"`
+	}{w.seq + 1, time.Now(), eventType, data, w.prev})
+	if err != nil {
+		return err
+	}
+	// 去掉结尾的 }，追加哈希字段
+	line = line[:len(line)-1]
+	hash := lineHash(w.key, line)
+	line = append(line, hashField...)
+	line = append(line, hash...)
+	line = append(line, '"', '}', '\n')
+
+	if _, err := w.file.Write(line); err != nil {
+		return err
+	}
+	w.seq++
+	w.prev = hash
+	return nil
+}
+
+// Close 写入缓冲的数据并关闭文件
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	return w.file.Close()
+}
+
+// lineHash 计算一行的哈希，key 为空时为 SHA-256，否则为 HMAC-SHA256
+func lineHash(key, data []byte) string {
+	if len(key) == 0 {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// lastFile 返回链的最后一行所在的文件：当前文件，为空时为最近的轮转文件，都没有时返回空字符串
+func lastFile(path string) string {
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		return path
+	}
+	files := rotate.Archives(path)
+	if len(files) == 0 {
+		return ""
+	}
+	return files[len(files)-1]
+}
+
+// repairTail 截掉文件末尾没有换行的不完整行，没有时返回 nil
+func repairTail(name string) (*Recovery, error) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// 从末尾向前查找最后一个换行
+	size := info.Size()
+	end := size
+	buf := make([]byte, 64<<10)
+	for end > 0 {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			return nil, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+	if end == size {
+		return nil, nil
+	}
+
+	partial := make([]byte, min(size-end, 1024))
+	if _, err := f.ReadAt(partial, end); err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(end); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	return &Recovery{File: name, Offset: end, Discarded: int(size - end), Partial: string(partial)}, nil
+}
+
+// lastEntry 返回文件的最后一行，没有时返回 nil
+func lastEntry(name string) (*Entry, error) {
+	if name == "" {
+		return nil, nil
+	}
+	var last *Entry
+	err := readFile(name, func(line []byte) error {
+		var entry Entry
+		if json.Unmarshal(line, &entry) == nil && entry.Hash != "" {
+			last = &entry
+		}
+		return nil
+	})
+	return last, err
+}
+
+// readFile 逐行读取审计日志文件，.gz 文件先解压
+func readFile(name string, fn func(line []byte) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if ferr := fn(bytes.TrimRight(line, "\r\n")); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// VerifyOptions 校验选项
+type VerifyOptions struct {
+	Key     []byte            // HMAC 密钥，需要与写入时配置的相同，为空时按 SHA-256 校验
+	Anchors map[uint64]string // 在日志文件以外记录的 seq -> hash，对应的行必须存在且 hash 相同
+}
+
+// VerifyResult 校验结果
+type VerifyResult struct {
+	Entries   uint64 // 校验的行数
+	FirstSeq  uint64 // 第一行的序号
+	LastSeq   uint64 // 最后一行的序号
+	LastHash  string // 最后一行的哈希，可以记录下来作为之后校验的锚点
+	Recovered uint64 // 崩溃后截掉不完整行的次数（RecoveredType 记录数）
+}
+
+// Verify 按顺序校验审计日志文件（含轮转后的 .gz 文件）的哈希链，返回第一处不一致的位置
+// 第一个文件的第一行不要求 prev_hash 为空，只校验的文件之间以及文件内部是连续的
+func Verify(files []string, opts VerifyOptions) (*VerifyResult, error) {
+	result := &VerifyResult{}
+	anchored := 0
+	var prev string
+	for _, name := range files {
+		lineNo := 0
+		err := readFile(name, func(line []byte) error {
+			lineNo++
+			if len(line) == 0 {
+				return nil
+			}
+			if err := verifyLine(opts.Key, line, prev, result.Entries == 0); err != nil {
+				return fmt.Errorf("%s:%d: %w", name, lineNo, err)
+			}
+			var entry Entry
+			json.Unmarshal(line, &entry)
+			if result.Entries > 0 && entry.Seq != result.LastSeq+1 {
+				return fmt.Errorf("%s:%d: seq %d follows %d", name, lineNo, entry.Seq, result.LastSeq)
+			}
+			if anchor, ok := opts.Anchors[entry.Seq]; ok {
+				if anchor != entry.Hash {
+					return fmt.Errorf("%s:%d: seq %d: hash does not match anchor %s, the chain was rewritten", name, lineNo, entry.Seq, anchor)
+				}
+				anchored++
+			}
+			if entry.Type == RecoveredType {
+				result.Recovered++
+			}
+			if result.Entries == 0 {
+				result.FirstSeq = entry.Seq
+			}
+			result.Entries++
+			result.LastSeq = entry.Seq
+			result.LastHash = entry.Hash
+			prev = entry.Hash
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	if anchored < len(opts.Anchors) {
+		return result, fmt.Errorf("%d anchors not found in seq %d-%d", len(opts.Anchors)-anchored, result.FirstSeq, result.LastSeq)
+	}
+	return result, nil
+}
+
+// verifyLine 校验一行的哈希，first 为 false 时同时校验 prev_hash
+func verifyLine(key, line []byte, prev string, first bool) error {
+	i := bytes.LastIndex(line, []byte(hashField))
+	if i < 0 {
+		return fmt.Errorf("missing hash")
+	}
+	var entry Entry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return fmt.Errorf("invalid entry: %v", err)
+	}
+	if !bytes.Equal(line[i:], []byte(hashField+entry.Hash+`"}`)) {
+		return fmt.Errorf("hash is not the last field")
+	}
+	if lineHash(key, line[:i]) != entry.Hash {
+		return fmt.Errorf("seq %d: hash mismatch, entry was modified", entry.Seq)
+	}
+	if !first && entry.PrevHash != prev {
+		return fmt.Errorf("seq %d: prev_hash does not match previous entry, entries were removed or reordered", entry.Seq)
+	}
+	return nil
+}
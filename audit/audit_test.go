@@ -0,0 +1,185 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/if-nil/proxyx/rotate"
+)
+
+// writeLog 写入 n 条记录，返回每一行（不含换行）
+func writeLog(t *testing.T, path string, key []byte, n int) [][]byte {
+	t.Helper()
+	w, err := NewWriter(rotate.Config{Path: path}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := w.Write("query", map[string]interface{}{"query": "SELECT 1", "n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+}
+
+// hashOf 返回某一行的 hash 字段
+func hashOf(line []byte) string {
+	i := bytes.LastIndex(line, []byte(hashField))
+	return string(line[i+len(hashField) : len(line)-2])
+}
+
+func TestVerify(t *testing.T) {
+	key := []byte("secret")
+	tests := []struct {
+		name    string
+		key     []byte                       // 写入时的密钥
+		opts    func([][]byte) VerifyOptions // 校验选项，nil 表示不带密钥和锚点
+		tamper  func([][]byte) [][]byte      // 校验前修改日志，nil 表示不修改
+		wantErr string                       // 期望的错误，空表示校验通过
+	}{
+		{name: "intact"},
+		{
+			name: "modified entry",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[2] = bytes.Replace(lines[2], []byte("SELECT 1"), []byte("SELECT 2"), 1)
+				return lines
+			},
+			wantErr: "hash mismatch",
+		},
+		{
+			name:    "removed entry",
+			tamper:  func(lines [][]byte) [][]byte { return append(lines[:2], lines[3:]...) },
+			wantErr: "prev_hash does not match",
+		},
+		{
+			name:    "reordered entries",
+			tamper:  func(lines [][]byte) [][]byte { lines[1], lines[2] = lines[2], lines[1]; return lines },
+			wantErr: "prev_hash does not match",
+		},
+		{
+			name:   "removed head",
+			tamper: func(lines [][]byte) [][]byte { return lines[2:] },
+		},
+		{
+			name: "hash not last",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[0] = append(lines[0][:len(lines[0])-1], []byte(`,"x":1}`)...)
+				return lines
+			},
+			wantErr: "hash is not the last field",
+		},
+		{
+			name: "hmac",
+			key:  key,
+			opts: func([][]byte) VerifyOptions { return VerifyOptions{Key: key} },
+		},
+		{
+			name:    "hmac without key",
+			key:     key,
+			wantErr: "hash mismatch",
+		},
+		{
+			name:    "wrong key",
+			key:     key,
+			opts:    func([][]byte) VerifyOptions { return VerifyOptions{Key: []byte("other")} },
+			wantErr: "hash mismatch",
+		},
+		{
+			name: "anchor",
+			opts: func(lines [][]byte) VerifyOptions {
+				return VerifyOptions{Anchors: map[uint64]string{3: hashOf(lines[2])}}
+			},
+		},
+		{
+			name: "anchor mismatch",
+			opts: func(lines [][]byte) VerifyOptions {
+				return VerifyOptions{Anchors: map[uint64]string{3: hashOf(lines[1])}}
+			},
+			wantErr: "does not match anchor",
+		},
+		{
+			name: "anchor not found",
+			opts: func(lines [][]byte) VerifyOptions {
+				return VerifyOptions{Anchors: map[uint64]string{99: "00"}}
+			},
+			wantErr: "anchors not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			lines := writeLog(t, path, tt.key, 5)
+			var opts VerifyOptions
+			if tt.opts != nil {
+				opts = tt.opts(lines)
+			}
+			if tt.tamper != nil {
+				lines = tt.tamper(lines)
+				data := append(bytes.Join(lines, []byte("\n")), '\n')
+				if err := os.WriteFile(path, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := Verify([]string{path}, opts)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if result.Entries != uint64(len(lines)) || result.LastHash != hashOf(lines[len(lines)-1]) {
+					t.Errorf("result = %+v, want %d entries ending in %s", result, len(lines), hashOf(lines[len(lines)-1]))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAfterTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	lines := writeLog(t, path, nil, 3)
+
+	// 模拟进程在写最后一行时崩溃
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":4,"time":"2026-01-01T00:00:00Z","type":"qu`)
+	f.Close()
+
+	w, err := NewWriter(rotate.Config{Path: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write("query", map[string]string{"query": "SELECT 2"}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// 重新打开时原文件被轮转，轮转后的文件名（audit-<时间>.log）排在当前文件之前
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(path), "audit*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Verify(files, VerifyOptions{Anchors: map[uint64]string{3: hashOf(lines[2])}})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if result.Entries != 5 || result.LastSeq != 5 || result.Recovered != 1 {
+		t.Errorf("result = %+v, want 5 entries with 1 recovery", result)
+	}
+}
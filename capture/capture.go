@@ -0,0 +1,395 @@
+// Package capture 流量录制文件的格式，以及写入（录制插件）和读取
+//
+// 文件由 16 字节的文件头和若干条记录组成，所有整数为小端序或 varint：
+//
+//	文件头: "PXCAP" | 版本(1) | 来源(1, 'M' MySQL / 'R' Redis) | 保留(1) | 录制开始时间(int64, Unix 纳秒)
+//	记录:   长度(uvarint) | 内容
+//	内容:   类型(1) | 会话ID(uvarint) | 开始偏移(uvarint, 纳秒) | 耗时(uvarint, 纳秒)
+//	        | 名称(str) | 数据库(str) | 用户(str) | 文本(str) | 参数个数(uvarint) | 参数...
+//	        | 行数(uvarint) | 结果摘要(uint64) | 错误(str)
+//	str:    长度(uvarint) | 字节
+//	参数:   标记(1) | 值，标记 0 NULL、1 int64(varint)、2 uint64(uvarint)、3 float64(8字节)、4 bytes(str)、5 string(str)
+//
+// 轮转产生的每个文件都以相同的文件头开始，偏移都相对于录制开始时间。
+// 同一版本内只会在记录内容末尾追加字段，读取时忽略不认识的尾部字段。
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/if-nil/proxyx/rotate"
+)
+
+// Version 当前的文件格式版本
+const Version = 1
+
+// magic 文件头开始的标识
+const magic = "PXCAP"
+
+// headerSize 文件头长度
+const headerSize = 16
+
+// maxRecordSize 单条记录的最大长度，超过时认为文件已损坏
+const maxRecordSize = 256 << 20
+
+// 录制来源
+const (
+	SourceMySQL = 'M'
+	SourceRedis = 'R'
+)
+
+// 记录类型
+const (
+	KindConnect    = 1 // 客户端连接：用户，文本为客户端地址
+	KindDisconnect = 2 // 客户端断开
+	KindQuery      = 3 // MySQL 语句或协议命令：名称为事件类型（query、execute 等），文本为 SQL
+	KindCommand    = 4 // Redis 命令：名称为命令名，参数为命令参数
+)
+
+// 参数标记
+const (
+	argNull   = 0
+	argInt    = 1
+	argUint   = 2
+	argFloat  = 3
+	argBytes  = 4
+	argString = 5
+)
+
+// Record 一条录制记录
+type Record struct {
+	Kind     byte
+	Session  uint32        // 会话ID（代理分配的连接ID）
+	Time     time.Time     // 事件开始时间
+	Duration time.Duration // 执行耗时
+	Name     string        // MySQL 事件类型 / Redis 命令名
+	Database string        // MySQL 当前数据库
+	User     string        // 客户端用户名
+	Text     string        // SQL；连接记录为客户端地址
+	Args     []interface{} // 预处理语句参数 / Redis 命令参数，读取后为 nil、int64、uint64、float64、[]byte 或 string
+	Rows     uint64        // 返回或影响的行数
+	Digest   uint64        // 结果摘要，0 表示没有结果集
+	Error    string        // 错误信息
+}
+
+// Writer 录制文件写入，线程安全
+type Writer struct {
+	start time.Time
+	file  *rotate.Writer
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewWriter 创建录制文件，source 为录制来源
+func NewWriter(config rotate.Config, source byte) (*Writer, error) {
+	start := time.Now()
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[5] = Version
+	header[6] = source
+	binary.LittleEndian.PutUint64(header[8:], uint64(start.UnixNano()))
+
+	file, err := rotate.New(config, header)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{start: start, file: file}, nil
+}
+
+// Write 追加一条记录
+func (w *Writer) Write(rec *Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	body := encodeRecord(w.buf[:0], rec, w.start)
+	record := binary.AppendUvarint(make([]byte, 0, len(body)+binary.MaxVarintLen64), uint64(len(body)))
+	record = append(record, body...)
+	w.buf = body
+	_, err := w.file.Write(record)
+	return err
+}
+
+// Close 写入缓冲的数据并关闭文件
+func (w *Writer) Close() error {
+	return w.file.Close()
+}
+
+// encodeRecord 编码记录内容
+func encodeRecord(b []byte, rec *Record, start time.Time) []byte {
+	offset := rec.Time.Sub(start)
+	if offset < 0 {
+		offset = 0
+	}
+	b = append(b, rec.Kind)
+	b = binary.AppendUvarint(b, uint64(rec.Session))
+	b = binary.AppendUvarint(b, uint64(offset))
+	b = binary.AppendUvarint(b, uint64(rec.Duration))
+	b = appendString(b, rec.Name)
+	b = appendString(b, rec.Database)
+	b = appendString(b, rec.User)
+	b = appendString(b, rec.Text)
+	b = binary.AppendUvarint(b, uint64(len(rec.Args)))
+	for _, arg := range rec.Args {
+		b = appendArg(b, arg)
+	}
+	b = binary.AppendUvarint(b, rec.Rows)
+	b = binary.LittleEndian.AppendUint64(b, rec.Digest)
+	b = appendString(b, rec.Error)
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendArg 编码一个参数，其他类型按 fmt 格式化为字符串
+func appendArg(b []byte, arg interface{}) []byte {
+	switch v := arg.(type) {
+	case nil:
+		return append(b, argNull)
+	case int:
+		return binary.AppendVarint(append(b, argInt), int64(v))
+	case int8:
+		return binary.AppendVarint(append(b, argInt), int64(v))
+	case int16:
+		return binary.AppendVarint(append(b, argInt), int64(v))
+	case int32:
+		return binary.AppendVarint(append(b, argInt), int64(v))
+	case int64:
+		return binary.AppendVarint(append(b, argInt), v)
+	case uint:
+		return binary.AppendUvarint(append(b, argUint), uint64(v))
+	case uint8:
+		return binary.AppendUvarint(append(b, argUint), uint64(v))
+	case uint16:
+		return binary.AppendUvarint(append(b, argUint), uint64(v))
+	case uint32:
+		return binary.AppendUvarint(append(b, argUint), uint64(v))
+	case uint64:
+		return binary.AppendUvarint(append(b, argUint), v)
+	case float32:
+		return binary.LittleEndian.AppendUint64(append(b, argFloat), math.Float64bits(float64(v)))
+	case float64:
+		return binary.LittleEndian.AppendUint64(append(b, argFloat), math.Float64bits(v))
+	case []byte:
+		b = append(b, argBytes)
+		b = binary.AppendUvarint(b, uint64(len(v)))
+		return append(b, v...)
+	case string:
+		return appendString(append(b, argString), v)
+	}
+	return appendString(append(b, argString), fmt.Sprint(arg))
+}
+
+// ErrTruncated 文件末尾的记录不完整（录制进程异常退出或文件仍在写入）
+var ErrTruncated = errors.New("capture: truncated record at end of file")
+
+// Reader 录制文件读取
+type Reader struct {
+	Version byte
+	Source  byte      // SourceMySQL / SourceRedis
+	Start   time.Time // 录制开始时间
+
+	r *bufio.Reader
+}
+
+// NewReader 读取文件头，gzip 压缩的文件自动解压
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	if head, err := br.Peek(2); err == nil && head[0] == 0x1f && head[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReaderSize(zr, 64<<10)
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("capture: reading header: %w", err)
+	}
+	if !bytes.Equal(header[:len(magic)], []byte(magic)) {
+		return nil, errors.New("capture: not a proxyx capture file")
+	}
+	if header[5] > Version {
+		return nil, fmt.Errorf("capture: unsupported format version %d", header[5])
+	}
+	return &Reader{
+		Version: header[5],
+		Source:  header[6],
+		Start:   time.Unix(0, int64(binary.LittleEndian.Uint64(header[8:]))),
+		r:       br,
+	}, nil
+}
+
+// Next 读取下一条记录，文件结束时返回 io.EOF，末尾记录不完整时返回 ErrTruncated
+func (r *Reader) Next() (*Record, error) {
+	size, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, ErrTruncated
+	}
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("capture: record size %d exceeds limit", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return nil, ErrTruncated
+	}
+	rec, err := decodeRecord(body, r.Start)
+	if err != nil {
+		return nil, fmt.Errorf("capture: corrupt record: %w", err)
+	}
+	return rec, nil
+}
+
+// decoder 记录内容的解码，出错后后续读取都返回零值
+type decoder struct {
+	b   []byte
+	err error
+}
+
+var errShort = errors.New("unexpected end of record")
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.fail()
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil || len(d.b) < 8 {
+		d.fail()
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(d.b)
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil || uint64(len(d.b)) < n {
+		d.fail()
+		return nil
+	}
+	v := d.b[:n:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errShort
+	}
+}
+
+// decodeRecord 解码记录内容
+func decodeRecord(b []byte, start time.Time) (*Record, error) {
+	d := &decoder{b: b}
+	rec := &Record{Kind: d.byte()}
+	rec.Session = uint32(d.uvarint())
+	rec.Time = start.Add(time.Duration(d.uvarint()))
+	rec.Duration = time.Duration(d.uvarint())
+	rec.Name = d.string()
+	rec.Database = d.string()
+	rec.User = d.string()
+	rec.Text = d.string()
+	if n := d.uvarint(); n > 0 && n <= uint64(len(d.b)) {
+		rec.Args = make([]interface{}, 0, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			rec.Args = append(rec.Args, d.arg())
+		}
+	} else if n > 0 {
+		d.fail()
+	}
+	rec.Rows = d.uvarint()
+	rec.Digest = d.uint64()
+	rec.Error = d.string()
+	return rec, d.err
+}
+
+// arg 解码一个参数
+func (d *decoder) arg() interface{} {
+	switch tag := d.byte(); tag {
+	case argNull:
+		return nil
+	case argInt:
+		return d.varint()
+	case argUint:
+		return d.uvarint()
+	case argFloat:
+		return math.Float64frombits(d.uint64())
+	case argBytes:
+		return append([]byte(nil), d.bytes()...)
+	case argString:
+		return d.string()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown argument tag %d", tag)
+		}
+		return nil
+	}
+}
+
+// Open 打开录制文件
+func Open(path string) (*Reader, io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, file, nil
+}
@@ -0,0 +1,175 @@
+package capture
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/if-nil/proxyx/rotate"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name string
+		rec  Record
+		args []interface{} // 解码后的参数，nil 表示与 rec.Args 相同
+	}{
+		{
+			name: "connect",
+			rec:  Record{Kind: KindConnect, Session: 10001, Time: start, User: "app", Text: "10.0.0.1:5000"},
+		},
+		{
+			name: "query",
+			rec: Record{
+				Kind: KindQuery, Session: 10001, Time: start.Add(1500 * time.Millisecond), Duration: 3 * time.Millisecond,
+				Name: "query", Database: "shop", User: "app", Text: "SELECT * FROM t WHERE id = 1",
+				Rows: 1, Digest: 0xdeadbeefcafe,
+			},
+		},
+		{
+			name: "error",
+			rec: Record{
+				Kind: KindQuery, Session: 7, Time: start.Add(time.Second), Name: "query",
+				Text: "SELECT x", Error: "Unknown column 'x'",
+			},
+		},
+		{
+			name: "arguments",
+			rec: Record{
+				Kind: KindQuery, Session: 7, Time: start, Name: "execute", Text: "SELECT ?, ?, ?, ?, ?, ?",
+				Args: []interface{}{nil, int64(-42), uint64(math.MaxUint64), 3.5, []byte{0, 1, 2}, "héllo"},
+			},
+		},
+		{
+			name: "narrow arguments",
+			rec: Record{
+				Kind: KindCommand, Session: 3, Time: start, Name: "SET",
+				Args: []interface{}{int8(-1), int32(7), uint16(9), float32(0.5), true},
+			},
+			args: []interface{}{int64(-1), int64(7), uint64(9), 0.5, "true"},
+		},
+		{
+			name: "before start",
+			rec:  Record{Kind: KindDisconnect, Session: 1, Time: start.Add(-time.Second)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeRecord(encodeRecord(nil, &tt.rec, start), start)
+			if err != nil {
+				t.Fatalf("decodeRecord: %v", err)
+			}
+
+			want := tt.rec
+			if tt.args != nil {
+				want.Args = tt.args
+			}
+			if want.Time.Before(start) {
+				want.Time = start
+			}
+			if !got.Time.Equal(want.Time) {
+				t.Errorf("Time = %v, want %v", got.Time, want.Time)
+			}
+			got.Time, want.Time = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("decoded %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeRecordCorrupt(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	full := encodeRecord(nil, &Record{
+		Kind: KindQuery, Session: 1, Time: start, Name: "execute", Text: "SELECT ?",
+		Args: []interface{}{"abc"}, Error: "boom",
+	}, start)
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"empty", nil},
+		{"truncated", full[:len(full)-3]},
+		{"unknown argument tag", append(encodeRecord(nil, &Record{Kind: KindQuery, Time: start}, start)[:8:8], 1, 9)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeRecord(tt.body, start); err == nil {
+				t.Errorf("decodeRecord succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mysql.pxcap")
+	w, err := NewWriter(rotate.Config{Path: path}, SourceMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []Record{
+		{Kind: KindConnect, Session: 1, Time: time.Now(), User: "app"},
+		{Kind: KindQuery, Session: 1, Time: time.Now(), Name: "query", Text: "SELECT 1", Rows: 1},
+		{Kind: KindDisconnect, Session: 1, Time: time.Now()},
+	}
+	for i := range records {
+		if err := w.Write(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, closer, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	if r.Source != SourceMySQL || r.Version != Version {
+		t.Errorf("header source %c version %d", r.Source, r.Version)
+	}
+	for i, want := range records {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if got.Kind != want.Kind || got.Text != want.Text || got.Rows != want.Rows {
+			t.Errorf("record %d = %+v, want %+v", i, *got, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next at end = %v, want io.EOF", err)
+	}
+
+	// 末尾不完整的记录
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)-2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, closer, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	for {
+		if _, err = r.Next(); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("Next on truncated file = %v, want ErrTruncated", err)
+	}
+}
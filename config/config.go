@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"github.com/if-nil/proxyx/mysql"
 	"github.com/if-nil/proxyx/redisproxy"
@@ -20,12 +21,49 @@ type Config struct {
 
 // MySQLProxyConfig MySQL代理配置
 type MySQLProxyConfig struct {
-	Enabled  bool   `yaml:"enabled"`  // 是否启用MySQL代理
-	Addr     string `yaml:"addr"`     // 代理监听地址
-	Target   string `yaml:"target"`   // MySQL服务器地址
-	User     string `yaml:"user"`     // 用户名
-	Password string `yaml:"password"` // 密码
-	Database string `yaml:"database"` // 默认数据库
+	Enabled  bool             `yaml:"enabled"`  // 是否启用MySQL代理
+	Addr     string           `yaml:"addr"`     // 代理监听地址
+	Targets  mysql.TargetList `yaml:"target"`   // MySQL服务器地址，单个地址或带优先级的地址列表
+	Target   string           `yaml:"-"`        // 优先级最高的MySQL服务器地址（由 target 得出）
+	User     string           `yaml:"user"`     // 用户名
+	Password string           `yaml:"password"` // 密码
+	Database string           `yaml:"database"` // 默认数据库
+
+	// 健康检查与主库切换
+	HealthCheck mysql.HealthCheckConfig `yaml:"health_check"`
+
+	// 读写分离
+	Replicas     []string      `yaml:"replicas"`      // 只读从库地址列表（为空时不做读写分离）
+	StickyWindow time.Duration `yaml:"sticky_window"` // 写入后读请求继续发往主库的时长
+
+	// 后端连接池
+	Pool mysql.PoolConfig `yaml:"pool"`
+
+	// TLS
+	TLS        mysql.ServerTLSConfig  `yaml:"tls"`         // 对客户端提供TLS
+	BackendTLS mysql.BackendTLSConfig `yaml:"backend_tls"` // 连接后端MySQL时使用TLS
+
+	// 事务监控
+	Transaction mysql.TransactionConfig `yaml:"transaction"`
+
+	// 语句超时
+	QueryTimeout mysql.QueryTimeoutConfig `yaml:"query_timeout"`
+
+	// 分片
+	Sharding mysql.ShardingConfig `yaml:"sharding"`
+
+	// 影子流量
+	Mirror mysql.MirrorConfig `yaml:"mirror"`
+
+	// 变更数据捕获
+	Binlog mysql.BinlogConfig `yaml:"binlog"`
+
+	// 管理语句
+	Admin mysql.AdminConfig `yaml:"admin"`
+
+	// 多用户认证
+	Users     []mysql.UserConfig `yaml:"users"`      // 前端用户表，为空时使用 user/password 单用户认证
+	UsersFile string             `yaml:"users_file"` // 额外的用户表文件（YAML 用户列表）
 }
 
 // RedisProxyConfig Redis代理配置
@@ -37,14 +75,26 @@ type RedisProxyConfig struct {
 
 // MySQLPluginsConfig MySQL插件配置
 type MySQLPluginsConfig struct {
-	Log   LogPluginConfig         `yaml:"log"`
-	Redis mysql.RedisPluginConfig `yaml:"redis"`
+	Log       LogPluginConfig             `yaml:"log"`
+	Redis     mysql.RedisPluginConfig     `yaml:"redis"`
+	Firewall  mysql.FirewallPluginConfig  `yaml:"firewall"`
+	Digest    mysql.DigestPluginConfig    `yaml:"digest"`
+	SlowQuery mysql.SlowQueryPluginConfig `yaml:"slow_query"`
+	Cache     mysql.CachePluginConfig     `yaml:"cache"`
+	Mask      mysql.MaskPluginConfig      `yaml:"mask"`
+	RateLimit mysql.RateLimitPluginConfig `yaml:"rate_limit"`
+	Recorder  mysql.RecorderPluginConfig  `yaml:"recorder"`
+	Fault     mysql.FaultPluginConfig     `yaml:"fault"`
+	Audit     mysql.AuditPluginConfig     `yaml:"audit"`
 }
 
 // RedisPluginsConfig Redis代理插件配置
 type RedisPluginsConfig struct {
-	Log   LogPluginConfig              `yaml:"log"`
-	Redis redisproxy.RedisPluginConfig `yaml:"redis"`
+	Log      LogPluginConfig                 `yaml:"log"`
+	Redis    redisproxy.RedisPluginConfig    `yaml:"redis"`
+	Recorder redisproxy.RecorderPluginConfig `yaml:"recorder"`
+	Fault    redisproxy.FaultPluginConfig    `yaml:"fault"`
+	Audit    redisproxy.AuditPluginConfig    `yaml:"audit"`
 }
 
 // LogPluginConfig 日志插件配置
@@ -64,24 +114,75 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	// 加载外部用户表
+	if config.MySQL.UsersFile != "" {
+		users, err := loadUsers(config.MySQL.UsersFile)
+		if err != nil {
+			return nil, err
+		}
+		config.MySQL.Users = append(config.MySQL.Users, users...)
+	}
+
 	// 设置默认值
 	config.setDefaults()
 
 	return &config, nil
 }
 
+// loadUsers 从文件加载MySQL前端用户表
+func loadUsers(path string) ([]mysql.UserConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []mysql.UserConfig
+	if err := yaml.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // setDefaults 设置默认值
 func (c *Config) setDefaults() {
 	// MySQL代理默认值
 	if c.MySQL.Addr == "" {
 		c.MySQL.Addr = "127.0.0.1:4000"
 	}
-	if c.MySQL.Target == "" {
-		c.MySQL.Target = "127.0.0.1:3306"
+	if len(c.MySQL.Targets) == 0 {
+		c.MySQL.Targets = mysql.TargetList{{Addr: "127.0.0.1:3306"}}
 	}
+	c.MySQL.Target = c.MySQL.Targets.Primary()
 	if c.MySQL.User == "" {
 		c.MySQL.User = "root"
 	}
+	if c.MySQL.HealthCheck.User == "" {
+		c.MySQL.HealthCheck.User = c.MySQL.User
+		c.MySQL.HealthCheck.Password = c.MySQL.Password
+	}
+	if c.MySQL.Mirror.User == "" {
+		c.MySQL.Mirror.User = c.MySQL.User
+		c.MySQL.Mirror.Password = c.MySQL.Password
+	}
+	if c.MySQL.Binlog.Addr == "" {
+		c.MySQL.Binlog.Addr = c.MySQL.Target
+	}
+	if c.MySQL.Binlog.User == "" {
+		c.MySQL.Binlog.User = c.MySQL.User
+		c.MySQL.Binlog.Password = c.MySQL.Password
+	}
+	if c.MySQL.Pool.MaxSize <= 0 {
+		c.MySQL.Pool.MaxSize = 64
+	}
+	if c.MySQL.Pool.IdleTimeout == 0 {
+		c.MySQL.Pool.IdleTimeout = 5 * time.Minute
+	}
+	if c.MySQL.Pool.MaxLifetime == 0 {
+		c.MySQL.Pool.MaxLifetime = time.Hour
+	}
+	if c.MySQL.Pool.WaitTimeout == 0 {
+		c.MySQL.Pool.WaitTimeout = 5 * time.Second
+	}
 
 	// Redis代理默认值
 	if c.Redis.Addr == "" {
@@ -112,6 +213,13 @@ func (c *Config) setDefaults() {
 	if c.MySQLPlugins.Redis.ListKey == "" {
 		c.MySQLPlugins.Redis.ListKey = "mysql:query_list"
 	}
+	if c.MySQLPlugins.Recorder.Path == "" {
+		c.MySQLPlugins.Recorder.Path = "mysql.pxcap"
+	}
+	if c.MySQLPlugins.SlowQuery.User == "" {
+		c.MySQLPlugins.SlowQuery.User = c.MySQL.User
+		c.MySQLPlugins.SlowQuery.Password = c.MySQL.Password
+	}
 
 	// Redis插件默认值
 	if c.RedisPlugins.Redis.Channel == "" {
@@ -120,4 +228,7 @@ func (c *Config) setDefaults() {
 	if c.RedisPlugins.Redis.ListKey == "" {
 		c.RedisPlugins.Redis.ListKey = "redis:command_list"
 	}
+	if c.RedisPlugins.Recorder.Path == "" {
+		c.RedisPlugins.Recorder.Path = "redis.pxcap"
+	}
 }
@@ -0,0 +1,331 @@
+// Package fault 故障注入规则：按语句 / 命令、数据库、客户端、概率和时间窗口匹配，MySQL 和 Redis 代理共用
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/if-nil/proxyx/web"
+)
+
+// 故障类型
+const (
+	Latency  = "latency"  // 延迟后再执行
+	Error    = "error"    // 不执行，返回配置的错误
+	Drop     = "drop"     // 执行后在返回响应的中途断开客户端连接
+	Truncate = "truncate" // 执行后截断结果
+)
+
+// Config 故障注入配置
+type Config struct {
+	Paused   bool   `yaml:"paused"`    // 启动时暂停注入，之后通过 API 开启
+	APIToken string `yaml:"api_token"` // 通过 API 切换注入时需要的令牌（Authorization: Bearer <token>），为空时 API 只读
+	Rules    []Rule `yaml:"rules"`     // 按顺序匹配，使用第一条命中的规则
+}
+
+// Rule 故障注入规则，匹配条件为空时不限制
+type Rule struct {
+	Name        string  `yaml:"name" json:"name"`               // 规则名，通过 API 开关单条规则时使用，默认 rule-序号
+	Disabled    bool    `yaml:"disabled" json:"disabled"`       // 启动时关闭该规则
+	Pattern     string  `yaml:"pattern" json:"pattern"`         // 正则，MySQL 匹配 SQL 指纹，Redis 匹配命令名及参数（以空格分隔）
+	Database    string  `yaml:"database" json:"database"`       // 当前数据库，MySQL 为库名（不区分大小写），Redis 为 SELECT 的编号
+	Client      string  `yaml:"client" json:"client"`           // 客户端 IP 或 CIDR
+	User        string  `yaml:"user" json:"user"`               // 客户端用户名
+	Probability float64 `yaml:"probability" json:"probability"` // 命中条件后注入的概率（0-1，默认1）
+	Window      string  `yaml:"window" json:"window"`           // 每天生效的时间段（本地时间），如 "02:00-02:30"，可以跨过零点
+
+	Action       string        `yaml:"action" json:"action"`               // latency / error / drop / truncate
+	Latency      time.Duration `yaml:"latency" json:"latency"`             // latency：延迟时长
+	ErrorCode    uint16        `yaml:"error_code" json:"error_code"`       // error：MySQL 错误码（默认1105）
+	ErrorState   string        `yaml:"error_state" json:"error_state"`     // error：MySQL SQLSTATE（默认HY000）
+	ErrorMessage string        `yaml:"error_message" json:"error_message"` // error：错误信息，Redis 为 - 之后的内容（含 ERR 等前缀）
+	KeepRows     int           `yaml:"keep_rows" json:"keep_rows"`         // truncate：保留的行数（Redis 为数组元素数），0表示保留一半
+}
+
+// rule 编译后的规则
+type rule struct {
+	Rule
+	pattern      *regexp.Regexp
+	client       *net.IPNet
+	start, end   int // 时间窗口，当天的分钟数，start == end 表示不限制
+	hasWindow    bool
+	enabled      atomic.Bool
+	injected     atomic.Uint64
+	lastInjected atomic.Int64 // 最近一次注入的 Unix 纳秒
+}
+
+// RuleState 规则及其运行状态（API 返回）
+type RuleState struct {
+	Rule
+	Enabled  bool       `json:"enabled"`
+	Injected uint64     `json:"injected"`                // 注入次数
+	Last     *time.Time `json:"last_injected,omitempty"` // 最近一次注入的时间
+}
+
+// Target 待匹配的语句或命令
+type Target struct {
+	Text       string // MySQL SQL 指纹 / Redis 命令名及参数
+	Database   string // MySQL 当前数据库 / Redis 当前数据库编号
+	ClientAddr string // 客户端地址（host:port）
+	User       string
+}
+
+// Injector 按规则决定是否注入故障，规则和暂停状态可以在运行时切换
+type Injector struct {
+	rules  []*rule
+	token  string
+	paused atomic.Bool
+}
+
+// New 编译规则，mysql 为 false 时按 Redis 的规则编译：database 为数据库编号，错误信息默认带 ERR 前缀
+func New(config Config, mysql bool) (*Injector, error) {
+	in := &Injector{token: config.APIToken}
+	in.paused.Store(config.Paused)
+	names := make(map[string]bool)
+	for i, r := range config.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("fault rule %s: duplicate name", r.Name)
+		}
+		names[r.Name] = true
+
+		compiled, err := compile(r, mysql)
+		if err != nil {
+			return nil, fmt.Errorf("fault rule %s: %w", r.Name, err)
+		}
+		in.rules = append(in.rules, compiled)
+	}
+	return in, nil
+}
+
+func compile(r Rule, mysql bool) (*rule, error) {
+	switch r.Action {
+	case Latency:
+		if r.Latency <= 0 {
+			return nil, fmt.Errorf("latency must be positive")
+		}
+	case Error:
+		if r.ErrorCode == 0 {
+			r.ErrorCode = 1105 // ER_UNKNOWN_ERROR
+		}
+		if r.ErrorState == "" {
+			r.ErrorState = "HY000"
+		}
+		if r.ErrorMessage == "" {
+			r.ErrorMessage = "proxyx: injected fault"
+			if !mysql {
+				r.ErrorMessage = "ERR proxyx injected fault"
+			}
+		}
+	case Drop, Truncate:
+	default:
+		return nil, fmt.Errorf("unknown action %q", r.Action)
+	}
+	if r.KeepRows < 0 {
+		return nil, fmt.Errorf("keep_rows must not be negative")
+	}
+	if r.Probability == 0 {
+		r.Probability = 1
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return nil, fmt.Errorf("probability must be between 0 and 1")
+	}
+	if r.Database != "" && !mysql {
+		if n, err := strconv.Atoi(r.Database); err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid database %q: Redis database must be a number", r.Database)
+		}
+	}
+
+	c := &rule{Rule: r}
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		c.pattern = re
+	}
+	if r.Client != "" {
+		cidr := r.Client
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client %q", r.Client)
+		}
+		c.client = ipNet
+	}
+	if r.Window != "" {
+		from, to, ok := strings.Cut(r.Window, "-")
+		start, err1 := parseClock(from)
+		end, err2 := parseClock(to)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", r.Window)
+		}
+		c.start, c.end, c.hasWindow = start, end, start != end
+	}
+	c.enabled.Store(!r.Disabled)
+	return c, nil
+}
+
+// parseClock 解析 HH:MM，返回当天的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// matches 判断规则的条件（不含概率）是否满足
+func (r *rule) matches(t *Target, now time.Time) bool {
+	if !r.enabled.Load() {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(t.Text) {
+		return false
+	}
+	if r.Database != "" && !strings.EqualFold(r.Database, t.Database) {
+		return false
+	}
+	if r.User != "" && r.User != t.User {
+		return false
+	}
+	if r.client != nil {
+		host, _, err := net.SplitHostPort(t.ClientAddr)
+		if err != nil {
+			host = t.ClientAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !r.client.Contains(ip) {
+			return false
+		}
+	}
+	if r.hasWindow {
+		minute := now.Hour()*60 + now.Minute()
+		if r.start < r.end {
+			return minute >= r.start && minute < r.end
+		}
+		return minute >= r.start || minute < r.end
+	}
+	return true
+}
+
+// Match 按顺序返回第一条满足条件且按概率命中的规则，没有时返回 nil
+func (in *Injector) Match(t Target) *Rule {
+	if len(in.rules) == 0 || in.paused.Load() {
+		return nil
+	}
+	now := time.Now()
+	for _, r := range in.rules {
+		if !r.matches(&t, now) {
+			continue
+		}
+		if r.Probability < 1 && rand.Float64() >= r.Probability {
+			continue
+		}
+		r.injected.Add(1)
+		r.lastInjected.Store(now.UnixNano())
+		return &r.Rule
+	}
+	return nil
+}
+
+// Paused 返回是否暂停了注入
+func (in *Injector) Paused() bool {
+	return in.paused.Load()
+}
+
+// SetPaused 暂停或恢复注入
+func (in *Injector) SetPaused(paused bool) {
+	in.paused.Store(paused)
+}
+
+// SetEnabled 开关单条规则，规则不存在时返回 false
+func (in *Injector) SetEnabled(name string, enabled bool) bool {
+	for _, r := range in.rules {
+		if r.Name == name {
+			r.enabled.Store(enabled)
+			return true
+		}
+	}
+	return false
+}
+
+// Rules 返回所有规则及其运行状态
+func (in *Injector) Rules() []RuleState {
+	states := make([]RuleState, 0, len(in.rules))
+	for _, r := range in.rules {
+		state := RuleState{
+			Rule:     r.Rule,
+			Enabled:  r.enabled.Load(),
+			Injected: r.injected.Load(),
+		}
+		if last := r.lastInjected.Load(); last != 0 {
+			t := time.Unix(0, last)
+			state.Last = &t
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// ServeHTTP 查询和切换故障注入
+//
+//	GET  返回暂停状态和所有规则
+//	POST ?paused=true|false 暂停或恢复全部注入
+//	POST ?rule=名称&enabled=true|false 开关单条规则
+//
+// 切换需要 Authorization: Bearer <api_token>，与 MySQL 插件的 API 相同由 web.AuthorizeChange 校验，
+// 切换请求的响应不带 CORS 头；未配置 api_token 时拒绝所有切换
+func (in *Injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if !web.AuthorizeChange(w, r, in.token, "fault") {
+			return
+		}
+		query := r.URL.Query()
+		if v := query.Get("paused"); v != "" {
+			paused, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, `{"error":"invalid paused"}`, http.StatusBadRequest)
+				return
+			}
+			in.SetPaused(paused)
+		}
+		if name := query.Get("rule"); name != "" {
+			enabled, err := strconv.ParseBool(query.Get("enabled"))
+			if err != nil {
+				http.Error(w, `{"error":"invalid enabled"}`, http.StatusBadRequest)
+				return
+			}
+			if !in.SetEnabled(name, enabled) {
+				http.Error(w, `{"error":"rule not found"}`, http.StatusNotFound)
+				return
+			}
+		}
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"paused": in.Paused(),
+		"rules":  in.Rules(),
+	})
+}
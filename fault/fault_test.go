@@ -0,0 +1,230 @@
+package fault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		mysql   bool
+		wantErr string // 期望的错误，空表示编译成功
+	}{
+		{name: "defaults", rules: []Rule{{Action: Error}, {Action: Drop}}, mysql: true},
+		{name: "unknown action", rules: []Rule{{Action: "explode"}}, wantErr: "unknown action"},
+		{name: "latency without duration", rules: []Rule{{Action: Latency}}, wantErr: "latency must be positive"},
+		{name: "negative keep_rows", rules: []Rule{{Action: Truncate, KeepRows: -1}}, wantErr: "keep_rows"},
+		{name: "probability out of range", rules: []Rule{{Action: Drop, Probability: 1.5}}, wantErr: "probability"},
+		{name: "database for redis", rules: []Rule{{Action: Drop, Database: "2"}}},
+		{name: "database name for redis", rules: []Rule{{Action: Drop, Database: "shop"}}, wantErr: "must be a number"},
+		{name: "database for mysql", rules: []Rule{{Action: Drop, Database: "shop"}}, mysql: true},
+		{name: "bad pattern", rules: []Rule{{Action: Drop, Pattern: "("}}, wantErr: "rule-1"},
+		{name: "bad client", rules: []Rule{{Action: Drop, Client: "10.0.0.300"}}, wantErr: "invalid client"},
+		{name: "bad window", rules: []Rule{{Action: Drop, Window: "02:00"}}, wantErr: "invalid window"},
+		{name: "duplicate name", rules: []Rule{{Name: "a", Action: Drop}, {Name: "a", Action: Drop}}, wantErr: "duplicate name"},
+		{name: "generated name clash", rules: []Rule{{Action: Drop}, {Name: "rule-1", Action: Drop}}, wantErr: "duplicate name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := New(Config{Rules: tt.rules}, tt.mysql)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("New() = %v, want nil", err)
+				}
+				if n := len(in.Rules()); n != len(tt.rules) {
+					t.Errorf("%d rules, want %d", n, len(tt.rules))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("New() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRuleDefaults(t *testing.T) {
+	tests := []struct {
+		mysql       bool
+		wantMessage string
+	}{
+		{mysql: true, wantMessage: "proxyx: injected fault"},
+		{mysql: false, wantMessage: "ERR proxyx injected fault"},
+	}
+	for _, tt := range tests {
+		in, err := New(Config{Rules: []Rule{{Action: Error}}}, tt.mysql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := in.Rules()[0]
+		if r.Name != "rule-1" || r.ErrorCode != 1105 || r.ErrorState != "HY000" || r.ErrorMessage != tt.wantMessage || r.Probability != 1 {
+			t.Errorf("mysql=%v: rule = %+v", tt.mysql, r.Rule)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return t
+	}
+	tests := []struct {
+		name   string
+		rule   Rule
+		target Target
+		now    string // 当前时间 HH:MM，默认 12:00
+		want   bool
+	}{
+		{name: "no conditions", want: true},
+		{name: "pattern", rule: Rule{Pattern: `^select .* from orders`}, target: Target{Text: "select * from orders where id = ?"}, want: true},
+		{name: "pattern miss", rule: Rule{Pattern: `^select .* from orders`}, target: Target{Text: "select * from users"}},
+		{name: "database ignores case", rule: Rule{Database: "Shop"}, target: Target{Database: "shop"}, want: true},
+		{name: "database miss", rule: Rule{Database: "shop"}, target: Target{Database: "hr"}},
+		{name: "user", rule: Rule{User: "app"}, target: Target{User: "app"}, want: true},
+		{name: "user miss", rule: Rule{User: "app"}, target: Target{User: "report"}},
+		{name: "client ip", rule: Rule{Client: "10.0.0.5"}, target: Target{ClientAddr: "10.0.0.5:51000"}, want: true},
+		{name: "client cidr", rule: Rule{Client: "10.0.0.0/24"}, target: Target{ClientAddr: "10.0.0.77:51000"}, want: true},
+		{name: "client miss", rule: Rule{Client: "10.0.0.0/24"}, target: Target{ClientAddr: "10.0.1.5:51000"}},
+		{name: "client ipv6", rule: Rule{Client: "::1"}, target: Target{ClientAddr: "[::1]:51000"}, want: true},
+		{name: "client without port", rule: Rule{Client: "10.0.0.5"}, target: Target{ClientAddr: "10.0.0.5"}, want: true},
+		{name: "inside window", rule: Rule{Window: "02:00-02:30"}, now: "02:15", want: true},
+		{name: "window end is exclusive", rule: Rule{Window: "02:00-02:30"}, now: "02:30"},
+		{name: "before window", rule: Rule{Window: "02:00-02:30"}, now: "01:59"},
+		{name: "window past midnight", rule: Rule{Window: "23:00-01:00"}, now: "00:30", want: true},
+		{name: "window past midnight miss", rule: Rule{Window: "23:00-01:00"}, now: "12:00"},
+		{name: "empty window", rule: Rule{Window: "02:00-02:00"}, now: "12:00", want: true},
+		{name: "disabled", rule: Rule{Disabled: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Action = Drop
+			r, err := compile(tt.rule, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := "12:00"
+			if tt.now != "" {
+				now = tt.now
+			}
+			if got := r.matches(&tt.target, at(now)); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectorMatch(t *testing.T) {
+	in, err := New(Config{Rules: []Rule{
+		{Name: "orders", Pattern: "orders", Action: Latency, Latency: time.Millisecond},
+		{Name: "all", Action: Error},
+		{Name: "never", Action: Drop, Probability: 1e-12},
+	}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// step 按顺序执行的操作，target 为空时只切换状态
+	type step struct {
+		paused  *bool
+		toggle  string // 切换的规则名
+		enabled bool
+		target  string
+		want    string // 期望命中的规则，空表示不注入
+	}
+	yes, no := true, false
+	steps := []step{
+		{target: "select * from orders", want: "orders"},
+		{target: "select * from users", want: "all"},
+		{paused: &yes},
+		{target: "select * from orders"},
+		{paused: &no},
+		{toggle: "orders", enabled: false},
+		{target: "select * from orders", want: "all"},
+		{toggle: "all", enabled: false},
+		{target: "select * from orders"},
+		{toggle: "orders", enabled: true},
+		{target: "select * from orders", want: "orders"},
+	}
+	for i, s := range steps {
+		switch {
+		case s.paused != nil:
+			in.SetPaused(*s.paused)
+		case s.toggle != "":
+			if !in.SetEnabled(s.toggle, s.enabled) {
+				t.Fatalf("step %d: rule %s not found", i, s.toggle)
+			}
+		default:
+			got := ""
+			if r := in.Match(Target{Text: s.target}); r != nil {
+				got = r.Name
+			}
+			if got != s.want {
+				t.Errorf("step %d: Match(%q) = %q, want %q", i, s.target, got, s.want)
+			}
+		}
+	}
+
+	if in.SetEnabled("missing", true) {
+		t.Error("SetEnabled(missing) = true, want false")
+	}
+	injected := make(map[string]uint64)
+	for _, r := range in.Rules() {
+		injected[r.Name] = r.Injected
+		if (r.Injected > 0) != (r.Last != nil) {
+			t.Errorf("rule %s: injected %d, last %v", r.Name, r.Injected, r.Last)
+		}
+	}
+	if injected["orders"] != 2 || injected["all"] != 2 || injected["never"] != 0 {
+		t.Errorf("injected = %v, want orders 2, all 2, never 0", injected)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string // 配置的 api_token
+		method     string
+		auth       string
+		query      string
+		wantStatus int
+		wantPaused bool
+		wantCORS   bool
+	}{
+		{name: "get", token: "s3cret", method: http.MethodGet, wantStatus: http.StatusOK, wantCORS: true},
+		{name: "pause", token: "s3cret", method: http.MethodPost, auth: "Bearer s3cret", query: "paused=true", wantStatus: http.StatusOK, wantPaused: true},
+		{name: "wrong token", token: "s3cret", method: http.MethodPost, auth: "Bearer nope", query: "paused=true", wantStatus: http.StatusUnauthorized},
+		{name: "missing token", token: "s3cret", method: http.MethodPost, query: "paused=true", wantStatus: http.StatusUnauthorized},
+		{name: "read-only", method: http.MethodPost, auth: "Bearer ", query: "paused=true", wantStatus: http.StatusForbidden},
+		{name: "unknown rule", token: "s3cret", method: http.MethodPost, auth: "Bearer s3cret", query: "rule=missing&enabled=false", wantStatus: http.StatusNotFound},
+		{name: "invalid paused", token: "s3cret", method: http.MethodPost, auth: "Bearer s3cret", query: "paused=maybe", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := New(Config{APIToken: tt.token, Rules: []Rule{{Action: Drop}}}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(tt.method, "/fault?"+tt.query, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			in.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if in.Paused() != tt.wantPaused {
+				t.Errorf("paused = %v, want %v", in.Paused(), tt.wantPaused)
+			}
+			if cors := w.Header().Get("Access-Control-Allow-Origin") != ""; cors != tt.wantCORS {
+				t.Errorf("CORS header = %v, want %v", cors, tt.wantCORS)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package mysql
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// adminPrefix 管理语句的第一个单词，以它开头的语句由代理应答，不转发到后端
+const adminPrefix = "PROXYX"
+
+// AdminConfig 管理语句配置
+type AdminConfig struct {
+	Enabled bool     `yaml:"enabled"` // 是否启用，未启用时 PROXYX 语句照常转发到后端
+	Users   []string `yaml:"users"`   // 允许执行管理语句的用户，为空时所有用户都不能执行
+}
+
+// Admin 管理语句 - 在代理本地应答 PROXYX 开头的语句，客户端可以用 mysql 命令行查看和控制代理：
+//
+//	PROXYX SHOW CONNECTIONS          当前的客户端连接
+//	PROXYX SHOW DIGESTS [LIMIT n]    SQL指纹统计（需启用 DigestPlugin）
+//	PROXYX SHOW BACKENDS             后端及其健康状态
+//	PROXYX SHOW PLUGINS              插件及其是否暂停
+//	PROXYX KILL [CONNECTION] <id>    断开客户端连接
+//	PROXYX SET PLUGIN <name> ON|OFF  恢复或暂停插件
+type Admin struct {
+	users map[string]bool
+	pm    *PluginManager
+
+	// 由 SetDigests / SetBackends 在接受连接之前设置
+	digests  *DigestPlugin
+	target   string
+	router   *Router
+	failover *Failover
+
+	mu    sync.Mutex
+	conns map[uint32]*adminConn
+}
+
+// adminConn 客户端连接及其当前状态
+type adminConn struct {
+	session    Session
+	conn       net.Conn
+	database   string
+	backend    string
+	query      string    // 执行中的语句，空闲时为空
+	since      time.Time // 进入当前状态（执行 / 空闲）的时间
+	statements uint64
+}
+
+// NewAdmin 创建管理语句处理，未启用时返回 nil；users 为空时任何用户执行管理语句都会被拒绝
+func NewAdmin(config AdminConfig, pm *PluginManager) *Admin {
+	if !config.Enabled {
+		return nil
+	}
+	a := &Admin{
+		users: make(map[string]bool, len(config.Users)),
+		pm:    pm,
+		conns: make(map[uint32]*adminConn),
+	}
+	for _, u := range config.Users {
+		a.users[u] = true
+	}
+	return a
+}
+
+// SetDigests 设置 SHOW DIGESTS 使用的指纹统计，为 nil 时 SHOW DIGESTS 返回错误
+func (a *Admin) SetDigests(digests *DigestPlugin) {
+	a.digests = digests
+}
+
+// SetBackends 设置 SHOW BACKENDS 使用的主库地址、读写分离和主库切换，router、failover 可以为 nil
+func (a *Admin) SetBackends(target string, router *Router, failover *Failover) {
+	a.target = target
+	a.router = router
+	a.failover = failover
+}
+
+// Track 记录认证通过的客户端连接，KILL 时关闭 conn，返回的函数在连接关闭时调用
+func (a *Admin) Track(session Session, backend string, conn net.Conn) func() {
+	a.mu.Lock()
+	a.conns[session.ConnID] = &adminConn{
+		session: session,
+		conn:    conn,
+		backend: backend,
+		since:   time.Now(),
+	}
+	a.mu.Unlock()
+
+	return func() {
+		a.mu.Lock()
+		delete(a.conns, session.ConnID)
+		a.mu.Unlock()
+	}
+}
+
+func (a *Admin) Name() string {
+	return "Admin"
+}
+
+// OnQuery 记录连接正在执行的语句
+func (a *Admin) OnQuery(event *QueryEvent) {
+	if generatedEvent(event.Type) {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.conns[event.ConnID]; ok {
+		c.database = event.Database
+		c.backend = event.Backend
+		c.query = event.Query
+		c.since = event.Timestamp
+		c.statements++
+	}
+}
+
+// OnQueryComplete 语句结束后连接进入空闲
+func (a *Admin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	if generatedEvent(event.Type) {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.conns[event.ConnID]; ok {
+		if event.Type == "use_db" && err == nil {
+			c.database = event.Query
+		}
+		c.query = ""
+		c.since = time.Now()
+	}
+}
+
+// generatedEvent 判断是否为代理生成的事件（不对应客户端正在执行的语句）
+func generatedEvent(eventType string) bool {
+	switch eventType {
+	case "transaction", "long_transaction", "idle_transaction", "mirror_diff", "binlog_row", "slow_query":
+		return true
+	}
+	return false
+}
+
+// Intercept 应答 PROXYX 开头的文本协议语句，事件类型改为 admin
+func (a *Admin) Intercept(event *QueryEvent) (*mysql.Result, error) {
+	if event.Type != "query" {
+		return nil, nil
+	}
+	rest, _ := skipComments(event.Query)
+	if !strings.EqualFold(firstWord(rest), adminPrefix) {
+		return nil, nil
+	}
+	event.Type = "admin"
+
+	// 管理语句可以暂停防火墙、脱敏、审计等插件并断开其他用户的连接，只允许明确列出的用户执行
+	if !a.users[event.User] {
+		return nil, NewError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, "42000",
+			fmt.Sprintf("proxyx: user %s is not allowed to run %s statements", event.User, adminPrefix))
+	}
+
+	words := strings.Fields(strings.TrimRight(rest[len(adminPrefix):], "; \t\r\n"))
+	keyword := func(i int) string {
+		if i < len(words) {
+			return strings.ToUpper(words[i])
+		}
+		return ""
+	}
+
+	switch {
+	case keyword(0) == "SHOW" && keyword(1) == "CONNECTIONS" && len(words) == 2:
+		return a.showConnections(event)
+	case keyword(0) == "SHOW" && keyword(1) == "DIGESTS" && (len(words) == 2 || (len(words) == 4 && keyword(2) == "LIMIT")):
+		limit := 0
+		if len(words) == 4 {
+			n, err := strconv.Atoi(words[3])
+			if err != nil || n < 0 {
+				return nil, adminSyntaxError("invalid LIMIT %q", words[3])
+			}
+			limit = n
+		}
+		return a.showDigests(limit)
+	case keyword(0) == "SHOW" && keyword(1) == "BACKENDS" && len(words) == 2:
+		return a.showBackends()
+	case keyword(0) == "SHOW" && keyword(1) == "PLUGINS" && len(words) == 2:
+		return a.showPlugins()
+	case keyword(0) == "KILL" && (len(words) == 2 || (len(words) == 3 && keyword(1) == "CONNECTION")):
+		id, err := strconv.ParseUint(words[len(words)-1], 10, 32)
+		if err != nil {
+			return nil, adminSyntaxError("invalid connection id %q", words[len(words)-1])
+		}
+		return a.kill(event, uint32(id))
+	case keyword(0) == "SET" && keyword(1) == "PLUGIN" && len(words) == 4 && (keyword(3) == "ON" || keyword(3) == "OFF"):
+		return a.setPlugin(event, words[2], keyword(3) == "ON")
+	}
+	return nil, adminSyntaxError("unknown statement, expected SHOW CONNECTIONS|DIGESTS|BACKENDS|PLUGINS, KILL <id> or SET PLUGIN <name> ON|OFF")
+}
+
+// adminSyntaxError 管理语句的语法错误
+func adminSyntaxError(format string, args ...interface{}) error {
+	return NewError(mysql.ER_PARSE_ERROR, "42000", fmt.Sprintf("proxyx: %s: %s", adminPrefix, fmt.Sprintf(format, args...)))
+}
+
+// showConnections 按连接ID列出客户端连接，执行当前语句的连接显示为 Query
+func (a *Admin) showConnections(event *QueryEvent) (*mysql.Result, error) {
+	now := time.Now()
+	a.mu.Lock()
+	rows := make([][]interface{}, 0, len(a.conns))
+	for id, c := range a.conns {
+		command, info := "Sleep", interface{}(nil)
+		database, since := c.database, c.since
+		if id == event.ConnID {
+			database, since = event.Database, event.Timestamp
+			command, info = "Query", event.Query
+		} else if c.query != "" {
+			command, info = "Query", c.query
+		}
+		rows = append(rows, []interface{}{
+			id, c.session.User, c.session.ClientAddr, nullIfEmpty(database), c.backend,
+			command, int64(now.Sub(since).Seconds()), c.statements, c.session.StartTime.Format(time.DateTime), info,
+		})
+	}
+	a.mu.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0].(uint32) < rows[j][0].(uint32) })
+	return textResult([]string{"Id", "User", "Host", "db", "Backend", "Command", "Time", "Statements", "Connected", "Info"}, rows)
+}
+
+// showDigests 按总耗时降序列出SQL指纹统计，耗时单位为毫秒，limit 为 0 时不限制
+func (a *Admin) showDigests(limit int) (*mysql.Result, error) {
+	if a.digests == nil {
+		return nil, NewError(mysql.ER_NOT_SUPPORTED_YET, "42000", "proxyx: DigestPlugin is not enabled")
+	}
+	stats := a.digests.Snapshot()
+	if limit > 0 && limit < len(stats) {
+		stats = stats[:limit]
+	}
+	rows := make([][]interface{}, 0, len(stats))
+	for _, s := range stats {
+		rows = append(rows, []interface{}{
+			s.Digest, s.Fingerprint, s.Count, s.Errors,
+			milliseconds(s.TotalTime), milliseconds(s.AvgTime), milliseconds(s.MaxTime), milliseconds(s.P95), milliseconds(s.P99),
+			s.RowsReturned, s.RowsAffected, s.LastSeen.Format(time.DateTime),
+		})
+	}
+	return textResult([]string{"Digest", "Fingerprint", "Count", "Errors", "Total_ms", "Avg_ms", "Max_ms", "P95_ms", "P99_ms", "Rows_returned", "Rows_affected", "Last_seen"}, rows)
+}
+
+// showBackends 列出主库（启用主库切换时含健康状态）和读写分离的从库，未检查的列为 NULL
+func (a *Admin) showBackends() (*mysql.Result, error) {
+	var rows [][]interface{}
+	if a.failover != nil {
+		for _, s := range a.failover.State() {
+			var lastCheck, latency, errMsg interface{}
+			if !s.LastCheck.IsZero() {
+				lastCheck, latency = s.LastCheck.Format(time.DateTime), milliseconds(s.Latency)
+			}
+			if s.Error != "" {
+				errMsg = s.Error
+			}
+			rows = append(rows, []interface{}{s.Addr, "primary", s.Priority, s.State, yesNo(s.Active), lastCheck, latency, errMsg})
+		}
+	} else {
+		rows = append(rows, []interface{}{a.target, "primary", 0, nil, yesNo(true), nil, nil, nil})
+	}
+	if a.router != nil {
+		for _, addr := range a.router.Replicas() {
+			rows = append(rows, []interface{}{addr, "replica", nil, nil, yesNo(false), nil, nil, nil})
+		}
+	}
+	return textResult([]string{"Addr", "Role", "Priority", "State", "Active", "Last_check", "Latency_ms", "Error"}, rows)
+}
+
+// showPlugins 按注册顺序列出插件
+func (a *Admin) showPlugins() (*mysql.Result, error) {
+	plugins := a.pm.Plugins()
+	rows := make([][]interface{}, 0, len(plugins))
+	for _, p := range plugins {
+		rows = append(rows, []interface{}{p.Name, yesNo(p.Enabled)})
+	}
+	return textResult([]string{"Name", "Enabled"}, rows)
+}
+
+// kill 关闭客户端连接，配置了 kill_on_disconnect 时执行中的语句也会在后端终止
+func (a *Admin) kill(event *QueryEvent, id uint32) (*mysql.Result, error) {
+	a.mu.Lock()
+	c, ok := a.conns[id]
+	a.mu.Unlock()
+	if !ok {
+		return nil, NewError(mysql.ER_NO_SUCH_THREAD, "HY000", fmt.Sprintf("proxyx: unknown connection id %d", id))
+	}
+	log.Printf("[MySQL Admin] %s@%s killed connection %d (%s@%s)", event.User, event.ClientAddr, id, c.session.User, c.session.ClientAddr)
+	c.conn.Close()
+	return &mysql.Result{}, nil
+}
+
+// setPlugin 恢复或暂停插件，名称不区分大小写，可以省略 Plugin 后缀（如 log、LogPlugin）
+func (a *Admin) setPlugin(event *QueryEvent, name string, enabled bool) (*mysql.Result, error) {
+	var matched string
+	for _, p := range a.pm.Plugins() {
+		if strings.EqualFold(p.Name, name) || strings.EqualFold(p.Name, name+"Plugin") {
+			matched = p.Name
+			break
+		}
+	}
+	if matched == "" {
+		return nil, NewError(mysql.ER_UNKNOWN_ERROR, "HY000", fmt.Sprintf("proxyx: unknown plugin %s, see %s SHOW PLUGINS", name, adminPrefix))
+	}
+	if matched == a.Name() {
+		return nil, NewError(mysql.ER_NOT_SUPPORTED_YET, "42000", "proxyx: the admin statement handler cannot be disabled")
+	}
+	a.pm.SetEnabled(matched, enabled)
+	log.Printf("[MySQL Admin] %s@%s set plugin %s enabled=%v", event.User, event.ClientAddr, matched, enabled)
+	return &mysql.Result{}, nil
+}
+
+func (a *Admin) Close() error {
+	return nil
+}
+
+// textResult 生成文本协议的结果集，所有列的类型为字符串，nil 为 NULL
+func textResult(columns []string, rows [][]interface{}) (*mysql.Result, error) {
+	rs := &mysql.Resultset{
+		Fields:     make([]*mysql.Field, len(columns)),
+		FieldNames: make(map[string]int, len(columns)),
+		Values:     make([][]mysql.FieldValue, 0, len(rows)),
+		RowDatas:   make([]mysql.RowData, 0, len(rows)),
+	}
+	for i, name := range columns {
+		rs.Fields[i] = &mysql.Field{
+			Name:    []byte(name),
+			Charset: 33, // utf8_general_ci
+			Type:    mysql.MYSQL_TYPE_VAR_STRING,
+		}
+		rs.FieldNames[name] = i
+	}
+	for _, row := range rows {
+		var data []byte
+		for _, v := range row {
+			if v == nil {
+				data = append(data, 0xfb) // NULL
+				continue
+			}
+			data = append(data, mysql.PutLengthEncodedString([]byte(fmt.Sprint(v)))...)
+		}
+		values, err := mysql.RowData(data).Parse(rs.Fields, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		rs.RowDatas = append(rs.RowDatas, data)
+		rs.Values = append(rs.Values, values)
+	}
+	return &mysql.Result{Resultset: rs}, nil
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func TestAdminIntercept(t *testing.T) {
+	pm := NewPluginManager()
+	pm.Register(NewFirewallPlugin(FirewallPluginConfig{}))
+	digests := NewDigestPlugin(DigestPluginConfig{})
+	pm.Register(digests)
+	a := NewAdmin(AdminConfig{Enabled: true, Users: []string{"root"}}, pm)
+	pm.Register(a)
+	a.SetDigests(digests)
+	a.SetBackends("primary:3306", NewRouter("primary:3306", []string{"replica:3306"}, time.Minute), nil)
+
+	for _, query := range []string{"SELECT * FROM t WHERE id = 1", "SELECT * FROM t WHERE id = 2", "UPDATE t SET a = 1"} {
+		event := &QueryEvent{Type: "query", Query: query, Duration: time.Millisecond, Timestamp: time.Now()}
+		event.Fingerprint = Fingerprint(query)
+		event.Digest = Digest(event.Fingerprint)
+		digests.OnQueryComplete(event, nil, nil)
+	}
+
+	// 被 KILL 的连接，另一端读到 EOF
+	killed, peer := net.Pipe()
+	defer peer.Close()
+	a.Track(Session{ConnID: 1, User: "root", ClientAddr: "10.0.0.1:5000", StartTime: time.Now()}, "primary:3306", nil)
+	a.Track(Session{ConnID: 2, User: "app", ClientAddr: "10.0.0.2:5000", StartTime: time.Now()}, "primary:3306", killed)
+	untrack := a.Track(Session{ConnID: 3, User: "app", ClientAddr: "10.0.0.3:5000", StartTime: time.Now()}, "primary:3306", nil)
+	untrack()
+
+	tests := []struct {
+		user     string // 为空时使用 root
+		query    string
+		wantPass bool   // 不是管理语句，交给后续插件
+		wantRows int    // 结果集的行数，-1 表示 OK 包
+		wantErr  uint16 // 期望的错误码
+	}{
+		{query: "SELECT 1", wantPass: true},
+		{query: "PROXYXX SHOW PLUGINS", wantPass: true},
+		{query: "proxyx show connections", wantRows: 2},
+		{query: "/* admin */ PROXYX SHOW PLUGINS;", wantRows: 3},
+		{query: "PROXYX SHOW BACKENDS", wantRows: 2},
+		{query: "PROXYX SHOW DIGESTS", wantRows: 2},
+		{query: "PROXYX SHOW DIGESTS LIMIT 1", wantRows: 1},
+		{query: "PROXYX SHOW DIGESTS LIMIT many", wantErr: mysql.ER_PARSE_ERROR},
+		{query: "PROXYX SET PLUGIN firewall OFF", wantRows: -1},
+		{query: "PROXYX SET PLUGIN Admin OFF", wantErr: mysql.ER_NOT_SUPPORTED_YET},
+		{query: "PROXYX SET PLUGIN nope ON", wantErr: mysql.ER_UNKNOWN_ERROR},
+		{query: "PROXYX SET PLUGIN firewall MAYBE", wantErr: mysql.ER_PARSE_ERROR},
+		{query: "PROXYX KILL 3", wantErr: mysql.ER_NO_SUCH_THREAD},
+		{query: "PROXYX KILL x", wantErr: mysql.ER_PARSE_ERROR},
+		{query: "PROXYX KILL CONNECTION 2", wantRows: -1},
+		{query: "PROXYX", wantErr: mysql.ER_PARSE_ERROR},
+		{user: "app", query: "PROXYX SHOW PLUGINS", wantErr: mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR},
+	}
+	for _, tt := range tests {
+		user := tt.user
+		if user == "" {
+			user = "root"
+		}
+		event := &QueryEvent{Type: "query", ConnID: 1, User: user, Query: tt.query, Timestamp: time.Now()}
+		result, err := a.Intercept(event)
+
+		if tt.wantPass {
+			if result != nil || err != nil || event.Type != "query" {
+				t.Errorf("%q: intercepted (%v, %v, type %s), want passed on", tt.query, result, err, event.Type)
+			}
+			continue
+		}
+		if event.Type != "admin" {
+			t.Errorf("%q: event type %s, want admin", tt.query, event.Type)
+		}
+		if tt.wantErr != 0 {
+			if myErr, ok := err.(*mysql.MyError); !ok || myErr.Code != tt.wantErr {
+				t.Errorf("%q: error %v, want code %d", tt.query, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		rows := -1
+		if result.Resultset != nil {
+			rows = len(result.RowDatas)
+		}
+		if rows != tt.wantRows {
+			t.Errorf("%q: %d rows, want %d", tt.query, rows, tt.wantRows)
+		}
+	}
+
+	for _, p := range pm.Plugins() {
+		if want := p.Name != "FirewallPlugin"; p.Enabled != want {
+			t.Errorf("plugin %s enabled = %v, want %v", p.Name, p.Enabled, want)
+		}
+	}
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("killed connection read = %v, want EOF", err)
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	tests := []struct {
+		users   []string
+		user    string
+		allowed bool
+	}{
+		{users: nil, user: "root"},
+		{users: []string{}, user: "app"},
+		{users: []string{"root", "ops"}, user: "ops", allowed: true},
+		{users: []string{"root"}, user: "app"},
+	}
+	for _, tt := range tests {
+		a := NewAdmin(AdminConfig{Enabled: true, Users: tt.users}, NewPluginManager())
+		_, err := a.Intercept(&QueryEvent{Type: "query", User: tt.user, Query: "PROXYX SHOW PLUGINS"})
+		if allowed := err == nil; allowed != tt.allowed {
+			t.Errorf("users %v, user %s: error %v, want allowed %v", tt.users, tt.user, err, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package mysql
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIChangeAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string // 配置的 api_token
+		method     string
+		auth       string // Authorization 请求头
+		wantStatus int
+		wantCORS   bool
+	}{
+		{name: "get", token: "t0k", method: http.MethodGet, wantStatus: http.StatusOK, wantCORS: true},
+		{name: "get without token configured", method: http.MethodGet, wantStatus: http.StatusOK, wantCORS: true},
+		{name: "delete", token: "t0k", method: http.MethodDelete, auth: "Bearer t0k", wantStatus: http.StatusNoContent},
+		{name: "delete without token configured", method: http.MethodDelete, auth: "Bearer ", wantStatus: http.StatusForbidden},
+		{name: "delete without authorization", token: "t0k", method: http.MethodDelete, wantStatus: http.StatusUnauthorized},
+		{name: "delete with wrong token", token: "t0k", method: http.MethodDelete, auth: "Bearer other", wantStatus: http.StatusUnauthorized},
+		{name: "delete with basic auth", token: "t0k", method: http.MethodDelete, auth: "Basic t0k", wantStatus: http.StatusUnauthorized},
+	}
+	apis := map[string]func(token string) (http.Handler, func() bool){
+		"digest": func(token string) (http.Handler, func() bool) {
+			p := NewDigestPlugin(DigestPluginConfig{MaxDigests: 10, SampleSize: 10, APIToken: token})
+			p.OnQueryComplete(&QueryEvent{Type: "query", Digest: "D1", Fingerprint: "select ?"}, nil, nil)
+			return p, func() bool { return len(p.Snapshot()) == 0 }
+		},
+		"cache": func(token string) (http.Handler, func() bool) {
+			p, err := NewCachePlugin(CachePluginConfig{APIToken: token})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p, func() bool { return p.Stats().Invalidations > 0 }
+		},
+	}
+	for api, newAPI := range apis {
+		for _, tt := range tests {
+			t.Run(api+"/"+tt.name, func(t *testing.T) {
+				handler, cleared := newAPI(tt.token)
+				r := httptest.NewRequest(tt.method, "/", nil)
+				if tt.auth != "" {
+					r.Header.Set("Authorization", tt.auth)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+
+				if w.Code != tt.wantStatus {
+					t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+				}
+				if cors := w.Header().Get("Access-Control-Allow-Origin") == "*"; cors != tt.wantCORS {
+					t.Errorf("wildcard CORS = %v, want %v", cors, tt.wantCORS)
+				}
+				if got, want := cleared(), tt.wantStatus == http.StatusNoContent; got != want {
+					t.Errorf("cleared = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
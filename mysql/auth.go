@@ -0,0 +1,142 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// UserConfig 前端用户配置，描述客户端登录代理的账号以及映射到的后端账号
+type UserConfig struct {
+	User            string `yaml:"user"`             // 客户端登录代理使用的用户名
+	Password        string `yaml:"password"`         // 客户端登录代理使用的密码
+	PasswordHash    string `yaml:"password_hash"`    // 以哈希保存的密码（mysql_native_password，* 加 40 位十六进制），与 password 二选一
+	BackendUser     string `yaml:"backend_user"`     // 连接后端使用的用户名，为空时使用 mysql_proxy.user
+	BackendPassword string `yaml:"backend_password"` // 连接后端使用的密码，backend_user 为空时使用 mysql_proxy.password
+	Target          string `yaml:"target"`           // 后端地址，为空时使用 mysql_proxy.target
+	Database        string `yaml:"database"`         // 默认数据库，为空时使用 mysql_proxy.database
+}
+
+// UserTable 前端用户表，实现 go-mysql server.CredentialProvider
+type UserTable struct {
+	users  map[string]UserConfig
+	hashes map[string][]byte // 以哈希保存密码的用户 -> SHA1(SHA1(password))
+	secret string            // 以哈希保存密码的用户提供给 go-mysql 的替代密码，进程内随机生成
+}
+
+// NewUserTable 创建用户表，用户名重复或密码哈希格式错误时返回错误
+func NewUserTable(users []UserConfig) (*UserTable, error) {
+	t := &UserTable{
+		users:  make(map[string]UserConfig, len(users)),
+		hashes: make(map[string][]byte),
+	}
+	for _, u := range users {
+		if u.User == "" {
+			return nil, fmt.Errorf("mysql user table: empty user name")
+		}
+		if _, ok := t.users[u.User]; ok {
+			return nil, fmt.Errorf("mysql user table: duplicate user %q", u.User)
+		}
+		if u.PasswordHash != "" {
+			if u.Password != "" {
+				return nil, fmt.Errorf("mysql user table: user %q has both password and password_hash", u.User)
+			}
+			hash, err := parseNativeHash(u.PasswordHash)
+			if err != nil {
+				return nil, fmt.Errorf("mysql user table: user %q: %v", u.User, err)
+			}
+			t.hashes[u.User] = hash
+		}
+		t.users[u.User] = u
+	}
+	if len(t.hashes) > 0 {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		t.secret = hex.EncodeToString(buf)
+	}
+	return t, nil
+}
+
+// WrapConn 有用户以哈希保存密码时，返回在握手阶段按哈希校验认证数据的客户端连接，否则原样返回
+// tlsConfig 为代理对客户端提供的 TLS 配置，为 nil 时不向客户端提供 TLS
+func (t *UserTable) WrapConn(conn net.Conn, tlsConfig *tls.Config) net.Conn {
+	if len(t.hashes) == 0 {
+		return conn
+	}
+	return &hashAuthConn{Conn: conn, users: t, tlsConfig: tlsConfig}
+}
+
+// CheckUsername 实现 server.CredentialProvider
+func (t *UserTable) CheckUsername(username string) (bool, error) {
+	_, ok := t.users[username]
+	return ok, nil
+}
+
+// GetCredential 实现 server.CredentialProvider，返回客户端登录代理的密码；
+// 以哈希保存密码的用户返回替代密码，客户端的认证数据已由 WrapConn 返回的连接按哈希校验并改写
+func (t *UserTable) GetCredential(username string) (string, bool, error) {
+	u, ok := t.users[username]
+	if !ok {
+		return "", false, nil
+	}
+	if _, hashed := t.hashes[username]; hashed {
+		return t.secret, true, nil
+	}
+	return u.Password, true, nil
+}
+
+// Backend 返回用户映射到的后端，未配置的字段使用 defaults
+func (t *UserTable) Backend(username string, defaults Backend) Backend {
+	u, ok := t.users[username]
+	if !ok {
+		return defaults
+	}
+
+	backend := defaults
+	if u.BackendUser != "" {
+		backend.User = u.BackendUser
+		backend.Password = u.BackendPassword
+	}
+	if u.Target != "" {
+		backend.Addr = u.Target
+	}
+	if u.Database != "" {
+		backend.Database = u.Database
+	}
+	return backend
+}
+
+// AuthRecorder 记录握手时客户端提交的用户名，认证失败时 go-mysql 不返回连接，只能从这里取得用户名
+// 每个客户端连接使用一个实例
+type AuthRecorder struct {
+	server.CredentialProvider
+	user string
+}
+
+// NewAuthRecorder 包装 CredentialProvider
+func NewAuthRecorder(provider server.CredentialProvider) *AuthRecorder {
+	return &AuthRecorder{CredentialProvider: provider}
+}
+
+// CheckUsername 实现 server.CredentialProvider
+func (r *AuthRecorder) CheckUsername(username string) (bool, error) {
+	r.user = username
+	return r.CredentialProvider.CheckUsername(username)
+}
+
+// GetCredential 实现 server.CredentialProvider
+func (r *AuthRecorder) GetCredential(username string) (string, bool, error) {
+	r.user = username
+	return r.CredentialProvider.GetCredential(username)
+}
+
+// User 返回客户端提交的用户名
+func (r *AuthRecorder) User() string {
+	return r.user
+}
@@ -0,0 +1,314 @@
+package mysql
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// parseNativeHash 解析 mysql_native_password 的密码哈希（mysql.user 中的 authentication_string，
+// 即 * 加 40 位十六进制），返回 SHA1(SHA1(password))
+func parseNativeHash(s string) ([]byte, error) {
+	if len(s) != 41 || s[0] != '*' {
+		return nil, fmt.Errorf("password_hash must be * followed by 40 hex digits")
+	}
+	hash, err := hex.DecodeString(s[1:])
+	if err != nil {
+		return nil, fmt.Errorf("password_hash must be * followed by 40 hex digits")
+	}
+	return hash, nil
+}
+
+// checkNativeToken 按 SHA1(SHA1(password)) 校验客户端的 mysql_native_password 认证数据：
+// token = SHA1(password) XOR SHA1(salt + SHA1(SHA1(password)))
+func checkNativeToken(salt, stage2, token []byte) bool {
+	if len(token) != sha1.Size {
+		return false
+	}
+	h := sha1.New()
+	h.Write(salt)
+	h.Write(stage2)
+	stage1 := h.Sum(nil)
+	for i := range stage1 {
+		stage1[i] ^= token[i]
+	}
+	sum := sha1.Sum(stage1)
+	return subtle.ConstantTimeCompare(sum[:], stage2) == 1
+}
+
+// checkCredential 按 mysql_native_password 校验用户对 salt 的认证数据，以哈希保存密码的用户按哈希校验
+func checkCredential(credentials server.CredentialProvider, user string, salt, token []byte) bool {
+	if users, ok := credentials.(*UserTable); ok {
+		if stage2, hashed := users.hashes[user]; hashed {
+			return checkNativeToken(salt, stage2, token)
+		}
+	}
+	password, found, err := credentials.GetCredential(user)
+	if err != nil || !found {
+		return false
+	}
+	if password == "" {
+		return len(token) == 0
+	}
+	return subtle.ConstantTimeCompare(mysql.CalcPassword(salt, []byte(password)), token) == 1
+}
+
+// hashAuthConn 在握手阶段校验以哈希保存的 mysql_native_password 密码
+//
+// go-mysql 的服务端认证只能用明文密码计算 scramble。对配置了 password_hash 的用户，UserTable 向 go-mysql
+// 提供一个进程内随机生成的替代密码；hashAuthConn 位于 go-mysql 与客户端之间，从握手响应（或切换认证方式
+// 后的响应）中取出客户端的认证数据按哈希校验，通过时改写为替代密码对应的认证数据，否则原样交给 go-mysql 拒绝。
+// go-mysql 的 TLS 建立在传入的连接之上，这里看不到明文，因此握手阶段的 TLS 也在这里完成；认证结束后只做转发
+type hashAuthConn struct {
+	net.Conn // 当前读写的连接：客户端连接，或在其上建立的 TLS 连接
+
+	users     *UserTable
+	tlsConfig *tls.Config // 为 nil 时不向客户端提供 TLS
+
+	done      bool   // 认证已结束（go-mysql 写出了 OK 或错误）
+	salt      []byte // 最近一次发给客户端的 scramble
+	responded bool   // 已收到握手响应
+	user      string // 握手响应中的用户名
+	switched  bool   // go-mysql 发出了切换认证方式的请求，下一个客户端包是认证数据
+	seqShift  byte   // 完成 TLS 后客户端的包序号比 go-mysql 看到的大 1（TLS 请求包不交给 go-mysql）
+	wbuf      []byte // go-mysql 写出的不完整的包
+	rbuf      []byte // 已改写、尚未交给 go-mysql 的数据
+}
+
+func (c *hashAuthConn) Read(b []byte) (int, error) {
+	if len(c.rbuf) > 0 {
+		n := copy(b, c.rbuf)
+		c.rbuf = c.rbuf[n:]
+		return n, nil
+	}
+	if c.done {
+		return c.Conn.Read(b)
+	}
+
+	packet, err := readPacket(c.Conn)
+	if err != nil {
+		return 0, err
+	}
+	payload := packet[4:]
+	switch {
+	case c.salt == nil:
+		// 握手包还没有发出，不应该收到客户端数据
+	case c.switched:
+		c.switched = false
+		if token, ok := c.rewriteToken(payload); ok {
+			packet = append(packet[:4], token...)
+			setPacketLength(packet)
+		}
+	case !c.responded && c.isSSLRequest(payload):
+		if c.tlsConfig == nil {
+			return 0, fmt.Errorf("client requested TLS, which is not offered")
+		}
+		tlsConn := tls.Server(c.Conn, c.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return 0, err
+		}
+		c.Conn = tlsConn
+		c.seqShift = 1
+		if packet, err = readPacket(c.Conn); err != nil {
+			return 0, err
+		}
+		packet = c.rewriteResponse(packet)
+	case !c.responded:
+		packet = c.rewriteResponse(packet)
+	}
+	packet[3] -= c.seqShift
+
+	n := copy(b, packet)
+	c.rbuf = packet[n:]
+	return n, nil
+}
+
+func (c *hashAuthConn) Write(b []byte) (int, error) {
+	if c.done {
+		return c.Conn.Write(b)
+	}
+	c.wbuf = append(c.wbuf, b...)
+	for len(c.wbuf) >= 4 {
+		size := 4 + int(uint32(c.wbuf[0])|uint32(c.wbuf[1])<<8|uint32(c.wbuf[2])<<16)
+		if len(c.wbuf) < size {
+			break
+		}
+		packet := c.wbuf[:size]
+		c.wbuf = c.wbuf[size:]
+
+		payload := packet[4:]
+		if c.salt == nil {
+			c.inspectHandshake(payload)
+		} else if len(payload) > 0 {
+			switch payload[0] {
+			case mysql.EOF_HEADER:
+				// AuthSwitchRequest：插件名、新的 scramble
+				if i := bytes.IndexByte(payload[1:], 0); i >= 0 {
+					salt := payload[1+i+1:]
+					c.salt = append([]byte(nil), bytes.TrimSuffix(salt, []byte{0})...)
+					c.switched = true
+				}
+			case mysql.OK_HEADER, mysql.ERR_HEADER:
+				c.done = true
+			}
+		}
+		packet[3] += c.seqShift
+		if _, err := c.Conn.Write(packet); err != nil {
+			return 0, err
+		}
+		if c.done && len(c.wbuf) > 0 {
+			rest := c.wbuf
+			c.wbuf = nil
+			if _, err := c.Conn.Write(rest); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if c.done {
+		c.wbuf = nil
+	}
+	return len(b), nil
+}
+
+// inspectHandshake 从 go-mysql 的握手包中取出 scramble；不提供 TLS 时去掉 CLIENT_SSL，
+// 客户端不会发起 go-mysql 内置的 TLS（那样这里就看不到认证数据）
+func (c *hashAuthConn) inspectHandshake(payload []byte) {
+	// 协议版本、服务器版本（以 0 结尾）、连接ID、scramble 前 8 字节、填充、能力标志低 2 字节、
+	// 字符集、状态、能力标志高 2 字节、scramble 长度、保留 10 字节、scramble 剩余部分（以 0 结尾）
+	i := bytes.IndexByte(payload[1:], 0)
+	if i < 0 {
+		c.salt = []byte{}
+		return
+	}
+	pos := 1 + i + 1 + 4
+	if len(payload) < pos+8+1+18+12 {
+		c.salt = []byte{}
+		return
+	}
+	salt := append([]byte(nil), payload[pos:pos+8]...)
+	pos += 8 + 1
+	if c.tlsConfig == nil {
+		payload[pos+1] &^= byte(mysql.CLIENT_SSL >> 8)
+	}
+	pos += 18
+	salt = append(salt, payload[pos:pos+12]...)
+	c.salt = salt
+}
+
+// isSSLRequest 判断客户端的第一个包是否为 TLS 请求（只有能力标志、最大包长度、字符集和保留字节）
+func (c *hashAuthConn) isSSLRequest(payload []byte) bool {
+	return len(payload) == 4+4+1+23 && binary.LittleEndian.Uint32(payload)&mysql.CLIENT_SSL != 0
+}
+
+// rewriteResponse 改写握手响应中的认证数据，格式与 go-mysql 解析的一致
+func (c *hashAuthConn) rewriteResponse(packet []byte) []byte {
+	c.responded = true
+	payload := packet[4:]
+	if len(payload) < 4+4+1+23+1 {
+		return packet
+	}
+	capability := binary.LittleEndian.Uint32(payload)
+	if c.seqShift > 0 {
+		// TLS 已在这里完成，go-mysql 看到的是普通的握手响应
+		capability &^= mysql.CLIENT_SSL
+		binary.LittleEndian.PutUint32(payload, capability)
+	}
+	pos := 4 + 4 + 1 + 23
+	end := bytes.IndexByte(payload[pos:], 0)
+	if end < 0 {
+		return packet
+	}
+	c.user = string(payload[pos : pos+end])
+	pos += end + 1
+
+	// 认证数据
+	var token []byte
+	var fieldLen int
+	switch {
+	case capability&mysql.CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA != 0:
+		n, isNull, size := mysql.LengthEncodedInt(payload[pos:])
+		if isNull || pos+size+int(n) > len(payload) {
+			return packet
+		}
+		token = payload[pos+size : pos+size+int(n)]
+		fieldLen = size + int(n)
+	case capability&mysql.CLIENT_SECURE_CONNECTION != 0:
+		if pos >= len(payload) || pos+1+int(payload[pos]) > len(payload) {
+			return packet
+		}
+		token = payload[pos+1 : pos+1+int(payload[pos])]
+		fieldLen = 1 + len(token)
+	default:
+		return packet
+	}
+
+	// 客户端使用其他认证方式时 go-mysql 会要求切换为 mysql_native_password，认证数据在切换后的响应中
+	rest := payload[pos+fieldLen:]
+	if capability&mysql.CLIENT_PLUGIN_AUTH != 0 {
+		plugin := rest
+		if capability&mysql.CLIENT_CONNECT_WITH_DB != 0 {
+			if i := bytes.IndexByte(plugin, 0); i >= 0 {
+				plugin = plugin[i+1:]
+			}
+		}
+		if i := bytes.IndexByte(plugin, 0); i >= 0 {
+			plugin = plugin[:i]
+		}
+		if string(plugin) != mysql.AUTH_NATIVE_PASSWORD {
+			return packet
+		}
+	}
+
+	token, ok := c.rewriteToken(token)
+	if !ok {
+		return packet
+	}
+	// 新的认证数据为 20 字节，两种编码的长度都是 1 字节
+	rewritten := make([]byte, 0, len(packet))
+	rewritten = append(rewritten, packet[:4+pos]...)
+	rewritten = append(rewritten, byte(len(token)))
+	rewritten = append(rewritten, token...)
+	rewritten = append(rewritten, rest...)
+	setPacketLength(rewritten)
+	return rewritten
+}
+
+// rewriteToken 以哈希保存密码的用户认证数据校验通过时，返回替代密码对应的认证数据；
+// 其他情况返回 false，原来的认证数据交给 go-mysql 按替代密码校验，必然失败
+func (c *hashAuthConn) rewriteToken(token []byte) ([]byte, bool) {
+	stage2, ok := c.users.hashes[c.user]
+	if !ok || !checkNativeToken(c.salt, stage2, token) {
+		return nil, false
+	}
+	return mysql.CalcPassword(c.salt, []byte(c.users.secret)), true
+}
+
+// readPacket 从 r 读取一个完整的包（含包头）
+func readPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	size := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	packet := make([]byte, 4+size)
+	copy(packet, header)
+	if _, err := io.ReadFull(r, packet[4:]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// setPacketLength 按负载长度重写包头中的长度
+func setPacketLength(packet []byte) {
+	size := len(packet) - 4
+	packet[0], packet[1], packet[2] = byte(size), byte(size>>8), byte(size>>16)
+}
@@ -0,0 +1,178 @@
+package mysql
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// nativeHash 返回 mysql_native_password 的密码哈希（* 加 40 位十六进制）
+func nativeHash(password string) string {
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+	return "*" + strings.ToUpper(hex.EncodeToString(stage2[:]))
+}
+
+// writeTestCert 在 dir 中生成自签名证书和私钥，返回文件路径
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "proxyx-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// serveHandshake 在本地端口上按 main.go 的方式接受一个客户端连接并完成握手，返回地址和握手结果
+func serveHandshake(t *testing.T, srv *server.Server, serverTLS *tls.Config, users *UserTable) (string, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	done := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		conn, err := server.NewCustomizedConn(users.WrapConn(c, serverTLS), srv, users, server.EmptyHandler{})
+		if err != nil {
+			c.Close()
+			done <- err
+			return
+		}
+		done <- nil
+		// 认证之后的命令原样转发
+		for !conn.Closed() {
+			if err := conn.HandleCommand(); err != nil {
+				break
+			}
+		}
+	}()
+	return ln.Addr().String(), done
+}
+
+func TestHashAuthHandshake(t *testing.T) {
+	users, err := NewUserTable([]UserConfig{
+		{User: "hashed", PasswordHash: nativeHash("s3cret")},
+		{User: "plain", Password: "plain-pw"},
+		{User: "nopass"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainServer, _, err := NewServer(ServerTLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	tlsServer, serverTLS, err := NewServer(ServerTLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user     string
+		password string
+		wantOK   bool
+	}{
+		{user: "hashed", password: "s3cret", wantOK: true},
+		{user: "hashed", password: "wrong"},
+		{user: "hashed", password: ""},
+		{user: "plain", password: "plain-pw", wantOK: true},
+		{user: "plain", password: "wrong"},
+		{user: "nopass", password: "", wantOK: true},
+		{user: "unknown", password: "s3cret"},
+	}
+	for _, useTLS := range []bool{false, true} {
+		for _, tt := range tests {
+			name := tt.user + "/" + tt.password
+			if useTLS {
+				name = "tls/" + name
+			}
+			t.Run(name, func(t *testing.T) {
+				srv, cfg := plainServer, (*tls.Config)(nil)
+				var options []client.Option
+				if useTLS {
+					srv, cfg = tlsServer, serverTLS
+					options = append(options, func(c *client.Conn) error {
+						c.SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+						return nil
+					})
+				}
+				addr, done := serveHandshake(t, srv, cfg, users)
+
+				conn, err := client.ConnectWithTimeout(addr, tt.user, tt.password, "", 5*time.Second, options...)
+				serverErr := <-done
+				if !tt.wantOK {
+					if err == nil {
+						conn.Close()
+						t.Fatal("client connected, want access denied")
+					}
+					if serverErr == nil {
+						t.Error("server accepted the handshake, want error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("client: %v (server: %v)", err, serverErr)
+				}
+				defer conn.Close()
+				if serverErr != nil {
+					t.Fatalf("server: %v", serverErr)
+				}
+				if err := conn.Ping(); err != nil {
+					t.Errorf("ping after handshake: %v", err)
+				}
+				if _, isTLS := conn.Conn.Conn.(*tls.Conn); useTLS != isTLS {
+					t.Errorf("client connection TLS = %v, want %v", isTLS, useTLS)
+				}
+			})
+		}
+	}
+}
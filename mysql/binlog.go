@@ -0,0 +1,613 @@
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// 行变更的类型
+const (
+	RowInsert = "insert"
+	RowUpdate = "update"
+	RowDelete = "delete"
+)
+
+// systemSchemas 未配置 tables 时不订阅的系统库
+var systemSchemas = map[string]bool{
+	"mysql": true, "sys": true, "information_schema": true, "performance_schema": true,
+}
+
+// BinlogConfig 变更数据捕获配置，以从库身份订阅 binlog，将行变更作为 binlog_row 事件交给插件
+type BinlogConfig struct {
+	Enabled    bool          `yaml:"enabled"`     // 是否启用
+	Addr       string        `yaml:"addr"`        // 订阅的MySQL地址，默认使用 target 中优先级最高的地址
+	User       string        `yaml:"user"`        // 用户名（需要 REPLICATION SLAVE、REPLICATION CLIENT 权限），默认使用 mysql_proxy.user
+	Password   string        `yaml:"password"`    // 密码
+	ServerID   uint32        `yaml:"server_id"`   // 作为从库使用的 server_id，需要与其他从库不同，默认随机
+	Flavor     string        `yaml:"flavor"`      // mysql / mariadb，默认 mysql
+	StartFile  string        `yaml:"start_file"`  // 开始订阅的 binlog 文件，默认从当前位置开始
+	StartPos   uint32        `yaml:"start_pos"`   // 开始订阅的位置（配合 start_file）
+	Tables     []string      `yaml:"tables"`      // 订阅的表，格式 db.table 或 db.*，为空时订阅系统库以外的所有表
+	MaxValue   int           `yaml:"max_value"`   // 行镜像中字符串值的最大长度，超出部分截断，默认 1024（0表示使用默认值，-1表示不截断）
+	RetryDelay time.Duration `yaml:"retry_delay"` // 连接断开后重连的间隔，默认 5s
+}
+
+// BinlogRow binlog 中的一行变更
+type BinlogRow struct {
+	Action string                 `json:"action"`           // insert / update / delete
+	Schema string                 `json:"schema"`           // 库名
+	Table  string                 `json:"table"`            // 表名
+	GTID   string                 `json:"gtid,omitempty"`   // 所属事务的 GTID，未开启 GTID 时为空
+	File   string                 `json:"file"`             // binlog 文件
+	Pos    uint32                 `json:"pos"`              // 行事件在 binlog 中的结束位置
+	Before map[string]interface{} `json:"before,omitempty"` // 变更前的行（update / delete）
+	After  map[string]interface{} `json:"after,omitempty"`  // 变更后的行（insert / update）
+}
+
+// BinlogState 订阅状态的JSON视图
+type BinlogState struct {
+	Addr      string        `json:"addr"`
+	ServerID  uint32        `json:"server_id"`
+	Connected bool          `json:"connected"`
+	File      string        `json:"file"`            // 已处理完的事务的结束位置（文件），重连时从这里继续
+	Pos       uint32        `json:"pos"`             // 已处理完的事务的结束位置（偏移）
+	GTID      string        `json:"gtid,omitempty"`  // 最近一个事务的 GTID
+	Rows      uint64        `json:"rows"`            // 产生的行变更事件数
+	LastEvent time.Time     `json:"last_event"`      // 最近一个 binlog 事件在主库上的时间
+	Lag       time.Duration `json:"lag"`             // 处理最近一个事件时落后主库的时长，收到心跳（已追上）时为 0
+	Error     string        `json:"error,omitempty"` // 最近一次断开的原因
+}
+
+// BinlogSubscriber 以从库身份订阅 binlog，将行变更作为 binlog_row 事件触发插件
+type BinlogSubscriber struct {
+	config        BinlogConfig
+	dialer        *Dialer
+	pluginManager *PluginManager
+	mask          *MaskPlugin     // 行镜像在交给插件之前脱敏，未启用数据脱敏时为 nil
+	tables        map[string]bool // 订阅的表（db.table / db.*，小写），为空时订阅所有非系统库
+
+	// 以下字段只在订阅 goroutine 中访问
+	meta    *backendConn        // 查询列名的连接，按需建立
+	columns map[string][]string // db.table -> 列名
+	gtid    string              // 当前事务的 GTID
+	thread  uint32              // 当前事务在主库上的连接ID
+	commit  time.Time           // 当前事务的提交时间
+
+	mu    sync.Mutex
+	state BinlogState
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewBinlogSubscriber 创建 binlog 订阅，未启用时返回 nil
+// mask 不为 nil 时行镜像按脱敏规则改写后再交给插件
+func NewBinlogSubscriber(config BinlogConfig, dialer *Dialer, pm *PluginManager, mask *MaskPlugin) (*BinlogSubscriber, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	s, err := newBinlogSubscriber(config, dialer, pm, mask)
+	if err != nil {
+		return nil, err
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.run()
+	return s, nil
+}
+
+// newBinlogSubscriber 检查配置并创建订阅，不开始订阅
+func newBinlogSubscriber(config BinlogConfig, dialer *Dialer, pm *PluginManager, mask *MaskPlugin) (*BinlogSubscriber, error) {
+	if _, _, err := splitAddr(config.Addr); err != nil {
+		return nil, fmt.Errorf("binlog addr %q: %v", config.Addr, err)
+	}
+	if config.Flavor == "" {
+		config.Flavor = mysql.MySQLFlavor
+	}
+	if config.Flavor != mysql.MySQLFlavor && config.Flavor != mysql.MariaDBFlavor {
+		return nil, fmt.Errorf("unknown binlog flavor %q", config.Flavor)
+	}
+	if config.ServerID == 0 {
+		config.ServerID = uint32(rand.Int31n(1<<30)) + 1<<30
+	}
+	if config.MaxValue == 0 {
+		config.MaxValue = 1024
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = 5 * time.Second
+	}
+
+	s := &BinlogSubscriber{
+		config:        config,
+		dialer:        dialer,
+		pluginManager: pm,
+		mask:          mask,
+		tables:        make(map[string]bool, len(config.Tables)),
+		columns:       make(map[string][]string),
+		done:          make(chan struct{}),
+	}
+	for _, table := range config.Tables {
+		if !strings.Contains(table, ".") {
+			return nil, fmt.Errorf("binlog table %q: expected db.table or db.*", table)
+		}
+		s.tables[strings.ToLower(table)] = true
+	}
+	s.state = BinlogState{
+		Addr:     config.Addr,
+		ServerID: config.ServerID,
+		File:     config.StartFile,
+		Pos:      config.StartPos,
+	}
+	return s, nil
+}
+
+// splitAddr 拆分 host:port
+func splitAddr(addr string) (string, uint16, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, uint16(p), nil
+}
+
+// run 订阅 binlog，断开后从最近处理完的事务的结束位置重连
+func (s *BinlogSubscriber) run() {
+	defer close(s.done)
+	defer s.closeMeta()
+
+	for {
+		err := s.sync()
+		if s.ctx.Err() != nil {
+			return
+		}
+		s.mu.Lock()
+		s.state.Connected = false
+		s.state.Error = err.Error()
+		s.mu.Unlock()
+		log.Printf("[MySQL Binlog] Subscription to %s stopped: %v, retrying in %s", s.config.Addr, err, s.config.RetryDelay)
+
+		select {
+		case <-time.After(s.config.RetryDelay):
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// sync 建立一次订阅并处理事件，直到出错或关闭
+func (s *BinlogSubscriber) sync() error {
+	s.mu.Lock()
+	pos := mysql.Position{Name: s.state.File, Pos: s.state.Pos}
+	s.mu.Unlock()
+	if pos.Name == "" {
+		current, err := s.currentPosition()
+		if err != nil {
+			return fmt.Errorf("get current binlog position: %w", err)
+		}
+		pos = current
+		s.setPosition(pos.Name, pos.Pos)
+	}
+
+	host, port, _ := splitAddr(s.config.Addr)
+	tlsConfig, err := s.dialer.TLSConfig(s.config.Addr)
+	if err != nil {
+		return err
+	}
+	syncer := replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID:         s.config.ServerID,
+		Flavor:           s.config.Flavor,
+		Host:             host,
+		Port:             port,
+		User:             s.config.User,
+		Password:         s.config.Password,
+		TLSConfig:        tlsConfig,
+		ParseTime:        true,
+		HeartbeatPeriod:  10 * time.Second,
+		ReadTimeout:      30 * time.Second,
+		DisableRetrySync: true,
+	})
+	defer syncer.Close()
+
+	streamer, err := syncer.StartSync(pos)
+	if err != nil {
+		return err
+	}
+	log.Printf("[MySQL Binlog] Subscribed to %s as server_id %d from %s:%d", s.config.Addr, s.config.ServerID, pos.Name, pos.Pos)
+	s.mu.Lock()
+	s.state.Connected = true
+	s.state.Error = ""
+	s.mu.Unlock()
+
+	// 上次断开时未处理完的事务会从头重新产生事件
+	s.gtid, s.thread = "", 0
+	for {
+		ev, err := streamer.GetEvent(s.ctx)
+		if err != nil {
+			return err
+		}
+		s.handle(ev)
+	}
+}
+
+// currentPosition 查询主库当前的 binlog 位置
+func (s *BinlogSubscriber) currentPosition() (mysql.Position, error) {
+	conn, err := s.dialer.Dial(s.config.Addr, s.config.User, s.config.Password, "")
+	if err != nil {
+		return mysql.Position{}, err
+	}
+	defer conn.Close()
+
+	// MySQL 8.4 起 SHOW MASTER STATUS 改为 SHOW BINARY LOG STATUS
+	result, err := conn.Execute("SHOW BINARY LOG STATUS")
+	if err != nil {
+		result, err = conn.Execute("SHOW MASTER STATUS")
+	}
+	if err != nil {
+		return mysql.Position{}, err
+	}
+	if result.Resultset == nil || result.RowNumber() == 0 {
+		return mysql.Position{}, fmt.Errorf("binary logging is not enabled")
+	}
+	file, err := result.GetString(0, 0)
+	if err != nil {
+		return mysql.Position{}, err
+	}
+	position, err := result.GetUint(0, 1)
+	if err != nil {
+		return mysql.Position{}, err
+	}
+	return mysql.Position{Name: file, Pos: uint32(position)}, nil
+}
+
+// handle 处理一个 binlog 事件
+func (s *BinlogSubscriber) handle(ev *replication.BinlogEvent) {
+	s.mu.Lock()
+	if ev.Header.Timestamp > 0 {
+		s.state.LastEvent = time.Unix(int64(ev.Header.Timestamp), 0)
+		s.state.Lag = max(time.Since(s.state.LastEvent), 0)
+	} else if ev.Header.EventType == replication.HEARTBEAT_EVENT {
+		s.state.Lag = 0
+	}
+	s.mu.Unlock()
+
+	switch e := ev.Event.(type) {
+	case *replication.RotateEvent:
+		s.setPosition(string(e.NextLogName), uint32(e.Position))
+	case *replication.GTIDEvent:
+		s.gtid = fmt.Sprintf("%s:%d", formatUUID(e.SID), e.GNO)
+		s.commit = time.Time{}
+		if e.ImmediateCommitTimestamp > 0 {
+			s.commit = time.UnixMicro(int64(e.ImmediateCommitTimestamp))
+		}
+		s.setGTID(s.gtid)
+	case *replication.MariadbGTIDEvent:
+		s.gtid = e.GTID.String()
+		s.commit = time.Time{}
+		s.setGTID(s.gtid)
+	case *replication.QueryEvent:
+		// ROW 格式的事务以 BEGIN 开始，记录执行事务的连接ID；其他语句（DDL）可能改变表结构
+		if strings.EqualFold(strings.TrimSpace(string(e.Query)), "BEGIN") {
+			s.thread = e.SlaveProxyID
+			return
+		}
+		s.columns = make(map[string][]string)
+		s.endTransaction(ev.Header.LogPos)
+	case *replication.XIDEvent:
+		s.endTransaction(ev.Header.LogPos)
+	case *replication.TransactionPayloadEvent:
+		// binlog_transaction_compression 压缩的事务
+		for _, inner := range e.Events {
+			s.handle(inner)
+		}
+	case *replication.RowsEvent:
+		s.handleRows(ev.Header, e)
+	}
+}
+
+// endTransaction 事务结束，记录可以从这里继续订阅的位置
+func (s *BinlogSubscriber) endTransaction(pos uint32) {
+	s.thread = 0
+	if pos == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.state.Pos = pos
+	s.mu.Unlock()
+}
+
+// handleRows 将行事件中的每一行作为 binlog_row 事件触发插件
+func (s *BinlogSubscriber) handleRows(header *replication.EventHeader, e *replication.RowsEvent) {
+	var action string
+	switch header.EventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		action = RowInsert
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2,
+		replication.PARTIAL_UPDATE_ROWS_EVENT:
+		action = RowUpdate
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		action = RowDelete
+	default:
+		return
+	}
+	schema, table := string(e.Table.Schema), string(e.Table.Table)
+	if !s.subscribed(schema, table) {
+		return
+	}
+	columns := s.columnNames(e.Table)
+
+	s.mu.Lock()
+	file := s.state.File
+	s.mu.Unlock()
+
+	timestamp := s.commit
+	if timestamp.IsZero() {
+		timestamp = time.Unix(int64(header.Timestamp), 0)
+	}
+
+	step := 1
+	if action == RowUpdate {
+		step = 2 // 变更前后的行成对出现
+	}
+	for i := 0; i+step <= len(e.Rows); i += step {
+		row := &BinlogRow{
+			Action: action,
+			Schema: schema,
+			Table:  table,
+			GTID:   s.gtid,
+			File:   file,
+			Pos:    header.LogPos,
+		}
+		switch action {
+		case RowInsert:
+			row.After = s.image(columns, e.Rows[i])
+		case RowDelete:
+			row.Before = s.image(columns, e.Rows[i])
+		case RowUpdate:
+			row.Before = s.image(columns, e.Rows[i])
+			row.After = s.image(columns, e.Rows[i+1])
+		}
+		// 脱敏在生成摘要之前，日志、Web 界面和插件都看不到原始值
+		if s.mask != nil {
+			s.mask.maskImage(schema, table, row.Before)
+			s.mask.maskImage(schema, table, row.After)
+		}
+
+		event := &QueryEvent{
+			Type:            "binlog_row",
+			Query:           row.describe(),
+			Database:        schema,
+			Backend:         s.config.Addr,
+			Timestamp:       timestamp,
+			RowCount:        1,
+			BackendThreadID: s.thread,
+			Binlog:          row,
+		}
+		s.pluginManager.OnQuery(event)
+		s.pluginManager.OnQueryComplete(event, nil, nil)
+
+		s.mu.Lock()
+		s.state.Rows++
+		s.mu.Unlock()
+	}
+}
+
+// subscribed 判断是否订阅了该表
+func (s *BinlogSubscriber) subscribed(schema, table string) bool {
+	schema, table = strings.ToLower(schema), strings.ToLower(table)
+	if len(s.tables) == 0 {
+		return !systemSchemas[schema]
+	}
+	return s.tables[schema+"."+table] || s.tables[schema+".*"]
+}
+
+// columnNames 返回表的列名：binlog_row_metadata=FULL 时取自 binlog，否则查询 information_schema，
+// 都取不到时使用 @1、@2 ...
+func (s *BinlogSubscriber) columnNames(t *replication.TableMapEvent) []string {
+	if names := t.ColumnNameString(); len(names) == int(t.ColumnCount) {
+		return names
+	}
+	key := string(t.Schema) + "." + string(t.Table)
+	if names, ok := s.columns[key]; ok {
+		return names
+	}
+
+	names, err := s.queryColumns(string(t.Schema), string(t.Table))
+	if err != nil {
+		log.Printf("[MySQL Binlog] Failed to query columns of %s: %v", key, err)
+		s.closeMeta()
+	}
+	if len(names) != int(t.ColumnCount) {
+		names = make([]string, t.ColumnCount)
+		for i := range names {
+			names[i] = "@" + strconv.Itoa(i+1)
+		}
+	}
+	// 查询失败时同样缓存，避免每一行都查询；表结构变化（DDL）时清空
+	s.columns[key] = names
+	return names
+}
+
+// queryColumns 从 information_schema 查询表的列名
+func (s *BinlogSubscriber) queryColumns(schema, table string) ([]string, error) {
+	if s.meta == nil {
+		conn, err := s.dialer.Dial(s.config.Addr, s.config.User, s.config.Password, "")
+		if err != nil {
+			return nil, err
+		}
+		s.meta = conn
+	}
+	result, err := s.meta.Execute("SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", schema, table)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, result.RowNumber())
+	for i := 0; i < result.RowNumber(); i++ {
+		name, err := result.GetString(i, 0)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (s *BinlogSubscriber) closeMeta() {
+	if s.meta != nil {
+		s.meta.Close()
+		s.meta = nil
+	}
+}
+
+// image 将一行的值按列名转为 JSON 友好的值，未记录的列（binlog_row_image=MINIMAL）为 nil
+func (s *BinlogSubscriber) image(columns []string, values []interface{}) map[string]interface{} {
+	image := make(map[string]interface{}, len(values))
+	for i, v := range values {
+		name := "@" + strconv.Itoa(i+1)
+		if i < len(columns) {
+			name = columns[i]
+		}
+		image[name] = s.value(v)
+	}
+	return image
+}
+
+// value 文本类的 []byte 转为字符串，过长的字符串截断
+func (s *BinlogSubscriber) value(v interface{}) interface{} {
+	var str string
+	switch val := v.(type) {
+	case []byte:
+		if !utf8.Valid(val) {
+			return val // JSON 中为 base64
+		}
+		str = string(val)
+	case string:
+		str = val
+	default:
+		return v
+	}
+	if s.config.MaxValue > 0 && len(str) > s.config.MaxValue {
+		cut := s.config.MaxValue
+		for cut > 0 && !utf8.RuneStart(str[cut]) {
+			cut--
+		}
+		str = str[:cut] + "..."
+	}
+	return str
+}
+
+// describe 生成在日志和 Web 界面中显示的摘要，如 UPDATE shop.orders SET status='paid' (was 'new')
+func (r *BinlogRow) describe() string {
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(r.Action))
+	b.WriteString(" ")
+	b.WriteString(r.Schema + "." + r.Table)
+
+	image := r.After
+	if r.Action == RowDelete {
+		image = r.Before
+	}
+	var parts []string
+	for _, name := range sortedKeys(image) {
+		if r.Action == RowUpdate {
+			before, after := r.Before[name], r.After[name]
+			if fmt.Sprint(before) == fmt.Sprint(after) {
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("%s=%s (was %s)", name, formatValue(after), formatValue(before)))
+			continue
+		}
+		parts = append(parts, name+"="+formatValue(image[name]))
+	}
+	if len(parts) > 0 {
+		b.WriteString(": ")
+		b.WriteString(strings.Join(parts, ", "))
+	}
+	s := b.String()
+	if len(s) > 512 {
+		cut := 512
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut] + "..."
+	}
+	return s
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + val + "'"
+	case time.Time:
+		return "'" + val.Format("2006-01-02 15:04:05.999999") + "'"
+	}
+	return fmt.Sprint(v)
+}
+
+// formatUUID 格式化 GTID 中的 server_uuid
+func formatUUID(b []byte) string {
+	if len(b) != 16 {
+		return fmt.Sprintf("%x", b)
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func (s *BinlogSubscriber) setPosition(file string, pos uint32) {
+	s.mu.Lock()
+	s.state.File, s.state.Pos = file, pos
+	s.mu.Unlock()
+}
+
+func (s *BinlogSubscriber) setGTID(gtid string) {
+	s.mu.Lock()
+	s.state.GTID = gtid
+	s.mu.Unlock()
+}
+
+// State 返回订阅状态
+func (s *BinlogSubscriber) State() BinlogState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// ServeHTTP 返回订阅状态
+func (s *BinlogSubscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(s.State())
+}
+
+// Close 停止订阅
+func (s *BinlogSubscriber) Close() {
+	s.cancel()
+	<-s.done
+}
@@ -0,0 +1,210 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// rowsEvent 构造 binlog_row_metadata=FULL 的行事件，表映射中带有列名
+func rowsEvent(eventType replication.EventType, logPos uint32, schema, table string, columns []string, rows ...[]interface{}) *replication.BinlogEvent {
+	names := make([][]byte, len(columns))
+	for i, c := range columns {
+		names[i] = []byte(c)
+	}
+	return &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: eventType, LogPos: logPos, Timestamp: uint32(time.Now().Unix())},
+		Event: &replication.RowsEvent{
+			Table: &replication.TableMapEvent{
+				Schema:      []byte(schema),
+				Table:       []byte(table),
+				ColumnCount: uint64(len(columns)),
+				ColumnName:  names,
+			},
+			Rows: rows,
+		},
+	}
+}
+
+// newTestSubscriber 创建不连接主库的订阅，事件直接交给 handle
+func newTestSubscriber(t *testing.T, config BinlogConfig, mask *MaskPlugin) (*BinlogSubscriber, *collectPlugin) {
+	t.Helper()
+	config.Addr = "primary:3306"
+	pm := NewPluginManager()
+	events := &collectPlugin{name: "collect"}
+	pm.Register(events)
+	s, err := newBinlogSubscriber(config, nil, pm, mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, events
+}
+
+func TestBinlogRows(t *testing.T) {
+	mask, err := NewMaskPlugin(MaskPluginConfig{Rules: []MaskRule{{Column: "shop.users.phone", Strategy: "redact"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []string{"id", "name", "phone"}
+	tests := []struct {
+		name      string
+		config    BinlogConfig
+		mask      bool
+		event     *replication.BinlogEvent
+		wantQuery []string // 插件收到的 binlog_row 事件的摘要
+	}{
+		{
+			name:      "insert",
+			event:     rowsEvent(replication.WRITE_ROWS_EVENTv2, 100, "shop", "users", columns, []interface{}{int64(1), []byte("ann"), nil}, []interface{}{int64(2), "bob", nil}),
+			wantQuery: []string{"INSERT shop.users: id=1, name='ann', phone=NULL", "INSERT shop.users: id=2, name='bob', phone=NULL"},
+		},
+		{
+			name: "update pairs",
+			event: rowsEvent(replication.UPDATE_ROWS_EVENTv2, 100, "shop", "users", columns,
+				[]interface{}{int64(1), "ann", nil}, []interface{}{int64(1), "anna", nil}),
+			wantQuery: []string{"UPDATE shop.users: name='anna' (was 'ann')"},
+		},
+		{
+			name:      "delete",
+			event:     rowsEvent(replication.DELETE_ROWS_EVENTv1, 100, "shop", "users", columns, []interface{}{int64(1), "ann", nil}),
+			wantQuery: []string{"DELETE shop.users: id=1, name='ann', phone=NULL"},
+		},
+		{
+			name:  "system schema skipped",
+			event: rowsEvent(replication.WRITE_ROWS_EVENTv2, 100, "mysql", "user", []string{"user"}, []interface{}{"root"}),
+		},
+		{
+			name:      "listed table",
+			config:    BinlogConfig{Tables: []string{"Shop.Users"}},
+			event:     rowsEvent(replication.WRITE_ROWS_EVENTv2, 100, "shop", "users", []string{"id"}, []interface{}{int64(1)}),
+			wantQuery: []string{"INSERT shop.users: id=1"},
+		},
+		{
+			name:   "unlisted table",
+			config: BinlogConfig{Tables: []string{"shop.users"}},
+			event:  rowsEvent(replication.WRITE_ROWS_EVENTv2, 100, "shop", "orders", []string{"id"}, []interface{}{int64(1)}),
+		},
+		{
+			name:      "schema wildcard",
+			config:    BinlogConfig{Tables: []string{"mysql.*"}},
+			event:     rowsEvent(replication.WRITE_ROWS_EVENTv2, 100, "mysql", "user", []string{"user"}, []interface{}{"root"}),
+			wantQuery: []string{"INSERT mysql.user: user='root'"},
+		},
+		{
+			name:      "max_value truncates on a character boundary",
+			config:    BinlogConfig{MaxValue: 4},
+			event:     rowsEvent(replication.WRITE_ROWS_EVENTv2, 100, "shop", "notes", []string{"body"}, []interface{}{[]byte("ab数据")}),
+			wantQuery: []string{"INSERT shop.notes: body='ab...'"},
+		},
+		{
+			name:      "masked before plugins",
+			mask:      true,
+			event:     rowsEvent(replication.UPDATE_ROWS_EVENTv2, 100, "shop", "users", columns, []interface{}{int64(1), "ann", "13800000000"}, []interface{}{int64(1), "ann", "13900000000"}),
+			wantQuery: []string{"UPDATE shop.users"},
+		},
+		{
+			name:      "unnamed column nulled when masking",
+			mask:      true,
+			event:     rowsEvent(replication.WRITE_ROWS_EVENTv2, 100, "shop", "users", columns, []interface{}{int64(1), "ann", "13800000000", "extra"}),
+			wantQuery: []string{"INSERT shop.users: @4=NULL, id=1, name='ann', phone='****'"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m *MaskPlugin
+			if tt.mask {
+				m = mask
+			}
+			s, events := newTestSubscriber(t, tt.config, m)
+			s.handle(tt.event)
+
+			var queries []string
+			for _, e := range events.events {
+				if e.Type != "binlog_row" || e.Binlog == nil || e.Database != e.Binlog.Schema {
+					t.Errorf("event %+v is not a binlog_row", e)
+				}
+				queries = append(queries, e.Query)
+				for _, image := range []map[string]interface{}{e.Binlog.Before, e.Binlog.After} {
+					if phone, ok := image["phone"]; ok && tt.mask && phone != "****" {
+						t.Errorf("plugins saw phone %v", phone)
+					}
+				}
+			}
+			if strings.Join(queries, "\n") != strings.Join(tt.wantQuery, "\n") {
+				t.Errorf("binlog_row events:\n%s\nwant:\n%s", strings.Join(queries, "\n"), strings.Join(tt.wantQuery, "\n"))
+			}
+			if rows := s.State().Rows; rows != uint64(len(tt.wantQuery)) {
+				t.Errorf("state rows = %d, want %d", rows, len(tt.wantQuery))
+			}
+		})
+	}
+}
+
+func TestBinlogValue(t *testing.T) {
+	long := strings.Repeat("x", 2000)
+	tests := []struct {
+		maxValue int
+		value    interface{}
+		want     interface{}
+	}{
+		{value: []byte("ann"), want: "ann"},
+		{value: []byte(long), want: long[:1024] + "..."},
+		{maxValue: -1, value: long, want: long},
+		{maxValue: 4, value: "ab数据", want: "ab..."},
+		{maxValue: 4, value: []byte{0xff, 0xfe, 0xfd, 0xfc, 0xfb}, want: []byte{0xff, 0xfe, 0xfd, 0xfc, 0xfb}},
+		{maxValue: 4, value: int64(123456), want: int64(123456)},
+	}
+	for _, tt := range tests {
+		s, _ := newTestSubscriber(t, BinlogConfig{MaxValue: tt.maxValue}, nil)
+		if got := s.value(tt.value); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("max_value %d: value(%.20v) = %.20v, want %.20v", tt.maxValue, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBinlogPosition(t *testing.T) {
+	s, events := newTestSubscriber(t, BinlogConfig{StartFile: "binlog.000001", StartPos: 4}, nil)
+	event := func(eventType replication.EventType, logPos uint32, e replication.Event) *replication.BinlogEvent {
+		return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: eventType, LogPos: logPos}, Event: e}
+	}
+	sid := []byte{0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x33}
+	row := func(logPos uint32) *replication.BinlogEvent {
+		return rowsEvent(replication.WRITE_ROWS_EVENTv2, logPos, "shop", "orders", []string{"id"}, []interface{}{int64(logPos)})
+	}
+
+	steps := []struct {
+		event    *replication.BinlogEvent
+		wantFile string // 处理后重连时开始订阅的位置
+		wantPos  uint32
+	}{
+		{event: event(replication.ROTATE_EVENT, 0, &replication.RotateEvent{NextLogName: []byte("binlog.000002"), Position: 4}), wantFile: "binlog.000002", wantPos: 4},
+		{event: event(replication.GTID_EVENT, 200, &replication.GTIDEvent{SID: sid, GNO: 7}), wantFile: "binlog.000002", wantPos: 4},
+		{event: event(replication.QUERY_EVENT, 300, &replication.QueryEvent{Query: []byte("BEGIN"), SlaveProxyID: 42}), wantFile: "binlog.000002", wantPos: 4},
+		// 事务未结束时断开，重连后从事务开始处重新订阅
+		{event: row(400), wantFile: "binlog.000002", wantPos: 4},
+		{event: event(replication.XID_EVENT, 450, &replication.XIDEvent{}), wantFile: "binlog.000002", wantPos: 450},
+		{event: event(replication.QUERY_EVENT, 500, &replication.QueryEvent{Query: []byte("ALTER TABLE orders ADD COLUMN note TEXT")}), wantFile: "binlog.000002", wantPos: 500},
+		{event: event(replication.QUERY_EVENT, 550, &replication.QueryEvent{Query: []byte("BEGIN")}), wantFile: "binlog.000002", wantPos: 500},
+		{event: row(600), wantFile: "binlog.000002", wantPos: 500},
+	}
+	for i, step := range steps {
+		s.handle(step.event)
+		if state := s.State(); state.File != step.wantFile || state.Pos != step.wantPos {
+			t.Errorf("step %d: resume at %s:%d, want %s:%d", i, state.File, state.Pos, step.wantFile, step.wantPos)
+		}
+	}
+
+	if len(events.events) != 2 {
+		t.Fatalf("%d binlog_row events, want 2", len(events.events))
+	}
+	first := events.events[0]
+	if first.Binlog.GTID != "3e11fa47-71ca-11e1-9e33-c80aa9429533:7" || first.BackendThreadID != 42 || first.Binlog.File != "binlog.000002" || first.Binlog.Pos != 400 {
+		t.Errorf("first row: gtid %s thread %d at %s:%d", first.Binlog.GTID, first.BackendThreadID, first.Binlog.File, first.Binlog.Pos)
+	}
+	if second := events.events[1]; second.BackendThreadID != 0 {
+		t.Errorf("second row: thread %d from the previous transaction", second.BackendThreadID)
+	}
+}
@@ -0,0 +1,268 @@
+package mysql
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/gob"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/redis/go-redis/v9"
+)
+
+// cacheStore 结果缓存的存储
+// 失效通过表版本号实现：缓存键包含语句涉及的所有表的版本号，写入时递增版本号，旧条目不再被命中，随 TTL 或 LRU 淘汰
+type cacheStore interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	// Versions 返回表的当前版本号
+	Versions(tables []string) ([]int64, error)
+	// Bump 递增表的版本号，使涉及这些表的缓存失效
+	Bump(tables []string) error
+	Close() error
+}
+
+// memoryCacheStore 进程内LRU缓存，按字节数限制容量
+type memoryCacheStore struct {
+	maxBytes int64
+
+	mu       sync.Mutex
+	used     int64
+	lru      *list.List // 最近使用的在前
+	items    map[string]*list.Element
+	versions map[string]int64
+}
+
+type memoryCacheItem struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newMemoryCacheStore(maxBytes int64) *memoryCacheStore {
+	return &memoryCacheStore{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+		versions: make(map[string]int64),
+	}
+}
+
+func (s *memoryCacheStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*memoryCacheItem)
+	if time.Now().After(item.expires) {
+		s.remove(elem)
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+	return item.value, true
+}
+
+func (s *memoryCacheStore) Set(key string, value []byte, ttl time.Duration) {
+	size := int64(len(key) + len(value))
+	if size > s.maxBytes {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+	for s.used+size > s.maxBytes && s.lru.Len() > 0 {
+		s.remove(s.lru.Back())
+	}
+	s.items[key] = s.lru.PushFront(&memoryCacheItem{key: key, value: value, expires: time.Now().Add(ttl)})
+	s.used += size
+}
+
+// remove 删除条目，调用方需持有锁
+func (s *memoryCacheStore) remove(elem *list.Element) {
+	item := s.lru.Remove(elem).(*memoryCacheItem)
+	delete(s.items, item.key)
+	s.used -= int64(len(item.key) + len(item.value))
+}
+
+func (s *memoryCacheStore) Versions(tables []string) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := make([]int64, len(tables))
+	for i, t := range tables {
+		versions[i] = s.versions[t]
+	}
+	return versions, nil
+}
+
+func (s *memoryCacheStore) Bump(tables []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range tables {
+		s.versions[t]++
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) Close() error {
+	return nil
+}
+
+// redisCacheStore 存放在Redis中的缓存，多个代理实例共享缓存和表版本号
+type redisCacheStore struct {
+	client *redis.Client
+	prefix string
+	ctx    context.Context
+}
+
+func newRedisCacheStore(config CacheRedisConfig) (*redisCacheStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.Addr,
+		Password: config.Password,
+		DB:       config.DB,
+	})
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &redisCacheStore{
+		client: client,
+		prefix: config.KeyPrefix,
+		ctx:    ctx,
+	}, nil
+}
+
+func (s *redisCacheStore) Get(key string) ([]byte, bool) {
+	value, err := s.client.Get(s.ctx, s.prefix+"result:"+key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (s *redisCacheStore) Set(key string, value []byte, ttl time.Duration) {
+	s.client.Set(s.ctx, s.prefix+"result:"+key, value, ttl)
+}
+
+func (s *redisCacheStore) Versions(tables []string) ([]int64, error) {
+	keys := make([]string, len(tables))
+	for i, t := range tables {
+		keys[i] = s.prefix + "version:" + t
+	}
+	values, err := s.client.MGet(s.ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int64, len(tables))
+	for i, v := range values {
+		if str, ok := v.(string); ok {
+			versions[i], _ = strconv.ParseInt(str, 10, 64)
+		}
+	}
+	return versions, nil
+}
+
+func (s *redisCacheStore) Bump(tables []string) error {
+	pipe := s.client.Pipeline()
+	for _, t := range tables {
+		pipe.Incr(s.ctx, s.prefix+"version:"+t)
+	}
+	_, err := pipe.Exec(s.ctx)
+	return err
+}
+
+func (s *redisCacheStore) Close() error {
+	return s.client.Close()
+}
+
+// cachedResult mysql.Result 的序列化形式，保存列定义和原始行数据，原样写回客户端
+type cachedResult struct {
+	Status       uint16
+	Warnings     uint16
+	InsertId     uint64
+	AffectedRows uint64
+	Fields       [][]byte
+	Rows         [][]byte
+}
+
+// cacheSessionStatus 描述会话状态的状态位（IN_TRANS、AUTOCOMMIT、IN_TRANS_READONLY、SESSION_STATE_CHANGED），不随结果缓存
+const cacheSessionStatus = mysql.SERVER_STATUS_IN_TRANS | mysql.SERVER_STATUS_AUTOCOMMIT | 0x2000 | 0x4000
+
+// encodeResult 序列化结果集，不保存会话状态位
+func encodeResult(result *mysql.Result) ([]byte, error) {
+	cached := cachedResult{
+		Status:       result.Status &^ cacheSessionStatus,
+		Warnings:     result.Warnings,
+		InsertId:     result.InsertId,
+		AffectedRows: result.AffectedRows,
+		Fields:       make([][]byte, len(result.Fields)),
+		Rows:         make([][]byte, len(result.RowDatas)),
+	}
+	for i, f := range result.Fields {
+		cached.Fields[i] = f.Dump()
+	}
+	for i, row := range result.RowDatas {
+		cached.Rows[i] = row
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&cached); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeResult 反序列化结果集，binary 表示行数据是否为二进制协议（预处理语句）格式，状态位按自动提交、不在事务中设置
+func decodeResult(data []byte, binary bool) (*mysql.Result, error) {
+	var cached cachedResult
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cached); err != nil {
+		return nil, err
+	}
+
+	rs := &mysql.Resultset{
+		Fields:     make([]*mysql.Field, len(cached.Fields)),
+		FieldNames: make(map[string]int, len(cached.Fields)),
+		Values:     make([][]mysql.FieldValue, len(cached.Rows)),
+		RowDatas:   make([]mysql.RowData, len(cached.Rows)),
+	}
+	for i, data := range cached.Fields {
+		f, err := mysql.FieldData(data).Parse()
+		if err != nil {
+			return nil, err
+		}
+		rs.Fields[i] = f
+		rs.FieldNames[string(f.Name)] = i
+	}
+	for i, row := range cached.Rows {
+		rs.RowDatas[i] = row
+		values, err := rs.RowDatas[i].Parse(rs.Fields, binary, nil)
+		if err != nil {
+			return nil, err
+		}
+		rs.Values[i] = values
+	}
+
+	// 只有自动提交模式下、事务之外的语句会命中缓存
+	return &mysql.Result{
+		Status:       cached.Status | mysql.SERVER_STATUS_AUTOCOMMIT,
+		Warnings:     cached.Warnings,
+		InsertId:     cached.InsertId,
+		AffectedRows: cached.AffectedRows,
+		Resultset:    rs,
+	}, nil
+}
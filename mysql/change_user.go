@@ -0,0 +1,233 @@
+package mysql
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// errResponded 响应已由代理直接写给客户端，go-mysql 随后为该命令写出的错误包被丢弃
+var errResponded = errors.New("proxyx: response already written")
+
+// responseConn go-mysql 服务端连接使用的底层连接（TLS 之上），代理直接写出响应后丢弃 go-mysql 的响应
+// go-mysql 每次 Write 写出一个完整的包，丢弃一次 Write 即丢弃一个包；
+// 读取时按包读取，命令包在交给 go-mysql 之前先交给 inspect
+type responseConn struct {
+	net.Conn
+	skip       bool                 // 丢弃下一次写出
+	closeAfter bool                 // 下一次写出后断开连接
+	inspect    func(payload []byte) // 查看（可以就地修改）客户端发来的命令，为 nil 时不查看
+	rbuf       []byte               // 已读取、尚未交给 go-mysql 的数据
+}
+
+func (c *responseConn) Read(b []byte) (int, error) {
+	if len(c.rbuf) == 0 {
+		packet, err := readPacket(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		// 序号为 0 的包是一个新命令，认证交互和大于 16MB 的命令的后续包序号不为 0
+		if packet[3] == 0 && len(packet) > 4 && c.inspect != nil {
+			c.inspect(packet[4:])
+		}
+		c.rbuf = packet
+	}
+	n := copy(b, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *responseConn) Write(b []byte) (int, error) {
+	if c.skip {
+		c.skip = false
+		return len(b), nil
+	}
+	n, err := c.Conn.Write(b)
+	if c.closeAfter {
+		c.Conn.Close()
+	}
+	return n, err
+}
+
+// Attach 设置认证通过后的 go-mysql 服务端连接，需要自行写出响应的命令（COM_CHANGE_USER、COM_STATISTICS、
+// COM_PROCESS_INFO、COM_STMT_FETCH）通过它应答；credentials 和 defaults 用于 COM_CHANGE_USER 重新认证和映射后端
+// 未调用 Attach 时这些命令返回不支持
+func (h *Handler) Attach(conn *server.Conn, credentials server.CredentialProvider, defaults Backend) {
+	h.out = &responseConn{Conn: conn.Conn.Conn, inspect: h.inspectCommand}
+	conn.Conn.Conn = h.out
+	h.server = conn
+	h.credentials = credentials
+	h.defaults = defaults
+}
+
+// respond 按顺序写出响应包（包头 4 字节由 go-mysql 填写），返回 errResponded
+func (h *Handler) respond(packets ...[]byte) error {
+	for _, packet := range packets {
+		if err := h.server.WritePacket(packet); err != nil {
+			return err
+		}
+	}
+	return errResponded
+}
+
+// respondResultset 以文本协议写出结果集，格式与 go-mysql 写出的结果集相同（列定义和行之后各有一个 EOF 包）
+func (h *Handler) respondResultset(rs *mysql.Resultset) error {
+	packets := make([][]byte, 0, len(rs.Fields)+len(rs.RowDatas)+3)
+	packets = append(packets, append(make([]byte, 4), mysql.PutLengthEncodedInt(uint64(len(rs.Fields)))...))
+	for _, f := range rs.Fields {
+		packets = append(packets, append(make([]byte, 4), f.Dump()...))
+	}
+	packets = append(packets, h.eofPacket(0))
+	for _, row := range rs.RowDatas {
+		packets = append(packets, append(make([]byte, 4), row...))
+	}
+	packets = append(packets, h.eofPacket(0))
+	return h.respond(packets...)
+}
+
+// eofPacket 生成带有会话状态的 EOF 包，status 为额外的状态标志（如游标状态）
+func (h *Handler) eofPacket(status uint16) []byte {
+	packet := []byte{0, 0, 0, 0, mysql.EOF_HEADER}
+	if h.server.Capability()&mysql.CLIENT_PROTOCOL_41 == 0 {
+		return packet
+	}
+	if h.primary == nil || h.primary.IsAutoCommit() {
+		status |= mysql.SERVER_STATUS_AUTOCOMMIT
+	}
+	if h.tx != nil {
+		status |= mysql.SERVER_STATUS_IN_TRANS
+	}
+	return append(packet, 0, 0, byte(status), byte(status>>8))
+}
+
+// statistics 处理 COM_STATISTICS：转发到主库，后端返回的状态字符串原样写给客户端
+func (h *Handler) statistics() error {
+	var resp []byte
+	err := h.withPrimary(func(conn *backendConn) error {
+		var err error
+		resp, err = conn.request(mysql.COM_STATISTICS, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return h.respond(append(make([]byte, 4, 4+len(resp)), resp...))
+}
+
+// processInfo 处理 COM_PROCESS_INFO（旧协议的 SHOW PROCESSLIST）：只返回当前会话，连接ID为代理分配的ID
+// 后端的线程列表中是代理的连接（连接池模式下由多个会话共用），不返回给客户端
+func (h *Handler) processInfo() error {
+	result, err := textResult(
+		[]string{"Id", "User", "Host", "db", "Command", "Time", "State", "Info"},
+		[][]interface{}{{
+			h.session.ConnID, h.session.User, h.session.ClientAddr, nullIfEmpty(h.currentDB),
+			"Query", 0, "executing", "SHOW PROCESSLIST",
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	return h.respondResultset(result.Resultset)
+}
+
+// changeUser 处理 COM_CHANGE_USER：要求客户端切换为 mysql_native_password，用新的 scramble 重新认证
+// （握手时的 scramble 保存在 go-mysql 内部，无法校验命令中携带的认证数据）
+// 认证通过后按新用户映射后端并重置会话：与 MySQL 一致回滚未结束的事务，关闭持有的后端连接，
+// 清空记录的 SET 语句、预处理语句和会话固定状态，当前数据库切换为命令中指定的数据库；
+// 认证失败时返回错误并断开连接
+func (h *Handler) changeUser(data []byte) error {
+	user, db, ok := parseChangeUser(data, h.server.Capability())
+	if !ok {
+		return NewError(mysql.ER_UNKNOWN_ERROR, "HY000", "proxyx: malformed COM_CHANGE_USER")
+	}
+
+	// AuthSwitchRequest：0xFE、插件名、新的 scramble
+	salt := mysql.RandomBuf(20)
+	req := make([]byte, 4, 4+1+len(mysql.AUTH_NATIVE_PASSWORD)+1+len(salt)+1)
+	req = append(req, mysql.EOF_HEADER)
+	req = append(req, mysql.AUTH_NATIVE_PASSWORD...)
+	req = append(req, 0)
+	req = append(req, salt...)
+	req = append(req, 0)
+	if err := h.server.WritePacket(req); err != nil {
+		return err
+	}
+	token, err := h.server.ReadPacket()
+	if err != nil {
+		return err
+	}
+
+	if !checkCredential(h.credentials, user, salt, token) {
+		host, _, _ := net.SplitHostPort(h.session.ClientAddr)
+		err := NewError(mysql.ER_ACCESS_DENIED_ERROR, "28000",
+			fmt.Sprintf("Access denied for user '%s'@'%s' (using password: %s)", user, host, yesNo(len(token) > 0)))
+		event := h.ConnEvent("auth_failure", h.target, err)
+		event.User = user
+		h.pluginManager.OnAuthFailure(event)
+		h.out.closeAfter = true
+		return err
+	}
+
+	if h.tx != nil {
+		h.emitTransaction(h.endTransaction(TxChangeUser))
+	}
+	h.sets = nil
+	h.pinned = false
+	h.holding = false
+	h.stickyUntil = time.Time{}
+	h.stmtRefs = make(map[string]int)
+	h.cursors = make(map[uint32]*stmtCursor)
+	h.discardConns()
+
+	backend := h.defaults
+	if users, ok := h.credentials.(*UserTable); ok {
+		backend = users.Backend(user, backend)
+	}
+	log.Printf("[MySQL] Connection %d changed user from %s to %s", h.session.ConnID, h.session.User, user)
+	session := h.session
+	session.User = user
+	h.currentDB = db
+	// 与连接时一致，后端不可用时仍然完成切换，之后的语句返回错误
+	err = h.Connect(session, backend)
+	if err != nil {
+		log.Printf("Failed to connect to MySQL: %v", err)
+	}
+	h.pluginManager.OnConnect(h.ConnEvent("change_user", h.target, err))
+	return nil
+}
+
+// parseChangeUser 解析 COM_CHANGE_USER 的用户名和数据库，其后的字符集、认证插件和连接属性不使用
+func parseChangeUser(data []byte, capability uint32) (user, db string, ok bool) {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return "", "", false
+	}
+	user = string(data[:i])
+	data = data[i+1:]
+
+	// 认证数据
+	if capability&mysql.CLIENT_SECURE_CONNECTION != 0 {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return "", "", false
+		}
+		data = data[1+int(data[0]):]
+	} else {
+		if i = bytes.IndexByte(data, 0); i < 0 {
+			return "", "", false
+		}
+		data = data[i+1:]
+	}
+
+	if i = bytes.IndexByte(data, 0); i >= 0 {
+		db = string(data[:i])
+	} else {
+		db = string(data)
+	}
+	return user, db, true
+}
@@ -0,0 +1,255 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// commandNames MySQL协议命令的名称
+var commandNames = map[byte]string{
+	mysql.COM_SLEEP:               "COM_SLEEP",
+	mysql.COM_QUIT:                "COM_QUIT",
+	mysql.COM_INIT_DB:             "COM_INIT_DB",
+	mysql.COM_QUERY:               "COM_QUERY",
+	mysql.COM_FIELD_LIST:          "COM_FIELD_LIST",
+	mysql.COM_CREATE_DB:           "COM_CREATE_DB",
+	mysql.COM_DROP_DB:             "COM_DROP_DB",
+	mysql.COM_REFRESH:             "COM_REFRESH",
+	mysql.COM_SHUTDOWN:            "COM_SHUTDOWN",
+	mysql.COM_STATISTICS:          "COM_STATISTICS",
+	mysql.COM_PROCESS_INFO:        "COM_PROCESS_INFO",
+	mysql.COM_CONNECT:             "COM_CONNECT",
+	mysql.COM_PROCESS_KILL:        "COM_PROCESS_KILL",
+	mysql.COM_DEBUG:               "COM_DEBUG",
+	mysql.COM_PING:                "COM_PING",
+	mysql.COM_TIME:                "COM_TIME",
+	mysql.COM_DELAYED_INSERT:      "COM_DELAYED_INSERT",
+	mysql.COM_CHANGE_USER:         "COM_CHANGE_USER",
+	mysql.COM_BINLOG_DUMP:         "COM_BINLOG_DUMP",
+	mysql.COM_TABLE_DUMP:          "COM_TABLE_DUMP",
+	mysql.COM_CONNECT_OUT:         "COM_CONNECT_OUT",
+	mysql.COM_REGISTER_SLAVE:      "COM_REGISTER_SLAVE",
+	mysql.COM_STMT_PREPARE:        "COM_STMT_PREPARE",
+	mysql.COM_STMT_EXECUTE:        "COM_STMT_EXECUTE",
+	mysql.COM_STMT_SEND_LONG_DATA: "COM_STMT_SEND_LONG_DATA",
+	mysql.COM_STMT_CLOSE:          "COM_STMT_CLOSE",
+	mysql.COM_STMT_RESET:          "COM_STMT_RESET",
+	mysql.COM_SET_OPTION:          "COM_SET_OPTION",
+	mysql.COM_STMT_FETCH:          "COM_STMT_FETCH",
+	mysql.COM_DAEMON:              "COM_DAEMON",
+	mysql.COM_BINLOG_DUMP_GTID:    "COM_BINLOG_DUMP_GTID",
+	mysql.COM_RESET_CONNECTION:    "COM_RESET_CONNECTION",
+}
+
+// CommandName 返回命令的名称，如 COM_RESET_CONNECTION
+func CommandName(cmd byte) string {
+	if name, ok := commandNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("COM_UNKNOWN(0x%02x)", cmd)
+}
+
+// commandEventType 返回命令对应的事件类型，如 reset_connection
+func commandEventType(cmd byte) string {
+	if name, ok := commandNames[cmd]; ok {
+		return strings.ToLower(strings.TrimPrefix(name, "COM_"))
+	}
+	return "unknown_command"
+}
+
+// describeCommand 生成命令的可读描述，记录在事件的 Query 中
+func describeCommand(cmd byte, data []byte) string {
+	name := CommandName(cmd)
+	switch cmd {
+	case mysql.COM_PROCESS_KILL:
+		if len(data) >= 4 {
+			return fmt.Sprintf("%s %d", name, binary.LittleEndian.Uint32(data))
+		}
+	case mysql.COM_STMT_FETCH:
+		if len(data) >= 8 {
+			return fmt.Sprintf("%s stmt=%d rows=%d", name, binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint32(data[4:]))
+		}
+	case mysql.COM_SET_OPTION:
+		if len(data) >= 2 {
+			switch option := binary.LittleEndian.Uint16(data); option {
+			case mysql.MYSQL_OPTION_MULTI_STATEMENTS_ON:
+				return name + " MULTI_STATEMENTS_ON"
+			case mysql.MYSQL_OPTION_MULTI_STATEMENTS_OFF:
+				return name + " MULTI_STATEMENTS_OFF"
+			default:
+				return fmt.Sprintf("%s %d", name, option)
+			}
+		}
+	case mysql.COM_CREATE_DB, mysql.COM_DROP_DB:
+		return name + " " + string(data)
+	case mysql.COM_CHANGE_USER:
+		if i := strings.IndexByte(string(data), 0); i >= 0 {
+			return name + " " + string(data[:i])
+		}
+	case mysql.COM_REFRESH:
+		if len(data) >= 1 {
+			return fmt.Sprintf("%s 0x%02x", name, data[0])
+		}
+	}
+	return name
+}
+
+// HandleOtherCommand 处理 go-mysql 服务端没有单独回调的命令
+// 只返回 OK/ERR 的命令转发到主库；需要返回其他格式响应或额外认证交互的命令由代理通过 Attach 设置的连接直接应答，
+// 未设置时返回明确的错误
+// COM_STMT_SEND_LONG_DATA / COM_STMT_RESET 由 go-mysql 服务端处理，长数据合并到参数中随 COM_STMT_EXECUTE 转发，不会到达这里
+func (h *Handler) HandleOtherCommand(cmd byte, data []byte) error {
+	event := h.newEvent(commandEventType(cmd), describeCommand(cmd, data))
+	h.pluginManager.OnQuery(event)
+
+	startTime := time.Now()
+	respErr := h.dispatchCommand(cmd, data)
+	event.Duration = time.Since(startTime)
+	event.BackendThreadID = h.backendThread
+	err := respErr
+	if respErr == errResponded {
+		h.out.skip = true
+		err = nil
+	}
+	summary := h.trackTransaction(event, nil, err)
+
+	h.pluginManager.OnQueryComplete(event, nil, err)
+	h.emitTransaction(summary)
+	return respErr
+}
+
+func (h *Handler) dispatchCommand(cmd byte, data []byte) error {
+	switch cmd {
+	case mysql.COM_RESET_CONNECTION:
+		return h.resetConnection()
+
+	case mysql.COM_PROCESS_KILL:
+		// 连接ID是代理分配的，按会话表找到对应的会话，不能原样转发到后端
+		if len(data) < 4 {
+			return NewError(mysql.ER_UNKNOWN_ERROR, "HY000", "proxyx: malformed COM_PROCESS_KILL")
+		}
+		return h.kill(binary.LittleEndian.Uint32(data), false)
+
+	case mysql.COM_REFRESH, mysql.COM_DEBUG, mysql.COM_SHUTDOWN,
+		mysql.COM_CREATE_DB, mysql.COM_DROP_DB:
+		return h.withPrimary(func(conn *backendConn) error {
+			return conn.command(cmd, data)
+		})
+
+	case mysql.COM_SET_OPTION:
+		// 后端连接由 go-mysql 客户端管理，不支持多语句和多结果集：关闭多语句与代理的行为一致，开启时拒绝
+		if len(data) < 2 {
+			return NewError(mysql.ER_UNKNOWN_ERROR, "HY000", "proxyx: malformed COM_SET_OPTION")
+		}
+		if binary.LittleEndian.Uint16(data) != mysql.MYSQL_OPTION_MULTI_STATEMENTS_OFF {
+			return NewError(mysql.ER_NOT_SUPPORTED_YET, "42000", "proxyx: multi-statements are not supported")
+		}
+		return nil
+
+	case mysql.COM_CHANGE_USER, mysql.COM_STATISTICS, mysql.COM_PROCESS_INFO, mysql.COM_STMT_FETCH:
+		// 需要返回其他格式的响应或额外的认证交互，由代理直接写给客户端
+		if h.server == nil {
+			return NewError(mysql.ER_NOT_SUPPORTED_YET, "42000", fmt.Sprintf("proxyx: %s is not supported", CommandName(cmd)))
+		}
+		switch cmd {
+		case mysql.COM_CHANGE_USER:
+			return h.changeUser(data)
+		case mysql.COM_STATISTICS:
+			return h.statistics()
+		case mysql.COM_STMT_FETCH:
+			return h.fetch(data)
+		default:
+			return h.processInfo()
+		}
+
+	case mysql.COM_BINLOG_DUMP, mysql.COM_BINLOG_DUMP_GTID, mysql.COM_REGISTER_SLAVE, mysql.COM_TABLE_DUMP:
+		return NewError(mysql.ER_NOT_SUPPORTED_YET, "42000", fmt.Sprintf("proxyx: %s is not supported", CommandName(cmd)))
+	}
+	return NewError(mysql.ER_UNKNOWN_COM_ERROR, "08S01", fmt.Sprintf("proxyx: unknown command %s", CommandName(cmd)))
+}
+
+// resetConnection 处理 COM_RESET_CONNECTION：重置持有的后端连接，并清空记录的会话状态
+// 与 MySQL 一致，当前数据库保持不变
+func (h *Handler) resetConnection() error {
+	h.sets = nil
+	h.pinned = false
+	h.holding = false
+	h.stickyUntil = time.Time{}
+	h.stmtRefs = make(map[string]int)
+	h.cursors = make(map[uint32]*stmtCursor)
+
+	if h.replica != nil {
+		h.discard(h.replica)
+		h.replica = nil
+	}
+	for addr, conn := range h.shardConns {
+		h.discard(conn)
+		delete(h.shardConns, addr)
+	}
+	// 连接池模式下未持有连接时，下一条语句会租用没有额外会话状态的连接
+	if h.primary == nil {
+		return nil
+	}
+
+	conn := h.primary
+	err := conn.command(mysql.COM_RESET_CONNECTION, nil)
+	if err == nil {
+		// 后端已释放该连接上的预处理语句和会话变量
+		conn.sets = nil
+		conn.stmts = make(map[string]*client.Stmt)
+		// 刷新客户端记录的事务状态
+		err = conn.Ping()
+	}
+	h.releasePrimary(err)
+	if isConnError(err) && conn.pool == nil {
+		h.discard(conn)
+		h.primary = nil
+	}
+	return err
+}
+
+// command 在后端连接上发送一条只返回 OK/ERR 的命令
+func (c *backendConn) command(cmd byte, data []byte) error {
+	_, err := c.request(cmd, data)
+	return err
+}
+
+// request 在后端连接上发送一条只返回一个响应包的命令，返回响应包的内容；响应为 ERR 时返回错误
+func (c *backendConn) request(cmd byte, data []byte) ([]byte, error) {
+	c.ResetSequence()
+	packet := make([]byte, 4, 5+len(data))
+	packet = append(packet, cmd)
+	packet = append(packet, data...)
+	if err := c.WritePacket(packet); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) > 0 && resp[0] == mysql.ERR_HEADER {
+		return nil, parseErrPacket(resp)
+	}
+	return resp, nil
+}
+
+// parseErrPacket 解析ERR包
+func parseErrPacket(data []byte) error {
+	if len(data) < 3 {
+		return NewError(mysql.ER_UNKNOWN_ERROR, "HY000", "malformed error packet")
+	}
+	code := binary.LittleEndian.Uint16(data[1:3])
+	data = data[3:]
+	state := ""
+	if len(data) >= 6 && data[0] == '#' {
+		state = string(data[1:6])
+		data = data[6:]
+	}
+	return NewError(code, state, string(data))
+}
@@ -0,0 +1,40 @@
+package mysql
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// CountingConn 统计读写字节数的客户端连接
+type CountingConn struct {
+	net.Conn
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+// NewCountingConn 包装客户端连接
+func NewCountingConn(conn net.Conn) *CountingConn {
+	return &CountingConn{Conn: conn}
+}
+
+func (c *CountingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (c *CountingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesOut.Add(int64(n))
+	return n, err
+}
+
+// BytesIn 返回从客户端读取的字节数
+func (c *CountingConn) BytesIn() int64 {
+	return c.bytesIn.Load()
+}
+
+// BytesOut 返回发送给客户端的字节数
+func (c *CountingConn) BytesOut() int64 {
+	return c.bytesOut.Load()
+}
@@ -0,0 +1,85 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// cursorTypes COM_STMT_EXECUTE 标志中的游标类型（CURSOR_TYPE_READ_ONLY、CURSOR_TYPE_FOR_UPDATE、CURSOR_TYPE_SCROLLABLE）
+const cursorTypes = 0x07
+
+// stmtCursor 客户端语句上打开的游标
+//
+// 代理执行预处理语句时总是读取完整的结果集（插件按完整的结果集脱敏、缓存和统计），客户端请求游标时
+// 只写出列定义，行保存在游标中，由 COM_STMT_FETCH 分批取回；后端连接不因游标而被占用
+type stmtCursor struct {
+	rows []mysql.RowData // 尚未取回的行（二进制协议）
+}
+
+// inspectCommand 在 go-mysql 处理客户端命令之前查看命令
+// go-mysql 只接受不带游标的 COM_STMT_EXECUTE，请求游标时清除标志并记录，由 HandleStmtExecute 打开游标；
+// 语句重新执行、COM_STMT_RESET 和 COM_STMT_CLOSE 关闭语句上的游标
+func (h *Handler) inspectCommand(payload []byte) {
+	h.openCursor = false
+	if len(payload) < 5 {
+		return
+	}
+	id := binary.LittleEndian.Uint32(payload[1:5])
+	switch payload[0] {
+	case mysql.COM_STMT_EXECUTE:
+		delete(h.cursors, id)
+		if len(payload) > 5 && payload[5]&cursorTypes != 0 {
+			payload[5] &^= cursorTypes
+			h.openCursor = true
+			h.cursorStmt = id
+		}
+	case mysql.COM_STMT_RESET, mysql.COM_STMT_CLOSE:
+		delete(h.cursors, id)
+	}
+}
+
+// respondCursor 为请求了游标的 COM_STMT_EXECUTE 打开游标：写出列定义和带 SERVER_STATUS_CURSOR_EXISTS 的 EOF，
+// 不写出行，返回 errResponded
+func (h *Handler) respondCursor(rs *mysql.Resultset) error {
+	h.cursors[h.cursorStmt] = &stmtCursor{rows: rs.RowDatas}
+
+	packets := make([][]byte, 0, len(rs.Fields)+2)
+	packets = append(packets, append(make([]byte, 4), mysql.PutLengthEncodedInt(uint64(len(rs.Fields)))...))
+	for _, f := range rs.Fields {
+		packets = append(packets, append(make([]byte, 4), f.Dump()...))
+	}
+	packets = append(packets, h.eofPacket(mysql.SERVER_STATUS_CURSOR_EXISTS))
+	return h.respond(packets...)
+}
+
+// fetch 处理 COM_STMT_FETCH：从游标中取出最多请求数量的行，
+// 取完最后一行时 EOF 带 SERVER_STATUS_LAST_ROW_SEND 并关闭游标
+func (h *Handler) fetch(data []byte) error {
+	if len(data) < 8 {
+		return NewError(mysql.ER_UNKNOWN_ERROR, "HY000", "proxyx: malformed COM_STMT_FETCH")
+	}
+	id := binary.LittleEndian.Uint32(data)
+	cursor, ok := h.cursors[id]
+	if !ok {
+		return NewError(mysql.ER_STMT_HAS_NO_OPEN_CURSOR, "HY000", fmt.Sprintf("The statement (%d) has no open cursor.", id))
+	}
+
+	n := len(cursor.rows)
+	if want := binary.LittleEndian.Uint32(data[4:]); uint64(want) < uint64(n) {
+		n = int(want)
+	}
+	packets := make([][]byte, 0, n+1)
+	for _, row := range cursor.rows[:n] {
+		packets = append(packets, append(make([]byte, 4), row...))
+	}
+	cursor.rows = cursor.rows[n:]
+
+	status := mysql.SERVER_STATUS_CURSOR_EXISTS
+	if len(cursor.rows) == 0 {
+		status = mysql.SERVER_STATUS_LAST_ROW_SEND
+		delete(h.cursors, id)
+	}
+	return h.respond(append(packets, h.eofPacket(status))...)
+}
@@ -0,0 +1,302 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// stmtBackend 只支持预处理语句的后端，每次执行都返回同一个结果集
+type stmtBackend struct {
+	server.EmptyHandler
+	result *mysql.Result
+}
+
+func (b *stmtBackend) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return 0, len(b.result.Fields), nil, nil
+}
+
+func (b *stmtBackend) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	return b.result, nil
+}
+
+// serve 在本地端口上接受连接，每个连接由 handle 处理
+func serve(t *testing.T, handle func(c net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// serveBackend 启动一个由 handler 处理命令的后端，用户 app 没有密码，返回后端地址
+func serveBackend(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	credentials := server.NewInMemoryProvider()
+	credentials.AddUser("app", "")
+	backendServer := server.NewDefaultServer()
+	return serve(t, func(c net.Conn) {
+		conn, err := server.NewCustomizedConn(c, backendServer, credentials, handler)
+		if err != nil {
+			c.Close()
+			return
+		}
+		for conn.HandleCommand() == nil {
+		}
+	})
+}
+
+// serveProxy 启动一个后端和一个按 main.go 的方式接入 Handler 的代理，返回代理地址
+func serveProxy(t *testing.T, result *mysql.Result) string {
+	t.Helper()
+	backendAddr := serveBackend(t, &stmtBackend{result: result})
+
+	credentials := server.NewInMemoryProvider()
+	credentials.AddUser("app", "")
+
+	dialer, err := NewDialer(BackendTLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer, _, err := NewServer(ServerTLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serve(t, func(c net.Conn) {
+		handler := NewHandler(dialer, nil, nil, nil, nil, nil, nil, nil, NewPluginManager())
+		defer handler.Close()
+		conn, err := server.NewCustomizedConn(c, proxyServer, credentials, handler)
+		if err != nil {
+			c.Close()
+			return
+		}
+		backend := Backend{Addr: backendAddr, User: "app"}
+		handler.Attach(conn, credentials, backend)
+		if err := handler.Connect(Session{ConnID: conn.ConnectionID(), User: conn.GetUser()}, backend); err != nil {
+			conn.Close()
+			return
+		}
+		for conn.HandleCommand() == nil {
+		}
+	})
+}
+
+// sendCommand 发送一条命令，返回第一个响应包
+func sendCommand(t *testing.T, conn *client.Conn, cmd byte, data ...byte) []byte {
+	t.Helper()
+	conn.ResetSequence()
+	if err := conn.WritePacket(append([]byte{0, 0, 0, 0, cmd}, data...)); err != nil {
+		t.Fatal(err)
+	}
+	return readResponse(t, conn)
+}
+
+func readResponse(t *testing.T, conn *client.Conn) []byte {
+	t.Helper()
+	packet, err := conn.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+// readUntilEOF 读取到 EOF 包为止，返回之前的包数量和 EOF 中的状态
+func readUntilEOF(t *testing.T, conn *client.Conn, packet []byte) (int, uint16) {
+	t.Helper()
+	n := 0
+	for !(packet[0] == mysql.EOF_HEADER && len(packet) < 9) {
+		if packet[0] == mysql.ERR_HEADER {
+			t.Fatalf("unexpected error: %v", parseErrPacket(packet))
+		}
+		n++
+		packet = readResponse(t, conn)
+	}
+	return n, binary.LittleEndian.Uint16(packet[3:])
+}
+
+// stmtID 编码语句ID，后接 extra
+func stmtID(id uint32, extra ...byte) []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, id), extra...)
+}
+
+func fetchRows(id, rows uint32) []byte {
+	return binary.LittleEndian.AppendUint32(stmtID(id), rows)
+}
+
+func TestCursorFetch(t *testing.T) {
+	rows := make([][]interface{}, 5)
+	for i := range rows {
+		rows[i] = []interface{}{int64(i)}
+	}
+	addr := serveProxy(t, buildResult(t, true, []string{"id"}, rows))
+
+	// step 按顺序发送的命令，fetch 为 0 时执行语句
+	type step struct {
+		cursor    bool   // 执行时请求游标
+		fetch     uint32 // COM_STMT_FETCH 请求的行数
+		close     bool   // 关闭语句
+		wantRows  int
+		wantState uint16 // EOF 中期望的游标状态
+		wantErr   uint16 // 期望的错误码
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "without cursor",
+			steps: []step{{wantRows: 5}},
+		},
+		{
+			name: "fetch in batches",
+			steps: []step{
+				{cursor: true, wantState: mysql.SERVER_STATUS_CURSOR_EXISTS},
+				{fetch: 2, wantRows: 2, wantState: mysql.SERVER_STATUS_CURSOR_EXISTS},
+				{fetch: 2, wantRows: 2, wantState: mysql.SERVER_STATUS_CURSOR_EXISTS},
+				{fetch: 2, wantRows: 1, wantState: mysql.SERVER_STATUS_LAST_ROW_SEND},
+				{fetch: 2, wantErr: mysql.ER_STMT_HAS_NO_OPEN_CURSOR},
+			},
+		},
+		{
+			name: "fetch all",
+			steps: []step{
+				{cursor: true, wantState: mysql.SERVER_STATUS_CURSOR_EXISTS},
+				{fetch: 100, wantRows: 5, wantState: mysql.SERVER_STATUS_LAST_ROW_SEND},
+			},
+		},
+		{
+			name: "re-execute reopens cursor",
+			steps: []step{
+				{cursor: true, wantState: mysql.SERVER_STATUS_CURSOR_EXISTS},
+				{fetch: 4, wantRows: 4, wantState: mysql.SERVER_STATUS_CURSOR_EXISTS},
+				{cursor: true, wantState: mysql.SERVER_STATUS_CURSOR_EXISTS},
+				{fetch: 4, wantRows: 4, wantState: mysql.SERVER_STATUS_CURSOR_EXISTS},
+			},
+		},
+		{
+			name: "execute without cursor closes cursor",
+			steps: []step{
+				{cursor: true, wantState: mysql.SERVER_STATUS_CURSOR_EXISTS},
+				{wantRows: 5},
+				{fetch: 1, wantErr: mysql.ER_STMT_HAS_NO_OPEN_CURSOR},
+			},
+		},
+		{
+			name: "close",
+			steps: []step{
+				{cursor: true, wantState: mysql.SERVER_STATUS_CURSOR_EXISTS},
+				{close: true},
+				{fetch: 1, wantErr: mysql.ER_STMT_HAS_NO_OPEN_CURSOR},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := client.Connect(addr, "app", "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// COM_STMT_PREPARE 的响应：语句ID、列数、参数数，之后是列定义
+			resp := sendCommand(t, conn, mysql.COM_STMT_PREPARE, []byte("SELECT id FROM t")...)
+			if resp[0] != mysql.OK_HEADER {
+				t.Fatalf("prepare: %v", parseErrPacket(resp))
+			}
+			id := binary.LittleEndian.Uint32(resp[1:5])
+			readUntilEOF(t, conn, readResponse(t, conn))
+
+			for i, s := range tt.steps {
+				var resp []byte
+				switch {
+				case s.close:
+					conn.ResetSequence()
+					if err := conn.WritePacket(append([]byte{0, 0, 0, 0, mysql.COM_STMT_CLOSE}, stmtID(id)...)); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				case s.fetch > 0:
+					resp = sendCommand(t, conn, mysql.COM_STMT_FETCH, fetchRows(id, s.fetch)...)
+				default:
+					var flags byte
+					if s.cursor {
+						flags = 0x01 // CURSOR_TYPE_READ_ONLY
+					}
+					resp = sendCommand(t, conn, mysql.COM_STMT_EXECUTE, stmtID(id, flags, 1, 0, 0, 0)...)
+				}
+
+				if s.wantErr != 0 {
+					if err := parseErrPacket(resp); resp[0] != mysql.ERR_HEADER || err.(*mysql.MyError).Code != s.wantErr {
+						t.Fatalf("step %d: response %v, want error %d", i, resp, s.wantErr)
+					}
+					continue
+				}
+				var n int
+				var status uint16
+				if s.fetch == 0 {
+					// 执行的响应先是列数和列定义，打开游标时列定义之后的 EOF 带游标状态，不返回行
+					var columns int
+					if columns, status = readUntilEOF(t, conn, resp); columns != 2 {
+						t.Fatalf("step %d: %d column packets, want 2", i, columns)
+					}
+					if !s.cursor {
+						n, status = readUntilEOF(t, conn, readResponse(t, conn))
+					}
+				} else {
+					n, status = readUntilEOF(t, conn, resp)
+				}
+				if n != s.wantRows {
+					t.Errorf("step %d: %d rows, want %d", i, n, s.wantRows)
+				}
+				cursorState := status & (mysql.SERVER_STATUS_CURSOR_EXISTS | mysql.SERVER_STATUS_LAST_ROW_SEND)
+				if cursorState != s.wantState {
+					t.Errorf("step %d: cursor status 0x%04x, want 0x%04x", i, cursorState, s.wantState)
+				}
+			}
+
+			// 确认连接仍然可用
+			if err := conn.Ping(); err != nil {
+				t.Errorf("ping: %v", err)
+			}
+		})
+	}
+}
+
+func TestSetOption(t *testing.T) {
+	addr := serveProxy(t, buildResult(t, true, []string{"id"}, nil))
+	conn, err := client.Connect(addr, "app", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		option  uint16
+		wantErr bool
+	}{
+		{option: mysql.MYSQL_OPTION_MULTI_STATEMENTS_OFF},
+		{option: mysql.MYSQL_OPTION_MULTI_STATEMENTS_ON, wantErr: true},
+		{option: 7, wantErr: true},
+	}
+	for _, tt := range tests {
+		resp := sendCommand(t, conn, mysql.COM_SET_OPTION, binary.LittleEndian.AppendUint16(nil, tt.option)...)
+		if gotErr := resp[0] == mysql.ERR_HEADER; gotErr != tt.wantErr {
+			t.Errorf("COM_SET_OPTION %d: response %v, want error %v", tt.option, resp, tt.wantErr)
+		}
+	}
+}
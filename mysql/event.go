@@ -2,15 +2,81 @@ package mysql
 
 import "time"
 
+// ConnEvent 连接生命周期事件
+type ConnEvent struct {
+	Type       string        `json:"type"`        // 事件类型: connect, auth_failure, dial_failure, change_user, disconnect, backend_up, backend_read_only, backend_down, failover
+	ConnID     uint32        `json:"conn_id"`     // 代理分配的连接ID
+	ClientAddr string        `json:"client_addr"` // 客户端地址
+	User       string        `json:"user"`        // 客户端用户名（认证失败时为客户端提交的用户名）
+	Backend    string        `json:"backend"`     // 后端地址
+	Timestamp  time.Time     `json:"timestamp"`   // 时间戳
+	Duration   time.Duration `json:"duration"`    // 连接持续时长（disconnect）
+	BytesIn    int64         `json:"bytes_in"`    // 从客户端读取的字节数
+	BytesOut   int64         `json:"bytes_out"`   // 发送给客户端的字节数
+	Statements uint64        `json:"statements"`  // 处理的语句和命令数
+	Error      string        `json:"error"`       // 错误信息（如果有）
+
+	// 主库切换
+	Previous string `json:"previous,omitempty"` // 切换前新连接发往的后端（failover）
+}
+
 // QueryEvent 查询事件，包含SQL执行的相关信息
 type QueryEvent struct {
-	Type      string        `json:"type"`      // 事件类型: query, prepare, execute, use_db, etc.
+	Type      string        `json:"type"`      // 事件类型: query, prepare, execute, use_db, mirror_diff, binlog_row, slow_query, admin, etc.
 	Query     string        `json:"query"`     // SQL语句
 	Args      []interface{} `json:"args"`      // 参数（用于prepared statement）
 	Database  string        `json:"database"`  // 数据库名
+	Backend   string        `json:"backend"`   // 实际执行语句的后端地址
+	User      string        `json:"user"`      // 客户端认证的用户名
 	Timestamp time.Time     `json:"timestamp"` // 时间戳
 	Duration  time.Duration `json:"duration"`  // 执行耗时
 	Error     string        `json:"error"`     // 错误信息（如果有）
 	RowCount  int           `json:"row_count"` // 影响/返回的行数
+
+	// 连接与会话
+	ConnID          uint32            `json:"conn_id"`           // 代理分配的连接ID
+	ClientAddr      string            `json:"client_addr"`       // 客户端地址
+	ConnectAttrs    map[string]string `json:"connect_attrs"`     // 客户端连接属性（program_name、_client_version 等）
+	BackendThreadID uint32            `json:"backend_thread_id"` // 执行语句的后端连接 CONNECTION_ID()，未转发到后端时为 0
+	SessionStart    time.Time         `json:"session_start"`     // 会话开始时间
+	Seq             uint64            `json:"seq"`               // 会话内的事件序号，从 1 开始递增
+
+	// 事务
+	TxID         uint64 `json:"tx_id"`         // 所属事务ID（会话内从 1 递增，结合 ConnID 唯一），不在事务中时为 0
+	Autocommit   bool   `json:"autocommit"`    // 语句执行前会话是否处于自动提交模式
+	TxStatements int    `json:"tx_statements"` // 事务中执行的语句数（transaction / long_transaction / idle_transaction 事件）
+	TxOutcome    string `json:"tx_outcome"`    // 事务结束方式（transaction 事件）：commit / rollback / implicit_commit / aborted / disconnect
+
+	// SQL指纹
+	Fingerprint string `json:"fingerprint"` // 归一化后的SQL（字面量替换为 ?）
+	Digest      string `json:"digest"`      // 指纹摘要
+
+	// 语句超时
+	Timeout time.Duration `json:"timeout,omitempty"` // 语句的最长执行时间，未限制时为 0
+	Killed  string        `json:"killed,omitempty"`  // 代理终止语句的原因：timeout / client_disconnect / kill
+
+	// 分片
+	Shards []string `json:"shards,omitempty"` // 执行语句的分片，不涉及分片表时为空
+
+	// 影子流量
+	Mirror *MirrorDiff `json:"mirror,omitempty"` // 主库和镜像结果的差异（mirror_diff 事件）
+
+	// 变更数据捕获
+	Binlog *BinlogRow `json:"binlog,omitempty"` // binlog 中的行变更（binlog_row 事件），BackendThreadID 为执行该事务的主库连接ID
+
+	// 故障注入（由 FaultPlugin 填充）
+	Fault     string `json:"fault,omitempty"`      // 注入的故障类型：latency / error / drop / truncate
+	FaultRule string `json:"fault_rule,omitempty"` // 命中的规则名
+
+	// 防火墙（由 FirewallPlugin 填充）
+	FirewallSkipped string `json:"firewall_skipped,omitempty"` // 防火墙放行但未检查的原因：unparsable（无法解析且 block_unparsable 为 false）
+
+	// 慢查询（由 SlowQueryPlugin 填充）
+	SlowQuery *SlowQueryInfo `json:"slow_query,omitempty"`
+
+	// 拦截信息
+	InterceptedBy string `json:"intercepted_by"` // 拦截该语句的插件（仅改写时为空）
+
+	disabled *map[string]bool // 第一次回调时暂停的插件，由 PluginManager 设置
 }
 
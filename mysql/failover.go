@@ -0,0 +1,476 @@
+package mysql
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 后端的健康状态
+const (
+	BackendUp       = "up"        // 健康且可写
+	BackendReadOnly = "read_only" // 健康但 read_only=ON，只在没有可写后端时接收新连接
+	BackendDown     = "down"      // 健康检查连续失败或连接失败
+)
+
+// TargetConfig 主库候选地址
+type TargetConfig struct {
+	Addr     string `yaml:"addr"`     // MySQL服务器地址
+	Priority int    `yaml:"priority"` // 优先级，数值越大越优先，相同时按配置顺序
+}
+
+// TargetList 主库地址列表，配置中可以写单个地址，也可以写地址或 {addr, priority} 的列表
+type TargetList []TargetConfig
+
+// UnmarshalYAML 兼容单个地址的写法
+func (l *TargetList) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		*l = nil
+		if value.Value != "" {
+			*l = TargetList{{Addr: value.Value}}
+		}
+		return nil
+	case yaml.SequenceNode:
+		list := make(TargetList, 0, len(value.Content))
+		for _, item := range value.Content {
+			var target TargetConfig
+			if item.Kind == yaml.ScalarNode {
+				target.Addr = item.Value
+			} else if err := item.Decode(&target); err != nil {
+				return err
+			}
+			if target.Addr == "" {
+				return fmt.Errorf("line %d: target addr is empty", item.Line)
+			}
+			list = append(list, target)
+		}
+		*l = list
+		return nil
+	}
+	return fmt.Errorf("line %d: target must be an address or a list of addresses", value.Line)
+}
+
+// sorted 按优先级从高到低排序，优先级相同时保持配置顺序
+func (l TargetList) sorted() TargetList {
+	s := append(TargetList(nil), l...)
+	sort.SliceStable(s, func(i, j int) bool {
+		return s[i].Priority > s[j].Priority
+	})
+	return s
+}
+
+// Primary 返回优先级最高的地址
+func (l TargetList) Primary() string {
+	if len(l) == 0 {
+		return ""
+	}
+	return l.sorted()[0].Addr
+}
+
+// HealthCheckConfig 后端健康检查配置，target 配置了多个地址或 enabled 为 true 时生效
+type HealthCheckConfig struct {
+	Enabled       bool          `yaml:"enabled"`         // 只有一个 target 时也进行健康检查
+	Interval      time.Duration `yaml:"interval"`        // 检查间隔，默认 2s
+	Timeout       time.Duration `yaml:"timeout"`         // 单次检查的超时，默认 1s
+	FailThreshold int           `yaml:"fail_threshold"`  // 连续失败多少次后标记为 down，默认 3
+	RiseThreshold int           `yaml:"rise_threshold"`  // down 的后端连续成功多少次后恢复，默认 2
+	CheckReadOnly bool          `yaml:"check_read_only"` // 检查 @@global.read_only，只读的后端不接收新连接
+	User          string        `yaml:"user"`            // 检查使用的用户名，默认使用 mysql_proxy.user
+	Password      string        `yaml:"password"`        // 检查使用的密码
+}
+
+// BackendState 后端健康状态的JSON视图
+type BackendState struct {
+	Addr      string        `json:"addr"`
+	Priority  int           `json:"priority"`
+	State     string        `json:"state"`           // up / read_only / down
+	Active    bool          `json:"active"`          // 新连接发往该后端
+	Since     time.Time     `json:"since"`           // 进入当前状态的时间
+	LastCheck time.Time     `json:"last_check"`      // 最近一次检查的时间
+	Latency   time.Duration `json:"latency"`         // 最近一次检查的耗时
+	Error     string        `json:"error,omitempty"` // 最近一次失败的原因
+}
+
+// backendHealth 后端的健康状态与连续成功/失败次数
+type backendHealth struct {
+	BackendState
+	fails  int
+	passes int
+}
+
+// transition 切换到 state，状态变化时返回状态事件
+func (b *backendHealth) transition(state string, now time.Time) *ConnEvent {
+	if b.State == state {
+		return nil
+	}
+	b.State = state
+	b.Since = now
+	return &ConnEvent{
+		Type:      "backend_" + state,
+		Backend:   b.Addr,
+		Timestamp: now,
+		Error:     b.Error,
+	}
+}
+
+// probeResult 一次健康检查的结果
+type probeResult struct {
+	readOnly bool
+	latency  time.Duration
+	err      error
+}
+
+// Failover 对 target 中的后端做健康检查，新连接发往优先级最高的健康可写后端
+type Failover struct {
+	config        HealthCheckConfig
+	dialer        *Dialer
+	pluginManager *PluginManager
+
+	mu       sync.Mutex
+	backends []*backendHealth // 按优先级从高到低
+	active   string           // 新连接发往的后端
+	done     chan struct{}
+}
+
+// NewFailover 创建健康检查与主库切换，只有一个 target 且未启用健康检查时返回 nil
+func NewFailover(targets TargetList, config HealthCheckConfig, dialer *Dialer, pm *PluginManager) *Failover {
+	if len(targets) == 0 || (len(targets) == 1 && !config.Enabled) {
+		return nil
+	}
+	if config.Interval <= 0 {
+		config.Interval = 2 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second
+	}
+	if config.FailThreshold <= 0 {
+		config.FailThreshold = 3
+	}
+	if config.RiseThreshold <= 0 {
+		config.RiseThreshold = 2
+	}
+
+	// 启动时假定所有后端健康，第一次检查之前新连接发往优先级最高的后端
+	now := time.Now()
+	f := &Failover{
+		config:        config,
+		dialer:        dialer,
+		pluginManager: pm,
+		done:          make(chan struct{}),
+	}
+	for _, target := range targets.sorted() {
+		f.backends = append(f.backends, &backendHealth{BackendState: BackendState{
+			Addr:     target.Addr,
+			Priority: target.Priority,
+			State:    BackendUp,
+			Since:    now,
+		}})
+	}
+	f.active = f.backends[0].Addr
+	go f.loop()
+	return f
+}
+
+// Contains 判断 addr 是否为 target 中的后端
+func (f *Failover) Contains(addr string) bool {
+	for _, b := range f.backends {
+		if b.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Active 返回新连接发往的后端
+func (f *Failover) Active() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.active
+}
+
+// Candidates 返回依次尝试连接的后端：prefer（健康可写时）、健康可写的后端、只读的后端；
+// 都不可用时只返回当前后端
+func (f *Failover) Candidates(prefer string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var addrs []string
+	for _, b := range f.backends {
+		if b.Addr == prefer && b.State == BackendUp {
+			addrs = append(addrs, prefer)
+		}
+	}
+	for _, state := range []string{BackendUp, BackendReadOnly} {
+		for _, b := range f.backends {
+			if b.State == state && b.Addr != prefer {
+				addrs = append(addrs, b.Addr)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		addrs = append(addrs, f.active)
+	}
+	return addrs
+}
+
+// RecordFailure 记录一次连接后端失败，与健康检查的失败一起计数：连续失败达到 fail_threshold 时标记为 down
+// 并重新选择新连接发往的后端，直到健康检查连续成功；返回后端是否已经是 down
+func (f *Failover) RecordFailure(addr string, err error) bool {
+	now := time.Now()
+	var events []*ConnEvent
+	down := false
+
+	f.mu.Lock()
+	for _, b := range f.backends {
+		if b.Addr != addr {
+			continue
+		}
+		b.Error = err.Error()
+		b.passes = 0
+		b.fails++
+		if b.fails >= f.config.FailThreshold {
+			if event := b.transition(BackendDown, now); event != nil {
+				events = append(events, event)
+			}
+		}
+		down = b.State == BackendDown
+	}
+	if event := f.elect(now); event != nil {
+		events = append(events, event)
+	}
+	f.mu.Unlock()
+
+	f.emit(events)
+	return down
+}
+
+// elect 重新选择新连接发往的后端，发生切换时返回 failover 事件；所有后端都不可用时保持不变
+func (f *Failover) elect(now time.Time) *ConnEvent {
+	addr := f.pick()
+	if addr == "" || addr == f.active {
+		return nil
+	}
+	event := &ConnEvent{
+		Type:      "failover",
+		Backend:   addr,
+		Previous:  f.active,
+		Timestamp: now,
+	}
+	f.active = addr
+	return event
+}
+
+// pick 返回优先级最高的健康可写后端，没有时返回优先级最高的只读后端，都不可用时返回空
+func (f *Failover) pick() string {
+	for _, state := range []string{BackendUp, BackendReadOnly} {
+		for _, b := range f.backends {
+			if b.State == state {
+				return b.Addr
+			}
+		}
+	}
+	return ""
+}
+
+// loop 启动后立即检查一次，之后按间隔定期检查
+func (f *Failover) loop() {
+	ticker := time.NewTicker(f.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		f.check()
+		select {
+		case <-ticker.C:
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// check 并行检查所有后端，更新健康状态并重新选择新连接发往的后端
+func (f *Failover) check() {
+	results := make([]probeResult, len(f.backends))
+	var wg sync.WaitGroup
+	for i, b := range f.backends {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			start := time.Now()
+			readOnly, err := f.probe(addr)
+			results[i] = probeResult{readOnly: readOnly, latency: time.Since(start), err: err}
+		}(i, b.Addr)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	var events []*ConnEvent
+
+	f.mu.Lock()
+	for i, b := range f.backends {
+		r := results[i]
+		b.LastCheck = now
+		b.Latency = r.latency
+		state := b.State
+		if r.err != nil {
+			b.Error = r.err.Error()
+			b.passes = 0
+			b.fails++
+			if b.fails >= f.config.FailThreshold {
+				state = BackendDown
+			}
+		} else {
+			b.fails = 0
+			b.passes++
+			if b.State != BackendDown || b.passes >= f.config.RiseThreshold {
+				b.Error = ""
+				state = BackendUp
+				if r.readOnly {
+					state = BackendReadOnly
+				}
+			}
+		}
+		if event := b.transition(state, now); event != nil {
+			events = append(events, event)
+		}
+	}
+	if event := f.elect(now); event != nil {
+		events = append(events, event)
+	}
+	f.mu.Unlock()
+
+	f.emit(events)
+}
+
+// probe 建立新连接并执行 SELECT 1，配置了 check_read_only 时同时查询 @@global.read_only
+// 超时后关闭连接，让仍在进行的检查尽快返回
+func (f *Failover) probe(addr string) (bool, error) {
+	var mu sync.Mutex
+	var conn *backendConn
+	expired := false
+	done := make(chan probeResult, 1)
+
+	go func() {
+		c, err := f.dialer.Dial(addr, f.config.User, f.config.Password, "")
+		if err != nil {
+			done <- probeResult{err: err}
+			return
+		}
+		mu.Lock()
+		if expired {
+			mu.Unlock()
+			c.Close()
+			return
+		}
+		conn = c
+		mu.Unlock()
+		defer c.Close()
+
+		if _, err := c.Execute("SELECT 1"); err != nil {
+			done <- probeResult{err: err}
+			return
+		}
+		var readOnly bool
+		if f.config.CheckReadOnly {
+			r, err := c.Execute("SELECT @@global.read_only")
+			if err != nil {
+				done <- probeResult{err: err}
+				return
+			}
+			v, err := r.GetInt(0, 0)
+			if err != nil {
+				done <- probeResult{err: err}
+				return
+			}
+			readOnly = v != 0
+		}
+		done <- probeResult{readOnly: readOnly}
+	}()
+
+	timer := time.NewTimer(f.config.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.readOnly, r.err
+	case <-timer.C:
+		mu.Lock()
+		expired = true
+		if conn != nil {
+			conn.Close()
+		}
+		mu.Unlock()
+		return false, fmt.Errorf("health check timed out after %s", f.config.Timeout)
+	}
+}
+
+// emit 记录日志并将状态事件发给插件
+func (f *Failover) emit(events []*ConnEvent) {
+	for _, event := range events {
+		if event.Type == "failover" {
+			log.Printf("[MySQL Failover] New connections now go to %s (was %s)", event.Backend, event.Previous)
+		} else if event.Error != "" {
+			log.Printf("[MySQL Failover] Backend %s is %s: %s", event.Backend, event.Type[len("backend_"):], event.Error)
+		} else {
+			log.Printf("[MySQL Failover] Backend %s is %s", event.Backend, event.Type[len("backend_"):])
+		}
+		f.pluginManager.OnBackendState(event)
+	}
+}
+
+// State 返回所有后端的健康状态，按优先级从高到低
+func (f *Failover) State() []BackendState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	states := make([]BackendState, 0, len(f.backends))
+	for _, b := range f.backends {
+		state := b.BackendState
+		state.Active = b.Addr == f.active
+		states = append(states, state)
+	}
+	return states
+}
+
+// ServeHTTP 以JSON返回后端健康状态
+func (f *Failover) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(f.State())
+}
+
+// Close 停止健康检查
+func (f *Failover) Close() {
+	close(f.done)
+}
+
+// leasePrimary 获取主库连接；启用主库切换时，连接失败计入后端的连续失败次数，后端因此标记为 down 时依次尝试其他后端
+// 会话在当前后端健康可写时继续使用它
+func (h *Handler) leasePrimary() (*backendConn, error) {
+	if !h.failingOver {
+		return h.lease(h.target)
+	}
+	var lastErr error
+	for _, addr := range h.failover.Candidates(h.target) {
+		conn, err := h.lease(addr)
+		if err == nil {
+			h.target = addr
+			return conn, nil
+		}
+		// 服务器返回的错误（如账号无权限）和连接池等待超时不说明后端不可用
+		if !isConnError(err) {
+			return nil, err
+		}
+		// 一次连接失败可能是暂时的，后端没有标记为 down 时返回错误，不把会话的写入转到其他可写的后端
+		if !h.failover.RecordFailure(addr, err) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
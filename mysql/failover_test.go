@@ -0,0 +1,81 @@
+package mysql
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// closedAddr 返回一个没有监听的本地地址，连接它会被拒绝
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestLeasePrimaryFailThreshold(t *testing.T) {
+	tests := []struct {
+		name          string
+		failThreshold int
+		leases        int // 连续获取连接的次数，优先级高的后端一直不可用
+		wantErr       bool
+		wantState     string // 优先级高的后端最后的状态
+		wantActive    bool   // 新连接改发往优先级低的后端
+	}{
+		{name: "single failure", failThreshold: 3, leases: 1, wantErr: true, wantState: BackendUp},
+		{name: "below threshold", failThreshold: 3, leases: 2, wantErr: true, wantState: BackendUp},
+		{name: "reaches threshold", failThreshold: 3, leases: 3, wantState: BackendDown, wantActive: true},
+		{name: "threshold of one", failThreshold: 1, leases: 1, wantState: BackendDown, wantActive: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer, err := NewDialer(BackendTLSConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			primary := closedAddr(t)
+			standby := serveBackend(t, server.EmptyHandler{})
+			// 不启动健康检查，后端的状态只由连接失败改变
+			f := &Failover{
+				config:        HealthCheckConfig{FailThreshold: tt.failThreshold, RiseThreshold: 2},
+				dialer:        dialer,
+				pluginManager: NewPluginManager(),
+				backends: []*backendHealth{
+					{BackendState: BackendState{Addr: primary, Priority: 10, State: BackendUp}},
+					{BackendState: BackendState{Addr: standby, State: BackendUp}},
+				},
+				active: primary,
+			}
+			h := &Handler{dialer: dialer, failover: f, failingOver: true, target: primary, user: "app", pluginManager: NewPluginManager()}
+
+			var conn *backendConn
+			for i := 0; i < tt.leases; i++ {
+				if conn, err = h.leasePrimary(); err == nil {
+					defer conn.Close()
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("leasePrimary error = %v, want error %v", err, tt.wantErr)
+			}
+			if state := f.State()[0].State; state != tt.wantState {
+				t.Errorf("primary state = %s, want %s", state, tt.wantState)
+			}
+			wantTarget := primary
+			if tt.wantActive {
+				wantTarget = standby
+			}
+			if active := f.Active(); active != wantTarget {
+				t.Errorf("active = %s, want %s", active, wantTarget)
+			}
+			if h.target != wantTarget {
+				t.Errorf("session target = %s, want %s", h.target, wantTarget)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package mysql
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+var (
+	// IN (?, ?, ?) 折叠为 in(?+)
+	inListPattern = regexp.MustCompile(`\bin ?\(\?(?: ?, ?\?)*\)`)
+	// VALUES (?, ?), (?, ?) 折叠为 values(?+)
+	valuesPattern = regexp.MustCompile(`\bvalues ?\([?, ]*\)(?: ?, ?\([?, ]*\))*`)
+)
+
+// Fingerprint 将SQL归一化为指纹：去掉注释，字面量替换为 ?，IN/VALUES 列表折叠，压缩空白并转为小写
+func Fingerprint(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	// space 写入一个空格，连续空白只保留一个
+	space := func() {
+		if b.Len() > 0 && b.String()[b.Len()-1] != ' ' {
+			b.WriteByte(' ')
+		}
+	}
+	// afterIdent 判断上一个写入的字符是否属于标识符，用于区分 t1 与数字字面量
+	afterIdent := func() bool {
+		if b.Len() == 0 {
+			return false
+		}
+		return isIdentByte(b.String()[b.Len()-1])
+	}
+
+	n := len(query)
+	for i := 0; i < n; {
+		c := query[i]
+		switch {
+		case c == '/' && i+1 < n && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 4
+			}
+			space()
+		case c == '#' || (c == '-' && i+2 < n && query[i+1] == '-' && isSpaceByte(query[i+2])):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 1
+			}
+			space()
+		case c == '\'' || c == '"':
+			i = skipQuoted(query, i)
+			b.WriteByte('?')
+		case c == '`':
+			end := strings.IndexByte(query[i+1:], '`')
+			if end < 0 {
+				end = n - i - 2
+			}
+			b.WriteString(strings.ToLower(query[i : i+end+2]))
+			i += end + 2
+		case (c == 'x' || c == 'X' || c == 'b' || c == 'B' || c == 'n' || c == 'N') &&
+			i+1 < n && query[i+1] == '\'' && !afterIdent():
+			// X'0F'、b'01'、N'abc' 形式的字面量
+			i = skipQuoted(query, i+1)
+			b.WriteByte('?')
+		case isDigitByte(c) && !afterIdent():
+			for i < n && (isIdentByte(query[i]) || query[i] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		case isSpaceByte(c):
+			space()
+			i++
+		default:
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	fp := strings.TrimSpace(b.String())
+	fp = strings.TrimRight(fp, "; ")
+	fp = inListPattern.ReplaceAllString(fp, "in(?+)")
+	fp = valuesPattern.ReplaceAllString(fp, "values(?+)")
+	return fp
+}
+
+// Digest 返回指纹的摘要（MD5 后16位十六进制，与 pt-query-digest 的 checksum 一致）
+func Digest(fingerprint string) string {
+	sum := md5.Sum([]byte(fingerprint))
+	return strings.ToUpper(hex.EncodeToString(sum[8:]))
+}
+
+// skipQuoted 跳过从 start 开始的引号字符串，返回其后的位置
+func skipQuoted(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case quote:
+			// 连续两个引号表示转义
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || isDigitByte(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isDigitByte(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,54 @@
+package mysql
+
+import "testing"
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"number", "SELECT * FROM t WHERE id = 1", "select * from t where id = ?"},
+		{"whitespace", "SELECT  *\n\tFROM t\r\nWHERE id=42", "select * from t where id=?"},
+		{"string", "SELECT * FROM t WHERE name = 'it''s' AND note = \"a\\\"b\"", "select * from t where name = ? and note = ?"},
+		{"block comment", "/* app=web */ SELECT 1", "select ?"},
+		{"line comments", "# leading\nSELECT 1 -- trailing", "select ?"},
+		{"double dash without space", "SELECT 5--1", "select ?--?"},
+		{"in list", "SELECT * FROM t WHERE id IN (1, 2, 3)", "select * from t where id in(?+)"},
+		{"in list without space", "select * from t where id in(4,5)", "select * from t where id in(?+)"},
+		{"values", "INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y')", "insert into t (a, b) values(?+)"},
+		{"identifiers with digits", "SELECT c1 FROM t2 WHERE c1 > 3", "select c1 from t2 where c1 > ?"},
+		{"quoted identifiers", "SELECT `Col 1` FROM `T`", "select `col 1` from `t`"},
+		{"prefixed literals", "SELECT X'0F', b'01', N'abc'", "select ?, ?, ?"},
+		{"decimal and exponent", "SELECT 1.5, 2e10", "select ?, ?"},
+		{"trailing semicolon", "SELECT 1 ;", "select ?"},
+		{"placeholders", "UPDATE t SET a = ? WHERE id = ?", "update t set a = ? where id = ?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fingerprint(tt.query); got != tt.want {
+				t.Errorf("Fingerprint(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigest(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"SELECT * FROM t WHERE id = 1", "select *  from t where id = 99", true},
+		{"SELECT * FROM t WHERE id IN (1)", "SELECT * FROM t WHERE id IN (1, 2, 3)", true},
+		{"SELECT * FROM t WHERE id = 1", "SELECT * FROM u WHERE id = 1", false},
+	}
+	for _, tt := range tests {
+		da, db := Digest(Fingerprint(tt.a)), Digest(Fingerprint(tt.b))
+		if len(da) != 16 {
+			t.Errorf("Digest(%q) = %q, want 16 hex digits", tt.a, da)
+		}
+		if (da == db) != tt.same {
+			t.Errorf("Digest(%q) = %s, Digest(%q) = %s, same = %v", tt.a, da, tt.b, db, tt.same)
+		}
+	}
+}
@@ -1,44 +1,204 @@
 package mysql
 
 import (
+	"sync"
 	"time"
 
 	"github.com/go-mysql-org/go-mysql/client"
 	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
 )
 
 // Handler 代理Handler，将请求转发到真正的MySQL服务器
 type Handler struct {
-	conn          *client.Conn   // 到真正MySQL服务器的连接
+	session       Session        // 客户端连接的身份信息
+	target        string         // 主库地址，为空表示尚未连接后端
+	user          string         // 连接后端使用的用户名
+	password      string         // 连接后端使用的密码
 	pluginManager *PluginManager // 插件管理器
 	currentDB     string         // 当前数据库
+
+	// 后端连接与会话状态
+	dialer   *Dialer        // 建立后端连接
+	pools    *Pools         // 为 nil 时每个客户端独占一个后端连接
+	primary  *backendConn   // 当前持有的主库连接，启用连接池时只在事务中或会话被固定时持有
+	sets     []string       // 会话中执行过的 SET 语句，复用连接时按顺序重放
+	pinned   bool           // 会话使用了无法恢复的状态（临时表、表锁等），始终持有主库连接
+	holding  bool           // 上一条语句的 LAST_INSERT_ID() 等结果只能在其连接上读取，持有主库连接到下一条语句
+	stmtRefs map[string]int // 客户端预处理语句的引用计数（按SQL）
+
+	// 读写分离
+	router      *Router      // 为 nil 时不做读写分离
+	routing     bool         // 当前用户的后端是 router 的主库时为 true，会话才做读写分离
+	replica     *backendConn // 独占模式下使用的从库连接（按需建立）
+	stickyUntil time.Time    // 在此之前读请求仍发往主库
+
+	// 主库切换
+	failover    *Failover // 为 nil 时只使用 target 中的一个后端
+	failingOver bool      // 当前用户的后端属于 failover 时为 true，会话才做主库切换
+
+	// 事件
+	seq           uint64 // 会话内的事件序号
+	backendThread uint32 // 最近一次执行语句的后端连接 CONNECTION_ID()
+
+	// 事务
+	tx        *txState   // 进行中的事务，为 nil 表示不在事务中
+	txSeq     uint64     // 会话内的事务ID计数
+	txMonitor *TxMonitor // 为 nil 时不检查长事务和事务中空闲
+
+	// 语句超时
+	timeouts *QueryTimeouts // 为 nil 时不限制执行时间
+	client   *ClientConn    // 客户端连接，用于检测执行期间客户端断开
+	watch    *queryWatch    // 执行中的语句的超时设置
+
+	// KILL
+	sessions *Sessions           // 按代理连接ID查找会话，为 nil 时拒绝 KILL
+	runMu    sync.Mutex          // 保护 running，KILL 在其他会话的协程中读取
+	running  func(reason string) // 终止执行中的语句，空闲时为 nil
+
+	// 分片
+	sharding   *Sharding               // 为 nil 时不分片
+	shardConns map[string]*backendConn // 独占模式下使用的分片连接（按需建立）
+
+	// 需要代理直接应答的协议命令，由 Attach 设置
+	server      *server.Conn              // go-mysql 服务端连接，为 nil 时这些命令返回不支持
+	out         *responseConn             // 丢弃 go-mysql 在代理已应答后写出的包
+	credentials server.CredentialProvider // COM_CHANGE_USER 重新认证
+	defaults    Backend                   // COM_CHANGE_USER 按用户表映射后端时的默认值
+
+	// 预处理语句的游标，由 Attach 设置的连接在读取命令时记录请求
+	openCursor bool                   // 当前的 COM_STMT_EXECUTE 请求了游标
+	cursorStmt uint32                 // 当前的 COM_STMT_EXECUTE 的客户端语句ID
+	cursors    map[uint32]*stmtCursor // 客户端语句ID -> 打开的游标
 }
 
-// NewHandler 创建一个新的代理Handler
-func NewHandler(mysqlAddr, user, password, db string, pm *PluginManager) (*Handler, error) {
-	conn, err := client.Connect(mysqlAddr, user, password, db)
-	if err != nil {
-		return nil, err
-	}
+// Session 客户端连接的身份信息，记录在该连接的每个事件上
+type Session struct {
+	ConnID     uint32            // 代理分配的连接ID
+	ClientAddr string            // 客户端地址
+	User       string            // 客户端认证的用户名
+	Attributes map[string]string // 客户端连接属性（program_name、_client_version 等）
+	StartTime  time.Time         // 会话开始时间
+}
+
+// Backend 后端MySQL的连接参数
+type Backend struct {
+	Addr     string // 主库地址
+	User     string // 用户名
+	Password string // 密码
+	Database string // 默认数据库
+}
+
+// NewHandler 创建一个新的代理Handler，客户端认证通过后调用 Connect 连接后端
+// router 为 nil 时所有语句都发往主库，failover 为 nil 时不做主库切换，pools 为 nil 时不使用连接池，
+// txMonitor 为 nil 时不检查长事务，timeouts 为 nil 时不限制语句执行时间，sharding 为 nil 时不分片，
+// sessions 为 nil 时拒绝 KILL
+func NewHandler(dialer *Dialer, router *Router, failover *Failover, pools *Pools, txMonitor *TxMonitor, timeouts *QueryTimeouts, sharding *Sharding, sessions *Sessions, pm *PluginManager) *Handler {
 	return &Handler{
-		conn:          conn,
 		pluginManager: pm,
-		currentDB:     db,
-	}, nil
+		sessions:      sessions,
+		dialer:        dialer,
+		pools:         pools,
+		stmtRefs:      make(map[string]int),
+		cursors:       make(map[uint32]*stmtCursor),
+		router:        router,
+		failover:      failover,
+		txMonitor:     txMonitor,
+		timeouts:      timeouts,
+		sharding:      sharding,
+		shardConns:    make(map[string]*backendConn),
+	}
+}
+
+// Connect 客户端认证通过后连接后端，session 为客户端连接的身份信息
+func (h *Handler) Connect(session Session, backend Backend) error {
+	h.session = session
+	if h.sessions != nil {
+		h.sessions.add(h)
+	}
+	h.target = backend.Addr
+	h.user = backend.User
+	h.password = backend.Password
+	// 握手时客户端指定的数据库优先于配置的默认数据库
+	if h.currentDB == "" {
+		h.currentDB = backend.Database
+	}
+	// 用户映射到其他后端时，读写分离的从库和主库切换不再适用；COM_CHANGE_USER 会按新用户重新判断
+	h.routing = h.router != nil && h.router.Primary() == backend.Addr
+	h.failingOver = h.failover != nil && h.failover.Contains(backend.Addr)
+	if h.failingOver {
+		h.target = h.failover.Active()
+	}
+
+	// 先获取一次主库连接，尽早发现后端不可用；启用连接池时随即归还
+	conn, err := h.leasePrimary()
+	if err != nil {
+		return err
+	}
+	h.primary = conn
+	h.releasePrimary(nil)
+	return nil
+}
+
+// Target 返回会话当前使用的主库地址
+func (h *Handler) Target() string {
+	return h.target
+}
+
+// newEvent 创建带有会话信息的事件
+func (h *Handler) newEvent(eventType, query string) *QueryEvent {
+	h.seq++
+	h.backendThread = 0
+	return &QueryEvent{
+		Type:         eventType,
+		Query:        query,
+		Database:     h.currentDB,
+		Backend:      h.target,
+		User:         h.session.User,
+		Timestamp:    time.Now(),
+		ConnID:       h.session.ConnID,
+		ClientAddr:   h.session.ClientAddr,
+		ConnectAttrs: h.session.Attributes,
+		SessionStart: h.session.StartTime,
+		Seq:          h.seq,
+		TxID:         h.currentTxID(),
+		Autocommit:   h.primary == nil || h.primary.IsAutoCommit(),
+	}
+}
+
+// ConnEvent 创建带有会话信息的连接事件
+func (h *Handler) ConnEvent(eventType, backend string, err error) *ConnEvent {
+	event := &ConnEvent{
+		Type:       eventType,
+		ConnID:     h.session.ConnID,
+		ClientAddr: h.session.ClientAddr,
+		User:       h.session.User,
+		Backend:    backend,
+		Timestamp:  time.Now(),
+		Statements: h.seq,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
 }
 
 func (h *Handler) UseDB(dbName string) error {
-	event := &QueryEvent{
-		Type:      "use_db",
-		Query:     dbName,
-		Database:  h.currentDB,
-		Timestamp: time.Now(),
+	// 握手阶段客户端指定的数据库在认证完成前到达，等连接后端时再切换
+	if h.target == "" {
+		h.currentDB = dbName
+		return nil
 	}
+
+	event := h.newEvent("use_db", dbName)
 	h.pluginManager.OnQuery(event)
 
 	startTime := time.Now()
-	err := h.conn.UseDB(dbName)
+	err := h.withPrimary(func(conn *backendConn) error {
+		return conn.UseDB(dbName)
+	})
 	event.Duration = time.Since(startTime)
+	event.BackendThreadID = h.backendThread
 
 	if err == nil {
 		h.currentDB = dbName
@@ -49,101 +209,179 @@ func (h *Handler) UseDB(dbName string) error {
 }
 
 func (h *Handler) HandleQuery(query string) (*mysql.Result, error) {
-	event := &QueryEvent{
-		Type:      "query",
-		Query:     query,
-		Database:  h.currentDB,
-		Timestamp: time.Now(),
-	}
-	h.pluginManager.OnQuery(event)
+	event := h.newEvent("query", query)
+	event.Fingerprint = Fingerprint(event.Query)
+	event.Digest = Digest(event.Fingerprint)
 
 	startTime := time.Now()
-	result, err := h.conn.Execute(query)
+	result, err := h.pluginManager.Intercept(event)
+	h.pluginManager.OnQuery(event)
+	if result == nil && err == nil {
+		result, err = h.guard(event, h.execute)
+	}
 	event.Duration = time.Since(startTime)
+	event.BackendThreadID = h.backendThread
+	summary := h.trackTransaction(event, result, err)
 
 	h.pluginManager.OnQueryComplete(event, result, err)
+	h.emitTransaction(summary)
+	h.injectDrop(event)
 	return result, err
 }
 
 func (h *Handler) HandleFieldList(table string, fieldWildcard string) ([]*mysql.Field, error) {
-	event := &QueryEvent{
-		Type:      "field_list",
-		Query:     table + " " + fieldWildcard,
-		Database:  h.currentDB,
-		Timestamp: time.Now(),
-	}
+	event := h.newEvent("field_list", table+" "+fieldWildcard)
 	h.pluginManager.OnQuery(event)
 
 	startTime := time.Now()
-	result, err := h.conn.FieldList(table, fieldWildcard)
+	var result []*mysql.Field
+	err := h.withPrimary(func(conn *backendConn) error {
+		var err error
+		result, err = conn.FieldList(table, fieldWildcard)
+		return err
+	})
 	event.Duration = time.Since(startTime)
+	event.BackendThreadID = h.backendThread
 
 	h.pluginManager.OnQueryComplete(event, nil, err)
 	return result, err
 }
 
 func (h *Handler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
-	event := &QueryEvent{
-		Type:      "prepare",
-		Query:     query,
-		Database:  h.currentDB,
-		Timestamp: time.Now(),
-	}
+	event := h.newEvent("prepare", query)
+	event.Fingerprint = Fingerprint(event.Query)
+	event.Digest = Digest(event.Fingerprint)
 	h.pluginManager.OnQuery(event)
 
 	startTime := time.Now()
-	stmt, err := h.conn.Prepare(query)
+	var stmt *client.Stmt
+	prepare := func(conn *backendConn) error {
+		var err error
+		stmt, err = conn.prepare(query)
+		return err
+	}
+	// 涉及分片表的语句在分片上预处理，主库上可能没有该表
+	shard, err := h.sharding.prepareShard(query, h.currentDB)
+	if _, _, isKill, _ := parseKill(query); isKill {
+		// KILL 的参数是代理分配的连接ID，不能交给后端执行
+		err = NewError(mysql.ER_NOT_SUPPORTED_YET, "42000", "proxyx: KILL cannot be prepared, use the text protocol")
+	}
+	switch {
+	case err != nil:
+	case shard != "":
+		event.Backend = h.sharding.Addr(shard)
+		err = h.withShard(event.Backend, prepare)
+	default:
+		err = h.withPrimary(prepare)
+	}
 	event.Duration = time.Since(startTime)
+	event.BackendThreadID = h.backendThread
 
 	h.pluginManager.OnQueryComplete(event, nil, err)
 
 	if err != nil {
 		return 0, 0, nil, err
 	}
-	return stmt.ParamNum(), stmt.ColumnNum(), stmt, nil
+	// 后端预处理语句缓存在连接上，客户端的语句句柄只记录SQL
+	h.stmtRefs[query]++
+	return stmt.ParamNum(), stmt.ColumnNum(), query, nil
 }
 
 func (h *Handler) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
-	event := &QueryEvent{
-		Type:      "execute",
-		Query:     query,
-		Args:      args,
-		Database:  h.currentDB,
-		Timestamp: time.Now(),
-	}
-	h.pluginManager.OnQuery(event)
+	event := h.newEvent("execute", query)
+	event.Args = args
+	event.Fingerprint = Fingerprint(event.Query)
+	event.Digest = Digest(event.Fingerprint)
 
 	startTime := time.Now()
-	stmt := context.(*client.Stmt)
-	result, err := stmt.Execute(args...)
+	result, err := h.pluginManager.Intercept(event)
+	h.pluginManager.OnQuery(event)
+	if result == nil && err == nil {
+		result, err = h.guard(event, h.executeStmt)
+	}
 	event.Duration = time.Since(startTime)
+	event.BackendThreadID = h.backendThread
+	summary := h.trackTransaction(event, result, err)
 
 	h.pluginManager.OnQueryComplete(event, result, err)
+	h.emitTransaction(summary)
+	h.injectDrop(event)
+	if h.openCursor && err == nil && result != nil && result.Resultset != nil && len(result.Fields) > 0 {
+		if err = h.respondCursor(result.Resultset); err == errResponded {
+			h.out.skip = true
+		}
+		return nil, err
+	}
 	return result, err
 }
 
-func (h *Handler) HandleStmtClose(context interface{}) error {
-	if stmt, ok := context.(*client.Stmt); ok {
-		return stmt.Close()
+// executeStmt 在主库上执行预处理语句，当前连接上尚未预处理（连接池复用或被拦截器改写）时先预处理
+func (h *Handler) executeStmt(event *QueryEvent) (*mysql.Result, error) {
+	if result, handled, err := h.executeSharded(event, true); handled {
+		return result, err
 	}
-	return nil
+
+	h.endHolding(event.Query)
+	var result *mysql.Result
+	err := h.withPrimary(func(conn *backendConn) error {
+		stmt, err := conn.prepare(event.Query)
+		if err != nil {
+			return err
+		}
+		result, err = stmt.Execute(event.Args...)
+		if err == nil {
+			kind, _ := classifyStatement(event.Query)
+			h.holdResult(conn, kind, event.Query)
+		}
+		return err
+	})
+	return result, err
 }
 
-func (h *Handler) HandleOtherCommand(cmd byte, data []byte) error {
-	event := &QueryEvent{
-		Type:      "other",
-		Query:     string(data),
-		Database:  h.currentDB,
-		Timestamp: time.Now(),
+func (h *Handler) HandleStmtClose(context interface{}) error {
+	query, ok := context.(string)
+	if !ok {
+		return nil
+	}
+	h.stmtRefs[query]--
+	if h.stmtRefs[query] > 0 {
+		return nil
+	}
+	delete(h.stmtRefs, query)
+
+	// 独占连接上立即关闭；连接池中的连接保留缓存，供其他会话复用
+	if h.primary != nil && h.primary.pool == nil {
+		return h.primary.closeStmt(query)
 	}
-	h.pluginManager.OnQuery(event)
-	h.pluginManager.OnQueryComplete(event, nil, nil)
 	return nil
 }
 
 func (h *Handler) Close() {
-	if h.conn != nil {
-		h.conn.Close()
+	if h.sessions != nil && h.session.ConnID != 0 {
+		h.sessions.remove(h)
+	}
+
+	// 断开时未结束的事务由服务器回滚
+	if h.tx != nil {
+		h.emitTransaction(h.endTransaction(TxDisconnect))
 	}
+
+	// 会话结束时仍持有的连接可能带有未结束的事务或无法复用的状态，直接关闭
+	h.discardConns()
 }
 
+// discardConns 关闭会话持有的所有后端连接
+func (h *Handler) discardConns() {
+	if h.primary != nil {
+		h.discard(h.primary)
+		h.primary = nil
+	}
+	if h.replica != nil {
+		h.discard(h.replica)
+		h.replica = nil
+	}
+	for addr, conn := range h.shardConns {
+		h.discard(conn)
+		delete(h.shardConns, addr)
+	}
+}
@@ -0,0 +1,93 @@
+package mysql
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// killStatement KILL [CONNECTION | QUERY] <id>，id 为代理分配的连接ID
+var killStatement = regexp.MustCompile(`(?i)^KILL\s+(?:(CONNECTION|QUERY)\s+)?(\d+)\s*;?\s*$`)
+
+// Sessions 代理上已认证的客户端会话，KILL 按代理连接ID找到对应的会话
+// 客户端看到的连接ID（CONNECTION_ID()、握手包）由代理分配，与后端的线程ID无关，不能原样转发到后端
+type Sessions struct {
+	mu       sync.Mutex
+	handlers map[uint32]*Handler
+}
+
+// NewSessions 创建会话表
+func NewSessions() *Sessions {
+	return &Sessions{handlers: make(map[uint32]*Handler)}
+}
+
+func (s *Sessions) add(h *Handler) {
+	s.mu.Lock()
+	s.handlers[h.session.ConnID] = h
+	s.mu.Unlock()
+}
+
+func (s *Sessions) remove(h *Handler) {
+	s.mu.Lock()
+	if s.handlers[h.session.ConnID] == h {
+		delete(s.handlers, h.session.ConnID)
+	}
+	s.mu.Unlock()
+}
+
+func (s *Sessions) get(id uint32) *Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handlers[id]
+}
+
+// parseKill 解析 KILL 语句，ok 为 false 表示不是 KILL 语句；
+// 是 KILL 语句但参数不是连接ID（如 KILL CONNECTION_ID()）时返回错误，不转发到后端
+func parseKill(query string) (id uint32, queryOnly bool, ok bool, err error) {
+	rest, _ := skipComments(query)
+	if !strings.EqualFold(firstWord(rest), "KILL") {
+		return 0, false, false, nil
+	}
+	m := killStatement.FindStringSubmatch(rest)
+	if m == nil {
+		return 0, false, true, NewError(mysql.ER_NOT_SUPPORTED_YET, "42000", "proxyx: KILL only supports a literal connection id: KILL [CONNECTION | QUERY] <id>")
+	}
+	n, err := strconv.ParseUint(m[2], 10, 32)
+	if err != nil {
+		return 0, false, true, NewError(mysql.ER_NO_SUCH_THREAD, "HY000", fmt.Sprintf("Unknown thread id: %s", m[2]))
+	}
+	return uint32(n), strings.EqualFold(m[1], "QUERY"), true, nil
+}
+
+// kill 终止代理连接 id 正在执行的语句，queryOnly 为 false 时同时断开该客户端连接
+// 语句在该会话当前使用的后端上通过旁路连接 KILL QUERY 终止；断开客户端后会话持有的后端连接随之关闭，
+// 连接池中的空闲连接可能已被其他会话使用，不会被终止
+// 与 MySQL 一致，只能终止同一用户的连接
+func (h *Handler) kill(id uint32, queryOnly bool) error {
+	var target *Handler
+	if h.sessions != nil {
+		target = h.sessions.get(id)
+	}
+	if target == nil {
+		return NewError(mysql.ER_NO_SUCH_THREAD, "HY000", fmt.Sprintf("Unknown thread id: %d", id))
+	}
+	if target.session.User != h.session.User {
+		return NewError(mysql.ER_KILL_DENIED_ERROR, "HY000", fmt.Sprintf("You are not owner of thread %d", id))
+	}
+
+	what := "connection"
+	if queryOnly {
+		what = "query"
+	}
+	log.Printf("[MySQL] Connection %d killed %s %d", h.session.ConnID, what, id)
+	target.killRunning(KillStatement)
+	if !queryOnly && target.client != nil {
+		target.client.Close()
+	}
+	return nil
+}
@@ -0,0 +1,467 @@
+package mysql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// 镜像模式
+const (
+	MirrorReadOnly = "read_only" // 只镜像事务外的只读语句，以及维持会话状态的 SET
+	MirrorAll      = "all"       // 镜像所有语句，只能用于可丢弃的副本
+)
+
+// 修改全局变量的 SET，只读镜像时不执行
+var globalSet = regexp.MustCompile(`(?i)\b(global|persist|persist_only)\b|@@(global|persist)\.`)
+
+// MirrorConfig 影子流量配置
+type MirrorConfig struct {
+	Addr        string        `yaml:"addr"`         // 镜像MySQL地址，为空时不启用
+	User        string        `yaml:"user"`         // 连接镜像的用户名，默认与 mysql_proxy.user 相同
+	Password    string        `yaml:"password"`     // 连接镜像的密码
+	Mode        string        `yaml:"mode"`         // read_only（默认）或 all
+	MaxSessions int           `yaml:"max_sessions"` // 同时镜像的客户端会话数上限（默认64）
+	QueueSize   int           `yaml:"queue_size"`   // 每个会话待镜像语句的缓冲，满时丢弃（默认256）
+	Timeout     time.Duration `yaml:"timeout"`      // 镜像语句的超时，超时后断开镜像连接（默认30s）
+}
+
+// MirrorResult 语句在主库或镜像上的执行结果摘要
+type MirrorResult struct {
+	Columns  []string      `json:"columns,omitempty"`  // 结果集列名
+	Rows     uint64        `json:"rows"`               // 返回的行数，没有结果集时为影响的行数
+	Checksum string        `json:"checksum,omitempty"` // 与行顺序无关的行校验和
+	Error    string        `json:"error,omitempty"`    // 错误信息
+	Duration time.Duration `json:"duration"`           // 执行耗时
+
+	code uint16 // MySQL 错误码
+}
+
+// MirrorDiff 主库和镜像结果不一致的详情（mirror_diff 事件）
+type MirrorDiff struct {
+	Addr        string       `json:"addr"`        // 镜像地址
+	Differences []string     `json:"differences"` // 不一致的项：error / columns / rows / checksum
+	Primary     MirrorResult `json:"primary"`
+	Mirror      MirrorResult `json:"mirror"`
+}
+
+// MirrorStats 影子流量统计
+type MirrorStats struct {
+	Addr     string `json:"addr"`
+	Mode     string `json:"mode"`
+	Sessions int    `json:"sessions"` // 正在镜像的客户端会话数
+	Compared uint64 `json:"compared"` // 在镜像上执行并对比的语句数
+	Matched  uint64 `json:"matched"`  // 结果一致的语句数
+	Diffs    uint64 `json:"diffs"`    // 结果不一致的语句数
+	Dropped  uint64 `json:"dropped"`  // 队列已满或会话数达到上限而没有镜像的语句数
+	Failed   uint64 `json:"failed"`   // 连接镜像失败、连接中断或超时而没有对比的语句数
+}
+
+// mirrorSnapshot 语句执行结果的快照，主库结果在提交时取出，校验和在镜像协程中计算
+type mirrorSnapshot struct {
+	columns   []string
+	rows      []mysql.RowData
+	resultset bool
+	affected  uint64
+	err       error
+	duration  time.Duration
+}
+
+// mirrorTask 一条待镜像的语句
+type mirrorTask struct {
+	event   QueryEvent
+	primary mirrorSnapshot
+	compare bool // 是否对比结果，只读镜像时的 SET 只执行不对比
+}
+
+// mirrorSession 一个客户端会话在镜像上的连接，语句按主库上的执行顺序依次执行
+type mirrorSession struct {
+	queue      chan *mirrorTask
+	conn       *backendConn
+	db         string
+	dialFailed bool // 已记录过连接失败的日志
+}
+
+// Mirror 影子流量：主库执行完毕的语句异步发往镜像执行，对比两边的结果，不一致时产生 mirror_diff 事件
+// 作为插件注册，在 OnQueryComplete 中只取结果快照并入队，不增加客户端的延迟
+type Mirror struct {
+	config        MirrorConfig
+	dialer        *Dialer
+	pluginManager *PluginManager
+
+	mu       sync.Mutex
+	sessions map[uint32]*mirrorSession
+	closed   bool
+	wg       sync.WaitGroup
+
+	compared atomic.Uint64
+	matched  atomic.Uint64
+	diffs    atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+}
+
+// NewMirror 创建影子流量，未配置镜像地址时返回 nil
+func NewMirror(config MirrorConfig, dialer *Dialer, pm *PluginManager) (*Mirror, error) {
+	if config.Addr == "" {
+		return nil, nil
+	}
+	switch config.Mode {
+	case "":
+		config.Mode = MirrorReadOnly
+	case MirrorReadOnly, MirrorAll:
+	default:
+		return nil, fmt.Errorf("unknown mirror mode: %s", config.Mode)
+	}
+	if config.MaxSessions <= 0 {
+		config.MaxSessions = 64
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 256
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	return &Mirror{
+		config:        config,
+		dialer:        dialer,
+		pluginManager: pm,
+		sessions:      make(map[uint32]*mirrorSession),
+	}, nil
+}
+
+func (m *Mirror) Name() string {
+	return "Mirror"
+}
+
+func (m *Mirror) OnQuery(event *QueryEvent) {}
+
+// OnQueryComplete 在主库上执行过的语句入队等待镜像，被拦截、命中缓存或被代理终止的语句不镜像
+func (m *Mirror) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	if event.Type != "query" && event.Type != "execute" {
+		return
+	}
+	if event.InterceptedBy != "" || event.Backend == "" || event.Killed != "" || isConnError(err) {
+		return
+	}
+	compare, ok := m.accept(event)
+	if !ok {
+		return
+	}
+
+	task := &mirrorTask{
+		event:   *event,
+		primary: snapshotResult(result, err, event.Duration),
+		compare: compare,
+	}
+	if !m.enqueue(event.ConnID, task) {
+		m.dropped.Add(1)
+	}
+}
+
+// accept 判断语句是否镜像以及是否对比结果
+func (m *Mirror) accept(event *QueryEvent) (compare, ok bool) {
+	if m.config.Mode == MirrorAll {
+		return true, true
+	}
+	kind, _ := classifyStatement(event.Query)
+	switch {
+	case kind == kindSet:
+		return false, !globalSet.MatchString(event.Query)
+	case event.TxID != 0:
+		// 事务中的读可能依赖主库上未提交的数据
+		return false, false
+	}
+	return true, IsReadOnly(event.Query)
+}
+
+// enqueue 将语句放入会话的队列，会话不存在时创建；会话数达到上限、队列已满或已关闭时返回 false
+func (m *Mirror) enqueue(connID uint32, task *mirrorTask) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false
+	}
+	s, ok := m.sessions[connID]
+	if !ok {
+		if len(m.sessions) >= m.config.MaxSessions {
+			return false
+		}
+		s = &mirrorSession{queue: make(chan *mirrorTask, m.config.QueueSize)}
+		m.sessions[connID] = s
+		m.wg.Add(1)
+		go m.run(s)
+	}
+	select {
+	case s.queue <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Mirror) OnConnect(event *ConnEvent) {}
+
+func (m *Mirror) OnAuthFailure(event *ConnEvent) {}
+
+func (m *Mirror) OnDialFailure(event *ConnEvent) {}
+
+// OnDisconnect 客户端断开后执行完队列中的语句并关闭镜像连接
+func (m *Mirror) OnDisconnect(event *ConnEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[event.ConnID]; ok {
+		delete(m.sessions, event.ConnID)
+		close(s.queue)
+	}
+}
+
+// run 依次在镜像上执行会话的语句
+func (m *Mirror) run(s *mirrorSession) {
+	defer m.wg.Done()
+	defer m.closeSession(s)
+
+	for task := range s.queue {
+		m.execute(s, task)
+	}
+}
+
+// execute 在镜像上执行一条语句并与主库的结果对比
+func (m *Mirror) execute(s *mirrorSession, task *mirrorTask) {
+	event := &task.event
+	if s.conn == nil {
+		conn, err := m.dialer.Dial(m.config.Addr, m.config.User, m.config.Password, event.Database)
+		if err != nil {
+			m.failed.Add(1)
+			if !s.dialFailed {
+				log.Printf("[MySQL Mirror] Failed to connect to %s: %v", m.config.Addr, err)
+				s.dialFailed = true
+			}
+			return
+		}
+		s.conn = conn
+		s.db = event.Database
+		s.dialFailed = false
+	}
+
+	start := time.Now()
+	stop := m.watch(s.conn)
+	result, err := m.runStatement(s, event)
+	duration := time.Since(start)
+	if stop() {
+		err = fmt.Errorf("mirror statement timed out after %s", m.config.Timeout)
+	}
+	if isConnError(err) {
+		m.failed.Add(1)
+		log.Printf("[MySQL Mirror] Connection to %s failed: %v", m.config.Addr, err)
+		m.closeSession(s)
+		return
+	}
+	if !task.compare {
+		return
+	}
+
+	m.compared.Add(1)
+	primary := task.primary.summary()
+	mirror := snapshotResult(result, err, duration).summary()
+	differences := compareResults(&primary, &mirror)
+	if len(differences) == 0 {
+		m.matched.Add(1)
+		return
+	}
+	m.diffs.Add(1)
+	m.emit(event, &MirrorDiff{
+		Addr:        m.config.Addr,
+		Differences: differences,
+		Primary:     primary,
+		Mirror:      mirror,
+	})
+}
+
+// runStatement 切换到语句在主库执行时的当前数据库后执行语句
+func (m *Mirror) runStatement(s *mirrorSession, event *QueryEvent) (*mysql.Result, error) {
+	if event.Database != "" && event.Database != s.db {
+		if err := s.conn.UseDB(event.Database); err != nil {
+			return nil, err
+		}
+		s.db = event.Database
+	}
+	if event.Type == "execute" {
+		stmt, err := s.conn.prepare(event.Query)
+		if err != nil {
+			return nil, err
+		}
+		return stmt.Execute(event.Args...)
+	}
+	return s.conn.Execute(event.Query)
+}
+
+// watch 超时后关闭镜像连接，返回的函数停止计时并报告是否已超时
+func (m *Mirror) watch(conn *backendConn) func() bool {
+	timer := time.AfterFunc(m.config.Timeout, conn.interrupt)
+	return func() bool { return !timer.Stop() }
+}
+
+// closeSession 关闭会话的镜像连接，下一条语句重新连接
+func (m *Mirror) closeSession(s *mirrorSession) {
+	if s.conn == nil {
+		return
+	}
+	s.conn.closeStmts()
+	s.conn.Close()
+	s.conn = nil
+}
+
+// emit 产生 mirror_diff 事件，除结果对比外的字段与原语句相同，插件按对比完成时的启停状态回调
+func (m *Mirror) emit(event *QueryEvent, diff *MirrorDiff) {
+	diffEvent := *event
+	diffEvent.Type = "mirror_diff"
+	diffEvent.Timestamp = time.Now()
+	diffEvent.Duration = diff.Mirror.Duration
+	diffEvent.SlowQuery = nil
+	diffEvent.Mirror = diff
+	diffEvent.disabled = nil
+	m.pluginManager.OnQuery(&diffEvent)
+	m.pluginManager.OnQueryComplete(&diffEvent, nil, nil)
+}
+
+// Stats 返回影子流量统计
+func (m *Mirror) Stats() MirrorStats {
+	m.mu.Lock()
+	sessions := len(m.sessions)
+	m.mu.Unlock()
+
+	return MirrorStats{
+		Addr:     m.config.Addr,
+		Mode:     m.config.Mode,
+		Sessions: sessions,
+		Compared: m.compared.Load(),
+		Matched:  m.matched.Load(),
+		Diffs:    m.diffs.Load(),
+		Dropped:  m.dropped.Load(),
+		Failed:   m.failed.Load(),
+	}
+}
+
+// ServeHTTP 以JSON返回影子流量统计
+func (m *Mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(m.Stats())
+}
+
+// Close 停止接收语句，等待队列中的语句执行完毕
+func (m *Mirror) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	for connID, s := range m.sessions {
+		delete(m.sessions, connID)
+		close(s.queue)
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+	return nil
+}
+
+// snapshotResult 取出结果快照，只复制行的引用（脱敏等插件替换行数据而不修改原有的字节）
+func snapshotResult(result *mysql.Result, err error, duration time.Duration) mirrorSnapshot {
+	snap := mirrorSnapshot{err: err, duration: duration}
+	if err != nil || result == nil {
+		return snap
+	}
+	snap.affected = result.AffectedRows
+	if result.Resultset != nil {
+		snap.resultset = true
+		snap.columns = make([]string, len(result.Fields))
+		for i, f := range result.Fields {
+			snap.columns[i] = string(f.Name)
+		}
+		snap.rows = append([]mysql.RowData(nil), result.RowDatas...)
+	}
+	return snap
+}
+
+// summary 计算结果摘要，校验和为各行原始数据 FNV-1a 64 位哈希之和，与行的顺序无关
+func (s mirrorSnapshot) summary() MirrorResult {
+	r := MirrorResult{Duration: s.duration}
+	if s.err != nil {
+		r.Error = s.err.Error()
+		var myErr *mysql.MyError
+		if errors.As(s.err, &myErr) {
+			r.code = myErr.Code
+		}
+		return r
+	}
+	if !s.resultset {
+		r.Rows = s.affected
+		return r
+	}
+	r.Columns = s.columns
+	r.Rows = uint64(len(s.rows))
+	var sum uint64
+	h := fnv.New64a()
+	for _, row := range s.rows {
+		h.Reset()
+		h.Write(row)
+		sum += h.Sum64()
+	}
+	r.Checksum = fmt.Sprintf("%016x", sum)
+	return r
+}
+
+// compareResults 返回主库和镜像结果不一致的项；两边都出错时只比较错误码
+func compareResults(primary, mirror *MirrorResult) []string {
+	if primary.Error != "" || mirror.Error != "" {
+		if primary.Error == "" || mirror.Error == "" || primary.code != mirror.code {
+			return []string{"error"}
+		}
+		return nil
+	}
+	var differences []string
+	if !sameColumns(primary.Columns, mirror.Columns) {
+		differences = append(differences, "columns")
+	}
+	if primary.Rows != mirror.Rows {
+		differences = append(differences, "rows")
+	}
+	if primary.Checksum != mirror.Checksum {
+		differences = append(differences, "checksum")
+	}
+	return differences
+}
+
+// sameColumns 比较列名集合，不区分顺序和大小写
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	normalize := func(columns []string) []string {
+		out := make([]string, len(columns))
+		for i, c := range columns {
+			out[i] = strings.ToLower(c)
+		}
+		sort.Strings(out)
+		return out
+	}
+	na, nb := normalize(a), normalize(b)
+	for i := range na {
+		if na[i] != nb[i] {
+			return false
+		}
+	}
+	return true
+}
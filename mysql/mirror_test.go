@@ -0,0 +1,229 @@
+package mysql
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// collectPlugin 记录收到 OnQueryComplete 的事件（复制事件，之后的修改不影响记录）
+type collectPlugin struct {
+	name   string
+	events []QueryEvent
+}
+
+func (p *collectPlugin) Name() string              { return p.name }
+func (p *collectPlugin) OnQuery(event *QueryEvent) {}
+func (p *collectPlugin) Close() error              { return nil }
+func (p *collectPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	p.events = append(p.events, *event)
+}
+
+// types 返回记录的事件类型
+func (p *collectPlugin) types() []string {
+	types := make([]string, len(p.events))
+	for i, e := range p.events {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func TestMirrorEmitPluginStates(t *testing.T) {
+	pm := NewPluginManager()
+	audit := &collectPlugin{name: "audit"}
+	pm.Register(audit)
+	m, err := NewMirror(MirrorConfig{Addr: "mirror:3306"}, nil, pm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 语句执行时插件开启，对比完成前被关闭
+	event := &QueryEvent{Type: "query", Query: "SELECT 1", Timestamp: time.Now()}
+	pm.OnQueryComplete(event, nil, nil)
+	pm.SetEnabled("audit", false)
+	m.emit(event, &MirrorDiff{Differences: []string{"rows"}})
+
+	if types := audit.types(); len(types) != 1 || types[0] != "query" {
+		t.Errorf("audit saw %v, want only the statement", types)
+	}
+
+	// 重新开启后的 mirror_diff 事件照常送达
+	pm.SetEnabled("audit", true)
+	m.emit(event, &MirrorDiff{Differences: []string{"rows"}})
+	if types := audit.types(); len(types) != 2 || types[1] != "mirror_diff" {
+		t.Errorf("audit saw %v, want the statement and one mirror_diff", types)
+	}
+}
+
+func TestCompareResults(t *testing.T) {
+	rows := func(names []string, values ...[]interface{}) *mysql.Result {
+		return buildResult(t, false, names, values)
+	}
+	deadlock := mysql.NewError(mysql.ER_LOCK_DEADLOCK, "Deadlock found")
+	tests := []struct {
+		name          string
+		primary       *mysql.Result
+		primaryErr    error
+		mirror        *mysql.Result
+		mirrorErr     error
+		wantDiffering []string
+	}{
+		{
+			name:    "same rows in another order",
+			primary: rows([]string{"id", "name"}, []interface{}{int64(1), "a"}, []interface{}{int64(2), "b"}),
+			mirror:  rows([]string{"ID", "name"}, []interface{}{int64(2), "b"}, []interface{}{int64(1), "a"}),
+		},
+		{
+			name:          "column mismatch",
+			primary:       rows([]string{"id", "name"}, []interface{}{int64(1), "a"}),
+			mirror:        rows([]string{"id", "title"}, []interface{}{int64(1), "a"}),
+			wantDiffering: []string{"columns"},
+		},
+		{
+			name:          "missing row",
+			primary:       rows([]string{"id"}, []interface{}{int64(1)}, []interface{}{int64(2)}),
+			mirror:        rows([]string{"id"}, []interface{}{int64(1)}),
+			wantDiffering: []string{"rows", "checksum"},
+		},
+		{
+			name:          "changed value",
+			primary:       rows([]string{"id", "name"}, []interface{}{int64(1), "a"}),
+			mirror:        rows([]string{"id", "name"}, []interface{}{int64(1), "b"}),
+			wantDiffering: []string{"checksum"},
+		},
+		{
+			name:          "affected rows",
+			primary:       &mysql.Result{AffectedRows: 2},
+			mirror:        &mysql.Result{AffectedRows: 1},
+			wantDiffering: []string{"rows"},
+		},
+		{
+			name:          "error on the mirror only",
+			primary:       rows([]string{"id"}, []interface{}{int64(1)}),
+			mirrorErr:     mysql.NewError(mysql.ER_NO_SUCH_TABLE, "Table 'shop.t' doesn't exist"),
+			wantDiffering: []string{"error"},
+		},
+		{
+			name:          "error on the primary only",
+			primaryErr:    deadlock,
+			mirror:        &mysql.Result{},
+			wantDiffering: []string{"error"},
+		},
+		{
+			name:       "same error code",
+			primaryErr: deadlock,
+			mirrorErr:  mysql.NewError(mysql.ER_LOCK_DEADLOCK, "Deadlock found when trying to get lock"),
+		},
+		{
+			name:          "different error codes",
+			primaryErr:    deadlock,
+			mirrorErr:     mysql.NewError(mysql.ER_LOCK_WAIT_TIMEOUT, "Lock wait timeout exceeded"),
+			wantDiffering: []string{"error"},
+		},
+		{
+			name:          "error without a code",
+			primaryErr:    deadlock,
+			mirrorErr:     errors.New("mirror statement timed out after 30s"),
+			wantDiffering: []string{"error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := snapshotResult(tt.primary, tt.primaryErr, time.Millisecond).summary()
+			mirror := snapshotResult(tt.mirror, tt.mirrorErr, time.Millisecond).summary()
+			got := compareResults(&primary, &mirror)
+			if strings.Join(got, ",") != strings.Join(tt.wantDiffering, ",") {
+				t.Errorf("compareResults() = %v, want %v", got, tt.wantDiffering)
+			}
+		})
+	}
+}
+
+func TestMirrorAccept(t *testing.T) {
+	tests := []struct {
+		mode        string
+		query       string
+		txID        uint64
+		wantOK      bool // 是否镜像
+		wantCompare bool // 镜像时是否对比结果
+	}{
+		{query: "SELECT * FROM t", wantOK: true, wantCompare: true},
+		{query: "SELECT * FROM t FOR UPDATE"},
+		{query: "UPDATE t SET a = 1"},
+		{query: "SELECT * FROM t", txID: 1},
+		// SET 维持镜像连接的会话状态，只执行不对比
+		{query: "SET NAMES utf8mb4", wantOK: true},
+		{query: "SET NAMES utf8mb4", txID: 1, wantOK: true},
+		{query: "SET GLOBAL max_connections = 10"},
+		{query: "SET @@global.sql_mode = ''"},
+		{mode: MirrorAll, query: "UPDATE t SET a = 1", txID: 1, wantOK: true, wantCompare: true},
+		{mode: MirrorAll, query: "SET GLOBAL max_connections = 10", wantOK: true, wantCompare: true},
+	}
+	for _, tt := range tests {
+		m, err := NewMirror(MirrorConfig{Addr: "mirror:3306", Mode: tt.mode}, nil, NewPluginManager())
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare, ok := m.accept(&QueryEvent{Type: "query", Query: tt.query, TxID: tt.txID})
+		if ok != tt.wantOK || (ok && compare != tt.wantCompare) {
+			t.Errorf("%s %q (tx %d): accept() = %v, %v, want %v, %v", m.config.Mode, tt.query, tt.txID, compare, ok, tt.wantCompare, tt.wantOK)
+		}
+	}
+}
+
+func TestMirrorQueue(t *testing.T) {
+	b := serveSessionBackend(t)
+	dialer, err := NewDialer(BackendTLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := NewPluginManager()
+	events := &collectPlugin{name: "collect"}
+	pm.Register(events)
+	m, err := NewMirror(MirrorConfig{Addr: b.addr, User: "app", MaxSessions: 1, QueueSize: 1, Timeout: 100 * time.Millisecond}, dialer, pm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.Register(m)
+
+	complete := func(connID uint32, query string, result *mysql.Result) {
+		event := &QueryEvent{Type: "query", ConnID: connID, Query: query, Backend: "primary:3306", Timestamp: time.Now()}
+		m.OnQueryComplete(event, result, nil)
+	}
+
+	// 镜像上的第一条语句一直执行到超时，期间队列只能再放一条
+	complete(1, "SELECT SLEEP(10)", &mysql.Result{})
+	deadline := time.Now().Add(2 * time.Second)
+	for !b.received("SELECT SLEEP(10)") {
+		if time.Now().After(deadline) {
+			t.Fatal("mirror did not run the first statement")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	complete(1, "SELECT * FROM t", buildResult(t, false, []string{"id"}, [][]interface{}{{int64(1)}}))
+	complete(1, "SELECT * FROM t WHERE id = 2", &mysql.Result{})
+	// 会话数达到上限，其他会话的语句不镜像
+	complete(2, "SELECT * FROM t", &mysql.Result{})
+	// 不镜像的语句不计入丢弃
+	complete(1, "UPDATE t SET a = 1", &mysql.Result{})
+	m.Close()
+
+	// 超时的语句没有对比；排队的语句在新连接上执行，镜像返回 OK 包而主库返回一行
+	stats := m.Stats()
+	if stats.Dropped != 2 || stats.Failed != 1 || stats.Compared != 1 || stats.Diffs != 1 || stats.Sessions != 0 {
+		t.Errorf("stats = %+v, want 2 dropped, 1 failed, 1 compared with a diff", stats)
+	}
+	if len(events.events) != 1 || events.events[0].Type != "mirror_diff" {
+		t.Fatalf("events %v, want one mirror_diff", events.types())
+	}
+	diff := events.events[0]
+	if diff.Query != "SELECT * FROM t" || strings.Join(diff.Mirror.Differences, ",") != "columns,rows,checksum" || diff.Mirror.Primary.Rows != 1 {
+		t.Errorf("mirror_diff for %q: %+v", diff.Query, diff.Mirror)
+	}
+	if complete(1, "SELECT 1", &mysql.Result{}); m.Stats().Dropped != 3 {
+		t.Error("statement accepted after Close")
+	}
+}
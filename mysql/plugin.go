@@ -2,6 +2,7 @@ package mysql
 
 import (
 	"log"
+	"sync/atomic"
 
 	"github.com/go-mysql-org/go-mysql/mysql"
 )
@@ -21,35 +22,209 @@ type Plugin interface {
 	Close() error
 }
 
+// Interceptor 拦截器接口，插件可选实现
+// 在语句转发到MySQL服务器之前按注册顺序调用：
+//   - 修改 event.Query / event.Args 即可改写语句，Fingerprint 和 Digest 随之按改写后的语句重新计算
+//   - 返回非nil的 error 则拒绝执行，错误原样返回给客户端（可用 NewError 指定错误码和SQLSTATE）
+//   - 返回非nil的 *mysql.Result 则直接用该结果响应客户端，不再转发
+type Interceptor interface {
+	Intercept(event *QueryEvent) (*mysql.Result, error)
+}
+
+// ConnectionPlugin 连接生命周期接口，插件可选实现
+type ConnectionPlugin interface {
+	// OnConnect 客户端认证通过并连接后端后调用，连接后端失败时 event.Error 为失败原因
+	OnConnect(event *ConnEvent)
+
+	// OnAuthFailure 客户端握手或认证失败时调用
+	OnAuthFailure(event *ConnEvent)
+
+	// OnDialFailure 连接后端失败时调用
+	OnDialFailure(event *ConnEvent)
+
+	// OnDisconnect 客户端连接关闭时调用
+	OnDisconnect(event *ConnEvent)
+}
+
+// BackendPlugin 后端状态接口，插件可选实现
+type BackendPlugin interface {
+	// OnBackendState 健康检查发现后端状态变化（backend_up / backend_read_only / backend_down）
+	// 或新连接改发往其他后端（failover）时调用
+	OnBackendState(event *ConnEvent)
+}
+
+// NewError 创建一个MySQL错误，客户端会收到对应的错误码和SQLSTATE
+func NewError(code uint16, state string, message string) error {
+	err := mysql.NewError(code, message)
+	if state != "" {
+		err.State = state
+	}
+	return err
+}
+
 // PluginManager 插件管理器
 type PluginManager struct {
-	plugins []Plugin
+	plugins        []Plugin
+	interceptors   []Plugin // 同时实现了 Interceptor 的插件
+	connPlugins    []Plugin // 同时实现了 ConnectionPlugin 的插件
+	backendPlugins []Plugin // 同时实现了 BackendPlugin 的插件
+
+	// 暂停的插件（按名称），替换整个集合而不修改，事件保存第一次回调时的集合
+	disabled atomic.Pointer[map[string]bool]
+}
+
+// PluginState 插件及其是否暂停
+type PluginState struct {
+	Name    string
+	Enabled bool
 }
 
 // NewPluginManager 创建插件管理器
 func NewPluginManager() *PluginManager {
-	return &PluginManager{
+	pm := &PluginManager{
 		plugins: make([]Plugin, 0),
 	}
+	pm.disabled.Store(&map[string]bool{})
+	return pm
 }
 
 // Register 注册插件
 func (pm *PluginManager) Register(p Plugin) {
 	pm.plugins = append(pm.plugins, p)
+	if _, ok := p.(Interceptor); ok {
+		pm.interceptors = append(pm.interceptors, p)
+	}
+	if _, ok := p.(ConnectionPlugin); ok {
+		pm.connPlugins = append(pm.connPlugins, p)
+	}
+	if _, ok := p.(BackendPlugin); ok {
+		pm.backendPlugins = append(pm.backendPlugins, p)
+	}
 	log.Printf("[MySQL PluginManager] Registered plugin: %s", p.Name())
 }
 
+// Plugins 返回所有插件及其是否暂停，按注册顺序排列
+func (pm *PluginManager) Plugins() []PluginState {
+	disabled := *pm.disabled.Load()
+	states := make([]PluginState, 0, len(pm.plugins))
+	for _, p := range pm.plugins {
+		states = append(states, PluginState{Name: p.Name(), Enabled: !disabled[p.Name()]})
+	}
+	return states
+}
+
+// SetEnabled 暂停或恢复插件的语句回调（Intercept / OnQuery / OnQueryComplete），插件不存在时返回 false
+// 连接和后端事件不受影响，插件仍能维护按连接保存的状态；执行中的语句按开始时的状态完成回调
+func (pm *PluginManager) SetEnabled(name string, enabled bool) bool {
+	found := false
+	for _, p := range pm.plugins {
+		if p.Name() == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	for {
+		old := pm.disabled.Load()
+		disabled := make(map[string]bool, len(*old)+1)
+		for k, v := range *old {
+			disabled[k] = v
+		}
+		if enabled {
+			delete(disabled, name)
+		} else {
+			disabled[name] = true
+		}
+		if pm.disabled.CompareAndSwap(old, &disabled) {
+			return true
+		}
+	}
+}
+
+// disabledFor 返回事件使用的暂停插件集合，同一事件的所有回调使用第一次回调时的集合
+func (pm *PluginManager) disabledFor(event *QueryEvent) map[string]bool {
+	if event.disabled == nil {
+		event.disabled = pm.disabled.Load()
+	}
+	return *event.disabled
+}
+
 // OnQuery 触发所有插件的 OnQuery
 func (pm *PluginManager) OnQuery(event *QueryEvent) {
+	disabled := pm.disabledFor(event)
 	for _, p := range pm.plugins {
-		p.OnQuery(event)
+		if !disabled[p.Name()] {
+			p.OnQuery(event)
+		}
 	}
 }
 
+// Intercept 依次调用拦截器，第一个返回结果或错误的拦截器将终止语句的转发
+func (pm *PluginManager) Intercept(event *QueryEvent) (*mysql.Result, error) {
+	disabled := pm.disabledFor(event)
+	for _, p := range pm.interceptors {
+		if disabled[p.Name()] {
+			continue
+		}
+		query := event.Query
+		result, err := p.(Interceptor).Intercept(event)
+		if event.Query != query {
+			// 之后的拦截器和插件按改写后的语句统计、限流和匹配
+			event.Fingerprint = Fingerprint(event.Query)
+			event.Digest = Digest(event.Fingerprint)
+		}
+		if err != nil || result != nil {
+			event.InterceptedBy = p.Name()
+			return result, err
+		}
+	}
+	return nil, nil
+}
+
 // OnQueryComplete 触发所有插件的 OnQueryComplete
 func (pm *PluginManager) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	disabled := pm.disabledFor(event)
 	for _, p := range pm.plugins {
-		p.OnQueryComplete(event, result, err)
+		if !disabled[p.Name()] {
+			p.OnQueryComplete(event, result, err)
+		}
+	}
+}
+
+// OnConnect 触发所有插件的 OnConnect
+func (pm *PluginManager) OnConnect(event *ConnEvent) {
+	for _, p := range pm.connPlugins {
+		p.(ConnectionPlugin).OnConnect(event)
+	}
+}
+
+// OnAuthFailure 触发所有插件的 OnAuthFailure
+func (pm *PluginManager) OnAuthFailure(event *ConnEvent) {
+	for _, p := range pm.connPlugins {
+		p.(ConnectionPlugin).OnAuthFailure(event)
+	}
+}
+
+// OnDialFailure 触发所有插件的 OnDialFailure
+func (pm *PluginManager) OnDialFailure(event *ConnEvent) {
+	for _, p := range pm.connPlugins {
+		p.(ConnectionPlugin).OnDialFailure(event)
+	}
+}
+
+// OnDisconnect 触发所有插件的 OnDisconnect
+func (pm *PluginManager) OnDisconnect(event *ConnEvent) {
+	for _, p := range pm.connPlugins {
+		p.(ConnectionPlugin).OnDisconnect(event)
+	}
+}
+
+// OnBackendState 触发所有插件的 OnBackendState
+func (pm *PluginManager) OnBackendState(event *ConnEvent) {
+	for _, p := range pm.backendPlugins {
+		p.(BackendPlugin).OnBackendState(event)
 	}
 }
 
@@ -0,0 +1,80 @@
+package mysql
+
+import (
+	"log"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/if-nil/proxyx/audit"
+	"github.com/if-nil/proxyx/rotate"
+)
+
+// AuditPluginConfig 审计日志插件配置
+type AuditPluginConfig struct {
+	Enabled       bool   `yaml:"enabled"`  // 是否启用
+	HMACKey       string `yaml:"hmac_key"` // 哈希链的 HMAC 密钥，为空时使用 SHA-256；校验时需要相同的密钥
+	rotate.Config `yaml:",inline"`
+}
+
+// AuditPlugin 审计日志插件 - 将语句、连接和后端状态事件以 JSON Lines 写入本地文件，
+// 每行带有链接上一行的哈希，删改可以通过 proxyx verify-audit 发现
+type AuditPlugin struct {
+	writer *audit.Writer
+}
+
+// NewAuditPlugin 创建审计日志插件
+func NewAuditPlugin(config AuditPluginConfig) (*AuditPlugin, error) {
+	writer, err := audit.NewWriter(config.Config, []byte(config.HMACKey))
+	if err != nil {
+		return nil, err
+	}
+	return &AuditPlugin{writer: writer}, nil
+}
+
+func (p *AuditPlugin) Name() string {
+	return "AuditPlugin"
+}
+
+func (p *AuditPlugin) OnQuery(event *QueryEvent) {}
+
+func (p *AuditPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if result != nil {
+		event.RowCount = int(result.AffectedRows)
+		if result.Resultset != nil {
+			event.RowCount = result.Resultset.RowNumber()
+		}
+	}
+	p.write(event.Type, event)
+}
+
+func (p *AuditPlugin) OnConnect(event *ConnEvent) {
+	p.write(event.Type, event)
+}
+
+func (p *AuditPlugin) OnAuthFailure(event *ConnEvent) {
+	p.write(event.Type, event)
+}
+
+func (p *AuditPlugin) OnDialFailure(event *ConnEvent) {
+	p.write(event.Type, event)
+}
+
+func (p *AuditPlugin) OnDisconnect(event *ConnEvent) {
+	p.write(event.Type, event)
+}
+
+func (p *AuditPlugin) OnBackendState(event *ConnEvent) {
+	p.write(event.Type, event)
+}
+
+func (p *AuditPlugin) write(eventType string, event interface{}) {
+	if err := p.writer.Write(eventType, event); err != nil {
+		log.Printf("[AuditPlugin] Write error: %v", err)
+	}
+}
+
+func (p *AuditPlugin) Close() error {
+	return p.writer.Close()
+}
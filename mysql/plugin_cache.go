@@ -0,0 +1,393 @@
+package mysql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/if-nil/proxyx/web"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// 缓存存储类型
+const (
+	CacheStoreMemory = "memory"
+	CacheStoreRedis  = "redis"
+)
+
+// cacheAllTables 所有缓存键都包含的全局版本号，无法确定写语句涉及的表或手动清空时递增
+const cacheAllTables = "*"
+
+// 结果依赖执行时间或会话状态的函数，包含它们的语句不缓存
+var nonDeterministic = regexp.MustCompile(`(?i)\b(?:(?:now|sysdate|curdate|curtime|unix_timestamp|utc_date|utc_time|utc_timestamp|rand|uuid|uuid_short|connection_id|current_user|user|session_user|system_user|database|schema|sleep|benchmark)\s*\(|(?:current_date|current_time|current_timestamp|localtime|localtimestamp)\b)`)
+
+// CachePluginConfig 结果缓存插件配置
+type CachePluginConfig struct {
+	Enabled       bool             `yaml:"enabled"`         // 是否启用
+	Store         string           `yaml:"store"`           // 存储类型：memory / redis
+	TTL           time.Duration    `yaml:"ttl"`             // 默认缓存时长
+	MaxBytes      int64            `yaml:"max_bytes"`       // memory 存储的容量（字节），超出时按LRU淘汰
+	MaxEntryBytes int64            `yaml:"max_entry_bytes"` // 单个结果集的最大字节数，超出时不缓存
+	Redis         CacheRedisConfig `yaml:"redis"`           // redis 存储的连接配置
+	Rules         []CacheRule      `yaml:"rules"`           // 缓存规则，没有规则时不缓存任何语句
+	APIToken      string           `yaml:"api_token"`       // 通过 API 清空缓存时需要的令牌（Authorization: Bearer <token>），为空时 API 只读
+}
+
+// CacheRedisConfig Redis缓存存储配置
+type CacheRedisConfig struct {
+	Addr      string `yaml:"addr"`       // Redis地址
+	Password  string `yaml:"password"`   // Redis密码
+	DB        int    `yaml:"db"`         // Redis数据库
+	KeyPrefix string `yaml:"key_prefix"` // 键前缀
+}
+
+// CacheRule 缓存规则：语句涉及的表全部在 Tables 中，或指纹在 Digests 中时可以缓存
+type CacheRule struct {
+	Tables  []string      `yaml:"tables"`  // 可缓存的表，格式 table、db.table 或 db.*
+	Digests []string      `yaml:"digests"` // 可缓存的SQL指纹摘要或指纹
+	TTL     time.Duration `yaml:"ttl"`     // 缓存时长，为 0 时使用默认值
+}
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Hits          int64 `json:"hits"`          // 命中次数
+	Misses        int64 `json:"misses"`        // 未命中次数
+	Stores        int64 `json:"stores"`        // 写入缓存的次数
+	Invalidations int64 `json:"invalidations"` // 写语句导致的失效次数
+}
+
+// cachePending 未命中的语句，执行完成后写入缓存
+type cachePending struct {
+	key string
+	ttl time.Duration
+}
+
+// CachePlugin 结果缓存插件 - 缓存 SELECT 的结果集，写语句经过代理时使涉及的表的缓存失效
+type CachePlugin struct {
+	config  CachePluginConfig
+	store   cacheStore
+	parsers sync.Pool
+
+	pending  sync.Map // *QueryEvent -> cachePending，等待写入缓存的语句
+	writes   sync.Map // *QueryEvent -> []string，执行完成后需要再次失效的表
+	txWrites sync.Map // cacheTx -> []string，事务中写入的表，事务结束时再次失效
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	stores        atomic.Int64
+	invalidations atomic.Int64
+}
+
+// NewCachePlugin 创建结果缓存插件
+func NewCachePlugin(config CachePluginConfig) (*CachePlugin, error) {
+	if config.TTL <= 0 {
+		config.TTL = time.Minute
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 64 << 20
+	}
+	if config.MaxEntryBytes <= 0 {
+		config.MaxEntryBytes = 1 << 20
+	}
+
+	p := &CachePlugin{
+		config: config,
+		parsers: sync.Pool{
+			New: func() interface{} { return parser.New() },
+		},
+	}
+
+	switch config.Store {
+	case "", CacheStoreMemory:
+		p.store = newMemoryCacheStore(config.MaxBytes)
+	case CacheStoreRedis:
+		if config.Redis.KeyPrefix == "" {
+			config.Redis.KeyPrefix = "proxyx:cache:"
+		}
+		store, err := newRedisCacheStore(config.Redis)
+		if err != nil {
+			return nil, err
+		}
+		p.store = store
+	default:
+		return nil, fmt.Errorf("unknown cache store: %s", config.Store)
+	}
+	return p, nil
+}
+
+func (p *CachePlugin) Name() string {
+	return "CachePlugin"
+}
+
+func (p *CachePlugin) OnQuery(event *QueryEvent) {}
+
+// cacheTx 标识一个会话中的事务
+type cacheTx struct {
+	connID uint32
+	txID   uint64
+}
+
+// Intercept 命中缓存时直接返回结果；写语句在执行前先使涉及的表失效
+// 事务中或关闭自动提交时的读取可能看到本事务未提交的写入，不查找也不写入缓存
+func (p *CachePlugin) Intercept(event *QueryEvent) (*mysql.Result, error) {
+	kind, _ := classifyStatement(event.Query)
+	switch kind {
+	case kindRead:
+		if event.TxID != 0 || !event.Autocommit {
+			return nil, nil
+		}
+		return p.lookup(event)
+	case kindWrite:
+		tables := p.writeTables(event)
+		p.invalidate(tables)
+		p.writes.Store(event, tables)
+	}
+	return nil, nil
+}
+
+// lookup 查找缓存，未命中时记录缓存键，执行完成后写入
+func (p *CachePlugin) lookup(event *QueryEvent) (*mysql.Result, error) {
+	if nonDeterministic.MatchString(event.Query) {
+		return nil, nil
+	}
+	tables, ok := p.readTables(event)
+	if !ok {
+		return nil, nil
+	}
+	ttl, ok := p.match(event, tables)
+	if !ok {
+		return nil, nil
+	}
+
+	tables = append(tables, cacheAllTables)
+	versions, err := p.store.Versions(tables)
+	if err != nil {
+		log.Printf("[CachePlugin] Load table versions error: %v", err)
+		return nil, nil
+	}
+	key := cacheKey(event, tables, versions)
+
+	if data, ok := p.store.Get(key); ok {
+		result, err := decodeResult(data, event.Type == "execute")
+		if err == nil {
+			p.hits.Add(1)
+			return result, nil
+		}
+		log.Printf("[CachePlugin] Decode cached result error: %v", err)
+	}
+
+	p.misses.Add(1)
+	p.pending.Store(event, cachePending{key: key, ttl: ttl})
+	return nil, nil
+}
+
+func (p *CachePlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	// 事务结束（提交或回滚）时再失效一次事务中写入的表，清除提交前其他连接缓存的旧结果
+	if event.Type == "transaction" {
+		if tables, ok := p.txWrites.LoadAndDelete(cacheTx{event.ConnID, event.TxID}); ok {
+			p.invalidate(tables.([]string))
+		}
+		return
+	}
+
+	// 写语句执行完成后再失效一次，清除执行期间其他连接写入的旧结果
+	if tables, ok := p.writes.LoadAndDelete(event); ok {
+		p.invalidate(tables.([]string))
+		if event.TxID != 0 {
+			key := cacheTx{event.ConnID, event.TxID}
+			written, _ := p.txWrites.Load(key)
+			list, _ := written.([]string)
+			p.txWrites.Store(key, append(list, tables.([]string)...))
+		}
+		return
+	}
+
+	v, ok := p.pending.LoadAndDelete(event)
+	if !ok || err != nil || result == nil || result.Resultset == nil || event.InterceptedBy != "" {
+		return
+	}
+	pending := v.(cachePending)
+
+	data, encodeErr := encodeResult(result)
+	if encodeErr != nil {
+		log.Printf("[CachePlugin] Encode result error: %v", encodeErr)
+		return
+	}
+	if int64(len(data)) > p.config.MaxEntryBytes {
+		return
+	}
+	p.store.Set(pending.key, data, pending.ttl)
+	p.stores.Add(1)
+}
+
+// readTables 解析只读语句，返回涉及的表（db.table，小写），无法缓存时返回 false
+func (p *CachePlugin) readTables(event *QueryEvent) ([]string, bool) {
+	psr := p.parsers.Get().(*parser.Parser)
+	stmts, _, err := psr.Parse(event.Query, "", "")
+	p.parsers.Put(psr)
+	if err != nil || len(stmts) != 1 {
+		return nil, false
+	}
+	switch stmts[0].(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt:
+	default:
+		return nil, false
+	}
+
+	tables := collectTables(stmts[0], event.Database)
+	return tables, len(tables) > 0
+}
+
+// writeTables 解析写语句，返回需要失效的表，无法解析时使全部缓存失效
+func (p *CachePlugin) writeTables(event *QueryEvent) []string {
+	psr := p.parsers.Get().(*parser.Parser)
+	stmts, _, err := psr.Parse(event.Query, "", "")
+	p.parsers.Put(psr)
+	if err != nil {
+		return []string{cacheAllTables}
+	}
+
+	var tables []string
+	for _, stmt := range stmts {
+		tables = append(tables, collectTables(stmt, event.Database)...)
+	}
+	if len(tables) == 0 {
+		return []string{cacheAllTables}
+	}
+	return tables
+}
+
+// match 按规则判断语句是否可以缓存，返回缓存时长
+func (p *CachePlugin) match(event *QueryEvent, tables []string) (time.Duration, bool) {
+	for _, rule := range p.config.Rules {
+		if rule.matchDigest(event) || rule.matchTables(tables) {
+			if rule.TTL > 0 {
+				return rule.TTL, true
+			}
+			return p.config.TTL, true
+		}
+	}
+	return 0, false
+}
+
+func (r *CacheRule) matchDigest(event *QueryEvent) bool {
+	for _, d := range r.Digests {
+		if strings.EqualFold(d, event.Digest) || d == event.Fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CacheRule) matchTables(tables []string) bool {
+	if len(r.Tables) == 0 {
+		return false
+	}
+	for _, t := range tables {
+		if !r.matchTable(t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *CacheRule) matchTable(table string) bool {
+	schema, name, _ := strings.Cut(table, ".")
+	for _, pattern := range r.Tables {
+		pattern = strings.ToLower(pattern)
+		if pattern == table || pattern == name || pattern == schema+".*" {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidate 递增表的版本号
+func (p *CachePlugin) invalidate(tables []string) {
+	if err := p.store.Bump(tables); err != nil {
+		log.Printf("[CachePlugin] Invalidate %v error: %v", tables, err)
+		return
+	}
+	p.invalidations.Add(1)
+}
+
+// Flush 使全部缓存失效
+func (p *CachePlugin) Flush() {
+	p.invalidate([]string{cacheAllTables})
+}
+
+// Stats 返回缓存统计
+func (p *CachePlugin) Stats() CacheStats {
+	return CacheStats{
+		Hits:          p.hits.Load(),
+		Misses:        p.misses.Load(),
+		Stores:        p.stores.Load(),
+		Invalidations: p.invalidations.Load(),
+	}
+}
+
+// ServeHTTP 以JSON返回缓存统计；DELETE 请求清空缓存，需要 Authorization: Bearer <api_token>，响应不带 CORS 头
+func (p *CachePlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodDelete {
+		if web.AuthorizeChange(w, r, p.config.APIToken, "cache") {
+			p.Flush()
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(p.Stats())
+}
+
+func (p *CachePlugin) Close() error {
+	return p.store.Close()
+}
+
+// collectTables 收集语句引用的表，返回去重排序后的 db.table（小写）
+func collectTables(stmt ast.StmtNode, currentDB string) []string {
+	collector := &tableCollector{}
+	stmt.Accept(collector)
+
+	seen := make(map[string]bool, len(collector.tables))
+	tables := make([]string, 0, len(collector.tables))
+	for _, t := range collector.tables {
+		schema := t.Schema.L
+		if schema == "" {
+			schema = strings.ToLower(currentDB)
+		}
+		name := schema + "." + t.Name.L
+		if !seen[name] {
+			seen[name] = true
+			tables = append(tables, name)
+		}
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// cacheKey 由语句、数据库、参数、用户、后端以及涉及的表的版本号生成缓存键
+// 文本协议和二进制协议的行格式不同，事件类型也参与计算；不同用户可能映射到权限不同的后端账号或其他后端，
+// 用户和后端地址也参与计算，互不共享缓存
+func cacheKey(event *QueryEvent, tables []string, versions []int64) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00", event.Type, event.User, event.Backend, event.Database, strings.TrimRight(strings.TrimSpace(event.Query), "; \t\r\n"))
+	args, _ := json.Marshal(event.Args)
+	h.Write(args)
+	for i, t := range tables {
+		fmt.Fprintf(h, "\x00%s=%d", t, versions[i])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
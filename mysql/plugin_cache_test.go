@@ -0,0 +1,133 @@
+package mysql
+
+import (
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func TestCachePlugin(t *testing.T) {
+	// step 按顺序经过缓存插件的语句，未命中时以一行结果完成
+	type step struct {
+		query   string
+		user    string // 为空时使用 app
+		txID    uint64
+		flush   bool // 不执行语句，清空缓存
+		wantHit bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "repeated read",
+			steps: []step{
+				{query: "SELECT * FROM shop.t WHERE id = 1"},
+				{query: "SELECT * FROM shop.t WHERE id = 1", wantHit: true},
+				{query: "SELECT * FROM t WHERE id = 1"}, // 缓存键包含语句文本
+				{query: "SELECT * FROM shop.t WHERE id = 2"},
+			},
+		},
+		{
+			name: "write invalidates table",
+			steps: []step{
+				{query: "SELECT * FROM shop.t"},
+				{query: "UPDATE shop.t SET a = 1 WHERE id = 1"},
+				{query: "SELECT * FROM shop.t"},
+				{query: "SELECT * FROM shop.t", wantHit: true},
+			},
+		},
+		{
+			name: "write to other table",
+			steps: []step{
+				{query: "SELECT * FROM shop.t"},
+				{query: "DELETE FROM shop.u WHERE id = 1"},
+				{query: "SELECT * FROM shop.t", wantHit: true},
+			},
+		},
+		{
+			name: "unparsable write invalidates everything",
+			steps: []step{
+				{query: "SELECT * FROM shop.t"},
+				{query: "UPDATE shop.u SET"},
+				{query: "SELECT * FROM shop.t"},
+			},
+		},
+		{
+			name: "flush",
+			steps: []step{
+				{query: "SELECT * FROM shop.t"},
+				{flush: true},
+				{query: "SELECT * FROM shop.t"},
+			},
+		},
+		{
+			name: "per user",
+			steps: []step{
+				{query: "SELECT * FROM shop.t", user: "a"},
+				{query: "SELECT * FROM shop.t", user: "b"},
+				{query: "SELECT * FROM shop.t", user: "a", wantHit: true},
+			},
+		},
+		{
+			name: "transaction bypasses cache",
+			steps: []step{
+				{query: "SELECT * FROM shop.t", txID: 1},
+				{query: "SELECT * FROM shop.t"},
+				{query: "SELECT * FROM shop.t", txID: 2},
+				{query: "SELECT * FROM shop.t", wantHit: true},
+			},
+		},
+		{
+			name: "not cacheable",
+			steps: []step{
+				{query: "SELECT * FROM shop.other"},
+				{query: "SELECT * FROM shop.other"},
+				{query: "SELECT NOW(), a FROM shop.t"},
+				{query: "SELECT NOW(), a FROM shop.t"},
+				{query: "SELECT * FROM shop.t FOR UPDATE"},
+				{query: "SELECT * FROM shop.t FOR UPDATE"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewCachePlugin(CachePluginConfig{Rules: []CacheRule{{Tables: []string{"shop.t", "u"}}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+
+			for i, s := range tt.steps {
+				if s.flush {
+					p.Flush()
+					continue
+				}
+				user := s.user
+				if user == "" {
+					user = "app"
+				}
+				event := &QueryEvent{Type: "query", Query: s.query, User: user, Database: "shop", Backend: "primary:3306", TxID: s.txID, Autocommit: true}
+				event.Fingerprint = Fingerprint(event.Query)
+				event.Digest = Digest(event.Fingerprint)
+
+				result, err := p.Intercept(event)
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if hit := result != nil; hit != s.wantHit {
+					t.Errorf("step %d %q: hit = %v, want %v", i, s.query, hit, s.wantHit)
+				}
+				if result == nil {
+					rs, err := mysql.BuildSimpleTextResultset([]string{"id"}, [][]interface{}{{int64(i)}})
+					if err != nil {
+						t.Fatal(err)
+					}
+					result = &mysql.Result{Resultset: rs}
+				}
+				p.OnQueryComplete(event, result, nil)
+			}
+		})
+	}
+}
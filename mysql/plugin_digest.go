@@ -0,0 +1,301 @@
+package mysql
+
+import (
+	"container/list"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/if-nil/proxyx/web"
+)
+
+// DigestPluginConfig SQL指纹统计插件配置
+type DigestPluginConfig struct {
+	Enabled        bool          `yaml:"enabled"`         // 是否启用
+	MaxDigests     int           `yaml:"max_digests"`     // 最多统计的指纹数量，超出时淘汰最久未出现的指纹
+	SampleSize     int           `yaml:"sample_size"`     // 计算耗时分位数使用的最近样本数
+	ReportInterval time.Duration `yaml:"report_interval"` // 定期输出JSON报告的间隔（0表示不输出）
+	ReportFile     string        `yaml:"report_file"`     // 报告文件路径，为空时输出到日志
+	APIToken       string        `yaml:"api_token"`       // 通过 API 清空统计时需要的令牌（Authorization: Bearer <token>），为空时 API 只读
+}
+
+// DigestStats 单个SQL指纹的统计信息
+// MySQL 协议的 OK 包和结果集不带扫描行数（rows examined），RowsExamined 始终为 nil（JSON 中为 null），
+// 与扫描了 0 行区分开；扫描行数需要从 performance_schema 获取
+type DigestStats struct {
+	Digest       string        `json:"digest"`        // 指纹摘要
+	Fingerprint  string        `json:"fingerprint"`   // 归一化后的SQL
+	Example      string        `json:"example"`       // 最近一次出现的原始SQL
+	Count        int64         `json:"count"`         // 执行次数
+	Errors       int64         `json:"errors"`        // 出错次数
+	TotalTime    time.Duration `json:"total_time"`    // 总耗时
+	AvgTime      time.Duration `json:"avg_time"`      // 平均耗时
+	MaxTime      time.Duration `json:"max_time"`      // 最大耗时
+	P50          time.Duration `json:"p50"`           // 最近样本的耗时中位数
+	P95          time.Duration `json:"p95"`           // 最近样本的95分位耗时
+	P99          time.Duration `json:"p99"`           // 最近样本的99分位耗时
+	RowsReturned int64         `json:"rows_returned"` // 返回的总行数
+	RowsAffected int64         `json:"rows_affected"` // 影响的总行数
+	RowsExamined *int64        `json:"rows_examined"` // 扫描的总行数，代理无法获取，始终为 null
+	FirstSeen    time.Time     `json:"first_seen"`    // 首次出现时间
+	LastSeen     time.Time     `json:"last_seen"`     // 最近出现时间
+}
+
+// digestEntry 单个指纹的累计数据
+type digestEntry struct {
+	stats   DigestStats
+	samples []time.Duration // 最近的耗时样本（环形缓冲区）
+	next    int             // 下一个写入位置
+}
+
+// DigestPlugin SQL指纹统计插件 - 按指纹聚合执行次数、耗时分位数、错误数和行数
+type DigestPlugin struct {
+	config  DigestPluginConfig
+	mu      sync.Mutex
+	lru     *list.List // 最近出现的在前，超出 max_digests 时淘汰末尾的指纹
+	entries map[string]*list.Element
+	done    chan struct{}
+}
+
+// NewDigestPlugin 创建SQL指纹统计插件
+func NewDigestPlugin(config DigestPluginConfig) *DigestPlugin {
+	if config.MaxDigests <= 0 {
+		config.MaxDigests = 10000
+	}
+	if config.SampleSize <= 0 {
+		config.SampleSize = 1000
+	}
+
+	p := &DigestPlugin{
+		config:  config,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		done:    make(chan struct{}),
+	}
+	if config.ReportInterval > 0 {
+		go p.reportLoop()
+	}
+	return p
+}
+
+func (p *DigestPlugin) Name() string {
+	return "DigestPlugin"
+}
+
+func (p *DigestPlugin) OnQuery(event *QueryEvent) {}
+
+func (p *DigestPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	if event.Digest == "" || (event.Type != "query" && event.Type != "execute") {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var entry *digestEntry
+	if elem, ok := p.entries[event.Digest]; ok {
+		entry = elem.Value.(*digestEntry)
+		p.lru.MoveToFront(elem)
+	} else {
+		if p.lru.Len() >= p.config.MaxDigests {
+			p.evictOldest()
+		}
+		entry = &digestEntry{
+			stats: DigestStats{
+				Digest:      event.Digest,
+				Fingerprint: event.Fingerprint,
+				FirstSeen:   event.Timestamp,
+			},
+			samples: make([]time.Duration, 0, p.config.SampleSize),
+		}
+		p.entries[event.Digest] = p.lru.PushFront(entry)
+	}
+
+	s := &entry.stats
+	s.Example = event.Query
+	s.Count++
+	s.TotalTime += event.Duration
+	if event.Duration > s.MaxTime {
+		s.MaxTime = event.Duration
+	}
+	s.LastSeen = event.Timestamp
+	if err != nil {
+		s.Errors++
+	}
+	if result != nil {
+		if result.Resultset != nil {
+			s.RowsReturned += int64(result.Resultset.RowNumber())
+		} else {
+			s.RowsAffected += int64(result.AffectedRows)
+		}
+	}
+
+	if len(entry.samples) < p.config.SampleSize {
+		entry.samples = append(entry.samples, event.Duration)
+	} else {
+		entry.samples[entry.next] = event.Duration
+		entry.next = (entry.next + 1) % p.config.SampleSize
+	}
+}
+
+// evictOldest 淘汰最久未出现的指纹，调用方需持有锁
+func (p *DigestPlugin) evictOldest() {
+	entry := p.lru.Remove(p.lru.Back()).(*digestEntry)
+	delete(p.entries, entry.stats.Digest)
+}
+
+// Snapshot 返回所有指纹的统计信息，按总耗时降序排列
+// 持有锁时只复制统计和样本，分位数的排序在锁外进行，不阻塞语句完成时的统计
+func (p *DigestPlugin) Snapshot() []DigestStats {
+	p.mu.Lock()
+	stats := make([]DigestStats, 0, p.lru.Len())
+	samples := make([][]time.Duration, 0, p.lru.Len())
+	for elem := p.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*digestEntry)
+		stats = append(stats, entry.stats)
+		samples = append(samples, append([]time.Duration(nil), entry.samples...))
+	}
+	p.mu.Unlock()
+
+	for i := range stats {
+		summarize(&stats[i], samples[i])
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].TotalTime > stats[j].TotalTime })
+	return stats
+}
+
+// Get 返回指定摘要的统计信息
+func (p *DigestPlugin) Get(digest string) (DigestStats, bool) {
+	p.mu.Lock()
+	elem, ok := p.entries[digest]
+	if !ok {
+		p.mu.Unlock()
+		return DigestStats{}, false
+	}
+	entry := elem.Value.(*digestEntry)
+	s := entry.stats
+	samples := append([]time.Duration(nil), entry.samples...)
+	p.mu.Unlock()
+
+	summarize(&s, samples)
+	return s, true
+}
+
+// summarize 根据累计数据和样本计算平均耗时和分位数，samples 会被排序
+func summarize(s *DigestStats, samples []time.Duration) {
+	if s.Count > 0 {
+		s.AvgTime = s.TotalTime / time.Duration(s.Count)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	s.P50 = percentile(samples, 0.50)
+	s.P95 = percentile(samples, 0.95)
+	s.P99 = percentile(samples, 0.99)
+}
+
+// Reset 清空统计数据
+func (p *DigestPlugin) Reset() {
+	p.mu.Lock()
+	p.lru.Init()
+	p.entries = make(map[string]*list.Element)
+	p.mu.Unlock()
+}
+
+// ServeHTTP 以JSON返回统计信息，支持 ?sort=total|count|avg|p99|errors 和 ?limit=N；
+// DELETE 请求清空统计，需要 Authorization: Bearer <api_token>，响应不带 CORS 头
+func (p *DigestPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodDelete {
+		if web.AuthorizeChange(w, r, p.config.APIToken, "digest") {
+			p.Reset()
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list := p.Snapshot()
+	switch r.URL.Query().Get("sort") {
+	case "count":
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Count > list[j].Count })
+	case "avg":
+		sort.SliceStable(list, func(i, j int) bool { return list[i].AvgTime > list[j].AvgTime })
+	case "p99":
+		sort.SliceStable(list, func(i, j int) bool { return list[i].P99 > list[j].P99 })
+	case "errors":
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Errors > list[j].Errors })
+	}
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit >= 0 && limit < len(list) {
+		list = list[:limit]
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// reportLoop 定期输出JSON报告
+func (p *DigestPlugin) reportLoop() {
+	ticker := time.NewTicker(p.config.ReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.report()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// report 输出一次JSON报告，写文件时先写临时文件再重命名，避免读到不完整的报告
+func (p *DigestPlugin) report() {
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"generated_at": time.Now(),
+		"digests":      p.Snapshot(),
+	}, "", "  ")
+	if err != nil {
+		log.Printf("[DigestPlugin] JSON marshal error: %v", err)
+		return
+	}
+
+	if p.config.ReportFile == "" {
+		log.Printf("[DigestPlugin] Report: %s", data)
+		return
+	}
+
+	tmp := p.config.ReportFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("[DigestPlugin] Write report error: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, p.config.ReportFile); err != nil {
+		log.Printf("[DigestPlugin] Write report error: %v", err)
+	}
+}
+
+func (p *DigestPlugin) Close() error {
+	close(p.done)
+	if p.config.ReportInterval > 0 {
+		p.report()
+	}
+	return nil
+}
+
+// percentile 返回已排序样本的分位数
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
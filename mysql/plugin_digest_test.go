@@ -0,0 +1,65 @@
+package mysql
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigestEviction(t *testing.T) {
+	p := NewDigestPlugin(DigestPluginConfig{MaxDigests: 2})
+	// 按顺序完成的语句，digest 超出上限时淘汰最久未出现的
+	steps := []struct {
+		digest string
+		want   string // 之后保留的 digest
+	}{
+		{digest: "A", want: "A"},
+		{digest: "B", want: "A,B"},
+		{digest: "A", want: "A,B"},
+		{digest: "C", want: "A,C"},
+		{digest: "D", want: "C,D"},
+		{digest: "C", want: "C,D"},
+		{digest: "A", want: "A,C"},
+	}
+	for i, s := range steps {
+		p.OnQueryComplete(&QueryEvent{Type: "query", Digest: s.digest, Fingerprint: s.digest, Timestamp: time.Now()}, nil, nil)
+		var kept []string
+		for _, stats := range p.Snapshot() {
+			kept = append(kept, stats.Digest)
+		}
+		sort.Strings(kept)
+		if got := strings.Join(kept, ","); got != s.want {
+			t.Errorf("step %d (%s): kept %s, want %s", i, s.digest, got, s.want)
+		}
+	}
+	if stats, ok := p.Get("A"); !ok || stats.Count != 1 {
+		t.Errorf("Get(A) = %+v, %v, want a fresh entry with count 1", stats, ok)
+	}
+	if stats, ok := p.Get("C"); !ok || stats.Count != 2 {
+		t.Errorf("Get(C) = %+v, %v, want count 2", stats, ok)
+	}
+
+	p.Reset()
+	if n := len(p.Snapshot()); n != 0 {
+		t.Errorf("%d digests after Reset, want 0", n)
+	}
+	p.OnQueryComplete(&QueryEvent{Type: "query", Digest: "E", Timestamp: time.Now()}, nil, nil)
+	if n := len(p.Snapshot()); n != 1 {
+		t.Errorf("%d digests after Reset and one statement, want 1", n)
+	}
+}
+
+func TestDigestRowsExamined(t *testing.T) {
+	p := NewDigestPlugin(DigestPluginConfig{})
+	p.OnQueryComplete(&QueryEvent{Type: "query", Digest: "A", Fingerprint: "select ?", Timestamp: time.Now()}, nil, nil)
+
+	// 扫描行数无法获取，输出 null 而不是 0
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, `"rows_examined":null`) {
+		t.Errorf("response %s, want rows_examined null", body)
+	}
+}
@@ -0,0 +1,94 @@
+package mysql
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/if-nil/proxyx/fault"
+)
+
+// FaultPluginConfig 故障注入插件配置
+type FaultPluginConfig struct {
+	Enabled      bool `yaml:"enabled"` // 是否启用
+	fault.Config `yaml:",inline"`
+}
+
+// FaultPlugin 故障注入插件 - 按SQL指纹、数据库、客户端、概率和时间窗口匹配规则，
+// 对命中的语句增加延迟、返回错误、在返回结果的中途断开客户端连接或截断结果集
+type FaultPlugin struct {
+	injector *fault.Injector
+	pending  sync.Map // *QueryEvent -> *fault.Rule，执行后截断结果
+}
+
+// NewFaultPlugin 创建故障注入插件
+func NewFaultPlugin(config FaultPluginConfig) (*FaultPlugin, error) {
+	injector, err := fault.New(config.Config, true)
+	if err != nil {
+		return nil, err
+	}
+	return &FaultPlugin{injector: injector}, nil
+}
+
+func (p *FaultPlugin) Name() string {
+	return "FaultPlugin"
+}
+
+// Intercept 在语句和预处理语句执行前匹配规则，命中缓存或被之前的拦截器拦截的语句不注入故障
+func (p *FaultPlugin) Intercept(event *QueryEvent) (*mysql.Result, error) {
+	rule := p.injector.Match(fault.Target{
+		Text:       event.Fingerprint,
+		Database:   event.Database,
+		ClientAddr: event.ClientAddr,
+		User:       event.User,
+	})
+	if rule == nil {
+		return nil, nil
+	}
+	event.Fault = rule.Action
+	event.FaultRule = rule.Name
+	log.Printf("[FaultPlugin] Injecting %s (rule %s) into conn %d: %s", rule.Action, rule.Name, event.ConnID, event.Fingerprint)
+
+	switch rule.Action {
+	case fault.Latency:
+		time.Sleep(rule.Latency)
+	case fault.Error:
+		return nil, NewError(rule.ErrorCode, rule.ErrorState, rule.ErrorMessage)
+	case fault.Truncate:
+		p.pending.Store(event, rule)
+	}
+	// drop 由 Handler 在写回响应时处理
+	return nil, nil
+}
+
+func (p *FaultPlugin) OnQuery(event *QueryEvent) {}
+
+// OnQueryComplete 截断结果集，保留 keep_rows 行（为 0 时保留一半）
+func (p *FaultPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	v, ok := p.pending.LoadAndDelete(event)
+	if !ok || result == nil || result.Resultset == nil {
+		return
+	}
+	rs := result.Resultset
+	keep := v.(*fault.Rule).KeepRows
+	if keep == 0 {
+		keep = len(rs.RowDatas) / 2
+	}
+	if keep < len(rs.RowDatas) {
+		rs.RowDatas = rs.RowDatas[:keep]
+	}
+	if keep < len(rs.Values) {
+		rs.Values = rs.Values[:keep]
+	}
+}
+
+// ServeHTTP 查询规则的注入次数，暂停或恢复注入，开关单条规则
+func (p *FaultPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.injector.ServeHTTP(w, r)
+}
+
+func (p *FaultPlugin) Close() error {
+	return nil
+}
@@ -32,6 +32,15 @@ func (p *FilterPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result,
 	}
 }
 
+// Intercept 内部插件实现了 Interceptor 时，仅对符合条件的SQL进行拦截
+func (p *FilterPlugin) Intercept(event *QueryEvent) (*mysql.Result, error) {
+	ic, ok := p.inner.(Interceptor)
+	if !ok || !p.predicate(event) {
+		return nil, nil
+	}
+	return ic.Intercept(event)
+}
+
 func (p *FilterPlugin) Close() error {
 	return p.inner.Close()
 }
@@ -0,0 +1,191 @@
+package mysql
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	_ "github.com/pingcap/tidb/pkg/parser/test_driver"
+)
+
+// FirewallPluginConfig SQL防火墙插件配置
+type FirewallPluginConfig struct {
+	Enabled                bool     `yaml:"enabled"`                   // 是否启用
+	DenyDeleteWithoutWhere bool     `yaml:"deny_delete_without_where"` // 拒绝不带WHERE的DELETE
+	DenyUpdateWithoutWhere bool     `yaml:"deny_update_without_where"` // 拒绝不带WHERE的UPDATE
+	DenyDrop               bool     `yaml:"deny_drop"`                 // 拒绝DROP TABLE/VIEW/DATABASE
+	DenyTruncate           bool     `yaml:"deny_truncate"`             // 拒绝TRUNCATE
+	DenyGrant              bool     `yaml:"deny_grant"`                // 拒绝GRANT/REVOKE
+	DenyMultiStatement     bool     `yaml:"deny_multi_statement"`      // 拒绝一次发送多条语句
+	DenySchemas            []string `yaml:"deny_schemas"`              // 禁止访问的数据库
+	DenyTables             []string `yaml:"deny_tables"`               // 禁止访问的表，格式 table 或 db.table
+	BlockUnparsable        *bool    `yaml:"block_unparsable"`          // 无法解析的语句是否拒绝，未配置时只要启用了任一拒绝规则就拒绝
+}
+
+// FirewallPlugin SQL防火墙插件 - 解析语句并拒绝危险操作
+type FirewallPlugin struct {
+	config          FirewallPluginConfig
+	schemas         map[string]bool
+	tables          map[string]bool // key: db.table 或 table（小写）
+	blockUnparsable bool
+	parsers         sync.Pool
+}
+
+// NewFirewallPlugin 创建SQL防火墙插件
+func NewFirewallPlugin(config FirewallPluginConfig) *FirewallPlugin {
+	p := &FirewallPlugin{
+		config:  config,
+		schemas: make(map[string]bool),
+		tables:  make(map[string]bool),
+		parsers: sync.Pool{
+			New: func() interface{} { return parser.New() },
+		},
+	}
+	for _, s := range config.DenySchemas {
+		p.schemas[strings.ToLower(s)] = true
+	}
+	for _, t := range config.DenyTables {
+		p.tables[strings.ToLower(t)] = true
+	}
+	// 无法解析的语句无法检查，默认在配置了拒绝规则时拒绝，避免用解析器不支持的语法绕过规则
+	p.blockUnparsable = config.DenyDeleteWithoutWhere || config.DenyUpdateWithoutWhere || config.DenyDrop ||
+		config.DenyTruncate || config.DenyGrant || config.DenyMultiStatement || len(p.schemas) > 0 || len(p.tables) > 0
+	if config.BlockUnparsable != nil {
+		p.blockUnparsable = *config.BlockUnparsable
+	}
+	return p
+}
+
+func (p *FirewallPlugin) Name() string {
+	return "FirewallPlugin"
+}
+
+func (p *FirewallPlugin) OnQuery(event *QueryEvent) {}
+
+func (p *FirewallPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {}
+
+// Intercept 解析语句，命中规则时返回拒绝错误
+func (p *FirewallPlugin) Intercept(event *QueryEvent) (*mysql.Result, error) {
+	psr := p.parsers.Get().(*parser.Parser)
+	stmts, _, err := psr.Parse(event.Query, "", "")
+	p.parsers.Put(psr)
+	if err != nil {
+		if p.blockUnparsable {
+			return nil, p.deny("statement cannot be parsed")
+		}
+		// 放行时记录下来，便于发现绕过规则的语句
+		event.FirewallSkipped = "unparsable"
+		log.Printf("[FirewallPlugin] Allowing unparsable statement from conn %d (%s): %v", event.ConnID, event.User, err)
+		return nil, nil
+	}
+
+	if p.config.DenyMultiStatement && len(stmts) > 1 {
+		return nil, p.deny("multiple statements are not allowed")
+	}
+
+	for _, stmt := range stmts {
+		if reason := p.check(stmt, event.Database); reason != "" {
+			return nil, p.deny(reason)
+		}
+	}
+	return nil, nil
+}
+
+// check 检查单条语句，返回拒绝原因，空字符串表示放行
+func (p *FirewallPlugin) check(stmt ast.StmtNode, currentDB string) string {
+	switch s := stmt.(type) {
+	case *ast.DeleteStmt:
+		if p.config.DenyDeleteWithoutWhere && s.Where == nil {
+			return "DELETE without WHERE is not allowed"
+		}
+	case *ast.UpdateStmt:
+		if p.config.DenyUpdateWithoutWhere && s.Where == nil {
+			return "UPDATE without WHERE is not allowed"
+		}
+	case *ast.DropTableStmt, *ast.DropDatabaseStmt:
+		if p.config.DenyDrop {
+			return "DROP is not allowed"
+		}
+	case *ast.TruncateTableStmt:
+		if p.config.DenyTruncate {
+			return "TRUNCATE is not allowed"
+		}
+	case *ast.GrantStmt, *ast.GrantRoleStmt, *ast.RevokeStmt, *ast.RevokeRoleStmt:
+		if p.config.DenyGrant {
+			return "GRANT/REVOKE is not allowed"
+		}
+	}
+
+	if len(p.schemas) == 0 && len(p.tables) == 0 {
+		return ""
+	}
+
+	// 不引用表的语句按其中的库名检查；COM_INIT_DB 不经过拦截器，切换后引用当前库的语句仍会被拒绝
+	switch s := stmt.(type) {
+	case *ast.DropDatabaseStmt:
+		if p.schemas[s.Name.L] {
+			return fmt.Sprintf("access to schema %s is denied", s.Name.O)
+		}
+	case *ast.UseStmt:
+		if p.schemas[strings.ToLower(s.DBName)] {
+			return fmt.Sprintf("access to schema %s is denied", s.DBName)
+		}
+	case *ast.ShowStmt:
+		schema := s.DBName
+		switch s.Tp {
+		case ast.ShowTables, ast.ShowTableStatus, ast.ShowTriggers, ast.ShowEvents:
+			// 未指定 FROM 时列出当前库
+			if schema == "" {
+				schema = currentDB
+			}
+		}
+		if p.schemas[strings.ToLower(schema)] {
+			return fmt.Sprintf("access to schema %s is denied", schema)
+		}
+	}
+
+	collector := &tableCollector{}
+	stmt.Accept(collector)
+	for _, t := range collector.tables {
+		schema := t.Schema.L
+		if schema == "" {
+			schema = strings.ToLower(currentDB)
+		}
+		if p.schemas[schema] {
+			return fmt.Sprintf("access to schema %s is denied", schema)
+		}
+		if p.tables[t.Name.L] || p.tables[schema+"."+t.Name.L] {
+			return fmt.Sprintf("access to table %s is denied", t.Name.O)
+		}
+	}
+	return ""
+}
+
+// deny 生成返回给客户端的拒绝错误
+func (p *FirewallPlugin) deny(reason string) error {
+	return NewError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, "42000", "proxyx firewall: "+reason)
+}
+
+func (p *FirewallPlugin) Close() error {
+	return nil
+}
+
+// tableCollector 遍历AST，收集语句中引用的所有表
+type tableCollector struct {
+	tables []*ast.TableName
+}
+
+func (c *tableCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if t, ok := n.(*ast.TableName); ok {
+		c.tables = append(c.tables, t)
+	}
+	return n, false
+}
+
+func (c *tableCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
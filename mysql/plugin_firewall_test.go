@@ -0,0 +1,65 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFirewallIntercept(t *testing.T) {
+	allow := false
+	tests := []struct {
+		name     string
+		config   FirewallPluginConfig
+		query    string
+		database string
+		wantErr  string // 期望的拒绝原因，空表示放行
+	}{
+		{name: "delete without where", config: FirewallPluginConfig{DenyDeleteWithoutWhere: true}, query: "DELETE FROM t", wantErr: "DELETE without WHERE"},
+		{name: "delete with where", config: FirewallPluginConfig{DenyDeleteWithoutWhere: true}, query: "DELETE FROM t WHERE id = 1"},
+		{name: "delete rule off", query: "DELETE FROM t"},
+		{name: "update without where", config: FirewallPluginConfig{DenyUpdateWithoutWhere: true}, query: "UPDATE t SET a = 1", wantErr: "UPDATE without WHERE"},
+		{name: "update with where", config: FirewallPluginConfig{DenyUpdateWithoutWhere: true}, query: "UPDATE t SET a = 1 WHERE id = 1"},
+		{name: "drop table", config: FirewallPluginConfig{DenyDrop: true}, query: "DROP TABLE t", wantErr: "DROP is not allowed"},
+		{name: "drop database", config: FirewallPluginConfig{DenyDrop: true}, query: "DROP DATABASE shop", wantErr: "DROP is not allowed"},
+		{name: "truncate", config: FirewallPluginConfig{DenyTruncate: true}, query: "TRUNCATE TABLE t", wantErr: "TRUNCATE is not allowed"},
+		{name: "grant", config: FirewallPluginConfig{DenyGrant: true}, query: "GRANT SELECT ON shop.* TO 'app'@'%'", wantErr: "GRANT/REVOKE"},
+		{name: "revoke", config: FirewallPluginConfig{DenyGrant: true}, query: "REVOKE SELECT ON shop.* FROM 'app'@'%'", wantErr: "GRANT/REVOKE"},
+		{name: "multi statement", config: FirewallPluginConfig{DenyMultiStatement: true}, query: "SELECT 1; SELECT 2", wantErr: "multiple statements"},
+		{name: "single statement", config: FirewallPluginConfig{DenyMultiStatement: true}, query: "SELECT 1;"},
+		{name: "schema in table name", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "SELECT * FROM secret.t", wantErr: "schema secret"},
+		{name: "schema from current database", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "SELECT * FROM t", database: "Secret", wantErr: "schema secret"},
+		{name: "schema in join", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "SELECT * FROM shop.a JOIN secret.b ON a.id = b.id", wantErr: "schema secret"},
+		{name: "other schema", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "SELECT * FROM shop.t", database: "secret"},
+		{name: "use schema", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "USE secret", wantErr: "schema secret"},
+		{name: "use other schema", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "USE shop"},
+		{name: "show tables from", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "SHOW TABLES FROM secret", database: "shop", wantErr: "schema secret"},
+		{name: "show tables in current database", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "SHOW TABLES", database: "secret", wantErr: "schema secret"},
+		{name: "show columns from", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "SHOW COLUMNS FROM t FROM secret", database: "shop", wantErr: "schema secret"},
+		{name: "show create database", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "SHOW CREATE DATABASE secret", wantErr: "schema secret"},
+		{name: "show databases", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "SHOW DATABASES", database: "secret"},
+		{name: "drop denied schema", config: FirewallPluginConfig{DenySchemas: []string{"secret"}}, query: "DROP DATABASE secret", wantErr: "schema secret"},
+		{name: "table", config: FirewallPluginConfig{DenyTables: []string{"salaries"}}, query: "SELECT * FROM hr.salaries", wantErr: "table salaries"},
+		{name: "qualified table", config: FirewallPluginConfig{DenyTables: []string{"hr.salaries"}}, query: "SELECT * FROM salaries", database: "hr", wantErr: "table salaries"},
+		{name: "qualified table in other schema", config: FirewallPluginConfig{DenyTables: []string{"hr.salaries"}}, query: "SELECT * FROM salaries", database: "shop"},
+		{name: "table in subquery", config: FirewallPluginConfig{DenyTables: []string{"salaries"}}, query: "SELECT * FROM t WHERE id IN (SELECT id FROM salaries)", wantErr: "table salaries"},
+		{name: "unparsable with rules", config: FirewallPluginConfig{DenyDrop: true}, query: "FLUSH SOMETHING ODD", wantErr: "cannot be parsed"},
+		{name: "unparsable without rules", query: "FLUSH SOMETHING ODD"},
+		{name: "unparsable allowed", config: FirewallPluginConfig{DenyDrop: true, BlockUnparsable: &allow}, query: "FLUSH SOMETHING ODD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewFirewallPlugin(tt.config)
+			event := &QueryEvent{Type: "query", Query: tt.query, Database: tt.database}
+			_, err := p.Intercept(event)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Intercept(%q) = %v, want allowed", tt.query, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Intercept(%q) = %v, want %q", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
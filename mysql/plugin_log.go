@@ -31,6 +31,43 @@ func (p *LogPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err
 	} else {
 		log.Printf("[MySQL] OK (duration: %v, rows: %d)", event.Duration, event.RowCount)
 	}
+	if slow := event.SlowQuery; slow != nil {
+		if slow.ExplainError != "" {
+			log.Printf("[MySQL] Slow query (threshold: %v), explain failed: %s", slow.Threshold, slow.ExplainError)
+		} else {
+			log.Printf("[MySQL] Slow query (threshold: %v), full scans: %v, filesort: %v, temporary table: %v, plan: %s",
+				slow.Threshold, slow.FullScans, slow.Filesort, slow.TemporaryTable, slow.Plan)
+		}
+	}
+}
+
+func (p *LogPlugin) OnConnect(event *ConnEvent) {
+	if event.Type == "change_user" {
+		log.Printf("[MySQL] Change user: conn %d, %s@%s -> %s", event.ConnID, event.User, event.ClientAddr, event.Backend)
+		return
+	}
+	log.Printf("[MySQL] Connect: conn %d, %s@%s -> %s", event.ConnID, event.User, event.ClientAddr, event.Backend)
+}
+
+func (p *LogPlugin) OnAuthFailure(event *ConnEvent) {
+	log.Printf("[MySQL] Auth failure: %s@%s: %s", event.User, event.ClientAddr, event.Error)
+}
+
+func (p *LogPlugin) OnDialFailure(event *ConnEvent) {
+	log.Printf("[MySQL] Dial failure: conn %d, %s: %s", event.ConnID, event.Backend, event.Error)
+}
+
+func (p *LogPlugin) OnDisconnect(event *ConnEvent) {
+	log.Printf("[MySQL] Disconnect: conn %d, %s@%s (duration: %v, statements: %d, in: %d bytes, out: %d bytes)",
+		event.ConnID, event.User, event.ClientAddr, event.Duration, event.Statements, event.BytesIn, event.BytesOut)
+}
+
+func (p *LogPlugin) OnBackendState(event *ConnEvent) {
+	if event.Type == "failover" {
+		log.Printf("[MySQL] Failover: %s -> %s", event.Previous, event.Backend)
+		return
+	}
+	log.Printf("[MySQL] Backend state: %s %s %s", event.Backend, event.Type, event.Error)
 }
 
 func (p *LogPlugin) Close() error {
@@ -0,0 +1,509 @@
+package mysql
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// 脱敏方式
+const (
+	MaskRedact  = "redact"  // 替换为固定值
+	MaskHash    = "hash"    // 替换为 HMAC-SHA256 摘要，相同的值脱敏后仍然相同
+	MaskPartial = "partial" // 只保留末尾几位，如 ****1234
+	MaskNullify = "nullify" // 替换为 NULL
+	MaskFake    = "fake"    // 替换为格式相同的伪造值（数字换数字、字母换字母），相同的值伪造结果相同
+)
+
+// MaskPluginConfig 数据脱敏插件配置
+type MaskPluginConfig struct {
+	Enabled     bool       `yaml:"enabled"`      // 是否启用
+	Salt        string     `yaml:"salt"`         // hash / fake 使用的密钥，未配置时相同的值在不同部署中脱敏结果相同
+	ExemptUsers []string   `yaml:"exempt_users"` // 不脱敏的用户
+	Rules       []MaskRule `yaml:"rules"`        // 脱敏规则，按顺序匹配第一条
+}
+
+// MaskRule 脱敏规则，Column 与 ColumnPattern 至少配置一个，都配置时需同时满足
+type MaskRule struct {
+	Column        string `yaml:"column"`         // db.table.column，各部分可用 * 通配，也可只写 table.column 或 column
+	ColumnPattern string `yaml:"column_pattern"` // 列名正则，如 (?i)phone|mobile
+	Strategy      string `yaml:"strategy"`       // 脱敏方式：redact / hash / partial / nullify / fake
+	KeepLast      int    `yaml:"keep_last"`      // partial 保留的末尾字符数，默认4
+	Value         string `yaml:"value"`          // redact 的替换值（默认 ****），fake 配置后使用该固定值
+}
+
+// maskRule 编译后的脱敏规则
+type maskRule struct {
+	MaskRule
+	schema, table, column string // 小写，空表示任意
+	pattern               *regexp.Regexp
+}
+
+// MaskPlugin 数据脱敏插件 - 在结果集返回客户端之前按列改写敏感数据
+// 需要在结果缓存等读取结果集的插件之后注册，缓存中保存的是未脱敏的结果，命中后同样会被脱敏
+// 结果集中只有直接引用的列带有原始表名和列名，以表达式、UNION、子查询等方式输出脱敏列的语句在执行前拒绝
+type MaskPlugin struct {
+	salt    []byte
+	exempt  map[string]bool
+	rules   []*maskRule
+	parsers sync.Pool
+}
+
+// NewMaskPlugin 创建数据脱敏插件
+func NewMaskPlugin(config MaskPluginConfig) (*MaskPlugin, error) {
+	p := &MaskPlugin{
+		salt:   []byte(config.Salt),
+		exempt: make(map[string]bool, len(config.ExemptUsers)),
+		parsers: sync.Pool{
+			New: func() interface{} { return parser.New() },
+		},
+	}
+	for _, user := range config.ExemptUsers {
+		p.exempt[user] = true
+	}
+
+	for i, rule := range config.Rules {
+		compiled := &maskRule{MaskRule: rule}
+		if rule.Column == "" && rule.ColumnPattern == "" {
+			return nil, fmt.Errorf("mask rule %d: column or column_pattern is required", i)
+		}
+		if rule.Column != "" {
+			parts := strings.Split(strings.ToLower(rule.Column), ".")
+			if len(parts) > 3 {
+				return nil, fmt.Errorf("mask rule %d: invalid column %q", i, rule.Column)
+			}
+			for len(parts) < 3 {
+				parts = append([]string{"*"}, parts...)
+			}
+			for j, part := range parts {
+				if part == "*" {
+					parts[j] = ""
+				}
+			}
+			compiled.schema, compiled.table, compiled.column = parts[0], parts[1], parts[2]
+		}
+		if rule.ColumnPattern != "" {
+			re, err := regexp.Compile(rule.ColumnPattern)
+			if err != nil {
+				return nil, fmt.Errorf("mask rule %d: %v", i, err)
+			}
+			compiled.pattern = re
+		}
+		switch rule.Strategy {
+		case MaskRedact, MaskHash, MaskPartial, MaskNullify, MaskFake:
+		default:
+			return nil, fmt.Errorf("mask rule %d: unknown strategy %q", i, rule.Strategy)
+		}
+		if compiled.KeepLast <= 0 {
+			compiled.KeepLast = 4
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+func (p *MaskPlugin) Name() string {
+	return "MaskPlugin"
+}
+
+func (p *MaskPlugin) OnQuery(event *QueryEvent) {}
+
+// Intercept 拒绝非豁免用户以非简单列引用的方式输出脱敏列的语句
+// MySQL 只为直接引用的列返回原始表名和列名，LOWER(email)、CONCAT(card, ”)、UNION、派生表、子查询输出的列
+// 在结果集中无法识别，只能在执行前按语句判断
+func (p *MaskPlugin) Intercept(event *QueryEvent) (*mysql.Result, error) {
+	if len(p.rules) == 0 || p.exempt[event.User] || (event.Type != "query" && event.Type != "execute") {
+		return nil, nil
+	}
+	if reason := p.checkQuery(event.Query, event.Database); reason != "" {
+		return nil, NewError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, "42000", "proxyx mask: "+reason)
+	}
+	return nil, nil
+}
+
+// checkQuery 检查语句是否会以无法识别的方式输出脱敏列，返回拒绝原因，空字符串表示放行
+func (p *MaskPlugin) checkQuery(query, currentDB string) string {
+	psr := p.parsers.Get().(*parser.Parser)
+	stmts, _, err := psr.Parse(query, "", "")
+	p.parsers.Put(psr)
+	if err != nil {
+		// 无法解析的只读语句无法确认输出的列
+		if kind, _ := classifyStatement(query); kind == kindRead {
+			return "statement cannot be parsed, masked columns cannot be verified"
+		}
+		return ""
+	}
+	for _, stmt := range stmts {
+		if reason := p.checkStmt(stmt, currentDB); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// checkStmt 检查单条语句，SQL 层的 PREPARE 检查其中的语句
+func (p *MaskPlugin) checkStmt(stmt ast.StmtNode, currentDB string) string {
+	if s, ok := stmt.(*ast.PrepareStmt); ok {
+		if s.SQLText == "" {
+			return "PREPARE from a user variable cannot be verified"
+		}
+		return p.checkQuery(s.SQLText, currentDB)
+	}
+	rules := p.relevantRules(collectTables(stmt, currentDB))
+	if len(rules) == 0 {
+		return ""
+	}
+	checker := &maskChecker{rules: rules, top: stmt, exists: make(map[ast.Node]bool)}
+	stmt.Accept(checker)
+	return checker.reason
+}
+
+// relevantRules 返回可能作用于语句涉及的表的规则，只按列名正则匹配的规则适用于所有表
+func (p *MaskPlugin) relevantRules(tables []string) []*maskRule {
+	var rules []*maskRule
+	for _, rule := range p.rules {
+		if rule.schema == "" && rule.table == "" {
+			rules = append(rules, rule)
+			continue
+		}
+		for _, t := range tables {
+			schema, table, _ := strings.Cut(t, ".")
+			if (rule.schema == "" || rule.schema == schema) && (rule.table == "" || rule.table == table) {
+				rules = append(rules, rule)
+				break
+			}
+		}
+	}
+	return rules
+}
+
+// OnQueryComplete 改写结果集中命中规则的列，文本协议和二进制协议的行数据都会重新编码
+func (p *MaskPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	if err != nil || result == nil || result.Resultset == nil || p.exempt[event.User] {
+		return
+	}
+	if err := p.mask(result.Resultset, event.Type == "execute"); err != nil {
+		// 无法脱敏时不能返回原始数据，清空结果集
+		log.Printf("[MaskPlugin] Mask result error: %v", err)
+		result.RowDatas = nil
+		result.Values = nil
+	}
+}
+
+// mask 按规则改写结果集，binary 表示二进制协议（预处理语句）的行格式
+func (p *MaskPlugin) mask(rs *mysql.Resultset, binary bool) error {
+	rules := make([]*maskRule, len(rs.Fields))
+	masked := false
+	for i, f := range rs.Fields {
+		if rules[i] = p.match(f); rules[i] != nil {
+			masked = true
+		}
+	}
+	if !masked {
+		return nil
+	}
+
+	// 在改写列类型之前取出原始值
+	for i, raw := range rs.RowDatas {
+		var values []mysql.FieldValue
+		if i < len(rs.Values) {
+			values = rs.Values[i]
+		} else {
+			var err error
+			if values, err = raw.Parse(rs.Fields, binary, nil); err != nil {
+				return err
+			}
+		}
+		row, err := splitRow(raw, rs.Fields, binary)
+		if err != nil {
+			return err
+		}
+		for col, rule := range rules {
+			if rule == nil || row.nulls[col] {
+				continue
+			}
+			value, null := p.apply(rule, fieldValueString(values[col]))
+			row.set(col, value, null)
+		}
+		rs.RowDatas[i] = row.encode(binary)
+	}
+
+	// 脱敏后的值都是字符串，修改列定义使客户端按字符串解析
+	for col, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		f := rs.Fields[col]
+		f.Flag &^= mysql.NOT_NULL_FLAG
+		if rule.Strategy != MaskNullify && !isStringType(f.Type) {
+			f.Type = mysql.MYSQL_TYPE_VAR_STRING
+			f.Charset = uint16(mysql.DEFAULT_COLLATION_ID)
+			f.Flag &^= mysql.UNSIGNED_FLAG | mysql.ZEROFILL_FLAG | mysql.BINARY_FLAG | mysql.NUM_FLAG
+			f.Decimal = 0
+		}
+	}
+
+	// 重新解析 Values，与写回客户端的行数据保持一致
+	rs.Values = rs.Values[:0]
+	for _, raw := range rs.RowDatas {
+		values, err := raw.Parse(rs.Fields, binary, nil)
+		if err != nil {
+			return err
+		}
+		rs.Values = append(rs.Values, values)
+	}
+	return nil
+}
+
+// match 返回列命中的第一条规则
+func (p *MaskPlugin) match(f *mysql.Field) *maskRule {
+	schema := strings.ToLower(string(f.Schema))
+	table := strings.ToLower(string(f.OrgTable))
+	if table == "" {
+		table = strings.ToLower(string(f.Table))
+	}
+	column := strings.ToLower(string(f.OrgName))
+	if column == "" {
+		column = strings.ToLower(string(f.Name))
+	}
+	return p.matchColumn(schema, table, column, string(f.OrgName), string(f.Name))
+}
+
+// matchColumn 按库名、表名、列名（小写）匹配第一条规则，列名正则同时匹配原始列名和别名
+func (p *MaskPlugin) matchColumn(schema, table, column, orgName, name string) *maskRule {
+	for _, rule := range p.rules {
+		if rule.Column != "" {
+			if (rule.schema != "" && rule.schema != schema) ||
+				(rule.table != "" && rule.table != table) ||
+				(rule.column != "" && rule.column != column) {
+				continue
+			}
+		}
+		if rule.pattern != nil && !rule.pattern.MatchString(orgName) && !rule.pattern.MatchString(name) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// maskImage 按规则改写 binlog 行镜像中的值，binlog 事件没有客户端用户，exempt_users 不适用
+// 取不到列名（@1、@2 ...）时无法按列匹配，该表命中任意规则时这些列一律置为 NULL
+func (p *MaskPlugin) maskImage(schema, table string, image map[string]interface{}) {
+	if len(image) == 0 || len(p.rules) == 0 {
+		return
+	}
+	schema, table = strings.ToLower(schema), strings.ToLower(table)
+	relevant := len(p.relevantRules([]string{schema + "." + table})) > 0
+	for name, v := range image {
+		if strings.HasPrefix(name, "@") {
+			if relevant {
+				image[name] = nil
+			}
+			continue
+		}
+		rule := p.matchColumn(schema, table, strings.ToLower(name), name, name)
+		if rule == nil || v == nil {
+			continue
+		}
+		if value, null := p.apply(rule, imageValueString(v)); null {
+			image[name] = nil
+		} else {
+			image[name] = value
+		}
+	}
+}
+
+// maskChecker 遍历AST，检查各 SELECT 的输出列
+// 最外层 SELECT 中直接引用的列和 * 由结果集的列定义脱敏；其他位置（表达式、UNION 的各分支、派生表、
+// 子查询、CTE）输出的列按列名匹配规则，引用了脱敏列或使用 * 时拒绝。列名不区分所属的表，宁可多拒绝
+type maskChecker struct {
+	rules  []*maskRule
+	top    ast.Node          // 语句本身，为 SELECT 时其中的简单列引用可以放行
+	exists map[ast.Node]bool // EXISTS 子查询，输出列不会返回客户端
+	reason string
+}
+
+func (c *maskChecker) Enter(n ast.Node) (ast.Node, bool) {
+	if c.reason != "" {
+		return n, true
+	}
+	if e, ok := n.(*ast.ExistsSubqueryExpr); ok {
+		if sub, ok := e.Sel.(*ast.SubqueryExpr); ok {
+			c.exists[sub.Query] = true
+		}
+		return n, false
+	}
+	sel, ok := n.(*ast.SelectStmt)
+	if !ok || sel.Fields == nil || c.exists[n] {
+		return n, false
+	}
+	for _, field := range sel.Fields.Fields {
+		if field.WildCard != nil {
+			if n != c.top {
+				c.reason = "SELECT * outside the outermost SELECT (UNION, subquery, derived table) cannot be masked"
+				return n, true
+			}
+			continue
+		}
+		if _, plain := field.Expr.(*ast.ColumnNameExpr); plain && n == c.top {
+			continue
+		}
+		if column := c.maskedColumn(field.Expr); column != "" {
+			c.reason = fmt.Sprintf("masked column %s can only be selected directly by the outermost SELECT, not through expressions, UNION, subqueries or derived tables", column)
+			return n, true
+		}
+	}
+	return n, false
+}
+
+func (c *maskChecker) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// maskedColumn 返回表达式中第一个命中规则的列名，没有时返回空字符串
+func (c *maskChecker) maskedColumn(expr ast.ExprNode) string {
+	collector := &columnCollector{}
+	expr.Accept(collector)
+	for _, col := range collector.columns {
+		for _, rule := range c.rules {
+			if rule.column != "" && rule.column != col.Name.L {
+				continue
+			}
+			if rule.pattern != nil && !rule.pattern.MatchString(col.Name.O) {
+				continue
+			}
+			return col.Name.O
+		}
+	}
+	return ""
+}
+
+// columnCollector 收集表达式中引用的列，子查询由 maskChecker 单独检查
+type columnCollector struct {
+	columns []*ast.ColumnName
+}
+
+func (c *columnCollector) Enter(n ast.Node) (ast.Node, bool) {
+	switch e := n.(type) {
+	case *ast.SubqueryExpr:
+		return n, true
+	case *ast.ColumnNameExpr:
+		c.columns = append(c.columns, e.Name)
+	}
+	return n, false
+}
+
+func (c *columnCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// apply 按规则脱敏一个值，返回新值以及是否为 NULL
+func (p *MaskPlugin) apply(rule *maskRule, value string) (string, bool) {
+	switch rule.Strategy {
+	case MaskNullify:
+		return "", true
+	case MaskHash:
+		return hex.EncodeToString(p.digest(value))[:16], false
+	case MaskPartial:
+		n := utf8.RuneCountInString(value)
+		if n <= rule.KeepLast {
+			return "****", false
+		}
+		runes := []rune(value)
+		return "****" + string(runes[n-rule.KeepLast:]), false
+	case MaskFake:
+		if rule.Value != "" {
+			return rule.Value, false
+		}
+		return p.fake(value), false
+	}
+	if rule.Value != "" {
+		return rule.Value, false
+	}
+	return "****", false
+}
+
+// digest 计算值的 HMAC-SHA256
+func (p *MaskPlugin) digest(value string) []byte {
+	mac := hmac.New(sha256.New, p.salt)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// fake 生成与原值格式相同的伪造值：数字替换为数字、字母替换为同样大小写的字母，其余字符保留
+func (p *MaskPlugin) fake(value string) string {
+	sum := p.digest(value)
+	var b strings.Builder
+	i := 0
+	for _, r := range value {
+		n := sum[i%len(sum)]
+		switch {
+		case r >= '0' && r <= '9':
+			r = rune('0' + n%10)
+			i++
+		case r >= 'a' && r <= 'z':
+			r = rune('a' + n%26)
+			i++
+		case r >= 'A' && r <= 'Z':
+			r = rune('A' + n%26)
+			i++
+		case unicode.IsLetter(r):
+			r = '*'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (p *MaskPlugin) Close() error {
+	return nil
+}
+
+// fieldValueString 将解析后的值转换为字符串
+func fieldValueString(v mysql.FieldValue) string {
+	switch val := v.Value().(type) {
+	case []byte:
+		return string(val)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// imageValueString 将 binlog 行镜像中的值转换为字符串
+func imageValueString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case time.Time:
+		return val.Format("2006-01-02 15:04:05.999999")
+	}
+	return fmt.Sprint(v)
+}
+
+// isStringType 判断列类型的值在两种协议中是否都按长度编码字符串传输
+func isStringType(t uint8) bool {
+	switch t {
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING, mysql.MYSQL_TYPE_STRING,
+		mysql.MYSQL_TYPE_TINY_BLOB, mysql.MYSQL_TYPE_MEDIUM_BLOB, mysql.MYSQL_TYPE_LONG_BLOB, mysql.MYSQL_TYPE_BLOB,
+		mysql.MYSQL_TYPE_ENUM, mysql.MYSQL_TYPE_SET, mysql.MYSQL_TYPE_JSON:
+		return true
+	}
+	return false
+}
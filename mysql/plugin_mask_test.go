@@ -0,0 +1,175 @@
+package mysql
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// buildResult 构造结果集，Values 与行数据一致
+func buildResult(t *testing.T, binary bool, names []string, rows [][]interface{}) *mysql.Result {
+	t.Helper()
+	rs, err := mysql.BuildSimpleResultset(names, rows, binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range rs.RowDatas {
+		values, err := raw.Parse(rs.Fields, binary, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Values = append(rs.Values, values)
+	}
+	return &mysql.Result{Resultset: rs}
+}
+
+// resultStrings 按文本返回结果集的值，NULL 返回 "NULL"
+func resultStrings(rs *mysql.Resultset) [][]string {
+	rows := make([][]string, 0, len(rs.Values))
+	for _, values := range rs.Values {
+		row := make([]string, len(values))
+		for i, v := range values {
+			if v.Value() == nil {
+				row[i] = "NULL"
+			} else {
+				row[i] = fieldValueString(v)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestMaskResult(t *testing.T) {
+	p, err := NewMaskPlugin(MaskPluginConfig{
+		ExemptUsers: []string{"dba"},
+		Rules: []MaskRule{
+			{Column: "shop.users.phone", Strategy: MaskPartial},
+			{Column: "users.email", Strategy: MaskRedact, Value: "hidden"},
+			{Column: "salary", Strategy: MaskRedact},
+			{ColumnPattern: "(?i)^ssn$", Strategy: MaskNullify},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"id", "phone", "email", "salary", "SSN"}
+	rows := [][]interface{}{
+		{int64(1), "13800138000", "a@example.com", int64(5000), "123-45-6789"},
+		{int64(2), "123", "b@example.com", int64(0), "987-65-4321"},
+		{int64(3), nil, "c@example.com", int64(7000), "555-55-5555"},
+	}
+	tests := []struct {
+		name string
+		user string
+		want [][]string
+	}{
+		{
+			name: "masked",
+			user: "app",
+			want: [][]string{
+				{"1", "****8000", "hidden", "****", "NULL"},
+				{"2", "****", "hidden", "****", "NULL"},
+				{"3", "NULL", "hidden", "****", "NULL"},
+			},
+		},
+		{
+			name: "exempt user",
+			user: "dba",
+			want: [][]string{
+				{"1", "13800138000", "a@example.com", "5000", "123-45-6789"},
+				{"2", "123", "b@example.com", "0", "987-65-4321"},
+				{"3", "NULL", "c@example.com", "7000", "555-55-5555"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, binary := range []bool{false, true} {
+			event := &QueryEvent{Type: "query", User: tt.user}
+			name := tt.name + "/text"
+			if binary {
+				event.Type = "execute"
+				name = tt.name + "/binary"
+			}
+			t.Run(name, func(t *testing.T) {
+				result := buildResult(t, binary, names, rows)
+				for _, f := range result.Fields {
+					f.Schema, f.Table, f.OrgTable = []byte("shop"), []byte("u"), []byte("users")
+				}
+				p.OnQueryComplete(event, result, nil)
+
+				if got := resultStrings(result.Resultset); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("rows = %v, want %v", got, tt.want)
+				}
+				// 客户端按列定义解析写回的行数据
+				for i, raw := range result.RowDatas {
+					values, err := raw.Parse(result.Fields, binary, nil)
+					if err != nil {
+						t.Fatalf("row %d: %v", i, err)
+					}
+					if !reflect.DeepEqual(values, result.Values[i]) {
+						t.Errorf("row %d raw data decodes to %v, want %v", i, values, result.Values[i])
+					}
+				}
+				if tt.user == "dba" {
+					return
+				}
+				if typ := result.Fields[0].Type; typ != mysql.MYSQL_TYPE_LONGLONG {
+					t.Errorf("id column type = %d, want unchanged", typ)
+				}
+				if f := result.Fields[3]; f.Type != mysql.MYSQL_TYPE_VAR_STRING || f.Flag&(mysql.NOT_NULL_FLAG|mysql.BINARY_FLAG) != 0 {
+					t.Errorf("masked salary column type = %d flag = %#x, want a nullable string", f.Type, f.Flag)
+				}
+			})
+		}
+	}
+}
+
+func TestMaskApply(t *testing.T) {
+	p, err := NewMaskPlugin(MaskPluginConfig{Salt: "salt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rule     MaskRule
+		value    string
+		want     string // 为空时按 pattern 检查
+		pattern  string
+		wantNull bool
+	}{
+		{rule: MaskRule{Strategy: MaskRedact}, value: "secret", want: "****"},
+		{rule: MaskRule{Strategy: MaskRedact, Value: "[hidden]"}, value: "secret", want: "[hidden]"},
+		{rule: MaskRule{Strategy: MaskPartial, KeepLast: 4}, value: "6222021234567890", want: "****7890"},
+		{rule: MaskRule{Strategy: MaskPartial, KeepLast: 2}, value: "张三丰", want: "****三丰"},
+		{rule: MaskRule{Strategy: MaskPartial, KeepLast: 4}, value: "1234", want: "****"},
+		{rule: MaskRule{Strategy: MaskNullify}, value: "secret", wantNull: true},
+		{rule: MaskRule{Strategy: MaskHash}, value: "secret", pattern: `^[0-9a-f]{16}$`},
+		{rule: MaskRule{Strategy: MaskFake}, value: "Ab-12 张", pattern: `^[A-Z][a-z]-[0-9]{2} \*$`},
+		{rule: MaskRule{Strategy: MaskFake, Value: "N/A"}, value: "secret", want: "N/A"},
+	}
+	for _, tt := range tests {
+		rule := &maskRule{MaskRule: tt.rule}
+		got, null := p.apply(rule, tt.value)
+		if null != tt.wantNull {
+			t.Errorf("%s(%q) null = %v, want %v", tt.rule.Strategy, tt.value, null, tt.wantNull)
+			continue
+		}
+		if tt.pattern != "" {
+			if !regexp.MustCompile(tt.pattern).MatchString(got) {
+				t.Errorf("%s(%q) = %q, want match %s", tt.rule.Strategy, tt.value, got, tt.pattern)
+			}
+			// 相同的值脱敏结果相同
+			if again, _ := p.apply(rule, tt.value); again != got {
+				t.Errorf("%s(%q) is not deterministic: %q, %q", tt.rule.Strategy, tt.value, got, again)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s(%q) = %q, want %q", tt.rule.Strategy, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package mysql
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// 限流维度
+const (
+	LimitByUser        = "user"
+	LimitByClientIP    = "client_ip"
+	LimitByDatabase    = "database"
+	LimitByFingerprint = "fingerprint"
+)
+
+// RateLimitPluginConfig 准入控制插件配置
+type RateLimitPluginConfig struct {
+	Enabled bool            `yaml:"enabled"` // 是否启用
+	Rules   []RateLimitRule `yaml:"rules"`   // 限流规则，一条语句需要通过所有命中的规则
+}
+
+// RateLimitRule 限流规则，按 Scope 的每个值分别计数
+type RateLimitRule struct {
+	Scope         string        `yaml:"scope"`          // 限流维度：user / client_ip / database / fingerprint
+	Values        []string      `yaml:"values"`         // 只限制这些值（用户名、IP、库名、指纹摘要或指纹），为空时限制所有值
+	MaxConcurrent int           `yaml:"max_concurrent"` // 同时执行的语句数上限（0表示不限制）
+	Rate          float64       `yaml:"rate"`           // 每秒语句数上限（0表示不限制）
+	Burst         int           `yaml:"burst"`          // 令牌桶容量，默认为 rate（至少为1）
+	MaxQueue      int           `yaml:"max_queue"`      // 超出限制时排队等待的语句数上限（0表示直接拒绝）
+	QueueTimeout  time.Duration `yaml:"queue_timeout"`  // 排队等待的最长时间，默认1s
+}
+
+// LimitState 单个限流对象的状态
+type LimitState struct {
+	Scope         string  `json:"scope"`          // 限流维度
+	Key           string  `json:"key"`            // 维度的值
+	Rule          int     `json:"rule"`           // 规则序号
+	InFlight      int     `json:"in_flight"`      // 正在执行的语句数
+	MaxConcurrent int     `json:"max_concurrent"` // 同时执行的语句数上限
+	Queued        int32   `json:"queued"`         // 正在排队的语句数
+	Tokens        float64 `json:"tokens"`         // 令牌桶中剩余的令牌
+	Rate          float64 `json:"rate"`           // 每秒语句数上限
+	Admitted      int64   `json:"admitted"`       // 放行的语句数
+	Rejected      int64   `json:"rejected"`       // 拒绝的语句数（含排队超时）
+	Timeouts      int64   `json:"timeouts"`       // 排队超时的语句数
+}
+
+// limiter 单个限流对象：并发槽位 + 令牌桶
+type limiter struct {
+	rule  *RateLimitRule
+	index int
+	key   string
+	slots chan struct{} // 并发槽位，不限制并发时为 nil
+
+	mu       sync.Mutex
+	tokens   float64
+	refilled time.Time
+	lastUsed time.Time
+
+	queued   atomic.Int32
+	admitted atomic.Int64
+	rejected atomic.Int64
+	timeouts atomic.Int64
+}
+
+// RateLimitPlugin 准入控制插件 - 按用户、客户端IP、数据库、SQL指纹限制并发语句数和每秒语句数
+// 超出限制的语句排队等待，队列已满或等待超时时返回 ER_USER_LIMIT_REACHED
+type RateLimitPlugin struct {
+	rules []RateLimitRule
+
+	mu       sync.Mutex
+	limiters map[string]*limiter // 规则序号 + 值 -> 限流对象
+
+	held sync.Map // *QueryEvent -> []*limiter，语句完成时释放并发槽位
+	done chan struct{}
+}
+
+// NewRateLimitPlugin 创建准入控制插件
+func NewRateLimitPlugin(config RateLimitPluginConfig) (*RateLimitPlugin, error) {
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		switch rule.Scope {
+		case LimitByUser, LimitByClientIP, LimitByDatabase, LimitByFingerprint:
+		default:
+			return nil, fmt.Errorf("rate limit rule %d: unknown scope %q", i, rule.Scope)
+		}
+		if rule.Rate > 0 && rule.Burst <= 0 {
+			rule.Burst = int(rule.Rate)
+			if rule.Burst < 1 {
+				rule.Burst = 1
+			}
+		}
+		if rule.QueueTimeout <= 0 {
+			rule.QueueTimeout = time.Second
+		}
+	}
+
+	p := &RateLimitPlugin{
+		rules:    config.Rules,
+		limiters: make(map[string]*limiter),
+		done:     make(chan struct{}),
+	}
+	go p.cleanupLoop()
+	return p, nil
+}
+
+func (p *RateLimitPlugin) Name() string {
+	return "RateLimitPlugin"
+}
+
+func (p *RateLimitPlugin) OnQuery(event *QueryEvent) {}
+
+// Intercept 依次通过所有命中的规则，任一规则拒绝时归还已通过的规则取走的令牌、释放已占用的槽位并返回错误
+func (p *RateLimitPlugin) Intercept(event *QueryEvent) (*mysql.Result, error) {
+	// 不限制结束事务的语句，避免事务因被拒绝而长时间持有锁
+	rest, _ := skipComments(event.Query)
+	switch strings.ToUpper(firstWord(rest)) {
+	case "COMMIT", "ROLLBACK":
+		return nil, nil
+	}
+
+	var passed, held []*limiter
+	for i := range p.rules {
+		l := p.limiter(i, event)
+		if l == nil {
+			continue
+		}
+		if err := l.acquire(); err != nil {
+			// 被拒绝的语句不会执行，不能消耗其他规则的令牌，否则连续被拒绝的语句会耗尽其他规则的令牌桶
+			for _, l := range passed {
+				l.refund()
+			}
+			releaseLimiters(held)
+			return nil, err
+		}
+		passed = append(passed, l)
+		if l.slots != nil {
+			held = append(held, l)
+		}
+	}
+	if len(held) > 0 {
+		p.held.Store(event, held)
+	}
+	return nil, nil
+}
+
+func (p *RateLimitPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	if held, ok := p.held.LoadAndDelete(event); ok {
+		releaseLimiters(held.([]*limiter))
+	}
+}
+
+// limiter 返回语句在第 i 条规则下的限流对象，规则不适用时返回 nil
+func (p *RateLimitPlugin) limiter(i int, event *QueryEvent) *limiter {
+	rule := &p.rules[i]
+	var key string
+	switch rule.Scope {
+	case LimitByUser:
+		key = event.User
+	case LimitByClientIP:
+		key = event.ClientAddr
+		if host, _, err := net.SplitHostPort(key); err == nil {
+			key = host
+		}
+	case LimitByDatabase:
+		key = strings.ToLower(event.Database)
+	case LimitByFingerprint:
+		key = event.Digest
+	}
+	if len(rule.Values) > 0 && !rule.matchValue(key, event) {
+		return nil
+	}
+
+	id := fmt.Sprintf("%d\x00%s", i, key)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.limiters[id]
+	if !ok {
+		l = &limiter{
+			rule:     rule,
+			index:    i,
+			key:      key,
+			tokens:   float64(rule.Burst),
+			refilled: time.Now(),
+		}
+		if rule.MaxConcurrent > 0 {
+			l.slots = make(chan struct{}, rule.MaxConcurrent)
+		}
+		p.limiters[id] = l
+	}
+	l.mu.Lock()
+	l.lastUsed = time.Now()
+	l.mu.Unlock()
+	return l
+}
+
+func (r *RateLimitRule) matchValue(key string, event *QueryEvent) bool {
+	for _, v := range r.Values {
+		switch r.Scope {
+		case LimitByDatabase:
+			if strings.EqualFold(v, key) {
+				return true
+			}
+		case LimitByFingerprint:
+			if strings.EqualFold(v, key) || v == event.Fingerprint {
+				return true
+			}
+		default:
+			if v == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acquire 先预约令牌，再占用并发槽位，需要等待时计入队列
+func (l *limiter) acquire() error {
+	deadline := time.Now().Add(l.rule.QueueTimeout)
+
+	wait, ok := l.reserve(deadline)
+	if !ok {
+		return l.reject("rate", false)
+	}
+	if wait > 0 {
+		if !l.enqueue() {
+			l.refund()
+			return l.reject("rate", false)
+		}
+		time.Sleep(wait)
+		l.queued.Add(-1)
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if !l.enqueue() {
+				l.refund()
+				return l.reject("max_concurrent", false)
+			}
+			timer := time.NewTimer(time.Until(deadline))
+			select {
+			case l.slots <- struct{}{}:
+				timer.Stop()
+				l.queued.Add(-1)
+			case <-timer.C:
+				l.queued.Add(-1)
+				l.refund()
+				return l.reject("max_concurrent", true)
+			}
+		}
+	}
+	l.admitted.Add(1)
+	return nil
+}
+
+// reserve 从令牌桶取一个令牌，令牌不足时预约未来的令牌并返回需要等待的时长
+// 等待会超过 deadline 或不允许排队时不预约，返回 false
+func (l *limiter) reserve(deadline time.Time) (time.Duration, bool) {
+	if l.rule.Rate <= 0 {
+		return 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.refilled).Seconds() * l.rule.Rate
+	if l.tokens > float64(l.rule.Burst) {
+		l.tokens = float64(l.rule.Burst)
+	}
+	l.refilled = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	wait := time.Duration((1 - l.tokens) / l.rule.Rate * float64(time.Second))
+	if l.rule.MaxQueue <= 0 || now.Add(wait).After(deadline) {
+		return 0, false
+	}
+	l.tokens--
+	return wait, true
+}
+
+// refund 归还 reserve 取走（或预约）的令牌，语句最终被拒绝时调用
+func (l *limiter) refund() {
+	if l.rule.Rate <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+	if l.tokens > float64(l.rule.Burst) {
+		l.tokens = float64(l.rule.Burst)
+	}
+}
+
+// enqueue 进入等待队列，队列已满时返回 false
+func (l *limiter) enqueue() bool {
+	if l.queued.Add(1) > int32(l.rule.MaxQueue) {
+		l.queued.Add(-1)
+		return false
+	}
+	return true
+}
+
+// reject 记录拒绝并返回客户端看到的错误
+func (l *limiter) reject(limit string, timeout bool) error {
+	l.rejected.Add(1)
+	if timeout {
+		l.timeouts.Add(1)
+	}
+	msg := fmt.Sprintf("proxyx: too many queries, %s '%s' has exceeded the '%s' limit", l.rule.Scope, l.key, limit)
+	if timeout {
+		msg += fmt.Sprintf(" (waited %s)", l.rule.QueueTimeout)
+	}
+	return NewError(mysql.ER_USER_LIMIT_REACHED, "42000", msg)
+}
+
+func releaseLimiters(held []*limiter) {
+	for _, l := range held {
+		<-l.slots
+	}
+}
+
+// State 返回当前所有限流对象的状态
+func (p *RateLimitPlugin) State() []LimitState {
+	p.mu.Lock()
+	limiters := make([]*limiter, 0, len(p.limiters))
+	for _, l := range p.limiters {
+		limiters = append(limiters, l)
+	}
+	p.mu.Unlock()
+
+	states := make([]LimitState, 0, len(limiters))
+	for _, l := range limiters {
+		l.mu.Lock()
+		tokens := l.tokens
+		if l.rule.Rate > 0 {
+			tokens += time.Since(l.refilled).Seconds() * l.rule.Rate
+			if tokens > float64(l.rule.Burst) {
+				tokens = float64(l.rule.Burst)
+			}
+		}
+		l.mu.Unlock()
+
+		states = append(states, LimitState{
+			Scope:         l.rule.Scope,
+			Key:           l.key,
+			Rule:          l.index,
+			InFlight:      len(l.slots),
+			MaxConcurrent: l.rule.MaxConcurrent,
+			Queued:        l.queued.Load(),
+			Tokens:        tokens,
+			Rate:          l.rule.Rate,
+			Admitted:      l.admitted.Load(),
+			Rejected:      l.rejected.Load(),
+			Timeouts:      l.timeouts.Load(),
+		})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Rule != states[j].Rule {
+			return states[i].Rule < states[j].Rule
+		}
+		return states[i].Key < states[j].Key
+	})
+	return states
+}
+
+// ServeHTTP 以JSON返回限流状态
+func (p *RateLimitPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(p.State())
+}
+
+// cleanupLoop 定期清理空闲的限流对象，按客户端IP、指纹限流时值的数量不受控制
+func (p *RateLimitPlugin) cleanupLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.cleanup(time.Now().Add(-10 * time.Minute))
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// cleanup 删除在 before 之后没有使用、也没有执行中和排队中语句的限流对象
+func (p *RateLimitPlugin) cleanup(before time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, l := range p.limiters {
+		l.mu.Lock()
+		idle := l.lastUsed.Before(before)
+		l.mu.Unlock()
+		if idle && len(l.slots) == 0 && l.queued.Load() == 0 {
+			delete(p.limiters, id)
+		}
+	}
+}
+
+func (p *RateLimitPlugin) Close() error {
+	close(p.done)
+	return nil
+}
@@ -0,0 +1,161 @@
+package mysql
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       RateLimitRule
+		tokens     float64       // 初始令牌数
+		idle       time.Duration // 距上次补充令牌的时间
+		timeout    time.Duration // 排队截止时间距现在的时长
+		wantOK     bool
+		wantWait   time.Duration
+		wantTokens float64 // 预留之后剩余的令牌数
+	}{
+		{
+			name:   "unlimited",
+			rule:   RateLimitRule{Rate: 0},
+			wantOK: true,
+		},
+		{
+			name:       "token available",
+			rule:       RateLimitRule{Rate: 10, Burst: 2},
+			tokens:     2,
+			wantOK:     true,
+			wantTokens: 1,
+		},
+		{
+			name:       "refill",
+			rule:       RateLimitRule{Rate: 10, Burst: 5},
+			idle:       200 * time.Millisecond,
+			wantOK:     true,
+			wantTokens: 1,
+		},
+		{
+			name:       "refill capped at burst",
+			rule:       RateLimitRule{Rate: 10, Burst: 3},
+			idle:       10 * time.Second,
+			wantOK:     true,
+			wantTokens: 2,
+		},
+		{
+			name:       "empty without queue",
+			rule:       RateLimitRule{Rate: 10, Burst: 1},
+			timeout:    time.Second,
+			wantOK:     false,
+			wantTokens: 0,
+		},
+		{
+			name:       "empty with queue",
+			rule:       RateLimitRule{Rate: 10, Burst: 1, MaxQueue: 1},
+			timeout:    time.Second,
+			wantOK:     true,
+			wantWait:   100 * time.Millisecond,
+			wantTokens: -1,
+		},
+		{
+			name:       "wait past deadline",
+			rule:       RateLimitRule{Rate: 10, Burst: 1, MaxQueue: 1},
+			timeout:    50 * time.Millisecond,
+			wantOK:     false,
+			wantTokens: 0,
+		},
+		{
+			name:       "queued behind earlier reservation",
+			rule:       RateLimitRule{Rate: 10, Burst: 1, MaxQueue: 2},
+			tokens:     -1,
+			timeout:    time.Second,
+			wantOK:     true,
+			wantWait:   200 * time.Millisecond,
+			wantTokens: -2,
+		},
+	}
+
+	// 调用之间经过的时间会补充少量令牌
+	const tolerance = 10 * time.Millisecond
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			l := &limiter{rule: &tt.rule, tokens: tt.tokens, refilled: now.Add(-tt.idle)}
+			wait, ok := l.reserve(now.Add(tt.timeout))
+			if ok != tt.wantOK {
+				t.Fatalf("reserve ok = %v, want %v", ok, tt.wantOK)
+			}
+			if wait > tt.wantWait || wait < tt.wantWait-tolerance {
+				t.Errorf("reserve wait = %v, want %v", wait, tt.wantWait)
+			}
+			if tt.rule.Rate > 0 && math.Abs(l.tokens-tt.wantTokens) > tolerance.Seconds()*tt.rule.Rate {
+				t.Errorf("tokens = %.3f, want %.3f", l.tokens, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestRateLimitRefund(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []RateLimitRule
+		// 先执行 running 条不结束的语句占用槽位，再发送 rejected 条被拒绝的语句
+		running  int
+		rejected int
+		// 被拒绝的语句之后，限速规则（规则 0）剩余的令牌数
+		wantTokens float64
+	}{
+		{
+			name: "later rule rejects",
+			rules: []RateLimitRule{
+				{Scope: LimitByUser, Rate: 1, Burst: 3},
+				{Scope: LimitByDatabase, MaxConcurrent: 1},
+			},
+			running:    1,
+			rejected:   5,
+			wantTokens: 2,
+		},
+		{
+			name: "same rule rejects on concurrency",
+			rules: []RateLimitRule{
+				{Scope: LimitByUser, Rate: 1, Burst: 3, MaxConcurrent: 1},
+			},
+			running:    1,
+			rejected:   5,
+			wantTokens: 2,
+		},
+		{
+			name: "wait past queue timeout",
+			rules: []RateLimitRule{
+				{Scope: LimitByUser, Rate: 1, Burst: 1, MaxQueue: 1, QueueTimeout: time.Nanosecond},
+			},
+			running:    1,
+			rejected:   3,
+			wantTokens: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewRateLimitPlugin(RateLimitPluginConfig{Rules: tt.rules})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+
+			for i := 0; i < tt.running+tt.rejected; i++ {
+				event := &QueryEvent{Type: "query", Query: "SELECT 1", User: "app", Database: "shop"}
+				_, err := p.Intercept(event)
+				if wantErr := i >= tt.running; (err != nil) != wantErr {
+					t.Fatalf("statement %d: err = %v, want error %v", i, err, wantErr)
+				}
+			}
+
+			for _, state := range p.State() {
+				if state.Rule == 0 && math.Abs(state.Tokens-tt.wantTokens) > 0.1 {
+					t.Errorf("rule 0 tokens = %.3f, want %.3f", state.Tokens, tt.wantTokens)
+				}
+			}
+		})
+	}
+}
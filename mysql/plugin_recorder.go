@@ -0,0 +1,125 @@
+package mysql
+
+import (
+	"hash/fnv"
+	"log"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/if-nil/proxyx/capture"
+	"github.com/if-nil/proxyx/rotate"
+)
+
+// RecorderPluginConfig 流量录制插件配置
+type RecorderPluginConfig struct {
+	Enabled       bool `yaml:"enabled"` // 是否启用
+	rotate.Config `yaml:",inline"`
+}
+
+// RecorderPlugin 流量录制插件 - 将客户端的语句和连接按会话写入录制文件，供 proxyx replay 回放
+type RecorderPlugin struct {
+	writer *capture.Writer
+}
+
+// NewRecorderPlugin 创建流量录制插件
+func NewRecorderPlugin(config RecorderPluginConfig) (*RecorderPlugin, error) {
+	writer, err := capture.NewWriter(config.Config, capture.SourceMySQL)
+	if err != nil {
+		return nil, err
+	}
+	return &RecorderPlugin{writer: writer}, nil
+}
+
+func (p *RecorderPlugin) Name() string {
+	return "RecorderPlugin"
+}
+
+func (p *RecorderPlugin) OnQuery(event *QueryEvent) {}
+
+func (p *RecorderPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	// 事务摘要、长事务、镜像差异、binlog 行变更等由代理生成的事件不是客户端流量，
+	// 管理语句由代理应答，回放时后端无法执行
+	switch event.Type {
+	case "transaction", "long_transaction", "idle_transaction", "mirror_diff", "binlog_row", "slow_query", "admin":
+		return
+	}
+
+	rec := &capture.Record{
+		Kind:     capture.KindQuery,
+		Session:  event.ConnID,
+		Time:     event.Timestamp,
+		Duration: event.Duration,
+		Name:     event.Type,
+		Database: event.Database,
+		User:     event.User,
+		Text:     event.Query,
+		Args:     event.Args,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if result != nil {
+		rec.Rows = result.AffectedRows
+		if result.Resultset != nil {
+			rec.Rows = uint64(len(result.RowDatas))
+			rec.Digest = ResultDigest(result.Resultset)
+		}
+	}
+	p.write(rec)
+}
+
+func (p *RecorderPlugin) OnConnect(event *ConnEvent) {
+	// COM_CHANGE_USER 不开始新的会话，回放时沿用原来的连接
+	if event.Type != "connect" {
+		return
+	}
+	p.write(&capture.Record{
+		Kind:    capture.KindConnect,
+		Session: event.ConnID,
+		Time:    event.Timestamp,
+		User:    event.User,
+		Text:    event.ClientAddr,
+	})
+}
+
+func (p *RecorderPlugin) OnAuthFailure(event *ConnEvent) {}
+
+func (p *RecorderPlugin) OnDialFailure(event *ConnEvent) {}
+
+func (p *RecorderPlugin) OnDisconnect(event *ConnEvent) {
+	p.write(&capture.Record{
+		Kind:     capture.KindDisconnect,
+		Session:  event.ConnID,
+		Time:     event.Timestamp,
+		Duration: event.Duration,
+		User:     event.User,
+		Text:     event.ClientAddr,
+		Error:    event.Error,
+	})
+}
+
+func (p *RecorderPlugin) write(rec *capture.Record) {
+	if err := p.writer.Write(rec); err != nil {
+		log.Printf("[RecorderPlugin] Write error: %v", err)
+	}
+}
+
+func (p *RecorderPlugin) Close() error {
+	return p.writer.Close()
+}
+
+// ResultDigest 按列名和各行的原始数据计算结果集摘要（与行的顺序有关）
+func ResultDigest(rs *mysql.Resultset) uint64 {
+	h := fnv.New64a()
+	for _, f := range rs.Fields {
+		h.Write(f.Name)
+		h.Write([]byte{0})
+	}
+	for _, row := range rs.RowDatas {
+		h.Write(row)
+	}
+	// 0 表示没有结果集
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
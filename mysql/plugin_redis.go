@@ -77,6 +77,31 @@ func (p *RedisPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, e
 		}
 	}
 
+	p.push(event)
+}
+
+func (p *RedisPlugin) OnConnect(event *ConnEvent) {
+	p.push(event)
+}
+
+func (p *RedisPlugin) OnAuthFailure(event *ConnEvent) {
+	p.push(event)
+}
+
+func (p *RedisPlugin) OnDialFailure(event *ConnEvent) {
+	p.push(event)
+}
+
+func (p *RedisPlugin) OnDisconnect(event *ConnEvent) {
+	p.push(event)
+}
+
+func (p *RedisPlugin) OnBackendState(event *ConnEvent) {
+	p.push(event)
+}
+
+// push 将事件以JSON推送到Redis
+func (p *RedisPlugin) push(event interface{}) {
 	data, jsonErr := json.Marshal(event)
 	if jsonErr != nil {
 		log.Printf("[RedisPlugin] JSON marshal error: %v", jsonErr)
@@ -0,0 +1,225 @@
+package mysql
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// SlowQueryPluginConfig 慢查询插件配置
+type SlowQueryPluginConfig struct {
+	Enabled       bool          `yaml:"enabled"`        // 是否启用
+	Threshold     time.Duration `yaml:"threshold"`      // 慢查询阈值
+	ExplainWrites bool          `yaml:"explain_writes"` // 是否也对 UPDATE/DELETE/INSERT/REPLACE 执行 EXPLAIN（默认只 EXPLAIN SELECT）
+	MaxConcurrent int           `yaml:"max_concurrent"` // 后台执行 EXPLAIN 的协程数，即同时使用的旁路连接数
+	QueueSize     int           `yaml:"queue_size"`     // 等待 EXPLAIN 的慢查询数上限，队列满时跳过
+	User          string        `yaml:"user"`           // 执行 EXPLAIN 使用的用户名，为空时使用代理连接后端的账号
+	Password      string        `yaml:"password"`       // 执行 EXPLAIN 使用的密码
+}
+
+// SlowQueryInfo 慢查询的执行计划分析结果
+type SlowQueryInfo struct {
+	Threshold      time.Duration   `json:"threshold"`                 // 触发时的阈值
+	Plan           json.RawMessage `json:"plan,omitempty"`            // EXPLAIN FORMAT=JSON 的结果
+	FullScans      []string        `json:"full_scans,omitempty"`      // 全表扫描的表
+	Filesort       bool            `json:"filesort"`                  // 是否使用了文件排序
+	TemporaryTable bool            `json:"temporary_table"`           // 是否使用了临时表
+	ExplainError   string          `json:"explain_error,omitempty"`   // EXPLAIN 失败或被跳过的原因
+	ExplainPending bool            `json:"explain_pending,omitempty"` // 执行计划在后台获取，完成后以 slow_query 事件发出
+}
+
+// SlowQueryPlugin 慢查询插件 - 语句耗时超过阈值时标记事件，并在后台通过旁路连接执行 EXPLAIN，
+// 执行计划附加在之后发出的 slow_query 事件上，不阻塞返回结果给客户端
+// 需要注册在 Log/Redis 等输出插件之前，输出插件才能看到慢查询标记
+type SlowQueryPlugin struct {
+	config        SlowQueryPluginConfig
+	pools         *Pools         // 旁路连接，与客户端的后端连接分开
+	pluginManager *PluginManager // 发出 slow_query 事件
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *QueryEvent // 等待 EXPLAIN 的 slow_query 事件
+	wg     sync.WaitGroup
+}
+
+// NewSlowQueryPlugin 创建慢查询插件，旁路连接通过 dialer 建立，slow_query 事件通过 pm 发出
+func NewSlowQueryPlugin(config SlowQueryPluginConfig, dialer *Dialer, pm *PluginManager) *SlowQueryPlugin {
+	if config.Threshold <= 0 {
+		config.Threshold = time.Second
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = 4
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 64
+	}
+
+	p := &SlowQueryPlugin{
+		config: config,
+		pools: NewPools(PoolConfig{
+			Enabled:     true,
+			MaxSize:     config.MaxConcurrent,
+			IdleTimeout: 5 * time.Minute,
+			MaxLifetime: time.Hour,
+		}, dialer),
+		pluginManager: pm,
+		queue:         make(chan *QueryEvent, config.QueueSize),
+	}
+	for i := 0; i < config.MaxConcurrent; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	return p
+}
+
+func (p *SlowQueryPlugin) Name() string {
+	return "SlowQueryPlugin"
+}
+
+func (p *SlowQueryPlugin) OnQuery(event *QueryEvent) {}
+
+func (p *SlowQueryPlugin) OnQueryComplete(event *QueryEvent, result *mysql.Result, err error) {
+	if event.Duration < p.config.Threshold || (event.Type != "query" && event.Type != "execute") {
+		return
+	}
+	if event.InterceptedBy != "" || event.Backend == "" || !p.explainable(event.Query) {
+		return
+	}
+
+	info := &SlowQueryInfo{Threshold: p.config.Threshold}
+	event.SlowQuery = info
+
+	// 复制事件交给后台协程，EXPLAIN 完成后作为 slow_query 事件发出
+	slow := *event
+	slow.Type = "slow_query"
+	slow.SlowQuery = &SlowQueryInfo{Threshold: p.config.Threshold}
+	slow.disabled = nil
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		info.ExplainError = "slow query plugin is closed"
+		return
+	}
+	select {
+	case p.queue <- &slow:
+		info.ExplainPending = true
+	default:
+		info.ExplainError = "explain queue is full"
+	}
+}
+
+// worker 依次对队列中的慢查询执行 EXPLAIN 并发出 slow_query 事件
+func (p *SlowQueryPlugin) worker() {
+	defer p.wg.Done()
+	for event := range p.queue {
+		plan, err := p.explain(event)
+		if err != nil {
+			event.SlowQuery.ExplainError = err.Error()
+		} else {
+			event.SlowQuery.Plan = plan
+			analyzePlan(plan, event.SlowQuery)
+		}
+		p.pluginManager.OnQuery(event)
+		p.pluginManager.OnQueryComplete(event, nil, nil)
+	}
+}
+
+// explainable 判断语句是否需要 EXPLAIN
+func (p *SlowQueryPlugin) explainable(query string) bool {
+	rest, _ := skipComments(query)
+	switch statementKeyword(rest) {
+	case "SELECT", "(":
+		return true
+	case "UPDATE", "DELETE", "INSERT", "REPLACE":
+		return p.config.ExplainWrites
+	}
+	return false
+}
+
+// explain 在旁路连接上执行 EXPLAIN FORMAT=JSON
+func (p *SlowQueryPlugin) explain(event *QueryEvent) (json.RawMessage, error) {
+	pool := p.pools.Get(event.Backend, p.config.User, p.config.Password)
+	conn, err := pool.Get(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := p.explainOn(conn, event)
+	if isConnError(err) {
+		pool.Discard(conn)
+	} else {
+		pool.Put(conn)
+	}
+	return plan, err
+}
+
+func (p *SlowQueryPlugin) explainOn(conn *backendConn, event *QueryEvent) (json.RawMessage, error) {
+	if event.Database != "" && conn.GetDB() != event.Database {
+		if err := conn.UseDB(event.Database); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := conn.Execute("EXPLAIN FORMAT=JSON "+event.Query, event.Args...)
+	if err != nil {
+		return nil, err
+	}
+	if result.Resultset == nil || result.Resultset.RowNumber() == 0 {
+		return nil, nil
+	}
+	plan, err := result.GetString(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(plan), nil
+}
+
+// analyzePlan 从执行计划中找出全表扫描、文件排序和临时表
+func analyzePlan(plan json.RawMessage, info *SlowQueryInfo) {
+	var root interface{}
+	if err := json.Unmarshal(plan, &root); err != nil {
+		return
+	}
+
+	var walk func(node interface{})
+	walk = func(node interface{}) {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			if v["access_type"] == "ALL" {
+				if table, ok := v["table_name"].(string); ok {
+					info.FullScans = append(info.FullScans, table)
+				}
+			}
+			if v["using_filesort"] == true {
+				info.Filesort = true
+			}
+			if v["using_temporary_table"] == true {
+				info.TemporaryTable = true
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+}
+
+// Close 停止接收新的慢查询，等待队列中的 EXPLAIN 完成后关闭旁路连接
+func (p *SlowQueryPlugin) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	p.pools.Close()
+	return nil
+}
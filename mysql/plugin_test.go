@@ -0,0 +1,47 @@
+package mysql
+
+import (
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// interceptFunc 用函数实现的拦截器
+type interceptFunc struct {
+	name string
+	fn   func(event *QueryEvent) (*mysql.Result, error)
+}
+
+func (p *interceptFunc) Name() string                                       { return p.name }
+func (p *interceptFunc) OnQuery(event *QueryEvent)                          {}
+func (p *interceptFunc) OnQueryComplete(*QueryEvent, *mysql.Result, error)  {}
+func (p *interceptFunc) Close() error                                       { return nil }
+func (p *interceptFunc) Intercept(event *QueryEvent) (*mysql.Result, error) { return p.fn(event) }
+
+func TestInterceptRewriteFingerprint(t *testing.T) {
+	pm := NewPluginManager()
+	pm.Register(&interceptFunc{name: "rewrite", fn: func(event *QueryEvent) (*mysql.Result, error) {
+		event.Query = "SELECT * FROM t_new WHERE id = 2"
+		return nil, nil
+	}})
+	var seen string
+	pm.Register(&interceptFunc{name: "observe", fn: func(event *QueryEvent) (*mysql.Result, error) {
+		seen = event.Digest
+		return nil, nil
+	}})
+
+	event := &QueryEvent{Type: "query", Query: "SELECT * FROM t_old WHERE id = 1"}
+	event.Fingerprint = Fingerprint(event.Query)
+	event.Digest = Digest(event.Fingerprint)
+	if _, err := pm.Intercept(event); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "select * from t_new where id = ?"
+	if event.Fingerprint != want || event.Digest != Digest(want) {
+		t.Errorf("fingerprint = %q digest = %s, want %q %s", event.Fingerprint, event.Digest, want, Digest(want))
+	}
+	if seen != Digest(want) {
+		t.Errorf("later interceptor saw digest %s, want %s", seen, Digest(want))
+	}
+}
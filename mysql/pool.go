@@ -0,0 +1,330 @@
+package mysql
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// maxCachedStmts 每个后端连接最多缓存的预处理语句数量
+const maxCachedStmts = 256
+
+// ErrPoolTimeout 连接池已满且等待超时，客户端收到 Too many connections 错误
+var ErrPoolTimeout = NewError(mysql.ER_CON_COUNT_ERROR, "08004", "proxyx: timed out waiting for a free backend connection")
+
+// PoolConfig 后端连接池配置
+type PoolConfig struct {
+	Enabled     bool          `yaml:"enabled"`      // 是否启用连接池
+	MaxSize     int           `yaml:"max_size"`     // 每个后端的最大连接数
+	IdleTimeout time.Duration `yaml:"idle_timeout"` // 空闲连接超过该时长后关闭
+	MaxLifetime time.Duration `yaml:"max_lifetime"` // 连接最长存活时间
+	WaitTimeout time.Duration `yaml:"wait_timeout"` // 连接池满时等待空闲连接的超时
+}
+
+// backendConn 到后端MySQL的连接，记录已应用的会话状态
+type backendConn struct {
+	*client.Conn
+	addr      string
+	pool      *Pool // 所属连接池，为 nil 表示独占连接
+	createdAt time.Time
+	lastUsed  time.Time
+	sets      []string                // 已在该连接上执行的 SET 语句
+	stmts     map[string]*client.Stmt // 已在该连接上预处理的语句
+}
+
+// prepare 返回该连接上已预处理的语句，没有则预处理并缓存
+func (c *backendConn) prepare(query string) (*client.Stmt, error) {
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt, nil
+	}
+	if len(c.stmts) >= maxCachedStmts {
+		c.closeStmts()
+	}
+	stmt, err := c.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+// closeStmt 关闭并移除一条缓存的预处理语句
+func (c *backendConn) closeStmt(query string) error {
+	stmt, ok := c.stmts[query]
+	if !ok {
+		return nil
+	}
+	delete(c.stmts, query)
+	return stmt.Close()
+}
+
+// closeStmts 关闭所有缓存的预处理语句
+func (c *backendConn) closeStmts() {
+	for query, stmt := range c.stmts {
+		stmt.Close()
+		delete(c.stmts, query)
+	}
+}
+
+// compatible 判断该连接的会话状态能否通过追加 SET 语句恢复为 sets
+func (c *backendConn) compatible(sets []string) bool {
+	if len(c.sets) > len(sets) {
+		return false
+	}
+	for i, s := range c.sets {
+		if sets[i] != s {
+			return false
+		}
+	}
+	return true
+}
+
+// expired 判断连接是否超过存活时间或空闲时间
+func (c *backendConn) expired(config PoolConfig, now time.Time) bool {
+	if config.MaxLifetime > 0 && now.Sub(c.createdAt) > config.MaxLifetime {
+		return true
+	}
+	return config.IdleTimeout > 0 && now.Sub(c.lastUsed) > config.IdleTimeout
+}
+
+// Pool 到单个后端的连接池
+type Pool struct {
+	addr     string
+	user     string
+	password string
+	config   PoolConfig
+	dialer   *Dialer
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	idle   []*backendConn
+	total  int // 已建立的连接数（空闲 + 使用中）
+	closed bool
+}
+
+// newPool 创建连接池
+func newPool(addr, user, password string, config PoolConfig, dialer *Dialer) *Pool {
+	p := &Pool{
+		addr:     addr,
+		user:     user,
+		password: password,
+		config:   config,
+		dialer:   dialer,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// Get 租用一个连接，优先选择会话状态与 sets 兼容的空闲连接
+func (p *Pool) Get(sets []string) (*backendConn, error) {
+	deadline := time.Now().Add(p.config.WaitTimeout)
+
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errors.New("mysql pool: closed")
+		}
+
+		// 优先复用会话状态兼容的空闲连接
+		now := time.Now()
+		for i := len(p.idle) - 1; i >= 0; i-- {
+			conn := p.idle[i]
+			if conn.expired(p.config, now) || !conn.compatible(sets) {
+				continue
+			}
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			p.mu.Unlock()
+			return conn, nil
+		}
+
+		if p.total < p.config.MaxSize {
+			p.total++
+			p.mu.Unlock()
+			return p.dial()
+		}
+
+		// 连接池已满但有不兼容的空闲连接，关闭一个后新建
+		if len(p.idle) > 0 {
+			conn := p.idle[0]
+			p.idle = p.idle[1:]
+			p.mu.Unlock()
+			conn.closeStmts()
+			conn.Close()
+			return p.dial()
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			p.mu.Unlock()
+			return nil, ErrPoolTimeout
+		}
+		timer := time.AfterFunc(remaining, p.cond.Broadcast)
+		p.cond.Wait()
+		timer.Stop()
+	}
+}
+
+// dial 新建连接，调用前已占用一个连接名额
+func (p *Pool) dial() (*backendConn, error) {
+	conn, err := p.dialer.Dial(p.addr, p.user, p.password, "")
+	if err != nil {
+		p.mu.Lock()
+		p.total--
+		p.cond.Signal()
+		p.mu.Unlock()
+		return nil, err
+	}
+	conn.pool = p
+	return conn, nil
+}
+
+// Put 归还连接
+func (p *Pool) Put(conn *backendConn) {
+	conn.lastUsed = time.Now()
+
+	p.mu.Lock()
+	if p.closed || conn.expired(p.config, conn.lastUsed) {
+		p.mu.Unlock()
+		p.Discard(conn)
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.cond.Signal()
+	p.mu.Unlock()
+}
+
+// Discard 关闭连接并释放名额，用于连接异常或会话状态无法复用的情况
+func (p *Pool) Discard(conn *backendConn) {
+	conn.closeStmts()
+	conn.Close()
+
+	p.mu.Lock()
+	p.total--
+	p.cond.Signal()
+	p.mu.Unlock()
+}
+
+// reap 关闭过期的空闲连接
+func (p *Pool) reap() {
+	now := time.Now()
+
+	p.mu.Lock()
+	var expired []*backendConn
+	alive := p.idle[:0]
+	for _, conn := range p.idle {
+		if conn.expired(p.config, now) {
+			expired = append(expired, conn)
+		} else {
+			alive = append(alive, conn)
+		}
+	}
+	p.idle = alive
+	p.total -= len(expired)
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	for _, conn := range expired {
+		conn.closeStmts()
+		conn.Close()
+	}
+}
+
+// close 关闭连接池及所有空闲连接
+func (p *Pool) close() {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.total -= len(idle)
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.closeStmts()
+		conn.Close()
+	}
+}
+
+// Pools 按后端地址和用户管理连接池
+type Pools struct {
+	config PoolConfig
+	dialer *Dialer
+	mu     sync.Mutex
+	pools  map[string]*Pool
+	done   chan struct{}
+}
+
+// NewPools 创建连接池管理器，未启用连接池时返回 nil
+func NewPools(config PoolConfig, dialer *Dialer) *Pools {
+	if !config.Enabled {
+		return nil
+	}
+	ps := &Pools{
+		config: config,
+		dialer: dialer,
+		pools:  make(map[string]*Pool),
+		done:   make(chan struct{}),
+	}
+	go ps.reapLoop()
+	return ps
+}
+
+// Get 返回到指定后端和用户的连接池，不存在时创建
+func (ps *Pools) Get(addr, user, password string) *Pool {
+	key := user + "@" + addr
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p, ok := ps.pools[key]
+	if !ok {
+		p = newPool(addr, user, password, ps.config, ps.dialer)
+		ps.pools[key] = p
+	}
+	return p
+}
+
+// reapLoop 定期清理过期的空闲连接
+func (ps *Pools) reapLoop() {
+	interval := ps.config.IdleTimeout / 2
+	if interval <= 0 || interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ps.mu.Lock()
+			pools := make([]*Pool, 0, len(ps.pools))
+			for _, p := range ps.pools {
+				pools = append(pools, p)
+			}
+			ps.mu.Unlock()
+
+			for _, p := range pools {
+				p.reap()
+			}
+		case <-ps.done:
+			return
+		}
+	}
+}
+
+// Close 关闭所有连接池
+func (ps *Pools) Close() {
+	close(ps.done)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for key, p := range ps.pools {
+		p.close()
+		delete(ps.pools, key)
+	}
+	log.Printf("[MySQL Pool] All connection pools closed")
+}
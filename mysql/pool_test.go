@@ -0,0 +1,131 @@
+package mysql
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPoolGet(t *testing.T) {
+	b := serveSessionBackend(t)
+	pool := newTestPools(t, 2).Get(b.addr, "app", "")
+
+	c1, err := pool.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := pool.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1.pool != pool || c2.pool != pool {
+		t.Fatal("leased connections do not belong to the pool")
+	}
+	if _, err := pool.Get(nil); !errors.Is(err, ErrPoolTimeout) {
+		t.Fatalf("Get() on a full pool = %v, want ErrPoolTimeout", err)
+	}
+
+	// 只复用会话状态可以通过追加 SET 恢复的连接：最近归还的 c1 带有 SET NAMES，不能给没有执行过 SET 的会话
+	c1.sets = []string{"SET NAMES utf8mb4"}
+	pool.Put(c2)
+	pool.Put(c1)
+	if c, _ := pool.Get(nil); c != c2 {
+		t.Error("Get() without SET history reused a connection carrying session state")
+	}
+	if c, _ := pool.Get([]string{"SET NAMES utf8mb4", "SET sql_mode = ''"}); c != c1 {
+		t.Error("Get() with a longer SET history did not reuse the compatible connection")
+	}
+	pool.Put(c1)
+
+	// 只剩不兼容的空闲连接且连接池已满时，关闭一个后新建
+	c, err := pool.Get([]string{"SET time_zone = '+00:00'"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == c1 || c.GetConnectionID() == c1.GetConnectionID() || len(c.sets) != 0 {
+		t.Error("Get() reused a connection whose session state cannot be restored")
+	}
+
+	// Discard 释放名额
+	pool.Discard(c)
+	if c, err = pool.Get(nil); err != nil {
+		t.Fatalf("Get() after Discard = %v", err)
+	}
+	pool.Put(c)
+	pool.Put(c2)
+}
+
+func TestPoolSessionState(t *testing.T) {
+	b := serveSessionBackend(t)
+	pools := newTestPools(t, 1) // 两个会话共用一个后端连接
+	clients := []*Handler{
+		newTestHandler(t, b.addr, pools, nil, NewPluginManager()),
+		newTestHandler(t, b.addr, pools, nil, NewPluginManager()),
+	}
+
+	steps := []struct {
+		client   int
+		query    string
+		wantErr  error    // 期望的错误
+		wantHeld bool     // 语句之后会话是否仍持有连接
+		wantSets []string // 执行语句的后端连接上执行过的 SET，用于检查会话状态的重放和泄漏
+	}{
+		{client: 0, query: "SET NAMES utf8mb4", wantSets: []string{"SET NAMES utf8mb4"}},
+		// 另一个会话不能拿到带有 SET NAMES 的连接
+		{client: 1, query: "SELECT 1"},
+		// 复用连接时重放会话的 SET
+		{client: 0, query: "SELECT 1", wantSets: []string{"SET NAMES utf8mb4"}},
+		// 事务中持有连接，其他会话等待超时
+		{client: 0, query: "BEGIN", wantHeld: true, wantSets: []string{"SET NAMES utf8mb4"}},
+		{client: 1, query: "SELECT 1", wantErr: ErrPoolTimeout},
+		{client: 0, query: "COMMIT", wantSets: []string{"SET NAMES utf8mb4"}},
+		{client: 1, query: "SELECT 1"},
+		// 关闭自动提交时持有连接
+		{client: 1, query: "SET autocommit = 0", wantHeld: true, wantSets: []string{"SET autocommit = 0"}},
+		{client: 0, query: "SELECT 1", wantErr: ErrPoolTimeout},
+		{client: 1, query: "SET autocommit = 1", wantSets: []string{"SET autocommit = 0", "SET autocommit = 1"}},
+		// 写入之后持有连接，下一条语句读取 LAST_INSERT_ID() 后归还
+		{client: 0, query: "INSERT INTO t VALUES (1)", wantHeld: true, wantSets: []string{"SET NAMES utf8mb4"}},
+		{client: 0, query: "SELECT LAST_INSERT_ID()", wantSets: []string{"SET NAMES utf8mb4"}},
+		// 临时表无法在其他连接上恢复，会话固定在连接上
+		{client: 1, query: "CREATE TEMPORARY TABLE tmp (id INT)", wantHeld: true, wantSets: []string{"SET autocommit = 0", "SET autocommit = 1"}},
+		{client: 1, query: "SELECT * FROM tmp", wantHeld: true, wantSets: []string{"SET autocommit = 0", "SET autocommit = 1"}},
+		{client: 0, query: "SELECT 1", wantErr: ErrPoolTimeout},
+	}
+	threads := make([]uint32, len(clients)) // 各会话上一条语句的后端线程
+	for i, s := range steps {
+		h := clients[s.client]
+		_, err := h.HandleQuery(s.query)
+		if !errors.Is(err, s.wantErr) {
+			t.Fatalf("step %d: client %d %q: error %v, want %v", i, s.client, s.query, err, s.wantErr)
+		}
+		if held := h.primary != nil; held != s.wantHeld {
+			t.Errorf("step %d: client %d %q: holding connection = %v, want %v", i, s.client, s.query, held, s.wantHeld)
+		}
+		if err != nil {
+			continue
+		}
+		threads[s.client] = h.backendThread
+		var sets []string
+		for _, q := range b.executed(h.backendThread) {
+			if strings.HasPrefix(q, "SET ") {
+				sets = append(sets, q)
+			}
+		}
+		if strings.Join(sets, "; ") != strings.Join(s.wantSets, "; ") {
+			t.Errorf("step %d: client %d %q ran on thread %d with SETs %q, want %q", i, s.client, s.query, h.backendThread, sets, s.wantSets)
+		}
+	}
+	if threads[0] == threads[1] {
+		t.Errorf("pinned session 1 shares thread %d with session 0", threads[1])
+	}
+
+	// 固定的会话结束时关闭连接，不归还连接池
+	clients[1].Close()
+	if _, err := clients[0].HandleQuery("SELECT 1"); err != nil {
+		t.Fatalf("statement after the pinned session closed: %v", err)
+	}
+	if clients[0].backendThread == threads[1] {
+		t.Errorf("connection with a temporary table was returned to the pool")
+	}
+}
@@ -0,0 +1,248 @@
+package mysql
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replicaCooldown 从库连接失败后被摘除的时长
+const replicaCooldown = 10 * time.Second
+
+// 路由提示注释，如 /* proxyx:primary */ SELECT ...
+const (
+	hintPrimary = "proxyx:primary"
+	hintReplica = "proxyx:replica"
+)
+
+// statementKind 语句的读写类型
+type statementKind int
+
+const (
+	kindOther statementKind = iota // 其他语句：SHOW、BEGIN、COMMIT 等
+	kindRead                       // 可以发往从库的只读语句
+	kindWrite                      // 写语句或DDL
+	kindSet                        // 修改会话变量的 SET 语句
+	kindUse                        // 切换数据库的 USE 语句
+)
+
+// 只读语句中仍需在主库执行的情况：加锁读、变量赋值/读取、依赖会话状态的函数
+var primaryOnlyRead = regexp.MustCompile(`(?i)\bfor\s+update\b|\bfor\s+share\b|\block\s+in\s+share\s+mode\b|\binto\b|@|\blast_insert_id\s*\(|\bfound_rows\s*\(|\brow_count\s*\(|\bget_lock\s*\(|\brelease_lock\s*\(|\bis_used_lock\s*\(|\bis_free_lock\s*\(`)
+
+// 使用后无法在其他连接上恢复的会话状态：临时表、表锁、命名锁、SQL级预处理语句
+var sessionPinning = regexp.MustCompile(`(?i)^\s*(create\s+temporary\b|lock\s+tables?\b|prepare\b)|\bget_lock\s*\(`)
+
+// 读取上一条语句结果的函数，只在执行该语句的连接上有效
+var lastResultFunction = regexp.MustCompile(`(?i)\blast_insert_id\s*\(|\bfound_rows\s*\(|\brow_count\s*\(`)
+
+// 结果可以被下一条语句的 FOUND_ROWS() 读取的 SELECT
+var calcFoundRows = regexp.MustCompile(`(?i)\bsql_calc_found_rows\b`)
+
+// SET 以外的语句中给用户变量赋值：SELECT @x := ...、SELECT ... INTO @x
+// 赋值无法按语句重放，用户变量会留在连接上
+var userVarAssignment = regexp.MustCompile(`(?i):=|\binto\s+@`)
+
+// 只读语句中会加锁或写入文件的情况，不视为只读
+var lockingRead = regexp.MustCompile(`(?i)\bfor\s+update\b|\bfor\s+share\b|\block\s+in\s+share\s+mode\b|\binto\s+(outfile|dumpfile)\b|\bget_lock\s*\(|\brelease_lock\s*\(`)
+
+// EXPLAIN ANALYZE 会实际执行其中的多表 UPDATE / DELETE
+var explainAnalyzeWrite = regexp.MustCompile(`(?is)\banalyze\b.*\b(update|delete)\b`)
+
+// Router 读写分离路由器，所有连接共享从库的健康状态
+type Router struct {
+	primary      string
+	replicas     []string
+	stickyWindow time.Duration // 写入后读请求粘滞主库的时长
+
+	mu       sync.Mutex
+	next     int                  // 轮询下标
+	downTill map[string]time.Time // 被摘除的从库及恢复时间
+}
+
+// NewRouter 创建读写分离路由器，没有配置从库时返回 nil
+func NewRouter(primary string, replicas []string, stickyWindow time.Duration) *Router {
+	if len(replicas) == 0 {
+		return nil
+	}
+	return &Router{
+		primary:      primary,
+		replicas:     replicas,
+		stickyWindow: stickyWindow,
+		downTill:     make(map[string]time.Time),
+	}
+}
+
+// Primary 返回从库对应的主库地址
+func (r *Router) Primary() string {
+	return r.primary
+}
+
+// Replicas 返回配置的从库地址
+func (r *Router) Replicas() []string {
+	return r.replicas
+}
+
+// PickReplica 轮询选择一个健康的从库
+func (r *Router) PickReplica() (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for i := 0; i < len(r.replicas); i++ {
+		addr := r.replicas[(r.next+i)%len(r.replicas)]
+		if now.Before(r.downTill[addr]) {
+			continue
+		}
+		r.next = (r.next + i + 1) % len(r.replicas)
+		return addr, true
+	}
+	return "", false
+}
+
+// MarkDown 将从库摘除一段时间
+func (r *Router) MarkDown(addr string) {
+	r.mu.Lock()
+	r.downTill[addr] = time.Now().Add(replicaCooldown)
+	r.mu.Unlock()
+}
+
+// classifyStatement 判断语句类型，hint 为语句中的路由提示（可能为空）
+func classifyStatement(query string) (kind statementKind, hint string) {
+	rest, hint := skipComments(query)
+
+	switch statementKeyword(rest) {
+	case "SELECT", "(":
+		if primaryOnlyRead.MatchString(rest) {
+			return kindOther, hint
+		}
+		return kindRead, hint
+	case "INSERT", "UPDATE", "DELETE", "REPLACE", "LOAD", "CALL", "WITH",
+		"CREATE", "ALTER", "DROP", "TRUNCATE", "RENAME", "GRANT", "REVOKE":
+		return kindWrite, hint
+	case "SET":
+		// SET TRANSACTION 只影响下一个事务，不属于需要恢复的会话状态
+		if strings.EqualFold(firstWord(strings.TrimSpace(rest[3:])), "TRANSACTION") {
+			return kindOther, hint
+		}
+		return kindSet, hint
+	case "USE":
+		return kindUse, hint
+	}
+	return kindOther, hint
+}
+
+// IsReadOnly 判断语句是否只读：不加锁的 SELECT（含 WITH ... SELECT），以及 SHOW、DESCRIBE、EXPLAIN
+func IsReadOnly(query string) bool {
+	rest, _ := skipComments(query)
+	switch statementKeyword(rest) {
+	case "SELECT", "(":
+		return !lockingRead.MatchString(rest)
+	case "SHOW":
+		return true
+	case "DESC", "DESCRIBE", "EXPLAIN":
+		return !explainAnalyzeWrite.MatchString(rest)
+	}
+	return false
+}
+
+// skipComments 跳过语句开头的注释，返回剩余部分以及注释中的路由提示
+func skipComments(query string) (rest string, hint string) {
+	rest = query
+	for {
+		rest = strings.TrimSpace(rest)
+		switch {
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest, "*/")
+			if end < 0 {
+				return "", hint
+			}
+			comment := strings.ToLower(rest[2:end])
+			if strings.Contains(comment, hintPrimary) {
+				hint = hintPrimary
+			} else if strings.Contains(comment, hintReplica) {
+				hint = hintReplica
+			}
+			rest = rest[end+2:]
+		case strings.HasPrefix(rest, "--"), strings.HasPrefix(rest, "#"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				return "", hint
+			}
+			rest = rest[end+1:]
+		default:
+			return rest, hint
+		}
+	}
+}
+
+// parseUseStatement 解析 USE 语句中的数据库名
+func parseUseStatement(query string) string {
+	rest, _ := skipComments(query)
+	name := strings.TrimSpace(rest[len("USE"):])
+	name = strings.TrimRight(name, "; \t\r\n")
+	return strings.Trim(name, "`")
+}
+
+// statementKeyword 返回语句的第一个单词（大写），WITH 开头时返回 CTE 之后主语句的关键字
+// WITH x AS (...) DELETE ... 是写语句，无法找到主语句时返回 WITH，由调用方按写语句处理
+func statementKeyword(rest string) string {
+	word := strings.ToUpper(firstWord(rest))
+	if word != "WITH" {
+		return word
+	}
+	depth := 0
+	closed := false // 上一个非空白字符是否为顶层 CTE 定义的右括号
+	for i := len(word); i < len(rest); {
+		c := rest[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(rest, i)
+			closed = false
+			continue
+		case c == '(':
+			// 紧跟在 CTE 定义之后的括号是主语句，如 WITH x AS (...) (SELECT ...)
+			if depth == 0 && closed {
+				return "("
+			}
+			depth++
+		case c == ')':
+			depth--
+			closed = depth == 0
+			i++
+			continue
+		case depth == 0 && isIdentByte(c) && !isIdentByte(rest[i-1]):
+			end := i
+			for end < len(rest) && isIdentByte(rest[end]) {
+				end++
+			}
+			// CTE 名和列名在顶层只能是标识符，第一个顶层的语句关键字就是主语句
+			switch w := strings.ToUpper(rest[i:end]); w {
+			case "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE":
+				return w
+			}
+			i = end
+			closed = false
+			continue
+		}
+		if !isSpaceByte(c) {
+			closed = false
+		}
+		i++
+	}
+	return word
+}
+
+// firstWord 返回语句的第一个单词
+func firstWord(s string) string {
+	if strings.HasPrefix(s, "(") {
+		return "("
+	}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '(' || r == ';' || r == '`'
+	})
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
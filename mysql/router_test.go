@@ -0,0 +1,123 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyStatement(t *testing.T) {
+	tests := []struct {
+		query    string
+		kind     statementKind
+		hint     string
+		readOnly bool
+	}{
+		{query: "SELECT * FROM t", kind: kindRead, readOnly: true},
+		{query: "  select 1", kind: kindRead, readOnly: true},
+		{query: "(SELECT 1) UNION (SELECT 2)", kind: kindRead, readOnly: true},
+		{query: "/* proxyx:replica */ SELECT 1", kind: kindRead, hint: hintReplica, readOnly: true},
+		{query: "/* PROXYX:PRIMARY */ SELECT 1", kind: kindRead, hint: hintPrimary, readOnly: true},
+		{query: "-- note\nSELECT 1", kind: kindRead, readOnly: true},
+		{query: "SELECT * FROM t FOR UPDATE", kind: kindOther},
+		{query: "SELECT * FROM t LOCK IN SHARE MODE", kind: kindOther},
+		{query: "SELECT LAST_INSERT_ID()", kind: kindOther, readOnly: true},
+		{query: "SELECT @a", kind: kindOther, readOnly: true},
+		{query: "SELECT * FROM t INTO OUTFILE '/tmp/t'", kind: kindOther},
+		{query: "WITH x AS (SELECT 1) SELECT * FROM x", kind: kindRead, readOnly: true},
+		{query: "WITH RECURSIVE x (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM x WHERE n < 5) SELECT * FROM x", kind: kindRead, readOnly: true},
+		{query: "WITH x AS (SELECT 1) (SELECT * FROM x)", kind: kindRead, readOnly: true},
+		{query: "WITH x AS (SELECT id FROM t WHERE a = 'select') DELETE FROM t WHERE id IN (SELECT id FROM x)", kind: kindWrite},
+		{query: "WITH x AS (SELECT 1 AS id), y AS (SELECT 2) UPDATE t JOIN x USING (id) SET a = 1", kind: kindWrite},
+		{query: "WITH `select` AS (SELECT 1) DELETE FROM t", kind: kindWrite},
+		{query: "WITH x AS (SELECT 1", kind: kindWrite},
+		{query: "INSERT INTO t VALUES (1)", kind: kindWrite},
+		{query: "update t set a = 1", kind: kindWrite},
+		{query: "CREATE TABLE t (id INT)", kind: kindWrite},
+		{query: "SET NAMES utf8mb4", kind: kindSet},
+		{query: "SET TRANSACTION ISOLATION LEVEL READ COMMITTED", kind: kindOther},
+		{query: "USE shop", kind: kindUse},
+		{query: "BEGIN", kind: kindOther},
+		{query: "SHOW TABLES", kind: kindOther, readOnly: true},
+		{query: "EXPLAIN SELECT 1", kind: kindOther, readOnly: true},
+		{query: "EXPLAIN ANALYZE UPDATE t SET a = 1", kind: kindOther},
+	}
+	for _, tt := range tests {
+		kind, hint := classifyStatement(tt.query)
+		if kind != tt.kind || hint != tt.hint {
+			t.Errorf("classifyStatement(%q) = %d, %q, want %d, %q", tt.query, kind, hint, tt.kind, tt.hint)
+		}
+		if got := IsReadOnly(tt.query); got != tt.readOnly {
+			t.Errorf("IsReadOnly(%q) = %v, want %v", tt.query, got, tt.readOnly)
+		}
+	}
+}
+
+func TestShouldUseReplica(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		sticky bool // 刚执行过写语句
+		pinned bool
+		want   bool
+	}{
+		{name: "read", query: "SELECT 1", want: true},
+		{name: "read after write", query: "SELECT 1", sticky: true},
+		{name: "replica hint after write", query: "/* proxyx:replica */ SELECT 1", sticky: true, want: true},
+		{name: "primary hint", query: "/* proxyx:primary */ SELECT 1"},
+		{name: "pinned session", query: "/* proxyx:replica */ SELECT 1", pinned: true},
+		{name: "write", query: "DELETE FROM t"},
+		{name: "cte write", query: "WITH x AS (SELECT 1) DELETE FROM t"},
+		{name: "replica hint on write", query: "/* proxyx:replica */ UPDATE t SET a = 1"},
+		{name: "replica hint on begin", query: "/* proxyx:replica */ BEGIN"},
+		{name: "replica hint on set", query: "/* proxyx:replica */ SET @a = 1"},
+		{name: "replica hint on use", query: "/* proxyx:replica */ USE shop"},
+		{name: "replica hint on locking read", query: "/* proxyx:replica */ SELECT * FROM t FOR UPDATE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{router: NewRouter("primary:3306", []string{"replica:3306"}, time.Minute), routing: true, pinned: tt.pinned}
+			if tt.sticky {
+				h.stickyUntil = time.Now().Add(time.Minute)
+			}
+			kind, hint := classifyStatement(tt.query)
+			if got := h.shouldUseReplica(kind, hint); got != tt.want {
+				t.Errorf("shouldUseReplica(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickReplica(t *testing.T) {
+	if r := NewRouter("primary:3306", nil, time.Minute); r != nil {
+		t.Errorf("NewRouter without replicas = %v, want nil", r)
+	}
+	r := NewRouter("primary:3306", []string{"a:3306", "b:3306", "c:3306"}, time.Minute)
+
+	// step 按顺序执行，down 不为空时摘除从库，否则选择从库
+	steps := []struct {
+		down string
+		want string // 空表示没有可用的从库
+	}{
+		{want: "a:3306"},
+		{want: "b:3306"},
+		{want: "c:3306"},
+		{want: "a:3306"},
+		{down: "b:3306"},
+		{want: "c:3306"},
+		{want: "a:3306"},
+		{want: "c:3306"},
+		{down: "a:3306"},
+		{down: "c:3306"},
+		{want: ""},
+	}
+	for i, s := range steps {
+		if s.down != "" {
+			r.MarkDown(s.down)
+			continue
+		}
+		addr, ok := r.PickReplica()
+		if addr != s.want || ok != (s.want != "") {
+			t.Errorf("step %d: PickReplica() = %q, %v, want %q", i, addr, ok, s.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package mysql
+
+import (
+	"fmt"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// rowColumns 原始行数据按列切分的结果
+type rowColumns struct {
+	cols  [][]byte // 每列的原始编码（含长度前缀），NULL 列为 nil
+	nulls []bool
+}
+
+// splitRow 将文本协议或二进制协议的原始行数据按列切分
+func splitRow(raw []byte, fields []*mysql.Field, binary bool) (*rowColumns, error) {
+	row := &rowColumns{
+		cols:  make([][]byte, len(fields)),
+		nulls: make([]bool, len(fields)),
+	}
+
+	if !binary {
+		pos := 0
+		for i := range fields {
+			if pos >= len(raw) {
+				return nil, fmt.Errorf("malformed text row")
+			}
+			if raw[pos] == 0xfb {
+				row.nulls[i] = true
+				pos++
+				continue
+			}
+			length, _, n := mysql.LengthEncodedInt(raw[pos:])
+			end := pos + n + int(length)
+			if n == 0 || end > len(raw) {
+				return nil, fmt.Errorf("malformed text row")
+			}
+			row.cols[i] = raw[pos:end]
+			pos = end
+		}
+		return row, nil
+	}
+
+	// 二进制协议：0x00 包头 + NULL 位图（偏移2位）+ 非 NULL 列的值
+	bitmapLen := (len(fields) + 7 + 2) / 8
+	if len(raw) < 1+bitmapLen {
+		return nil, fmt.Errorf("malformed binary row")
+	}
+	bitmap := raw[1 : 1+bitmapLen]
+	pos := 1 + bitmapLen
+	for i, f := range fields {
+		bit := i + 2
+		if bitmap[bit/8]&(1<<(uint(bit)%8)) != 0 {
+			row.nulls[i] = true
+			continue
+		}
+		size, err := binaryValueSize(raw[pos:], f.Type)
+		if err != nil {
+			return nil, err
+		}
+		if pos+size > len(raw) {
+			return nil, fmt.Errorf("malformed binary row")
+		}
+		row.cols[i] = raw[pos : pos+size]
+		pos += size
+	}
+	return row, nil
+}
+
+// binaryValueSize 返回二进制协议中一个值占用的字节数
+func binaryValueSize(data []byte, fieldType uint8) (int, error) {
+	switch fieldType {
+	case mysql.MYSQL_TYPE_NULL:
+		return 0, nil
+	case mysql.MYSQL_TYPE_TINY:
+		return 1, nil
+	case mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_YEAR:
+		return 2, nil
+	case mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_FLOAT:
+		return 4, nil
+	case mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_DOUBLE:
+		return 8, nil
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_TIMESTAMP, mysql.MYSQL_TYPE_TIME:
+		if len(data) < 1 {
+			return 0, fmt.Errorf("malformed binary row")
+		}
+		return 1 + int(data[0]), nil
+	}
+	length, _, n := mysql.LengthEncodedInt(data)
+	if n == 0 {
+		return 0, fmt.Errorf("malformed binary row")
+	}
+	return n + int(length), nil
+}
+
+// set 将第 i 列替换为字符串值，null 为 true 时替换为 NULL
+func (r *rowColumns) set(i int, value string, null bool) {
+	r.nulls[i] = null
+	r.cols[i] = nil
+	if !null {
+		r.cols[i] = mysql.PutLengthEncodedString([]byte(value))
+	}
+}
+
+// encode 按协议重新编码行数据
+func (r *rowColumns) encode(binary bool) mysql.RowData {
+	var data []byte
+	if !binary {
+		for i, col := range r.cols {
+			if r.nulls[i] {
+				data = append(data, 0xfb)
+			} else {
+				data = append(data, col...)
+			}
+		}
+		return data
+	}
+
+	bitmapLen := (len(r.cols) + 7 + 2) / 8
+	data = make([]byte, 1+bitmapLen)
+	for i, col := range r.cols {
+		if r.nulls[i] {
+			bit := i + 2
+			data[1+bit/8] |= 1 << (uint(bit) % 8)
+			continue
+		}
+		data = append(data, col...)
+	}
+	return data
+}
@@ -0,0 +1,141 @@
+package mysql
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func TestSplitRow(t *testing.T) {
+	fields := func(types ...uint8) []*mysql.Field {
+		fs := make([]*mysql.Field, len(types))
+		for i, typ := range types {
+			fs[i] = &mysql.Field{Type: typ}
+		}
+		return fs
+	}
+	datetime := []byte{7, 0xe8, 0x07, 1, 2, 3, 4, 5} // 2024-01-02 03:04:05
+	tests := []struct {
+		name     string
+		fields   []*mysql.Field
+		raw      []byte
+		binary   bool
+		wantCols [][]byte // nil 表示 NULL
+		wantErr  bool
+	}{
+		{
+			name:     "text",
+			fields:   fields(mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_VAR_STRING, mysql.MYSQL_TYPE_VAR_STRING),
+			raw:      []byte("\x0212\xfb\x00"),
+			wantCols: [][]byte{[]byte("\x0212"), nil, {0}},
+		},
+		{
+			name:   "text long value",
+			fields: fields(mysql.MYSQL_TYPE_BLOB),
+			raw:    append([]byte{0xfc, 0x2c, 0x01}, bytes.Repeat([]byte("x"), 300)...),
+			wantCols: [][]byte{
+				append([]byte{0xfc, 0x2c, 0x01}, bytes.Repeat([]byte("x"), 300)...),
+			},
+		},
+		{
+			name: "binary",
+			fields: fields(mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_VAR_STRING,
+				mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_DOUBLE),
+			raw: bytes.Join([][]byte{
+				{0x00, 0x20}, // 第 4 列（位 5）为 NULL
+				{0x07},
+				datetime,
+				[]byte("\x02hi"),
+				{0, 0, 0, 0, 0, 0, 0xf8, 0x3f}, // 1.5
+			}, nil),
+			binary:   true,
+			wantCols: [][]byte{{0x07}, datetime, []byte("\x02hi"), nil, {0, 0, 0, 0, 0, 0, 0xf8, 0x3f}},
+		},
+		{
+			name: "binary second bitmap byte",
+			fields: fields(mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_SHORT,
+				mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_LONG),
+			raw:      []byte{0x00, 0x00, 0x01, 1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 0}, // 第 7 列（位 8）为 NULL
+			binary:   true,
+			wantCols: [][]byte{{1, 0}, {2, 0}, {3, 0}, {4, 0}, {5, 0}, {6, 0}, nil},
+		},
+		{
+			name:    "text truncated",
+			fields:  fields(mysql.MYSQL_TYPE_VAR_STRING),
+			raw:     []byte("\x0512"),
+			wantErr: true,
+		},
+		{
+			name:    "text missing column",
+			fields:  fields(mysql.MYSQL_TYPE_VAR_STRING, mysql.MYSQL_TYPE_VAR_STRING),
+			raw:     []byte("\x0212"),
+			wantErr: true,
+		},
+		{
+			name:    "binary without bitmap",
+			fields:  fields(mysql.MYSQL_TYPE_LONG),
+			raw:     []byte{0x00},
+			binary:  true,
+			wantErr: true,
+		},
+		{
+			name:    "binary truncated string",
+			fields:  fields(mysql.MYSQL_TYPE_VAR_STRING),
+			raw:     []byte("\x00\x00\x05ab"),
+			binary:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, err := splitRow(tt.raw, tt.fields, tt.binary)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("splitRow succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitRow: %v", err)
+			}
+			for i, want := range tt.wantCols {
+				if row.nulls[i] != (want == nil) || !bytes.Equal(row.cols[i], want) {
+					t.Errorf("column %d = %x (null %v), want %x", i, row.cols[i], row.nulls[i], want)
+				}
+			}
+			if got := row.encode(tt.binary); !bytes.Equal(got, tt.raw) {
+				t.Errorf("encode = %x, want %x", got, tt.raw)
+			}
+		})
+	}
+}
+
+func TestRowColumnsSet(t *testing.T) {
+	names := []string{"id", "name", "phone"}
+	values := [][]interface{}{{int64(1), "alice", "13800138000"}}
+	for _, binary := range []bool{false, true} {
+		rs, err := mysql.BuildSimpleResultset(names, values, binary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		row, err := splitRow(rs.RowDatas[0], rs.Fields, binary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		row.set(1, "", true)
+		row.set(2, "****8000", false)
+
+		parsed, err := row.encode(binary).Parse(rs.Fields, binary, nil)
+		if err != nil {
+			t.Fatalf("binary %v: parse encoded row: %v", binary, err)
+		}
+		got := []interface{}{parsed[0].Value(), parsed[1].Value(), parsed[2].Value()}
+		want := []interface{}{int64(1), nil, []byte("****8000")}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("binary %v: row after set = %v, want %v", binary, got, want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package mysql
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// execute 按读写分离规则选择后端执行语句，并记录实际执行的后端
+func (h *Handler) execute(event *QueryEvent) (*mysql.Result, error) {
+	kind, hint := classifyStatement(event.Query)
+
+	// KILL 的参数是代理分配的连接ID，在代理的会话表中查找，不转发到后端
+	if id, queryOnly, ok, err := parseKill(event.Query); ok {
+		if err == nil {
+			err = h.kill(id, queryOnly)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &mysql.Result{}, nil
+	}
+
+	// USE 语句通过 COM_INIT_DB 执行，保证连接记录的当前数据库准确
+	if kind == kindUse {
+		dbName := parseUseStatement(event.Query)
+		err := h.withPrimary(func(conn *backendConn) error {
+			return conn.UseDB(dbName)
+		})
+		if err != nil {
+			return nil, err
+		}
+		h.currentDB = dbName
+		return &mysql.Result{}, nil
+	}
+
+	if result, handled, err := h.executeSharded(event, false); handled {
+		return result, err
+	}
+
+	h.endHolding(event.Query)
+	if h.shouldUseReplica(kind, hint) {
+		if conn := h.replicaConn(); conn != nil {
+			event.Backend = conn.addr
+			h.backendThread = conn.GetConnectionID()
+			stop := h.watchBackend(conn)
+			result, execErr := conn.Execute(event.Query, event.Args...)
+			err := stop(execErr)
+			h.releaseReplica(conn, execErr)
+			if !isConnError(err) {
+				return result, err
+			}
+			// 从库连接异常，摘除后回退到主库重试
+			log.Printf("[MySQL Router] Replica %s failed: %v", conn.addr, err)
+			h.router.MarkDown(conn.addr)
+		}
+	}
+
+	var result *mysql.Result
+	err := h.withPrimary(func(conn *backendConn) error {
+		event.Backend = conn.addr
+		var err error
+		result, err = conn.Execute(event.Query, event.Args...)
+		if err == nil {
+			h.trackSession(conn, kind, event.Query)
+			h.holdResult(conn, kind, event.Query)
+		}
+		return err
+	})
+	if err == nil && kind == kindWrite && h.routing {
+		h.stickyUntil = time.Now().Add(h.router.stickyWindow)
+	}
+	return result, err
+}
+
+// shouldUseReplica 判断语句是否可以发往从库
+func (h *Handler) shouldUseReplica(kind statementKind, hint string) bool {
+	// 只有只读语句可以发往从库，提示不能把 BEGIN、SET、USE 等会话语句带到从库
+	if !h.routing || h.pinned || kind != kindRead || hint == hintPrimary {
+		return false
+	}
+	// 事务中或关闭了自动提交时，所有语句都必须留在主库
+	if h.primary != nil && (h.primary.IsInTransaction() || !h.primary.IsAutoCommit()) {
+		return false
+	}
+	// 提示忽略写入后的粘滞窗口
+	if hint == hintReplica {
+		return true
+	}
+	return time.Now().After(h.stickyUntil)
+}
+
+// replicaConn 返回可用的从库连接，独占模式下复用同一个从库连接
+func (h *Handler) replicaConn() *backendConn {
+	if h.replica != nil {
+		if err := h.restore(h.replica); err == nil {
+			return h.replica
+		}
+		h.discard(h.replica)
+		h.replica = nil
+	}
+
+	addr, ok := h.router.PickReplica()
+	if !ok {
+		return nil
+	}
+	conn, err := h.lease(addr)
+	if err != nil {
+		log.Printf("[MySQL Router] Failed to connect to replica %s: %v", addr, err)
+		h.router.MarkDown(addr)
+		return nil
+	}
+	if conn.pool == nil {
+		h.replica = conn
+	}
+	return conn
+}
+
+// releaseReplica 语句执行完毕后归还从库连接
+func (h *Handler) releaseReplica(conn *backendConn, err error) {
+	if conn.pool != nil {
+		h.release(conn, err)
+		return
+	}
+	if isConnError(err) {
+		h.discard(conn)
+		h.replica = nil
+	}
+}
+
+// withPrimary 在主库连接上执行 fn，执行完毕后按需归还连接
+func (h *Handler) withPrimary(fn func(conn *backendConn) error) error {
+	conn, err := h.primaryConn()
+	if err != nil {
+		return err
+	}
+	h.backendThread = conn.GetConnectionID()
+	// 语句超时或客户端断开时在归还连接之前终止语句，避免终止连接池中其他会话的语句
+	stop := h.watchBackend(conn)
+	err = fn(conn)
+	stopErr := stop(err)
+	// 按语句本身的错误归还连接：KILL QUERY 失败时连接已被关闭，转换后的超时错误不能说明连接可用
+	h.releasePrimary(err)
+	return stopErr
+}
+
+// primaryConn 返回主库连接，启用连接池且当前未持有连接时从连接池租用，当前主库不可用时按主库切换重新选择
+func (h *Handler) primaryConn() (*backendConn, error) {
+	if h.primary != nil {
+		return h.primary, nil
+	}
+	conn, err := h.leasePrimary()
+	if err != nil {
+		return nil, err
+	}
+	h.primary = conn
+	return conn, nil
+}
+
+// releasePrimary 归还主库连接；事务中、关闭自动提交或会话被固定时继续持有
+func (h *Handler) releasePrimary(err error) {
+	conn := h.primary
+	if conn == nil || conn.pool == nil {
+		return
+	}
+	if !isConnError(err) && (h.pinned || h.holding || conn.IsInTransaction() || !conn.IsAutoCommit()) {
+		return
+	}
+	h.primary = nil
+	h.release(conn, err)
+}
+
+// holdResult 启用连接池时，写语句和 SQL_CALC_FOUND_ROWS 之后继续持有连接，
+// 使下一条语句中的 LAST_INSERT_ID()、ROW_COUNT()、FOUND_ROWS() 读到本会话的值
+func (h *Handler) holdResult(conn *backendConn, kind statementKind, query string) {
+	h.holding = conn.pool != nil && (kind == kindWrite || calcFoundRows.MatchString(query))
+}
+
+// endHolding 在下一条语句执行前结束 holdResult 的持有，语句读取上一条语句的结果时在持有的连接上执行后再归还
+func (h *Handler) endHolding(query string) {
+	if !h.holding {
+		return
+	}
+	h.holding = false
+	if !lastResultFunction.MatchString(query) {
+		h.releasePrimary(nil)
+	}
+}
+
+// lease 获取一个到 addr 的连接并恢复会话状态，未启用连接池时新建独占连接
+func (h *Handler) lease(addr string) (*backendConn, error) {
+	var conn *backendConn
+	var err error
+	if h.pools != nil {
+		conn, err = h.pools.Get(addr, h.user, h.password).Get(h.sets)
+	} else {
+		conn, err = h.dialer.Dial(addr, h.user, h.password, h.currentDB)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrPoolTimeout) {
+			h.pluginManager.OnDialFailure(h.ConnEvent("dial_failure", addr, err))
+		}
+		return nil, err
+	}
+
+	if err := h.restore(conn); err != nil {
+		h.discard(conn)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// release 将连接归还连接池，连接异常时直接关闭
+func (h *Handler) release(conn *backendConn, err error) {
+	if isConnError(err) {
+		conn.pool.Discard(conn)
+	} else {
+		conn.pool.Put(conn)
+	}
+}
+
+// discard 关闭连接，连接池中的连接同时释放名额
+func (h *Handler) discard(conn *backendConn) {
+	if conn.pool != nil {
+		conn.pool.Discard(conn)
+		return
+	}
+	conn.closeStmts()
+	conn.Close()
+}
+
+// restore 将连接的会话状态恢复为当前会话：当前数据库以及尚未执行的 SET 语句
+func (h *Handler) restore(conn *backendConn) error {
+	if h.currentDB != "" && conn.GetDB() != h.currentDB {
+		if err := conn.UseDB(h.currentDB); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range h.sets[len(conn.sets):] {
+		if _, err := conn.Execute(stmt); err != nil {
+			return err
+		}
+		conn.sets = append(conn.sets, stmt)
+	}
+	return nil
+}
+
+// maxSessionSets 记录的 SET 语句数量上限，超过后会话固定在当前连接上，不再记录
+const maxSessionSets = 256
+
+// trackSession 记录复用连接时需要恢复的会话状态
+// SET 语句按执行顺序全部记录、按顺序重放，后执行的赋值覆盖先前的值（SET a=1; SET a=2; SET a=1 恢复为 a=1）；
+// 只跳过与上一条完全相同且不引用变量的 SET（如每次取连接都执行的 SET NAMES），重复执行结果不变
+func (h *Handler) trackSession(conn *backendConn, kind statementKind, query string) {
+	if sessionPinning.MatchString(query) || (kind != kindSet && userVarAssignment.MatchString(query)) {
+		h.pinned = true
+		return
+	}
+	if kind != kindSet {
+		return
+	}
+	if n := len(h.sets); n > 0 && h.sets[n-1] == query && !strings.Contains(query, "@") {
+		return
+	}
+	if len(h.sets) >= maxSessionSets {
+		h.pinned = true
+		return
+	}
+	h.sets = append(h.sets, query)
+	conn.sets = append(conn.sets, query)
+}
+
+// isConnError 判断是否为连接层面的错误（服务器返回的SQL错误除外）
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var myErr *mysql.MyError
+	return !errors.As(err, &myErr)
+}
@@ -0,0 +1,289 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// shardMerge 跨分片 SELECT 的结果合并方式
+type shardMerge struct {
+	aggregated bool         // 只包含聚合函数，每个分片返回一行
+	aggregates []string     // 每个输出列的聚合函数：count / sum / min / max
+	orderBy    []shardOrder // 合并后按这些列排序
+	limited    bool
+	offset     uint64
+	count      uint64
+}
+
+// shardOrder ORDER BY 的一项，按列名或位置（从1开始）引用输出列
+type shardOrder struct {
+	name string
+	pos  int
+	desc bool
+}
+
+// shardRow 合并中的一行
+type shardRow struct {
+	raw    mysql.RowData
+	values []mysql.FieldValue
+}
+
+// merge 合并各分片的结果集，binary 表示二进制协议的行格式
+func (m *shardMerge) merge(results []*mysql.Result, binary bool) (*mysql.Result, error) {
+	base := results[0]
+	if base.Resultset == nil {
+		return base, nil
+	}
+	fields := base.Fields
+
+	var rows []shardRow
+	if m.aggregated {
+		row, err := m.aggregate(results, binary)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	} else {
+		for _, r := range results {
+			if r.Resultset == nil || len(r.Fields) != len(fields) {
+				return nil, shardingError("shards returned different result columns")
+			}
+			for i, raw := range r.RowDatas {
+				rows = append(rows, shardRow{raw: raw, values: r.Values[i]})
+			}
+		}
+	}
+
+	if len(m.orderBy) > 0 {
+		columns, err := m.resolveOrder(fields)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			for k, col := range columns {
+				c := compareFieldValues(fields[col], rows[i].values[col], rows[j].values[col])
+				if c == 0 {
+					continue
+				}
+				if m.orderBy[k].desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+
+	if m.limited {
+		if m.offset >= uint64(len(rows)) {
+			rows = nil
+		} else {
+			rows = rows[m.offset:]
+		}
+		if m.count < uint64(len(rows)) {
+			rows = rows[:m.count]
+		}
+	}
+
+	rs := &mysql.Resultset{
+		Fields:     fields,
+		FieldNames: base.FieldNames,
+		Values:     make([][]mysql.FieldValue, 0, len(rows)),
+		RowDatas:   make([]mysql.RowData, 0, len(rows)),
+	}
+	for _, row := range rows {
+		rs.RowDatas = append(rs.RowDatas, row.raw)
+		rs.Values = append(rs.Values, row.values)
+	}
+	return &mysql.Result{Status: base.Status, Resultset: rs}, nil
+}
+
+// resolveOrder 将 ORDER BY 的列名或位置解析为输出列下标
+func (m *shardMerge) resolveOrder(fields []*mysql.Field) ([]int, error) {
+	columns := make([]int, 0, len(m.orderBy))
+	for _, order := range m.orderBy {
+		if order.pos > 0 {
+			if order.pos > len(fields) {
+				return nil, shardingError("ORDER BY position %d is out of range", order.pos)
+			}
+			columns = append(columns, order.pos-1)
+			continue
+		}
+		col := -1
+		for i, f := range fields {
+			if strings.EqualFold(string(f.Name), order.name) {
+				col = i
+				break
+			}
+		}
+		if col < 0 {
+			for i, f := range fields {
+				if strings.EqualFold(string(f.OrgName), order.name) {
+					col = i
+					break
+				}
+			}
+		}
+		if col < 0 {
+			return nil, shardingError("cross-shard ORDER BY column %s must appear in the select list", order.name)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// aggregate 合并各分片返回的聚合结果：COUNT / SUM 求和，MIN / MAX 取各分片的最值
+func (m *shardMerge) aggregate(results []*mysql.Result, binary bool) (shardRow, error) {
+	var rows []*rowColumns
+	var values [][]mysql.FieldValue
+	fields := results[0].Fields
+	for _, r := range results {
+		if r.Resultset == nil || len(r.RowDatas) != 1 || len(r.Fields) != len(fields) {
+			return shardRow{}, shardingError("shards returned unexpected aggregate results")
+		}
+		row, err := splitRow(r.RowDatas[0], r.Fields, binary)
+		if err != nil {
+			return shardRow{}, err
+		}
+		rows = append(rows, row)
+		values = append(values, r.Values[0])
+	}
+
+	merged := rows[0]
+	for col, fn := range m.aggregates {
+		f := fields[col]
+		switch fn {
+		case "count", "sum":
+			total := new(big.Rat)
+			null := true
+			for i := range rows {
+				if rows[i].nulls[col] {
+					continue
+				}
+				v, ok := new(big.Rat).SetString(fieldValueString(values[i][col]))
+				if !ok {
+					return shardRow{}, shardingError("cannot add %s values of column %s", strings.ToUpper(fn), f.Name)
+				}
+				total.Add(total, v)
+				null = false
+			}
+			if null {
+				merged.nulls[col], merged.cols[col] = true, nil
+			} else {
+				merged.nulls[col], merged.cols[col] = false, encodeNumber(f, total, binary)
+			}
+
+		case "min", "max":
+			best := -1
+			for i := range rows {
+				if rows[i].nulls[col] {
+					continue
+				}
+				if best < 0 {
+					best = i
+					continue
+				}
+				c := compareFieldValues(f, values[i][col], values[best][col])
+				if (fn == "min" && c < 0) || (fn == "max" && c > 0) {
+					best = i
+				}
+			}
+			if best >= 0 {
+				merged.nulls[col], merged.cols[col] = false, rows[best].cols[col]
+			}
+		}
+	}
+
+	raw := merged.encode(binary)
+	parsed, err := raw.Parse(fields, binary, nil)
+	if err != nil {
+		return shardRow{}, err
+	}
+	return shardRow{raw: raw, values: parsed}, nil
+}
+
+// encodeNumber 按列类型编码合并后的数值
+func encodeNumber(f *mysql.Field, n *big.Rat, binaryRow bool) []byte {
+	var text string
+	switch f.Type {
+	case mysql.MYSQL_TYPE_DOUBLE, mysql.MYSQL_TYPE_FLOAT:
+		v, _ := n.Float64()
+		text = strconv.FormatFloat(v, 'g', -1, 64)
+		if binaryRow {
+			if f.Type == mysql.MYSQL_TYPE_FLOAT {
+				return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(v)))
+			}
+			return binary.LittleEndian.AppendUint64(nil, math.Float64bits(v))
+		}
+	case mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_YEAR, mysql.MYSQL_TYPE_INT24,
+		mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONGLONG:
+		text = n.FloatString(0)
+		if binaryRow {
+			v := n.Num().Int64()
+			switch f.Type {
+			case mysql.MYSQL_TYPE_TINY:
+				return []byte{byte(v)}
+			case mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_YEAR:
+				return binary.LittleEndian.AppendUint16(nil, uint16(v))
+			case mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG:
+				return binary.LittleEndian.AppendUint32(nil, uint32(v))
+			}
+			return binary.LittleEndian.AppendUint64(nil, uint64(v))
+		}
+	default:
+		// DECIMAL 等在两种协议中都以字符串传输
+		text = n.FloatString(int(f.Decimal))
+	}
+	return mysql.PutLengthEncodedString([]byte(text))
+}
+
+// compareFieldValues 比较同一列的两个值，NULL 最小；数值列按数值比较，其他列按字节比较
+// 字符串只支持二进制排序：与各分片按 _bin / binary 排序规则排出的顺序一致；按 _ci 等排序规则排序时，
+// 各分片的顺序与这里的比较不同，合并后的顺序以及 LIMIT、MIN / MAX 取到的行可能与单库执行不一致
+func compareFieldValues(f *mysql.Field, a, b mysql.FieldValue) int {
+	va, vb := a.Value(), b.Value()
+	switch {
+	case va == nil && vb == nil:
+		return 0
+	case va == nil:
+		return -1
+	case vb == nil:
+		return 1
+	}
+
+	_, aBytes := va.([]byte)
+	_, bBytes := vb.([]byte)
+	if aBytes && bBytes && !isNumericType(f.Type) {
+		return bytes.Compare(va.([]byte), vb.([]byte))
+	}
+	ra, okA := new(big.Rat).SetString(fieldValueString(a))
+	rb, okB := new(big.Rat).SetString(fieldValueString(b))
+	if !okA || !okB {
+		return strings.Compare(fieldValueString(a), fieldValueString(b))
+	}
+	return ra.Cmp(rb)
+}
+
+// isBinaryCollation 判断排序规则是否按字节比较（binary 或 xxx_bin）
+func isBinaryCollation(collation string) bool {
+	collation = strings.ToLower(collation)
+	return collation == "binary" || strings.HasSuffix(collation, "_bin")
+}
+
+// isNumericType 判断列是否为数值类型
+func isNumericType(t uint8) bool {
+	switch t {
+	case mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG,
+		mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_YEAR, mysql.MYSQL_TYPE_FLOAT, mysql.MYSQL_TYPE_DOUBLE,
+		mysql.MYSQL_TYPE_DECIMAL, mysql.MYSQL_TYPE_NEWDECIMAL:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package mysql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// shardResults 按协议构造各分片返回的结果集
+func shardResults(t *testing.T, binary bool, names []string, shards ...[][]interface{}) []*mysql.Result {
+	t.Helper()
+	results := make([]*mysql.Result, 0, len(shards))
+	for _, rows := range shards {
+		results = append(results, buildResult(t, binary, names, rows))
+	}
+	return results
+}
+
+func TestShardMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		merge   shardMerge
+		columns []string
+		shards  [][][]interface{}
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name:    "concatenate",
+			columns: []string{"id", "name"},
+			shards: [][][]interface{}{
+				{{int64(3), "c"}, {int64(1), "a"}},
+				{{int64(2), "b"}},
+			},
+			want: [][]string{{"3", "c"}, {"1", "a"}, {"2", "b"}},
+		},
+		{
+			name:    "order by number desc",
+			merge:   shardMerge{orderBy: []shardOrder{{name: "id", desc: true}}},
+			columns: []string{"id"},
+			shards: [][][]interface{}{
+				{{int64(1)}, {int64(10)}},
+				{{int64(9)}, {int64(2)}},
+			},
+			want: [][]string{{"10"}, {"9"}, {"2"}, {"1"}},
+		},
+		{
+			name:    "order by string then position",
+			merge:   shardMerge{orderBy: []shardOrder{{name: "NAME"}, {pos: 1}}},
+			columns: []string{"id", "name"},
+			shards: [][][]interface{}{
+				{{int64(4), "b"}, {int64(1), "a"}},
+				{{int64(3), "B"}, {int64(2), "b"}},
+			},
+			want: [][]string{{"3", "B"}, {"1", "a"}, {"2", "b"}, {"4", "b"}},
+		},
+		{
+			name:    "limit with offset",
+			merge:   shardMerge{orderBy: []shardOrder{{name: "id"}}, limited: true, offset: 1, count: 2},
+			columns: []string{"id"},
+			shards: [][][]interface{}{
+				{{int64(1)}, {int64(4)}},
+				{{int64(2)}, {int64(3)}},
+			},
+			want: [][]string{{"2"}, {"3"}},
+		},
+		{
+			name:    "offset past end",
+			merge:   shardMerge{limited: true, offset: 5, count: 2},
+			columns: []string{"id"},
+			shards:  [][][]interface{}{{{int64(1)}}, {{int64(2)}}},
+			want:    [][]string{},
+		},
+		{
+			name:    "aggregates",
+			merge:   shardMerge{aggregated: true, aggregates: []string{"count", "sum", "sum", "min", "max"}},
+			columns: []string{"COUNT(*)", "SUM(qty)", "SUM(price)", "MIN(name)", "MAX(name)"},
+			shards: [][][]interface{}{
+				{{int64(3), int64(10), 1.5, "b", "b"}},
+				{{int64(0), nil, nil, nil, nil}},
+				{{int64(2), int64(-4), 2.25, "a", "c"}},
+			},
+			want: [][]string{{"5", "6", "3.75", "a", "c"}},
+		},
+		{
+			name:    "aggregates all NULL",
+			merge:   shardMerge{aggregated: true, aggregates: []string{"count", "sum"}},
+			columns: []string{"COUNT(qty)", "SUM(qty)"},
+			shards:  [][][]interface{}{{{int64(0), nil}}, {{int64(0), nil}}},
+			want:    [][]string{{"0", "NULL"}},
+		},
+		{
+			name:    "order by column not selected",
+			merge:   shardMerge{orderBy: []shardOrder{{name: "created_at"}}},
+			columns: []string{"id"},
+			shards:  [][][]interface{}{{{int64(1)}}, {{int64(2)}}},
+			wantErr: "must appear in the select list",
+		},
+		{
+			name:    "order by position out of range",
+			merge:   shardMerge{orderBy: []shardOrder{{pos: 2}}},
+			columns: []string{"id"},
+			shards:  [][][]interface{}{{{int64(1)}}, {{int64(2)}}},
+			wantErr: "out of range",
+		},
+		{
+			name:    "aggregate shard without row",
+			merge:   shardMerge{aggregated: true, aggregates: []string{"count"}},
+			columns: []string{"COUNT(*)"},
+			shards:  [][][]interface{}{{{int64(1)}}, {{int64(1)}, {int64(2)}}},
+			wantErr: "unexpected aggregate results",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, binary := range []bool{false, true} {
+			name := tt.name + "/text"
+			if binary {
+				name = tt.name + "/binary"
+			}
+			t.Run(name, func(t *testing.T) {
+				result, err := tt.merge.merge(shardResults(t, binary, tt.columns, tt.shards...), binary)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("merge error = %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("merge: %v", err)
+				}
+				if got := resultStrings(result.Resultset); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("merged rows = %v, want %v", got, tt.want)
+				}
+				// 合并后的原始行数据与解析后的值一致
+				for i, raw := range result.RowDatas {
+					values, err := raw.Parse(result.Fields, binary, nil)
+					if err != nil {
+						t.Fatalf("row %d: %v", i, err)
+					}
+					if !reflect.DeepEqual(values, result.Values[i]) {
+						t.Errorf("row %d raw data decodes to %v, want %v", i, values, result.Values[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCompareFieldValues(t *testing.T) {
+	// value 按文本协议解析单个值，nil 为 NULL
+	value := func(f *mysql.Field, v interface{}) mysql.FieldValue {
+		raw := []byte{0xfb}
+		if v != nil {
+			raw = mysql.PutLengthEncodedString([]byte(v.(string)))
+		}
+		values, err := mysql.RowData(raw).Parse([]*mysql.Field{f}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return values[0]
+	}
+	integer := &mysql.Field{Type: mysql.MYSQL_TYPE_LONGLONG}
+	decimal := &mysql.Field{Type: mysql.MYSQL_TYPE_NEWDECIMAL}
+	double := &mysql.Field{Type: mysql.MYSQL_TYPE_DOUBLE}
+	varchar := &mysql.Field{Type: mysql.MYSQL_TYPE_VAR_STRING}
+
+	tests := []struct {
+		field *mysql.Field
+		a, b  interface{}
+		want  int
+	}{
+		{integer, "10", "9", 1},
+		{integer, "-3", "2", -1},
+		{integer, "7", "7", 0},
+		{decimal, "10.50", "9.75", 1},
+		{decimal, "1.50", "1.5", 0},
+		{double, "1e3", "999.5", 1},
+		{varchar, "10", "9", -1}, // 字符串按字节比较
+		{varchar, "B", "a", -1},
+		{varchar, "abc", "ab", 1},
+		{varchar, nil, "", -1}, // NULL 最小
+		{integer, "0", nil, 1},
+		{integer, nil, nil, 0},
+	}
+	for _, tt := range tests {
+		got := compareFieldValues(tt.field, value(tt.field, tt.a), value(tt.field, tt.b))
+		if got != tt.want {
+			t.Errorf("compareFieldValues(type %d, %v, %v) = %d, want %d", tt.field.Type, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIsBinaryCollation(t *testing.T) {
+	tests := []struct {
+		collation string
+		want      bool
+	}{
+		{"binary", true},
+		{"utf8mb4_bin", true},
+		{"UTF8MB4_BIN", true},
+		{"latin1_bin", true},
+		{"utf8mb4_0900_ai_ci", false},
+		{"utf8mb4_general_ci", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isBinaryCollation(tt.collation); got != tt.want {
+			t.Errorf("isBinaryCollation(%q) = %v, want %v", tt.collation, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package mysql
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// executeSharded 语句涉及分片表时按分片执行，handled 为 false 表示语句不涉及分片表，按普通语句执行
+func (h *Handler) executeSharded(event *QueryEvent, prepared bool) (result *mysql.Result, handled bool, err error) {
+	if h.sharding == nil {
+		return nil, false, nil
+	}
+	plan, err := h.sharding.route(event.Query, event.Args, h.currentDB)
+	if plan == nil && err == nil {
+		return nil, false, nil
+	}
+	// 分片之间没有分布式事务，事务中的语句只能在主库上执行
+	if err == nil && h.primary != nil && (h.primary.IsInTransaction() || !h.primary.IsAutoCommit()) {
+		err = shardingError("statements on sharded table %s are not supported inside a transaction", plan.table.Table)
+	}
+	if err != nil {
+		return nil, true, err
+	}
+
+	event.Shards = plan.shards
+	addrs := make([]string, len(plan.shards))
+	for i, shard := range plan.shards {
+		addrs[i] = h.sharding.Addr(shard)
+	}
+	event.Backend = strings.Join(addrs, ",")
+
+	// 先在会话协程中取得全部分片的连接，再并行执行
+	conns := make([]*backendConn, 0, len(addrs))
+	for _, addr := range addrs {
+		conn, err := h.shardConn(addr)
+		if err != nil {
+			for _, c := range conns {
+				h.releaseShard(c, nil)
+			}
+			return nil, true, err
+		}
+		conns = append(conns, conn)
+	}
+	if len(conns) == 1 {
+		h.backendThread = conns[0].GetConnectionID()
+	}
+
+	results := make([]*mysql.Result, len(conns))
+	errs := make([]error, len(conns))
+	stop := h.watchBackend(conns...)
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn *backendConn) {
+			defer wg.Done()
+			results[i], errs[i] = runOnShard(conn, plan.query, event.Args, prepared)
+		}(i, conn)
+	}
+	wg.Wait()
+	for _, e := range errs {
+		if e != nil {
+			err = e
+			break
+		}
+	}
+	err = stop(err)
+	for i, conn := range conns {
+		h.releaseShard(conn, errs[i])
+	}
+	if err != nil {
+		return nil, true, err
+	}
+
+	if plan.merge == nil {
+		return results[0], true, nil
+	}
+	result, err = plan.merge.merge(results, prepared)
+	return result, true, err
+}
+
+// runOnShard 在分片连接上执行语句，prepared 为 true 时使用二进制协议
+func runOnShard(conn *backendConn, query string, args []interface{}, prepared bool) (*mysql.Result, error) {
+	if !prepared {
+		return conn.Execute(query, args...)
+	}
+	stmt, err := conn.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Execute(args...)
+}
+
+// withShard 在分片连接上执行 fn
+func (h *Handler) withShard(addr string, fn func(conn *backendConn) error) error {
+	conn, err := h.shardConn(addr)
+	if err != nil {
+		return err
+	}
+	h.backendThread = conn.GetConnectionID()
+	err = fn(conn)
+	h.releaseShard(conn, err)
+	return err
+}
+
+// shardConn 返回到分片的连接并恢复会话状态，独占模式下每个分片复用同一个连接
+func (h *Handler) shardConn(addr string) (*backendConn, error) {
+	if conn, ok := h.shardConns[addr]; ok {
+		if err := h.restore(conn); err == nil {
+			return conn, nil
+		}
+		h.discard(conn)
+		delete(h.shardConns, addr)
+	}
+	conn, err := h.lease(addr)
+	if err != nil {
+		return nil, err
+	}
+	if conn.pool == nil {
+		h.shardConns[addr] = conn
+	}
+	return conn, nil
+}
+
+// releaseShard 语句执行完毕后归还分片连接
+func (h *Handler) releaseShard(conn *backendConn, err error) {
+	if conn.pool != nil {
+		h.release(conn, err)
+		return
+	}
+	if isConnError(err) {
+		h.discard(conn)
+		delete(h.shardConns, conn.addr)
+	}
+}
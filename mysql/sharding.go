@@ -0,0 +1,673 @@
+package mysql
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/format"
+	"github.com/pingcap/tidb/pkg/parser/opcode"
+	driver "github.com/pingcap/tidb/pkg/parser/test_driver"
+)
+
+// 分片算法
+const (
+	ShardHash   = "hash"   // 整数分片键按分片数取模，其他值取 CRC32 后取模
+	ShardRange  = "range"  // 整数分片键按区间上界分片
+	ShardLookup = "lookup" // 按分片键值查表
+)
+
+// ShardingConfig 分片配置，没有配置分片表时不启用
+type ShardingConfig struct {
+	Shards []ShardConfig      `yaml:"shards"` // 分片后端
+	Tables []ShardTableConfig `yaml:"tables"` // 分片的逻辑表
+}
+
+// ShardConfig 分片后端
+type ShardConfig struct {
+	Name string `yaml:"name"` // 分片名
+	Addr string `yaml:"addr"` // MySQL地址，各分片上的库名、表名与逻辑表相同
+}
+
+// ShardTableConfig 分片的逻辑表
+type ShardTableConfig struct {
+	Table        string             `yaml:"table"`         // 逻辑表，格式 db.table 或 table（任意库）
+	Column       string             `yaml:"column"`        // 分片键列
+	Algorithm    string             `yaml:"algorithm"`     // 分片算法：hash / range / lookup
+	Shards       []string           `yaml:"shards"`        // hash：参与取模的分片，按顺序编号，为空时使用全部分片
+	Ranges       []ShardRangeConfig `yaml:"ranges"`        // range：按上界升序排列的区间
+	Lookup       map[string]string  `yaml:"lookup"`        // lookup：分片键值 -> 分片名
+	DefaultShard string             `yaml:"default_shard"` // lookup：未命中时使用的分片，为空时拒绝
+}
+
+// ShardRangeConfig range 算法的区间
+type ShardRangeConfig struct {
+	Shard string `yaml:"shard"` // 分片名
+	Max   *int64 `yaml:"max"`   // 上界（不含），为空表示没有上界，只能出现在最后
+}
+
+// shardTable 编译后的分片表
+type shardTable struct {
+	ShardTableConfig
+	column string   // 小写的分片键列
+	shards []string // 表所在的全部分片，按配置顺序
+}
+
+// Sharding 分片路由，解析语句并选择执行的分片
+type Sharding struct {
+	addrs   map[string]string      // 分片名 -> 地址
+	tables  map[string]*shardTable // db.table 或 table（小写）-> 分片表
+	parsers sync.Pool
+}
+
+// shardPlan 语句的分片执行计划
+type shardPlan struct {
+	table  *shardTable
+	shards []string    // 执行的分片名
+	query  string      // 发往分片的语句，跨分片查询时可能被改写
+	merge  *shardMerge // 跨分片查询的结果合并方式，单分片时为 nil
+}
+
+// NewSharding 创建分片路由，没有配置分片表时返回 nil
+func NewSharding(config ShardingConfig) (*Sharding, error) {
+	if len(config.Tables) == 0 {
+		return nil, nil
+	}
+	s := &Sharding{
+		addrs:  make(map[string]string, len(config.Shards)),
+		tables: make(map[string]*shardTable, len(config.Tables)),
+		parsers: sync.Pool{
+			New: func() interface{} { return parser.New() },
+		},
+	}
+	var names []string
+	for _, shard := range config.Shards {
+		if shard.Name == "" || shard.Addr == "" {
+			return nil, fmt.Errorf("shard name and addr are required")
+		}
+		if _, ok := s.addrs[shard.Name]; ok {
+			return nil, fmt.Errorf("duplicate shard %s", shard.Name)
+		}
+		s.addrs[shard.Name] = shard.Addr
+		names = append(names, shard.Name)
+	}
+
+	for _, tc := range config.Tables {
+		t := &shardTable{ShardTableConfig: tc, column: strings.ToLower(tc.Column)}
+		if tc.Table == "" || tc.Column == "" {
+			return nil, fmt.Errorf("sharded table and column are required")
+		}
+		switch tc.Algorithm {
+		case ShardHash:
+			if len(t.Shards) == 0 {
+				t.Shards = names
+			}
+			t.shards = t.Shards
+		case ShardRange:
+			if len(tc.Ranges) == 0 {
+				return nil, fmt.Errorf("table %s: range algorithm requires ranges", tc.Table)
+			}
+			for i, r := range tc.Ranges {
+				if r.Max == nil && i != len(tc.Ranges)-1 {
+					return nil, fmt.Errorf("table %s: only the last range can be unbounded", tc.Table)
+				}
+				if i > 0 && r.Max != nil && *r.Max <= *tc.Ranges[i-1].Max {
+					return nil, fmt.Errorf("table %s: ranges must be in ascending order", tc.Table)
+				}
+				t.shards = append(t.shards, r.Shard)
+			}
+		case ShardLookup:
+			for _, shard := range tc.Lookup {
+				t.shards = append(t.shards, shard)
+			}
+			if tc.DefaultShard != "" {
+				t.shards = append(t.shards, tc.DefaultShard)
+			}
+		default:
+			return nil, fmt.Errorf("table %s: unknown sharding algorithm %q", tc.Table, tc.Algorithm)
+		}
+
+		// 去重并按配置顺序排列
+		seen := make(map[string]bool)
+		var shards []string
+		for _, name := range names {
+			for _, shard := range t.shards {
+				if shard == name && !seen[name] {
+					seen[name] = true
+					shards = append(shards, name)
+				}
+			}
+		}
+		for _, shard := range t.shards {
+			if _, ok := s.addrs[shard]; !ok {
+				return nil, fmt.Errorf("table %s: unknown shard %s", tc.Table, shard)
+			}
+		}
+		if len(shards) == 0 {
+			return nil, fmt.Errorf("table %s: no shards configured", tc.Table)
+		}
+		t.shards = shards
+		s.tables[strings.ToLower(tc.Table)] = t
+	}
+	return s, nil
+}
+
+// Addr 返回分片的地址
+func (s *Sharding) Addr(shard string) string {
+	return s.addrs[shard]
+}
+
+// shardingError 无法安全路由的语句返回的错误
+func shardingError(format string, args ...interface{}) error {
+	return NewError(mysql.ER_NOT_SUPPORTED_YET, "42000", "proxyx sharding: "+fmt.Sprintf(format, args...))
+}
+
+// lookupTable 返回 db.table 对应的分片表
+func (s *Sharding) lookupTable(name string) *shardTable {
+	if t, ok := s.tables[name]; ok {
+		return t
+	}
+	_, table, _ := strings.Cut(name, ".")
+	return s.tables[table]
+}
+
+// parse 解析语句，返回语句、语句涉及的分片表；不涉及分片表时 table 为 nil
+func (s *Sharding) parse(query, currentDB string) (ast.StmtNode, *shardTable, error) {
+	psr := s.parsers.Get().(*parser.Parser)
+	stmts, _, err := psr.Parse(query, "", "")
+	s.parsers.Put(psr)
+	if err != nil {
+		// 无法解析时按文本判断是否可能涉及分片表
+		lower := strings.ToLower(query)
+		for name, t := range s.tables {
+			_, table, _ := strings.Cut(name, ".")
+			if table == "" {
+				table = name
+			}
+			if strings.Contains(lower, table) {
+				return nil, nil, shardingError("cannot parse statement that may reference sharded table %s: %v", t.Table, err)
+			}
+		}
+		return nil, nil, nil
+	}
+
+	var table *shardTable
+	unsharded := ""
+	for _, stmt := range stmts {
+		collector := &tableCollector{}
+		stmt.Accept(collector)
+		for _, tn := range collector.tables {
+			schema := tn.Schema.L
+			if schema == "" {
+				schema = strings.ToLower(currentDB)
+			}
+			t := s.lookupTable(schema + "." + tn.Name.L)
+			switch {
+			case t == nil:
+				unsharded = tn.Name.O
+			case table != nil && table != t:
+				return nil, nil, shardingError("statement references more than one sharded table (%s, %s)", table.Table, t.Table)
+			default:
+				table = t
+			}
+		}
+	}
+	if table == nil {
+		return nil, nil, nil
+	}
+	if unsharded != "" {
+		return nil, nil, shardingError("statement mixes sharded table %s with unsharded table %s", table.Table, unsharded)
+	}
+	if len(stmts) != 1 {
+		return nil, nil, shardingError("multi-statement queries on sharded tables are not supported")
+	}
+	return stmts[0], table, nil
+}
+
+// prepareShard 返回预处理语句应当在哪个分片上预处理，不涉及分片表时返回空
+// 各分片的表结构相同，预处理只用于获取参数和列信息，执行时再按参数路由
+func (s *Sharding) prepareShard(query, currentDB string) (string, error) {
+	if s == nil {
+		return "", nil
+	}
+	_, table, err := s.parse(query, currentDB)
+	if err != nil || table == nil || len(table.shards) == 0 {
+		return "", err
+	}
+	return table.shards[0], nil
+}
+
+// route 解析语句并生成分片执行计划，语句不涉及分片表时返回 nil
+func (s *Sharding) route(query string, args []interface{}, currentDB string) (*shardPlan, error) {
+	stmt, table, err := s.parse(query, currentDB)
+	if err != nil || table == nil {
+		return nil, err
+	}
+
+	plan := &shardPlan{table: table, query: query}
+	switch st := stmt.(type) {
+	case *ast.SelectStmt:
+		keys, ok, err := shardKeyValues(st.Where, table.column, args)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if plan.shards, err = s.locate(table, keys); err != nil {
+				return nil, err
+			}
+		} else {
+			plan.shards = table.shards
+		}
+		if len(plan.shards) > 1 {
+			plan.merge, plan.query, err = planMerge(st, table, query)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case *ast.InsertStmt:
+		keys, err := insertKeyValues(st, table, args)
+		if err != nil {
+			return nil, err
+		}
+		if plan.shards, err = s.locate(table, keys); err != nil {
+			return nil, err
+		}
+		if len(plan.shards) != 1 {
+			return nil, shardingError("INSERT rows belong to different shards of %s, insert them separately", table.Table)
+		}
+
+	case *ast.UpdateStmt:
+		if st.TableRefs.TableRefs.Right != nil {
+			return nil, shardingError("multi-table UPDATE on sharded table %s is not supported", table.Table)
+		}
+		for _, a := range st.List {
+			if a.Column.Name.L == table.column {
+				return nil, shardingError("updating shard key %s of %s is not supported", table.Column, table.Table)
+			}
+		}
+		if plan.shards, err = s.locateWhere(table, st.Where, args, "UPDATE"); err != nil {
+			return nil, err
+		}
+
+	case *ast.DeleteStmt:
+		if st.IsMultiTable {
+			return nil, shardingError("multi-table DELETE on sharded table %s is not supported", table.Table)
+		}
+		if plan.shards, err = s.locateWhere(table, st.Where, args, "DELETE"); err != nil {
+			return nil, err
+		}
+
+	case *ast.ShowStmt:
+		// SHOW CREATE TABLE、SHOW COLUMNS 等查看表结构的语句，各分片结果相同
+		plan.shards = table.shards[:1]
+
+	default:
+		kind := strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%T", stmt), "*ast."), "Stmt")
+		return nil, shardingError("%s statement on sharded table %s is not supported, run it on each shard directly", kind, table.Table)
+	}
+	return plan, nil
+}
+
+// locateWhere 写语句必须通过 WHERE 中的分片键定位到单个分片
+func (s *Sharding) locateWhere(table *shardTable, where ast.ExprNode, args []interface{}, verb string) ([]string, error) {
+	keys, ok, err := shardKeyValues(where, table.column, args)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, shardingError("%s on %s requires shard key %s in WHERE (= or IN)", verb, table.Table, table.Column)
+	}
+	shards, err := s.locate(table, keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(shards) != 1 {
+		return nil, shardingError("%s on %s spans %d shards, it must target a single shard", verb, table.Table, len(shards))
+	}
+	return shards, nil
+}
+
+// locate 计算分片键值所在的分片，按配置顺序去重
+func (s *Sharding) locate(table *shardTable, keys []interface{}) ([]string, error) {
+	hit := make(map[string]bool)
+	for _, key := range keys {
+		shard, err := table.locate(key)
+		if err != nil {
+			return nil, err
+		}
+		hit[shard] = true
+	}
+	var shards []string
+	for _, shard := range table.shards {
+		if hit[shard] {
+			shards = append(shards, shard)
+		}
+	}
+	return shards, nil
+}
+
+// locate 计算单个分片键值所在的分片
+func (t *shardTable) locate(key interface{}) (string, error) {
+	i, isInt, str, err := normalizeShardKey(key)
+	if err != nil {
+		return "", shardingError("shard key %s of %s: %v", t.Column, t.Table, err)
+	}
+
+	switch t.Algorithm {
+	case ShardHash:
+		n := uint64(len(t.Shards))
+		if isInt {
+			return t.Shards[uint64(i)%n], nil
+		}
+		return t.Shards[uint64(crc32.ChecksumIEEE([]byte(str)))%n], nil
+	case ShardRange:
+		if !isInt {
+			return "", shardingError("shard key %s of %s must be an integer for range sharding", t.Column, t.Table)
+		}
+		for _, r := range t.Ranges {
+			if r.Max == nil || i < *r.Max {
+				return r.Shard, nil
+			}
+		}
+		return "", shardingError("shard key %s=%d of %s is out of all ranges", t.Column, i, t.Table)
+	}
+
+	if shard, ok := t.Lookup[str]; ok {
+		return shard, nil
+	}
+	if t.DefaultShard != "" {
+		return t.DefaultShard, nil
+	}
+	return "", shardingError("shard key %s=%s of %s has no lookup entry", t.Column, str, t.Table)
+}
+
+// normalizeShardKey 将分片键值统一为整数或字符串，整数形式的字符串视为整数
+func normalizeShardKey(key interface{}) (int64, bool, string, error) {
+	switch v := key.(type) {
+	case nil:
+		return 0, false, "", fmt.Errorf("NULL is not allowed")
+	case int64:
+		return v, true, strconv.FormatInt(v, 10), nil
+	case uint64:
+		return int64(v), true, strconv.FormatUint(v, 10), nil
+	case int:
+		return int64(v), true, strconv.Itoa(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return int64(v), true, strconv.FormatInt(int64(v), 10), nil
+		}
+		return 0, false, "", fmt.Errorf("non-integer number %v", v)
+	case []byte:
+		return normalizeShardKey(string(v))
+	case string:
+		if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			return i, true, strconv.FormatInt(i, 10), nil
+		}
+		return 0, false, v, nil
+	}
+	return normalizeShardKey(fmt.Sprint(key))
+}
+
+// shardKeyValues 从 WHERE 中提取分片键的取值（= 与 IN，AND / OR 组合），无法确定时 ok 为 false
+func shardKeyValues(expr ast.ExprNode, column string, args []interface{}) ([]interface{}, bool, error) {
+	switch e := expr.(type) {
+	case nil:
+		return nil, false, nil
+	case *ast.ParenthesesExpr:
+		return shardKeyValues(e.Expr, column, args)
+	case *ast.BinaryOperationExpr:
+		switch e.Op {
+		case opcode.LogicAnd:
+			// 任意一侧限定了分片键即可，另一侧只会进一步缩小结果
+			if values, ok, err := shardKeyValues(e.L, column, args); ok || err != nil {
+				return values, ok, err
+			}
+			return shardKeyValues(e.R, column, args)
+		case opcode.LogicOr:
+			left, ok, err := shardKeyValues(e.L, column, args)
+			if !ok || err != nil {
+				return nil, false, err
+			}
+			right, ok, err := shardKeyValues(e.R, column, args)
+			if !ok || err != nil {
+				return nil, false, err
+			}
+			return append(left, right...), true, nil
+		case opcode.EQ:
+			if isShardColumn(e.L, column) {
+				return literalValues([]ast.ExprNode{e.R}, args)
+			}
+			if isShardColumn(e.R, column) {
+				return literalValues([]ast.ExprNode{e.L}, args)
+			}
+		}
+	case *ast.PatternInExpr:
+		if !e.Not && e.Sel == nil && isShardColumn(e.Expr, column) {
+			return literalValues(e.List, args)
+		}
+	}
+	return nil, false, nil
+}
+
+func isShardColumn(expr ast.ExprNode, column string) bool {
+	c, ok := expr.(*ast.ColumnNameExpr)
+	return ok && c.Name.Name.L == column
+}
+
+// literalValues 取出常量或参数的值，包含其他表达式时 ok 为 false
+func literalValues(exprs []ast.ExprNode, args []interface{}) ([]interface{}, bool, error) {
+	values := make([]interface{}, 0, len(exprs))
+	for _, expr := range exprs {
+		switch e := expr.(type) {
+		case *driver.ParamMarkerExpr:
+			if e.Order >= len(args) {
+				return nil, false, shardingError("missing argument %d for shard key", e.Order+1)
+			}
+			values = append(values, args[e.Order])
+		case *driver.ValueExpr:
+			values = append(values, e.GetValue())
+		default:
+			return nil, false, nil
+		}
+	}
+	return values, true, nil
+}
+
+// insertKeyValues 提取 INSERT 每一行的分片键
+func insertKeyValues(st *ast.InsertStmt, table *shardTable, args []interface{}) ([]interface{}, error) {
+	column := table.column
+	if st.Select != nil {
+		return nil, shardingError("INSERT ... SELECT into %s is not supported", table.Table)
+	}
+	for _, a := range st.OnDuplicate {
+		if a.Column.Name.L == column {
+			return nil, shardingError("ON DUPLICATE KEY UPDATE cannot change shard key %s", column)
+		}
+	}
+
+	// INSERT ... SET 的列和值同样保存在 Columns 和 Lists 中
+	index := -1
+	for i, c := range st.Columns {
+		if c.Name.L == column {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, shardingError("INSERT must list shard key %s in its column list", column)
+	}
+	var keys []interface{}
+	for _, row := range st.Lists {
+		if index >= len(row) {
+			return nil, shardingError("INSERT row has no value for shard key %s", column)
+		}
+		values, ok, err := literalValues([]ast.ExprNode{row[index]}, args)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, shardingError("shard key %s must be a literal or parameter", column)
+		}
+		keys = append(keys, values...)
+	}
+	return keys, nil
+}
+
+// planMerge 检查跨分片的 SELECT 能否合并，并改写 LIMIT
+func planMerge(sel *ast.SelectStmt, table *shardTable, query string) (*shardMerge, string, error) {
+	reject := func(reason string) (*shardMerge, string, error) {
+		return nil, "", shardingError("cross-shard SELECT on %s: %s, add shard key %s to WHERE", table.Table, reason, table.Column)
+	}
+	switch {
+	case sel.Distinct:
+		return reject("DISTINCT is not supported")
+	case sel.GroupBy != nil:
+		return reject("GROUP BY is not supported")
+	case sel.Having != nil:
+		return reject("HAVING is not supported")
+	case sel.From == nil || sel.From.TableRefs.Right != nil:
+		return reject("joins are not supported")
+	case sel.SelectIntoOpt != nil:
+		return reject("SELECT ... INTO is not supported")
+	case sel.LockInfo != nil && sel.LockInfo.LockType != ast.SelectLockNone:
+		return reject("locking reads are not supported")
+	}
+	collector := &tableCollector{}
+	sel.Accept(collector)
+	if len(collector.tables) != 1 {
+		return reject("subqueries are not supported")
+	}
+
+	merge := &shardMerge{}
+	plain := false
+	for _, f := range sel.Fields.Fields {
+		if agg, ok := f.Expr.(*ast.AggregateFuncExpr); ok {
+			switch strings.ToLower(agg.F) {
+			case ast.AggFuncCount, ast.AggFuncSum, ast.AggFuncMin, ast.AggFuncMax:
+			default:
+				return reject(strings.ToUpper(agg.F) + "() is not supported")
+			}
+			if agg.Distinct {
+				return reject("aggregates with DISTINCT are not supported")
+			}
+			merge.aggregates = append(merge.aggregates, strings.ToLower(agg.F))
+			continue
+		}
+		if f.Expr != nil {
+			finder := &aggregateFinder{}
+			f.Expr.Accept(finder)
+			if finder.found {
+				return reject("expressions over aggregates are not supported")
+			}
+		}
+		merge.aggregates = append(merge.aggregates, "")
+		plain = true
+	}
+	if !plain {
+		merge.aggregated = true
+	} else {
+		for _, fn := range merge.aggregates {
+			if fn != "" {
+				return reject("mixing aggregates and plain columns requires GROUP BY")
+			}
+		}
+		merge.aggregates = nil
+	}
+
+	if sel.OrderBy != nil {
+		for _, item := range sel.OrderBy.Items {
+			order := shardOrder{desc: item.Desc}
+			expr := item.Expr
+			// 代理按字节归并，ORDER BY col COLLATE xxx_bin 使各分片按同样的顺序排序
+			if c, ok := expr.(*ast.SetCollationExpr); ok && isBinaryCollation(c.Collate) {
+				expr = c.Expr
+			}
+			switch e := expr.(type) {
+			case *ast.ColumnNameExpr:
+				order.name = e.Name.Name.O
+			case *ast.PositionExpr:
+				if e.P != nil {
+					return reject("ORDER BY position parameters are not supported")
+				}
+				order.pos = e.N
+			default:
+				return reject("ORDER BY expressions are not supported, order by a selected column")
+			}
+			merge.orderBy = append(merge.orderBy, order)
+		}
+	}
+
+	if sel.Limit == nil {
+		return merge, query, nil
+	}
+	count, ok := limitValue(sel.Limit.Count)
+	if !ok {
+		return reject("LIMIT must be a constant")
+	}
+	var offset uint64
+	if sel.Limit.Offset != nil {
+		if offset, ok = limitValue(sel.Limit.Offset); !ok {
+			return reject("LIMIT offset must be a constant")
+		}
+	}
+	merge.limited, merge.offset, merge.count = true, offset, count
+	// 每个分片返回前 offset+count 行，合并排序后再取 offset 之后的 count 行
+	sel.Limit = &ast.Limit{Count: ast.NewValueExpr(offset+count, "", "")}
+	var sb strings.Builder
+	if err := sel.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &sb)); err != nil {
+		return nil, "", shardingError("cannot rewrite LIMIT: %v", err)
+	}
+	return merge, sb.String(), nil
+}
+
+func limitValue(expr ast.ExprNode) (uint64, bool) {
+	// 参数标记是另一种类型，不会匹配
+	v, ok := expr.(*driver.ValueExpr)
+	if !ok {
+		return 0, false
+	}
+	switch n := v.GetValue().(type) {
+	case uint64:
+		return n, true
+	case int64:
+		if n >= 0 {
+			return uint64(n), true
+		}
+	}
+	return 0, false
+}
+
+// aggregateFinder 检查表达式中是否包含聚合函数
+type aggregateFinder struct {
+	found bool
+}
+
+func (f *aggregateFinder) Enter(n ast.Node) (ast.Node, bool) {
+	if _, ok := n.(*ast.AggregateFuncExpr); ok {
+		f.found = true
+		return n, true
+	}
+	return n, false
+}
+
+func (f *aggregateFinder) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// Tables 返回分片表及其所在的分片，用于日志
+func (s *Sharding) Tables() map[string][]string {
+	tables := make(map[string][]string, len(s.tables))
+	for _, t := range s.tables {
+		shards := append([]string(nil), t.shards...)
+		sort.Strings(shards)
+		tables[t.Table] = shards
+	}
+	return tables
+}
@@ -0,0 +1,219 @@
+package mysql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func int64Ptr(v int64) *int64 { return &v }
+
+// testShardingConfig 三个分片：orders 按 user_id 取模，logs 按 id 分区间，regions 按 region 查表
+func testShardingConfig() ShardingConfig {
+	return ShardingConfig{
+		Shards: []ShardConfig{
+			{Name: "s0", Addr: "127.0.0.1:3306"},
+			{Name: "s1", Addr: "127.0.0.1:3307"},
+			{Name: "s2", Addr: "127.0.0.1:3308"},
+		},
+		Tables: []ShardTableConfig{
+			{Table: "shop.orders", Column: "user_id", Algorithm: ShardHash},
+			{Table: "shop.logs", Column: "id", Algorithm: ShardRange, Ranges: []ShardRangeConfig{
+				{Shard: "s0", Max: int64Ptr(100)},
+				{Shard: "s1", Max: int64Ptr(200)},
+				{Shard: "s2"},
+			}},
+			{Table: "regions", Column: "region", Algorithm: ShardLookup, Lookup: map[string]string{"cn": "s0", "us": "s1"}},
+		},
+	}
+}
+
+func TestNewSharding(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*ShardingConfig)
+		wantErr string
+	}{
+		{name: "valid", modify: func(*ShardingConfig) {}},
+		{
+			name:    "duplicate shard",
+			modify:  func(c *ShardingConfig) { c.Shards = append(c.Shards, ShardConfig{Name: "s0", Addr: "x"}) },
+			wantErr: "duplicate shard s0",
+		},
+		{
+			name:    "missing column",
+			modify:  func(c *ShardingConfig) { c.Tables[0].Column = "" },
+			wantErr: "column are required",
+		},
+		{
+			name:    "unknown algorithm",
+			modify:  func(c *ShardingConfig) { c.Tables[0].Algorithm = "mod" },
+			wantErr: "unknown sharding algorithm",
+		},
+		{
+			name:    "unknown shard",
+			modify:  func(c *ShardingConfig) { c.Tables[2].Lookup["eu"] = "s9" },
+			wantErr: "unknown shard s9",
+		},
+		{
+			name:    "unbounded range not last",
+			modify:  func(c *ShardingConfig) { c.Tables[1].Ranges[0].Max = nil },
+			wantErr: "only the last range can be unbounded",
+		},
+		{
+			name:    "descending ranges",
+			modify:  func(c *ShardingConfig) { c.Tables[1].Ranges[1].Max = int64Ptr(50) },
+			wantErr: "ascending order",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testShardingConfig()
+			tt.modify(&config)
+			s, err := NewSharding(config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewSharding error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewSharding: %v", err)
+			}
+			want := map[string][]string{
+				"shop.orders": {"s0", "s1", "s2"},
+				"shop.logs":   {"s0", "s1", "s2"},
+				"regions":     {"s0", "s1"},
+			}
+			for name, shards := range want {
+				if got := s.tables[name].shards; !reflect.DeepEqual(got, shards) {
+					t.Errorf("table %s shards = %v, want %v", name, got, shards)
+				}
+			}
+		})
+	}
+
+	if s, err := NewSharding(ShardingConfig{}); s != nil || err != nil {
+		t.Errorf("NewSharding without tables = %v, %v, want nil", s, err)
+	}
+}
+
+func TestShardLocate(t *testing.T) {
+	s, err := NewSharding(testShardingConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		table   string
+		key     interface{}
+		want    string
+		wantErr string
+	}{
+		{table: "shop.orders", key: int64(7), want: "s1"},
+		{table: "shop.orders", key: uint64(9), want: "s0"},
+		{table: "shop.orders", key: float64(5), want: "s2"},
+		{table: "shop.orders", key: "7", want: "s1"},
+		{table: "shop.orders", key: []byte(" 8 "), want: "s2"},
+		{table: "shop.orders", key: "abc", want: "s0"}, // crc32("abc") % 3 == 0
+		{table: "shop.orders", key: 1.5, wantErr: "non-integer number"},
+		{table: "shop.orders", key: nil, wantErr: "NULL is not allowed"},
+		{table: "shop.logs", key: int64(-5), want: "s0"},
+		{table: "shop.logs", key: int64(99), want: "s0"},
+		{table: "shop.logs", key: int64(100), want: "s1"},
+		{table: "shop.logs", key: "150", want: "s1"},
+		{table: "shop.logs", key: int64(1 << 40), want: "s2"},
+		{table: "shop.logs", key: "x", wantErr: "must be an integer"},
+		{table: "regions", key: "cn", want: "s0"},
+		{table: "regions", key: []byte("us"), want: "s1"},
+		{table: "regions", key: "eu", wantErr: "no lookup entry"},
+	}
+
+	for _, tt := range tests {
+		got, err := s.tables[tt.table].locate(tt.key)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s locate(%v) error = %v, want %q", tt.table, tt.key, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s locate(%v) = %q, %v, want %q", tt.table, tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestShardRoute(t *testing.T) {
+	s, err := NewSharding(testShardingConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query     string
+		args      []interface{}
+		want      []string // 执行的分片，nil 表示不涉及分片表
+		wantMerge bool
+		wantQuery string // 改写后的语句包含的内容
+		wantErr   string
+	}{
+		{query: "SELECT * FROM t WHERE id = 1"},
+		{query: "SELECT * FROM orders WHERE user_id = 7", want: []string{"s1"}},
+		{query: "SELECT * FROM shop.orders WHERE status = 1 AND user_id = 3", want: []string{"s0"}},
+		{query: "SELECT * FROM orders WHERE user_id = ?", args: []interface{}{int64(5)}, want: []string{"s2"}},
+		{query: "SELECT * FROM orders WHERE user_id IN (1, 4, 2)", want: []string{"s1", "s2"}, wantMerge: true},
+		{query: "SELECT * FROM orders WHERE user_id = 1 OR user_id = 3", want: []string{"s0", "s1"}, wantMerge: true},
+		{query: "SELECT * FROM orders WHERE user_id > 1", want: []string{"s0", "s1", "s2"}, wantMerge: true},
+		{
+			query: "SELECT id FROM orders ORDER BY id LIMIT 10, 5", want: []string{"s0", "s1", "s2"},
+			wantMerge: true, wantQuery: "LIMIT 15",
+		},
+		{query: "SELECT * FROM logs WHERE id = 150", want: []string{"s1"}},
+		{query: "SELECT * FROM regions WHERE region IN ('cn', 'us')", want: []string{"s0", "s1"}, wantMerge: true},
+		{query: "INSERT INTO orders (id, user_id) VALUES (1, 3), (2, 6)", want: []string{"s0"}},
+		{query: "INSERT INTO orders SET id = 1, user_id = ?", args: []interface{}{"4"}, want: []string{"s1"}},
+		{query: "INSERT INTO orders (id, user_id) VALUES (1, 3), (2, 4)", wantErr: "belong to different shards"},
+		{query: "INSERT INTO orders (id) VALUES (1)", wantErr: "must list shard key"},
+		{query: "UPDATE orders SET status = 1 WHERE user_id = 4", want: []string{"s1"}},
+		{query: "UPDATE orders SET user_id = 1 WHERE user_id = 4", wantErr: "updating shard key"},
+		{query: "DELETE FROM orders WHERE id = 1", wantErr: "requires shard key"},
+		{query: "DELETE FROM orders WHERE user_id IN (1, 2)", wantErr: "spans 2 shards"},
+		{query: "SELECT * FROM orders WHERE user_id = ?", wantErr: "missing argument 1"},
+		{query: "SELECT DISTINCT status FROM orders", wantErr: "DISTINCT is not supported"},
+		{query: "SELECT status, COUNT(*) FROM orders GROUP BY status", wantErr: "GROUP BY is not supported"},
+		{query: "SELECT AVG(amount) FROM orders", wantErr: "AVG() is not supported"},
+		{query: "SELECT * FROM orders ORDER BY amount * 2", wantErr: "ORDER BY expressions are not supported"},
+		{query: "SELECT * FROM orders o JOIN users u ON o.user_id = u.id", wantErr: "mixes sharded table"},
+		{query: "SELECT * FROM orders JOIN logs ON orders.id = logs.id", wantErr: "more than one sharded table"},
+		{query: "TRUNCATE TABLE orders", wantErr: "is not supported"},
+	}
+
+	for _, tt := range tests {
+		plan, err := s.route(tt.query, tt.args, "shop")
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("route(%q) error = %v, want %q", tt.query, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("route(%q): %v", tt.query, err)
+			continue
+		}
+		if tt.want == nil {
+			if plan != nil {
+				t.Errorf("route(%q) = %+v, want nil", tt.query, plan)
+			}
+			continue
+		}
+		if plan == nil || !reflect.DeepEqual(plan.shards, tt.want) {
+			t.Errorf("route(%q) = %+v, want shards %v", tt.query, plan, tt.want)
+			continue
+		}
+		if (plan.merge != nil) != tt.wantMerge {
+			t.Errorf("route(%q) merge = %+v, want merge %v", tt.query, plan.merge, tt.wantMerge)
+		}
+		if tt.wantQuery != "" && !strings.Contains(plan.query, tt.wantQuery) {
+			t.Errorf("route(%q) query = %q, want it to contain %q", tt.query, plan.query, tt.wantQuery)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/if-nil/proxyx/fault"
+)
+
+// erQueryTimeout 与 MySQL max_execution_time 超时相同的错误码（ER_QUERY_TIMEOUT）
+const erQueryTimeout = 3024
+
+// 语句被代理终止的原因
+const (
+	KillTimeout    = "timeout"           // 超过最长执行时间
+	KillDisconnect = "client_disconnect" // 执行期间客户端断开
+	KillStatement  = "kill"              // 被 KILL / COM_PROCESS_KILL 终止
+)
+
+// QueryTimeoutConfig 语句超时配置，按 SQL指纹、用户、全局默认值的顺序取第一个配置的值
+type QueryTimeoutConfig struct {
+	Default          time.Duration            `yaml:"default"`            // 全局最长执行时间（0表示不限制）
+	Users            map[string]time.Duration `yaml:"users"`              // 按用户的最长执行时间
+	Digests          map[string]time.Duration `yaml:"digests"`            // 按SQL指纹摘要或指纹的最长执行时间
+	KillOnDisconnect bool                     `yaml:"kill_on_disconnect"` // 执行期间客户端断开时终止语句
+}
+
+// QueryTimeouts 语句超时与客户端断开检测
+type QueryTimeouts struct {
+	config  QueryTimeoutConfig
+	digests map[string]time.Duration // 摘要转为小写
+}
+
+// NewQueryTimeouts 创建语句超时检查，没有配置任何超时且不检测客户端断开时返回 nil
+func NewQueryTimeouts(config QueryTimeoutConfig) *QueryTimeouts {
+	if config.Default <= 0 && len(config.Users) == 0 && len(config.Digests) == 0 && !config.KillOnDisconnect {
+		return nil
+	}
+	t := &QueryTimeouts{
+		config:  config,
+		digests: make(map[string]time.Duration, len(config.Digests)),
+	}
+	for k, v := range config.Digests {
+		t.digests[k] = v
+		t.digests[strings.ToLower(k)] = v
+	}
+	return t
+}
+
+// lookup 返回语句的最长执行时间，0 表示不限制
+func (t *QueryTimeouts) lookup(event *QueryEvent) time.Duration {
+	if d, ok := t.digests[strings.ToLower(event.Digest)]; ok {
+		return d
+	}
+	if d, ok := t.digests[event.Fingerprint]; ok {
+		return d
+	}
+	if d, ok := t.config.Users[event.User]; ok {
+		return d
+	}
+	return t.config.Default
+}
+
+// queryWatch 执行中的语句的超时设置
+type queryWatch struct {
+	event    *QueryEvent
+	timeout  time.Duration
+	deadline time.Time // 为零值表示不限制执行时间
+}
+
+// guard 在超时、客户端断开检测和 KILL QUERY 下执行语句
+func (h *Handler) guard(event *QueryEvent, fn func(*QueryEvent) (*mysql.Result, error)) (*mysql.Result, error) {
+	w := &queryWatch{event: event}
+	if h.timeouts != nil {
+		if w.timeout = h.timeouts.lookup(event); w.timeout > 0 {
+			w.deadline = time.Now().Add(w.timeout)
+			event.Timeout = w.timeout
+		}
+	}
+	h.watch = w
+	defer func() { h.watch = nil }()
+	return fn(event)
+}
+
+// watchBackend 开始监视在 conns 上执行的语句，超时、客户端断开或其他会话 KILL 时在旁路连接上执行 KILL QUERY
+// 返回的函数在语句返回后、归还连接前调用，等待进行中的 KILL 完成，并将超时转换为超时错误
+func (h *Handler) watchBackend(conns ...*backendConn) func(error) error {
+	w := h.watch
+
+	var mu sync.Mutex
+	var killed string
+	done := false
+	threads := make([]uint32, len(conns))
+	for i, conn := range conns {
+		threads[i] = conn.GetConnectionID()
+	}
+	kill := func(reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if done || killed != "" {
+			return
+		}
+		killed = reason
+		for i, conn := range conns {
+			h.killQuery(conn, threads[i], reason)
+		}
+	}
+	h.setRunning(kill)
+
+	var timer *time.Timer
+	if w != nil && !w.deadline.IsZero() {
+		timer = time.AfterFunc(time.Until(w.deadline), func() { kill(KillTimeout) })
+	}
+	var stopWatch func()
+	if h.client != nil && h.timeouts != nil && h.timeouts.config.KillOnDisconnect {
+		stopWatch = h.client.watch(func() { kill(KillDisconnect) })
+	}
+
+	return func(err error) error {
+		h.setRunning(nil)
+		if timer != nil {
+			timer.Stop()
+		}
+		if stopWatch != nil {
+			stopWatch()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		done = true
+
+		if killed == "" {
+			return err
+		}
+		if w != nil {
+			w.event.Killed = killed
+		}
+		if killed == KillTimeout && err != nil {
+			return NewError(erQueryTimeout, "HY000", fmt.Sprintf("proxyx: query execution was interrupted, maximum statement execution time exceeded (%s)", w.timeout))
+		}
+		return err
+	}
+}
+
+// setRunning 设置终止当前语句的函数，语句结束时设为 nil
+func (h *Handler) setRunning(kill func(reason string)) {
+	h.runMu.Lock()
+	h.running = kill
+	h.runMu.Unlock()
+}
+
+// killRunning 终止会话正在执行的语句，会话空闲时不做任何事；可以在其他协程中调用
+func (h *Handler) killRunning(reason string) {
+	h.runMu.Lock()
+	kill := h.running
+	h.runMu.Unlock()
+	if kill != nil {
+		kill(reason)
+	}
+}
+
+// killQuery 在到同一后端的旁路连接上终止语句，旁路连接不可用时关闭执行语句的连接
+func (h *Handler) killQuery(conn *backendConn, thread uint32, reason string) {
+	log.Printf("[MySQL] Killing query on %s thread %d: %s", conn.addr, thread, reason)
+	side, err := h.dialer.Dial(conn.addr, h.user, h.password, "")
+	if err == nil {
+		_, err = side.Execute(fmt.Sprintf("KILL QUERY %d", thread))
+		side.Close()
+	}
+	if err != nil {
+		log.Printf("[MySQL] KILL QUERY %d on %s failed, closing backend connection: %v", thread, conn.addr, err)
+		conn.interrupt()
+	}
+}
+
+// interrupt 关闭底层网络连接，使连接上正在执行的语句立即出错返回；可以在其他协程中调用。
+// client.Conn.Close 会重置执行语句的协程正在使用的包序号，只能由持有连接的协程调用
+func (c *backendConn) interrupt() {
+	c.Conn.Conn.Conn.Close()
+}
+
+// ClientConn 客户端连接，语句执行期间可以检测客户端是否断开
+type ClientConn struct {
+	net.Conn
+	mu      sync.Mutex
+	pending []byte // 检测期间读到的客户端数据，留给下一次 Read
+	drop    bool   // 下一次 Write 只写出一半数据后关闭连接（故障注入）
+}
+
+// NewClientConn 包装客户端连接
+func NewClientConn(conn net.Conn) *ClientConn {
+	return &ClientConn{Conn: conn}
+}
+
+func (c *ClientConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		c.mu.Unlock()
+		return n, nil
+	}
+	c.mu.Unlock()
+	return c.Conn.Read(b)
+}
+
+func (c *ClientConn) Write(b []byte) (int, error) {
+	if !c.drop {
+		return c.Conn.Write(b)
+	}
+	c.drop = false
+	n, _ := c.Conn.Write(b[:len(b)/2])
+	c.Conn.Close()
+	return n, net.ErrClosed
+}
+
+// watch 在后台读取客户端连接，连接关闭时调用 onClose，返回的函数结束检测
+// 语句执行期间服务端不读取客户端连接，读到的数据（客户端提前发送的下一条命令）保留给之后的 Read；
+// 读到数据后不再继续检测
+func (c *ClientConn) watch(onClose func()) func() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		n, err := c.Conn.Read(buf)
+		if n > 0 {
+			c.mu.Lock()
+			c.pending = append(c.pending, buf[:n]...)
+			c.mu.Unlock()
+		}
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			onClose()
+		}
+	}()
+
+	return func() {
+		c.Conn.SetReadDeadline(time.Now())
+		<-done
+		c.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// injectDrop 语句被注入 drop 故障时，在写回响应的中途断开客户端连接
+func (h *Handler) injectDrop(event *QueryEvent) {
+	if event.Fault == fault.Drop && h.client != nil {
+		h.client.drop = true
+	}
+}
+
+// WatchClient 设置客户端连接，配置了 kill_on_disconnect 时在语句执行期间检测客户端断开
+func (h *Handler) WatchClient(conn *ClientConn) {
+	h.client = conn
+}
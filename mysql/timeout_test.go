@@ -0,0 +1,309 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// sessionBackend 按连接模拟 MySQL 会话的后端：记录每个连接执行的语句，SELECT SLEEP() 阻塞到被 KILL QUERY 终止；
+// BEGIN / COMMIT / ROLLBACK、SET autocommit、DDL 的隐式提交维护连接的事务状态，
+// SELECT deadlock 回滚事务并返回死锁错误，INSERT dup 返回重复键错误，SELECT crash 断开连接
+type sessionBackend struct {
+	addr   string
+	refuse atomic.Bool // 拒绝新连接（握手前关闭）
+
+	mu       sync.Mutex
+	queries  []backendQuery
+	sleeping map[uint32]chan struct{} // 线程ID -> 终止 SLEEP 的通道
+}
+
+// backendQuery 后端执行的一条语句
+type backendQuery struct {
+	thread uint32
+	query  string
+}
+
+// sessionConn sessionBackend 上的一个连接
+type sessionConn struct {
+	server.EmptyHandler
+	b    *sessionBackend
+	conn *server.Conn
+}
+
+// serveSessionBackend 启动 sessionBackend，用户 app 没有密码
+func serveSessionBackend(t *testing.T) *sessionBackend {
+	t.Helper()
+	b := &sessionBackend{sleeping: make(map[uint32]chan struct{})}
+	credentials := server.NewInMemoryProvider()
+	credentials.AddUser("app", "")
+	backendServer := server.NewDefaultServer()
+	b.addr = serve(t, func(c net.Conn) {
+		if b.refuse.Load() {
+			c.Close()
+			return
+		}
+		sc := &sessionConn{b: b}
+		conn, err := server.NewCustomizedConn(c, backendServer, credentials, sc)
+		if err != nil {
+			c.Close()
+			return
+		}
+		sc.conn = conn
+		conn.SetStatus(mysql.SERVER_STATUS_AUTOCOMMIT)
+		for conn.HandleCommand() == nil {
+		}
+	})
+	return b
+}
+
+func (c *sessionConn) HandleQuery(query string) (*mysql.Result, error) {
+	thread := c.conn.ConnectionID()
+	c.b.mu.Lock()
+	c.b.queries = append(c.b.queries, backendQuery{thread: thread, query: query})
+	c.b.mu.Unlock()
+
+	upper := strings.ToUpper(query)
+	switch {
+	case strings.HasPrefix(upper, "KILL QUERY "):
+		id, err := strconv.Atoi(query[len("KILL QUERY "):])
+		if err != nil {
+			return nil, err
+		}
+		c.b.mu.Lock()
+		if ch, ok := c.b.sleeping[uint32(id)]; ok {
+			close(ch)
+			delete(c.b.sleeping, uint32(id))
+		}
+		c.b.mu.Unlock()
+	case strings.HasPrefix(upper, "SELECT SLEEP("):
+		ch := make(chan struct{})
+		c.b.mu.Lock()
+		c.b.sleeping[thread] = ch
+		c.b.mu.Unlock()
+		select {
+		case <-ch:
+			return nil, mysql.NewError(mysql.ER_QUERY_INTERRUPTED, "Query execution was interrupted")
+		case <-time.After(5 * time.Second):
+		}
+	case upper == "BEGIN" || strings.HasPrefix(upper, "START TRANSACTION"):
+		c.conn.SetStatus(mysql.SERVER_STATUS_IN_TRANS)
+		return nil, nil
+	case upper == "COMMIT" || upper == "ROLLBACK":
+		c.conn.UnsetStatus(mysql.SERVER_STATUS_IN_TRANS)
+		return nil, nil
+	case upper == "SET AUTOCOMMIT = 0":
+		c.conn.UnsetStatus(mysql.SERVER_STATUS_AUTOCOMMIT)
+		return nil, nil
+	case upper == "SET AUTOCOMMIT = 1":
+		c.conn.SetStatus(mysql.SERVER_STATUS_AUTOCOMMIT)
+		c.conn.UnsetStatus(mysql.SERVER_STATUS_IN_TRANS)
+		return nil, nil
+	case strings.HasPrefix(upper, "CREATE TABLE") || strings.HasPrefix(upper, "ALTER ") || strings.HasPrefix(upper, "DROP "):
+		c.conn.UnsetStatus(mysql.SERVER_STATUS_IN_TRANS)
+		return nil, nil
+	case upper == "SELECT DEADLOCK":
+		c.conn.UnsetStatus(mysql.SERVER_STATUS_IN_TRANS)
+		return nil, mysql.NewError(mysql.ER_LOCK_DEADLOCK, "Deadlock found when trying to get lock; try restarting transaction")
+	case upper == "SELECT CRASH":
+		c.conn.Close()
+		return nil, nil
+	}
+	// 关闭自动提交时，第一条语句开启事务
+	if !c.conn.IsAutoCommit() && !strings.HasPrefix(upper, "SET ") {
+		c.conn.SetStatus(mysql.SERVER_STATUS_IN_TRANS)
+	}
+	if upper == "INSERT DUP" {
+		return nil, mysql.NewError(mysql.ER_DUP_ENTRY, "Duplicate entry '1' for key 'PRIMARY'")
+	}
+	return nil, nil
+}
+
+// executed 返回线程 thread 执行过的语句
+func (b *sessionBackend) executed(thread uint32) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var queries []string
+	for _, q := range b.queries {
+		if q.thread == thread {
+			queries = append(queries, q.query)
+		}
+	}
+	return queries
+}
+
+// received 报告后端是否收到过语句 query
+func (b *sessionBackend) received(query string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, q := range b.queries {
+		if q.query == query {
+			return true
+		}
+	}
+	return false
+}
+
+// killedBy 返回执行了 KILL QUERY thread 的线程，没有时返回 0
+func (b *sessionBackend) killedBy(thread uint32) uint32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, q := range b.queries {
+		if q.query == fmt.Sprintf("KILL QUERY %d", thread) {
+			return q.thread
+		}
+	}
+	return 0
+}
+
+// newTestPools 创建测试用的连接池
+func newTestPools(t *testing.T, maxSize int) *Pools {
+	t.Helper()
+	dialer, err := NewDialer(BackendTLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools := NewPools(PoolConfig{Enabled: true, MaxSize: maxSize, WaitTimeout: 100 * time.Millisecond}, dialer)
+	t.Cleanup(pools.Close)
+	return pools
+}
+
+// newTestHandler 创建连接到 addr 的会话，pools 为 nil 时独占后端连接
+func newTestHandler(t *testing.T, addr string, pools *Pools, timeouts *QueryTimeouts, pm *PluginManager) *Handler {
+	t.Helper()
+	dialer, err := NewDialer(BackendTLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(dialer, nil, nil, pools, nil, timeouts, nil, nil, pm)
+	t.Cleanup(h.Close)
+	if err := h.Connect(Session{ConnID: 1, User: "app"}, Backend{Addr: addr, User: "app", Database: "shop"}); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// errorCode 返回 MySQL 错误码，不是服务器错误时返回 0
+func errorCode(err error) uint16 {
+	var myErr *mysql.MyError
+	if errors.As(err, &myErr) {
+		return myErr.Code
+	}
+	return 0
+}
+
+func TestQueryTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		pooled     bool
+		killFails  bool // 旁路连接无法建立，KILL QUERY 改为关闭执行语句的连接
+		wantReused bool // 之后的语句是否复用同一个后端连接
+	}{
+		{name: "exclusive", wantReused: true},
+		{name: "pooled", pooled: true, wantReused: true},
+		{name: "pooled kill fails", pooled: true, killFails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := serveSessionBackend(t)
+			var pools *Pools
+			if tt.pooled {
+				pools = newTestPools(t, 2)
+			}
+			pm := NewPluginManager()
+			events := &collectPlugin{name: "collect"}
+			pm.Register(events)
+			h := newTestHandler(t, b.addr, pools, NewQueryTimeouts(QueryTimeoutConfig{Default: 50 * time.Millisecond}), pm)
+
+			b.refuse.Store(tt.killFails)
+			start := time.Now()
+			_, err := h.HandleQuery("SELECT SLEEP(10)")
+			b.refuse.Store(false)
+			if code := errorCode(err); code != erQueryTimeout {
+				t.Fatalf("error = %v, want code %d", err, erQueryTimeout)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("statement returned after %s", elapsed)
+			}
+			event := events.events[len(events.events)-1]
+			if event.Killed != KillTimeout || event.Timeout != 50*time.Millisecond {
+				t.Errorf("event killed %q timeout %s, want %q 50ms", event.Killed, event.Timeout, KillTimeout)
+			}
+			thread := event.BackendThreadID
+			if by := b.killedBy(thread); tt.killFails != (by == 0) || by == thread {
+				t.Errorf("KILL QUERY %d sent by thread %d", thread, by)
+			}
+
+			// 后端连接在终止后仍可用时继续使用，被关闭时换一个连接
+			if _, err := h.HandleQuery("SELECT 1"); err != nil {
+				t.Fatalf("next statement: %v", err)
+			}
+			next := events.events[len(events.events)-1].BackendThreadID
+			if reused := next == thread; reused != tt.wantReused {
+				t.Errorf("next statement on thread %d after %d, want reused %v", next, thread, tt.wantReused)
+			}
+		})
+	}
+}
+
+func TestKillOnDisconnect(t *testing.T) {
+	b := serveSessionBackend(t)
+	pm := NewPluginManager()
+	events := &collectPlugin{name: "collect"}
+	pm.Register(events)
+	h := newTestHandler(t, b.addr, nil, NewQueryTimeouts(QueryTimeoutConfig{KillOnDisconnect: true}), pm)
+
+	clientSide, serverSide := net.Pipe()
+	h.WatchClient(NewClientConn(serverSide))
+	time.AfterFunc(50*time.Millisecond, func() { clientSide.Close() })
+
+	_, err := h.HandleQuery("SELECT SLEEP(10)")
+	if code := errorCode(err); code != mysql.ER_QUERY_INTERRUPTED {
+		t.Errorf("error = %v, want the backend's interrupted error", err)
+	}
+	event := events.events[len(events.events)-1]
+	if event.Killed != KillDisconnect {
+		t.Errorf("event killed %q, want %q", event.Killed, KillDisconnect)
+	}
+	if b.killedBy(event.BackendThreadID) == 0 {
+		t.Errorf("no KILL QUERY for thread %d", event.BackendThreadID)
+	}
+}
+
+func TestQueryTimeoutLookup(t *testing.T) {
+	fingerprint := Fingerprint("SELECT * FROM orders WHERE id = 1")
+	timeouts := NewQueryTimeouts(QueryTimeoutConfig{
+		Default: time.Second,
+		Users:   map[string]time.Duration{"report": time.Minute},
+		Digests: map[string]time.Duration{Digest(fingerprint): 5 * time.Second, "select ?": 0},
+	})
+	tests := []struct {
+		user  string
+		query string
+		want  time.Duration
+	}{
+		{user: "app", query: "UPDATE t SET a = 1", want: time.Second},
+		{user: "report", query: "UPDATE t SET a = 1", want: time.Minute},
+		{user: "report", query: "SELECT * FROM orders WHERE id = 7", want: 5 * time.Second},
+		{user: "report", query: "SELECT 1", want: 0},
+	}
+	for _, tt := range tests {
+		event := &QueryEvent{User: tt.user, Fingerprint: Fingerprint(tt.query)}
+		event.Digest = strings.ToUpper(Digest(event.Fingerprint))
+		if got := timeouts.lookup(event); got != tt.want {
+			t.Errorf("%s %q: timeout %s, want %s", tt.user, tt.query, got, tt.want)
+		}
+	}
+	if NewQueryTimeouts(QueryTimeoutConfig{}) != nil {
+		t.Error("NewQueryTimeouts() without any setting is not nil")
+	}
+}
@@ -0,0 +1,231 @@
+package mysql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// 连接后端时的TLS模式
+const (
+	TLSModeDisabled   = "disabled"    // 不使用TLS
+	TLSModeSkipVerify = "skip-verify" // 使用TLS但不校验服务器证书
+	TLSModeVerifyCA   = "verify-ca"   // 校验证书链，不校验主机名
+	TLSModeVerifyFull = "verify-full" // 校验证书链和主机名
+)
+
+// ServerTLSConfig 代理对客户端提供TLS的配置
+type ServerTLSConfig struct {
+	Enabled          bool   `yaml:"enabled"`            // 是否向客户端提供TLS
+	CertFile         string `yaml:"cert_file"`          // 服务端证书
+	KeyFile          string `yaml:"key_file"`           // 服务端私钥
+	CAFile           string `yaml:"ca_file"`            // 校验客户端证书的CA
+	VerifyClientCert bool   `yaml:"verify_client_cert"` // 是否要求并校验客户端证书
+}
+
+// BackendTLSConfig 代理连接后端MySQL的TLS配置
+type BackendTLSConfig struct {
+	Mode       string `yaml:"mode"`        // disabled / skip-verify / verify-ca / verify-full
+	CAFile     string `yaml:"ca_file"`     // 校验服务器证书的CA，为空时使用系统CA
+	CertFile   string `yaml:"cert_file"`   // 客户端证书（可选）
+	KeyFile    string `yaml:"key_file"`    // 客户端私钥（可选）
+	ServerName string `yaml:"server_name"` // verify-full 校验的主机名，为空时使用后端地址中的主机
+}
+
+// NewServer 创建代理的MySQL服务端，启用TLS时向客户端提供TLS，并返回对客户端的TLS配置（未启用时为 nil）
+func NewServer(config ServerTLSConfig) (*server.Server, *tls.Config, error) {
+	if !config.Enabled {
+		return server.NewDefaultServer(), nil, nil
+	}
+
+	certPEM, err := os.ReadFile(config.CertFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if config.VerifyClientCert {
+		pool, err := loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	// 公钥用于 sha256_password / caching_sha2_password 的完整认证
+	pubKey, err := publicKeyPEM(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	return server.NewServer("8.0.11", mysql.DEFAULT_COLLATION_ID, mysql.AUTH_NATIVE_PASSWORD, pubKey, tlsConfig), tlsConfig, nil
+}
+
+// Dialer 建立到后端MySQL的连接，所有后端连接（包括连接池）都通过它建立
+type Dialer struct {
+	mode       string
+	serverName string
+	tlsConfig  *tls.Config
+}
+
+// NewDialer 根据后端TLS配置创建连接器
+func NewDialer(config BackendTLSConfig) (*Dialer, error) {
+	d := &Dialer{mode: config.Mode, serverName: config.ServerName}
+	if d.mode == "" {
+		d.mode = TLSModeDisabled
+	}
+
+	switch d.mode {
+	case TLSModeDisabled:
+		return d, nil
+	case TLSModeSkipVerify, TLSModeVerifyCA, TLSModeVerifyFull:
+	default:
+		return nil, fmt.Errorf("unknown backend tls mode: %s", d.mode)
+	}
+
+	d.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		d.tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if config.CAFile != "" {
+		pool, err := loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		d.tlsConfig.RootCAs = pool
+	}
+
+	switch d.mode {
+	case TLSModeSkipVerify:
+		d.tlsConfig.InsecureSkipVerify = true
+	case TLSModeVerifyCA:
+		// 跳过标准校验（含主机名），改为只校验证书链
+		d.tlsConfig.InsecureSkipVerify = true
+		d.tlsConfig.VerifyPeerCertificate = verifyChain(d.tlsConfig.RootCAs)
+	}
+	return d, nil
+}
+
+// Dial 建立到 addr 的连接
+func (d *Dialer) Dial(addr, user, password, db string) (*backendConn, error) {
+	var options []client.Option
+	tlsConfig, err := d.TLSConfig(addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		options = append(options, func(c *client.Conn) error {
+			c.SetTLSConfig(tlsConfig)
+			return nil
+		})
+	}
+
+	conn, err := client.Connect(addr, user, password, db, options...)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &backendConn{
+		Conn:      conn,
+		addr:      addr,
+		createdAt: now,
+		lastUsed:  now,
+		stmts:     make(map[string]*client.Stmt),
+	}, nil
+}
+
+// TLSConfig 返回连接 addr 使用的TLS配置，未启用后端TLS时返回 nil
+func (d *Dialer) TLSConfig(addr string) (*tls.Config, error) {
+	if d.tlsConfig == nil {
+		return nil, nil
+	}
+	tlsConfig := d.tlsConfig.Clone()
+	if d.mode == TLSModeVerifyFull {
+		tlsConfig.ServerName = d.serverName
+		if tlsConfig.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.ServerName = host
+		}
+	}
+	return tlsConfig, nil
+}
+
+// verifyChain 返回只校验证书链、不校验主机名的校验函数
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("backend tls: no server certificate")
+		}
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+}
+
+// loadCertPool 从PEM文件加载CA证书
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// publicKeyPEM 导出证书公钥的PEM编码
+func publicKeyPEM(cert tls.Certificate) ([]byte, error) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
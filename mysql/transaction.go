@@ -0,0 +1,274 @@
+package mysql
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// 事务结束的方式
+const (
+	TxCommit         = "commit"          // COMMIT
+	TxRollback       = "rollback"        // ROLLBACK
+	TxImplicitCommit = "implicit_commit" // DDL、BEGIN、SET autocommit=1 等语句隐式提交
+	TxAborted        = "aborted"         // 语句出错后服务器回滚了事务（如死锁）或后端连接断开
+	TxDisconnect     = "disconnect"      // 客户端断开时事务仍未结束，由服务器回滚
+	TxChangeUser     = "change_user"     // COM_CHANGE_USER 时事务仍未结束，由服务器回滚
+)
+
+// TransactionConfig 事务监控配置
+type TransactionConfig struct {
+	LongThreshold time.Duration `yaml:"long_threshold"` // 事务持续超过该时长时产生 long_transaction 事件（0表示不检查）
+	IdleThreshold time.Duration `yaml:"idle_threshold"` // 事务中空闲超过该时长时产生 idle_transaction 事件（0表示不检查）
+}
+
+// txState 进行中的事务，监控协程与会话协程共享
+type txState struct {
+	id      uint64
+	session Session
+	backend string
+	start   time.Time
+
+	mu           sync.Mutex
+	database     string
+	lastQuery    string
+	lastActive   time.Time
+	statements   int
+	rowsAffected uint64
+	flaggedLong  bool // 已产生 long_transaction 事件
+	flaggedIdle  bool // 本次空闲已产生 idle_transaction 事件，有新语句时重置
+}
+
+// record 记录事务中执行的一条语句
+func (tx *txState) record(event *QueryEvent, result *mysql.Result) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	tx.database = event.Database
+	tx.lastQuery = event.Query
+	tx.lastActive = time.Now()
+	tx.flaggedIdle = false
+	tx.statements++
+	if result != nil && result.Resultset == nil {
+		tx.rowsAffected += result.AffectedRows
+	}
+}
+
+// event 生成事务相关的事件，Query 为事务中最近执行的语句
+func (tx *txState) event(eventType string, duration time.Duration) *QueryEvent {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	return &QueryEvent{
+		Type:         eventType,
+		Query:        tx.lastQuery,
+		Database:     tx.database,
+		Backend:      tx.backend,
+		User:         tx.session.User,
+		Timestamp:    time.Now(),
+		Duration:     duration,
+		RowCount:     int(tx.rowsAffected),
+		ConnID:       tx.session.ConnID,
+		ClientAddr:   tx.session.ClientAddr,
+		ConnectAttrs: tx.session.Attributes,
+		SessionStart: tx.session.StartTime,
+		TxID:         tx.id,
+		TxStatements: tx.statements,
+	}
+}
+
+// TxMonitor 检查所有会话中进行中的事务，标记长事务和事务中空闲的会话
+type TxMonitor struct {
+	config        TransactionConfig
+	pluginManager *PluginManager
+
+	mu     sync.Mutex
+	active map[*txState]struct{}
+	done   chan struct{}
+}
+
+// NewTxMonitor 创建事务监控，两个阈值都未配置时返回 nil
+func NewTxMonitor(config TransactionConfig, pm *PluginManager) *TxMonitor {
+	if config.LongThreshold <= 0 && config.IdleThreshold <= 0 {
+		return nil
+	}
+	m := &TxMonitor{
+		config:        config,
+		pluginManager: pm,
+		active:        make(map[*txState]struct{}),
+		done:          make(chan struct{}),
+	}
+	go m.loop()
+	return m
+}
+
+func (m *TxMonitor) add(tx *txState) {
+	m.mu.Lock()
+	m.active[tx] = struct{}{}
+	m.mu.Unlock()
+}
+
+func (m *TxMonitor) remove(tx *txState) {
+	m.mu.Lock()
+	delete(m.active, tx)
+	m.mu.Unlock()
+}
+
+// loop 按最小阈值的一半定期检查
+func (m *TxMonitor) loop() {
+	interval := m.config.LongThreshold
+	if interval <= 0 || (m.config.IdleThreshold > 0 && m.config.IdleThreshold < interval) {
+		interval = m.config.IdleThreshold
+	}
+	interval /= 2
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.check()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *TxMonitor) check() {
+	m.mu.Lock()
+	active := make([]*txState, 0, len(m.active))
+	for tx := range m.active {
+		active = append(active, tx)
+	}
+	m.mu.Unlock()
+
+	now := time.Now()
+	for _, tx := range active {
+		var events []*QueryEvent
+
+		tx.mu.Lock()
+		open, idle := now.Sub(tx.start), now.Sub(tx.lastActive)
+		flagLong := m.config.LongThreshold > 0 && !tx.flaggedLong && open >= m.config.LongThreshold
+		flagIdle := m.config.IdleThreshold > 0 && !tx.flaggedIdle && idle >= m.config.IdleThreshold
+		tx.flaggedLong = tx.flaggedLong || flagLong
+		tx.flaggedIdle = tx.flaggedIdle || flagIdle
+		tx.mu.Unlock()
+
+		if flagLong {
+			events = append(events, tx.event("long_transaction", open))
+		}
+		if flagIdle {
+			events = append(events, tx.event("idle_transaction", idle))
+		}
+		for _, event := range events {
+			m.pluginManager.OnQuery(event)
+			m.pluginManager.OnQueryComplete(event, nil, nil)
+		}
+	}
+}
+
+// Close 停止监控
+func (m *TxMonitor) Close() {
+	close(m.done)
+}
+
+// currentTxID 返回当前事务ID，不在事务中时为 0
+func (h *Handler) currentTxID() uint64 {
+	if h.tx == nil {
+		return 0
+	}
+	return h.tx.id
+}
+
+// trackTransaction 语句执行后根据后端连接的事务状态维护事务边界，并为事件标记事务ID
+// 事务结束时返回事务摘要事件，由调用方在语句事件之后发出
+func (h *Handler) trackTransaction(event *QueryEvent, result *mysql.Result, err error) *QueryEvent {
+	inTx := h.primary != nil && h.primary.IsInTransaction() && !rolledBack(err)
+	rest, _ := skipComments(event.Query)
+	first := strings.ToUpper(firstWord(rest))
+	begins := first == "BEGIN" || (first == "START" && inTx)
+
+	var summary *QueryEvent
+	if h.tx != nil && (!inTx || begins) {
+		// 当前语句结束了事务：COMMIT/ROLLBACK、隐式提交，或 BEGIN 提交了上一个事务后开启新事务
+		outcome := TxImplicitCommit
+		switch {
+		case begins:
+		case err != nil:
+			outcome = TxAborted
+		case first == "COMMIT":
+			outcome = TxCommit
+		case first == "ROLLBACK", event.Type == "reset_connection":
+			outcome = TxRollback
+		}
+		if !begins {
+			h.tx.record(event, result)
+			event.TxID = h.tx.id
+		}
+		summary = h.endTransaction(outcome)
+		if !begins {
+			return summary
+		}
+	}
+
+	if h.tx == nil && inTx {
+		h.beginTransaction(event)
+	}
+	if h.tx != nil {
+		h.tx.record(event, result)
+		event.TxID = h.tx.id
+	}
+	return summary
+}
+
+// rolledBack 判断语句错误是否说明服务器已回滚了整个事务。ERR 包不带状态标志，
+// 连接上的事务状态还是语句之前的，死锁时只能按错误码判断
+func rolledBack(err error) bool {
+	var myErr *mysql.MyError
+	return errors.As(err, &myErr) && myErr.Code == mysql.ER_LOCK_DEADLOCK
+}
+
+// beginTransaction 开始跟踪一个新事务，开始时间为开启事务的语句的开始时间
+func (h *Handler) beginTransaction(event *QueryEvent) {
+	h.txSeq++
+	h.tx = &txState{
+		id:         h.txSeq,
+		session:    h.session,
+		backend:    h.target,
+		start:      event.Timestamp,
+		lastActive: event.Timestamp,
+	}
+	if h.txMonitor != nil {
+		h.txMonitor.add(h.tx)
+	}
+}
+
+// endTransaction 结束当前事务，返回事务摘要事件
+func (h *Handler) endTransaction(outcome string) *QueryEvent {
+	tx := h.tx
+	h.tx = nil
+	if h.txMonitor != nil {
+		h.txMonitor.remove(tx)
+	}
+
+	h.seq++
+	summary := tx.event("transaction", time.Since(tx.start))
+	summary.Seq = h.seq
+	summary.TxOutcome = outcome
+	return summary
+}
+
+// emitTransaction 发出事务摘要事件
+func (h *Handler) emitTransaction(summary *QueryEvent) {
+	if summary == nil {
+		return
+	}
+	h.pluginManager.OnQuery(summary)
+	h.pluginManager.OnQueryComplete(summary, nil, nil)
+}
@@ -0,0 +1,111 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTransactionBoundaries(t *testing.T) {
+	tests := []struct {
+		name     string
+		pooled   bool
+		queries  []string
+		close    bool     // 执行完语句后客户端断开
+		wantTx   []uint64 // 每条语句事件的 TxID
+		wantDone []string // 依次结束的事务：结束方式/语句数
+	}{
+		{
+			name:     "begin commit",
+			queries:  []string{"BEGIN", "INSERT INTO t VALUES (1)", "COMMIT", "SELECT * FROM t"},
+			wantTx:   []uint64{1, 1, 1, 0},
+			wantDone: []string{"commit/3"},
+		},
+		{
+			name:     "start transaction rollback",
+			queries:  []string{"START TRANSACTION", "UPDATE t SET a = 1", "ROLLBACK"},
+			wantTx:   []uint64{1, 1, 1},
+			wantDone: []string{"rollback/3"},
+		},
+		{
+			name:     "begin commits the open transaction",
+			queries:  []string{"BEGIN", "INSERT INTO t VALUES (1)", "/* again */ BEGIN", "COMMIT"},
+			wantTx:   []uint64{1, 1, 2, 2},
+			wantDone: []string{"implicit_commit/2", "commit/2"},
+		},
+		{
+			name:     "ddl commits implicitly",
+			queries:  []string{"BEGIN", "INSERT INTO t VALUES (1)", "CREATE TABLE t2 (id INT)", "SELECT * FROM t"},
+			wantTx:   []uint64{1, 1, 1, 0},
+			wantDone: []string{"implicit_commit/3"},
+		},
+		{
+			name:     "autocommit off",
+			queries:  []string{"SET autocommit = 0", "SELECT * FROM t", "UPDATE t SET a = 1", "COMMIT", "SELECT * FROM t", "SET autocommit = 1", "SELECT * FROM t"},
+			wantTx:   []uint64{0, 1, 1, 1, 2, 2, 0},
+			wantDone: []string{"commit/3", "implicit_commit/2"},
+		},
+		{
+			name:     "error keeps the transaction open",
+			queries:  []string{"BEGIN", "INSERT dup", "COMMIT"},
+			wantTx:   []uint64{1, 1, 1},
+			wantDone: []string{"commit/3"},
+		},
+		{
+			name:     "deadlock rolls back",
+			queries:  []string{"BEGIN", "UPDATE t SET a = 1", "SELECT deadlock", "SELECT * FROM t"},
+			wantTx:   []uint64{1, 1, 1, 0},
+			wantDone: []string{"aborted/3"},
+		},
+		{
+			name:     "backend connection lost",
+			pooled:   true,
+			queries:  []string{"BEGIN", "SELECT crash", "SELECT * FROM t"},
+			wantTx:   []uint64{1, 1, 0},
+			wantDone: []string{"aborted/2"},
+		},
+		{
+			name:     "client disconnects",
+			queries:  []string{"BEGIN", "INSERT INTO t VALUES (1)"},
+			close:    true,
+			wantTx:   []uint64{1, 1},
+			wantDone: []string{"disconnect/2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := serveSessionBackend(t)
+			var pools *Pools
+			if tt.pooled {
+				pools = newTestPools(t, 2)
+			}
+			pm := NewPluginManager()
+			events := &collectPlugin{name: "collect"}
+			pm.Register(events)
+			h := newTestHandler(t, b.addr, pools, nil, pm)
+			for _, query := range tt.queries {
+				h.HandleQuery(query)
+			}
+			if tt.close {
+				h.Close()
+			}
+
+			var txIDs []uint64
+			var done []string
+			for _, e := range events.events {
+				switch e.Type {
+				case "query":
+					txIDs = append(txIDs, e.TxID)
+				case "transaction":
+					done = append(done, fmt.Sprintf("%s/%d", e.TxOutcome, e.TxStatements))
+				}
+			}
+			if fmt.Sprint(txIDs) != fmt.Sprint(tt.wantTx) {
+				t.Errorf("TxID = %v, want %v", txIDs, tt.wantTx)
+			}
+			if strings.Join(done, ",") != strings.Join(tt.wantDone, ",") {
+				t.Errorf("transactions = %v, want %v", done, tt.wantDone)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package redisproxy
+
+import (
+	"bufio"
+	"hash/fnv"
+	"strings"
+)
+
+// errorPrefixes 记录为命令错误的响应前缀
+var errorPrefixes = []string{"ERR", "WRONGTYPE", "NOAUTH", "NOPERM", "WRONGPASS"}
+
+// readOnlyCommands 不修改数据的命令，以及只影响当前连接的命令
+var readOnlyCommands = map[string]bool{
+	// 连接
+	"PING": true, "ECHO": true, "SELECT": true, "AUTH": true, "HELLO": true,
+	// 通用
+	"EXISTS": true, "TYPE": true, "TTL": true, "PTTL": true, "EXPIRETIME": true, "PEXPIRETIME": true,
+	"KEYS": true, "SCAN": true, "RANDOMKEY": true, "DBSIZE": true, "DUMP": true, "OBJECT": true,
+	"TOUCH": true, "SORT_RO": true, "TIME": true, "INFO": true, "LASTSAVE": true,
+	// 字符串
+	"GET": true, "MGET": true, "STRLEN": true, "GETRANGE": true, "SUBSTR": true, "LCS": true,
+	"GETBIT": true, "BITCOUNT": true, "BITPOS": true, "BITFIELD_RO": true,
+	// 哈希
+	"HGET": true, "HMGET": true, "HGETALL": true, "HKEYS": true, "HVALS": true, "HLEN": true,
+	"HEXISTS": true, "HSTRLEN": true, "HSCAN": true, "HRANDFIELD": true,
+	// 列表
+	"LRANGE": true, "LLEN": true, "LINDEX": true, "LPOS": true,
+	// 集合
+	"SMEMBERS": true, "SISMEMBER": true, "SMISMEMBER": true, "SCARD": true, "SRANDMEMBER": true,
+	"SSCAN": true, "SINTER": true, "SINTERCARD": true, "SUNION": true, "SDIFF": true,
+	// 有序集合
+	"ZRANGE": true, "ZRANGEBYSCORE": true, "ZRANGEBYLEX": true, "ZREVRANGE": true,
+	"ZREVRANGEBYSCORE": true, "ZREVRANGEBYLEX": true, "ZSCORE": true, "ZMSCORE": true,
+	"ZRANK": true, "ZREVRANK": true, "ZCARD": true, "ZCOUNT": true, "ZLEXCOUNT": true,
+	"ZSCAN": true, "ZRANDMEMBER": true, "ZINTER": true, "ZINTERCARD": true, "ZUNION": true, "ZDIFF": true,
+	// HyperLogLog、地理位置、流
+	"PFCOUNT": true, "GEOPOS": true, "GEODIST": true, "GEOHASH": true, "GEOSEARCH": true,
+	"GEORADIUS_RO": true, "GEORADIUSBYMEMBER_RO": true,
+	"XRANGE": true, "XREVRANGE": true, "XLEN": true, "XINFO": true, "XPENDING": true,
+	// 只读脚本
+	"EVAL_RO": true, "EVALSHA_RO": true, "FCALL_RO": true,
+}
+
+// redactAuth 返回隐去认证信息的参数：AUTH 的所有参数和 HELLO 的 AUTH 选项中的用户名、密码替换为 ***，
+// 没有认证信息时返回原参数和 false。审计日志和流量录制都通过它隐去密码
+func redactAuth(command string, args []string) ([]string, bool) {
+	switch command {
+	case "AUTH":
+		redacted := make([]string, len(args))
+		for i := range redacted {
+			redacted[i] = "***"
+		}
+		return redacted, true
+	case "HELLO":
+		// HELLO [protover [AUTH username password] [SETNAME clientname]]
+		var redacted []string
+		for i := 0; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "AUTH":
+				if redacted == nil {
+					redacted = append([]string(nil), args...)
+				}
+				for j := i + 1; j <= i+2 && j < len(args); j++ {
+					redacted[j] = "***"
+				}
+				i += 2
+			case "SETNAME":
+				i++
+			}
+		}
+		if redacted != nil {
+			return redacted, true
+		}
+	}
+	return args, false
+}
+
+// IsReadOnlyCommand 判断命令（大写）是否只读
+func IsReadOnlyCommand(command string) bool {
+	return readOnlyCommands[command]
+}
+
+// ReadResponse 读取一个完整的 RESP2/RESP3 响应，返回摘要和原始字节
+func ReadResponse(reader *bufio.Reader) (summary string, raw []byte, err error) {
+	var h Handler
+	return h.readResponse(reader)
+}
+
+// IsErrorResponse 判断响应摘要是否为命令错误
+func IsErrorResponse(summary string) bool {
+	for _, prefix := range errorPrefixes {
+		if strings.HasPrefix(summary, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseDigest 计算响应原始字节的摘要，0 表示没有响应
+func ResponseDigest(raw []byte) uint64 {
+	if len(raw) == 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write(raw)
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
@@ -0,0 +1,35 @@
+package redisproxy
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// countingConn 统计读写字节数的客户端连接
+type countingConn struct {
+	net.Conn
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesOut.Add(int64(n))
+	return n, err
+}
+
+// BytesIn 返回从客户端读取的字节数
+func (c *countingConn) BytesIn() int64 {
+	return c.bytesIn.Load()
+}
+
+// BytesOut 返回发送给客户端的字节数
+func (c *countingConn) BytesOut() int64 {
+	return c.bytesOut.Load()
+}
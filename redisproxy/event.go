@@ -11,5 +11,34 @@ type CommandEvent struct {
 	Duration  time.Duration `json:"duration"`  // 执行耗时
 	Error     string        `json:"error"`     // 错误信息（如果有）
 	Response  string        `json:"response"`  // 响应摘要
+
+	// 连接
+	ConnID     uint32 `json:"conn_id"`     // 代理分配的连接ID
+	ClientAddr string `json:"client_addr"` // 客户端地址
+	User       string `json:"user"`        // AUTH 使用的用户名（未认证时为空）
+	DB         int    `json:"db"`          // 当前数据库编号（SELECT 切换，默认0）
+
+	// 故障注入（由 FaultPlugin 填充）
+	Fault     string `json:"fault,omitempty"`      // 注入的故障类型：latency / error / drop / truncate
+	FaultRule string `json:"fault_rule,omitempty"` // 命中的规则名
+
+	// 拦截信息
+	InterceptedBy string `json:"intercepted_by,omitempty"` // 拦截该命令的插件
+
+	rawResponse []byte // 完整的原始响应，写回客户端；供录制插件计算摘要，故障注入插件截断
 }
 
+// ConnEvent 连接生命周期事件
+type ConnEvent struct {
+	Type       string        `json:"type"`        // 事件类型: connect, auth_failure, dial_failure, disconnect
+	ConnID     uint32        `json:"conn_id"`     // 代理分配的连接ID
+	ClientAddr string        `json:"client_addr"` // 客户端地址
+	User       string        `json:"user"`        // AUTH 使用的用户名（未认证时为空）
+	Backend    string        `json:"backend"`     // 后端地址
+	Timestamp  time.Time     `json:"timestamp"`   // 时间戳
+	Duration   time.Duration `json:"duration"`    // 连接持续时长（disconnect）
+	BytesIn    int64         `json:"bytes_in"`    // 从客户端读取的字节数
+	BytesOut   int64         `json:"bytes_out"`   // 发送给客户端的字节数
+	Commands   uint64        `json:"commands"`    // 处理的命令数
+	Error      string        `json:"error"`       // 错误信息（如果有）
+}
@@ -2,19 +2,38 @@ package redisproxy
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/if-nil/proxyx/fault"
 )
 
+// errFaultDrop 注入 drop 故障时断开连接的原因
+var errFaultDrop = errors.New("connection dropped by fault injection")
+
 // Handler Redis代理处理器
 type Handler struct {
 	targetAddr    string
 	pluginManager *PluginManager
+	nextConnID    atomic.Uint32 // 连接ID计数器
+}
+
+// connSession 单个客户端连接的统计信息，用于生成连接事件
+type connSession struct {
+	id        uint32
+	conn      *countingConn
+	user      string // AUTH 成功后的用户名
+	db        int    // SELECT 成功后的数据库编号
+	startTime time.Time
+	commands  uint64
 }
 
 // NewHandler 创建Redis代理处理器
@@ -26,17 +45,33 @@ func NewHandler(targetAddr string, pm *PluginManager) *Handler {
 }
 
 // HandleConnection 处理客户端连接
-func (h *Handler) HandleConnection(clientConn net.Conn) {
-	defer clientConn.Close()
+func (h *Handler) HandleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	session := &connSession{
+		id:        h.nextConnID.Add(1),
+		conn:      &countingConn{Conn: conn},
+		startTime: time.Now(),
+	}
+	clientConn := session.conn
 
 	// 连接到真正的Redis服务器
 	serverConn, err := net.Dial("tcp", h.targetAddr)
 	if err != nil {
 		log.Printf("[Redis Proxy] Failed to connect to Redis server: %v", err)
+		h.pluginManager.OnDialFailure(h.connEvent(session, "dial_failure", err))
 		return
 	}
 	defer serverConn.Close()
 
+	h.pluginManager.OnConnect(h.connEvent(session, "connect", nil))
+	var closeErr error
+	defer func() {
+		event := h.connEvent(session, "disconnect", closeErr)
+		event.Duration = time.Since(session.startTime)
+		h.pluginManager.OnDisconnect(event)
+	}()
+
 	// 创建带缓冲的读取器
 	clientReader := bufio.NewReader(clientConn)
 	serverReader := bufio.NewReader(serverConn)
@@ -47,6 +82,7 @@ func (h *Handler) HandleConnection(clientConn net.Conn) {
 		if err != nil {
 			if err != io.EOF {
 				log.Printf("[Redis Proxy] Read command error: %v", err)
+				closeErr = err
 			}
 			return
 		}
@@ -56,56 +92,149 @@ func (h *Handler) HandleConnection(clientConn net.Conn) {
 			args = []string{}
 		}
 		event := &CommandEvent{
-			Command:   command,
-			Args:      args,
-			Raw:       raw,
-			Timestamp: time.Now(),
+			Command:    command,
+			Args:       args,
+			Raw:        raw,
+			Timestamp:  time.Now(),
+			ConnID:     session.id,
+			ClientAddr: session.conn.RemoteAddr().String(),
+			User:       session.user,
+			DB:         session.db,
 		}
 
-		// 触发命令前事件
-		h.pluginManager.OnCommand(event)
+		session.commands++
 
 		startTime := time.Now()
 
-		// 转发命令到Redis服务器
-		_, err = serverConn.Write([]byte(raw))
-		if err != nil {
-			log.Printf("[Redis Proxy] Write to server error: %v", err)
-			event.Error = err.Error()
-			event.Duration = time.Since(startTime)
-			h.pluginManager.OnCommandComplete(event)
-			return
-		}
+		// 拦截器返回响应时不转发命令
+		intercepted := h.pluginManager.Intercept(event)
 
-		// 读取并转发响应
-		response, respRaw, err := h.readResponse(serverReader)
-		if err != nil {
-			log.Printf("[Redis Proxy] Read response error: %v", err)
-			event.Error = err.Error()
-			event.Duration = time.Since(startTime)
-			h.pluginManager.OnCommandComplete(event)
-			return
+		// 触发命令前事件
+		h.pluginManager.OnCommand(event)
+
+		var response string
+		var respRaw []byte
+		if intercepted != nil {
+			response, respRaw, err = ReadResponse(bufio.NewReader(bytes.NewReader(intercepted)))
+			if err != nil {
+				response, respRaw = "ERR proxyx: invalid intercepted response", []byte("-ERR proxyx: invalid intercepted response\r\n")
+			}
+		} else {
+			// 转发命令到Redis服务器
+			_, err = serverConn.Write([]byte(raw))
+			if err != nil {
+				log.Printf("[Redis Proxy] Write to server error: %v", err)
+				closeErr = err
+				event.Error = err.Error()
+				event.Duration = time.Since(startTime)
+				h.pluginManager.OnCommandComplete(event)
+				return
+			}
+
+			// 读取响应
+			response, respRaw, err = h.readResponse(serverReader)
+			if err != nil {
+				log.Printf("[Redis Proxy] Read response error: %v", err)
+				closeErr = err
+				event.Error = err.Error()
+				event.Duration = time.Since(startTime)
+				h.pluginManager.OnCommandComplete(event)
+				return
+			}
 		}
 
 		event.Duration = time.Since(startTime)
 		event.Response = response
+		event.rawResponse = respRaw
 
 		// 检查响应是否是错误
-		if strings.HasPrefix(response, "ERR") || strings.HasPrefix(response, "WRONGTYPE") ||
-			strings.HasPrefix(response, "NOAUTH") || strings.HasPrefix(response, "NOPERM") {
+		if IsErrorResponse(response) {
 			event.Error = response
 		}
+		h.trackAuth(session, event)
+		trackDB(session, event)
 
 		// 触发命令完成事件
 		h.pluginManager.OnCommandComplete(event)
 
+		// 注入 drop 故障时只转发一半响应后断开客户端连接
+		if event.Fault == fault.Drop {
+			clientConn.Write(event.rawResponse[:len(event.rawResponse)/2])
+			closeErr = errFaultDrop
+			return
+		}
+
 		// 转发响应到客户端
-		_, err = clientConn.Write(respRaw)
+		_, err = clientConn.Write(event.rawResponse)
 		if err != nil {
 			log.Printf("[Redis Proxy] Write to client error: %v", err)
+			closeErr = err
+			return
+		}
+	}
+}
+
+// trackAuth 记录 AUTH / HELLO ... AUTH 的结果，失败时触发 OnAuthFailure
+func (h *Handler) trackAuth(session *connSession, event *CommandEvent) {
+	var user string
+	switch {
+	case event.Command == "AUTH" && len(event.Args) == 1:
+		user = "default"
+	case event.Command == "AUTH" && len(event.Args) >= 2:
+		user = event.Args[0]
+	case event.Command == "HELLO":
+		for i, arg := range event.Args {
+			if strings.EqualFold(arg, "AUTH") && i+1 < len(event.Args) {
+				user = event.Args[i+1]
+			}
+		}
+		if user == "" {
 			return
 		}
+	default:
+		return
+	}
+
+	if event.Error == "" {
+		session.user = user
+		return
+	}
+	authEvent := h.connEvent(session, "auth_failure", nil)
+	authEvent.User = user
+	authEvent.Error = event.Error
+	h.pluginManager.OnAuthFailure(authEvent)
+}
+
+// trackDB 记录 SELECT 切换的数据库，RESET 后回到 0
+// 事务中的 SELECT 响应为 QUEUED，不记录，EXEC 之后的命令仍按切换前的数据库匹配
+func trackDB(session *connSession, event *CommandEvent) {
+	switch {
+	case event.Command == "SELECT" && len(event.Args) == 1 && event.Response == "OK":
+		if db, err := strconv.Atoi(event.Args[0]); err == nil {
+			session.db = db
+		}
+	case event.Command == "RESET" && event.Error == "":
+		session.db = 0
+	}
+}
+
+// connEvent 创建连接事件
+func (h *Handler) connEvent(session *connSession, eventType string, err error) *ConnEvent {
+	event := &ConnEvent{
+		Type:       eventType,
+		ConnID:     session.id,
+		ClientAddr: session.conn.RemoteAddr().String(),
+		User:       session.user,
+		Backend:    h.targetAddr,
+		Timestamp:  time.Now(),
+		BytesIn:    session.conn.BytesIn(),
+		BytesOut:   session.conn.BytesOut(),
+		Commands:   session.commands,
+	}
+	if err != nil {
+		event.Error = err.Error()
 	}
+	return event
 }
 
 // readCommand 读取RESP协议命令
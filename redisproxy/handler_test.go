@@ -0,0 +1,30 @@
+package redisproxy
+
+import "testing"
+
+func TestTrackDB(t *testing.T) {
+	tests := []struct {
+		command  string
+		args     []string
+		response string
+		want     int
+	}{
+		{command: "SELECT", args: []string{"3"}, response: "OK", want: 3},
+		{command: "GET", args: []string{"k"}, response: "v", want: 3},
+		{command: "SELECT", args: []string{"99"}, response: "ERR DB index is out of range", want: 3},
+		{command: "SELECT", args: []string{"5"}, response: "QUEUED", want: 3}, // 事务中，EXEC 前不生效
+		{command: "SELECT", args: []string{"1"}, response: "OK", want: 1},
+		{command: "RESET", response: "RESET", want: 0},
+	}
+	session := &connSession{}
+	for _, tt := range tests {
+		event := &CommandEvent{Command: tt.command, Args: tt.args, Response: tt.response}
+		if IsErrorResponse(tt.response) {
+			event.Error = tt.response
+		}
+		trackDB(session, event)
+		if session.db != tt.want {
+			t.Errorf("after %s %v -> %s: db = %d, want %d", tt.command, tt.args, tt.response, session.db, tt.want)
+		}
+	}
+}
@@ -17,9 +17,33 @@ type Plugin interface {
 	Close() error
 }
 
+// Interceptor 拦截器接口，插件可选实现
+// 在命令转发到Redis服务器之前按注册顺序调用，返回非nil的完整RESP响应（如 "-ERR ...\r\n"）
+// 则直接用该响应回复客户端，不再转发
+type Interceptor interface {
+	Intercept(event *CommandEvent) []byte
+}
+
+// ConnectionPlugin 连接生命周期接口，插件可选实现
+type ConnectionPlugin interface {
+	// OnConnect 客户端连接并成功连接后端后调用
+	OnConnect(event *ConnEvent)
+
+	// OnAuthFailure AUTH 命令失败时调用
+	OnAuthFailure(event *ConnEvent)
+
+	// OnDialFailure 连接后端失败时调用
+	OnDialFailure(event *ConnEvent)
+
+	// OnDisconnect 客户端连接关闭时调用
+	OnDisconnect(event *ConnEvent)
+}
+
 // PluginManager Redis插件管理器
 type PluginManager struct {
-	plugins []Plugin
+	plugins      []Plugin
+	interceptors []Plugin // 同时实现了 Interceptor 的插件
+	connPlugins  []Plugin // 同时实现了 ConnectionPlugin 的插件
 }
 
 // NewPluginManager 创建Redis插件管理器
@@ -32,6 +56,12 @@ func NewPluginManager() *PluginManager {
 // Register 注册插件
 func (pm *PluginManager) Register(p Plugin) {
 	pm.plugins = append(pm.plugins, p)
+	if _, ok := p.(Interceptor); ok {
+		pm.interceptors = append(pm.interceptors, p)
+	}
+	if _, ok := p.(ConnectionPlugin); ok {
+		pm.connPlugins = append(pm.connPlugins, p)
+	}
 	log.Printf("[Redis PluginManager] Registered plugin: %s", p.Name())
 }
 
@@ -42,6 +72,17 @@ func (pm *PluginManager) OnCommand(event *CommandEvent) {
 	}
 }
 
+// Intercept 依次调用拦截器，第一个返回响应的拦截器将终止命令的转发
+func (pm *PluginManager) Intercept(event *CommandEvent) []byte {
+	for _, p := range pm.interceptors {
+		if response := p.(Interceptor).Intercept(event); response != nil {
+			event.InterceptedBy = p.Name()
+			return response
+		}
+	}
+	return nil
+}
+
 // OnCommandComplete 触发所有插件的 OnCommandComplete
 func (pm *PluginManager) OnCommandComplete(event *CommandEvent) {
 	for _, p := range pm.plugins {
@@ -49,6 +90,34 @@ func (pm *PluginManager) OnCommandComplete(event *CommandEvent) {
 	}
 }
 
+// OnConnect 触发所有插件的 OnConnect
+func (pm *PluginManager) OnConnect(event *ConnEvent) {
+	for _, p := range pm.connPlugins {
+		p.(ConnectionPlugin).OnConnect(event)
+	}
+}
+
+// OnAuthFailure 触发所有插件的 OnAuthFailure
+func (pm *PluginManager) OnAuthFailure(event *ConnEvent) {
+	for _, p := range pm.connPlugins {
+		p.(ConnectionPlugin).OnAuthFailure(event)
+	}
+}
+
+// OnDialFailure 触发所有插件的 OnDialFailure
+func (pm *PluginManager) OnDialFailure(event *ConnEvent) {
+	for _, p := range pm.connPlugins {
+		p.(ConnectionPlugin).OnDialFailure(event)
+	}
+}
+
+// OnDisconnect 触发所有插件的 OnDisconnect
+func (pm *PluginManager) OnDisconnect(event *ConnEvent) {
+	for _, p := range pm.connPlugins {
+		p.(ConnectionPlugin).OnDisconnect(event)
+	}
+}
+
 // Close 关闭所有插件
 func (pm *PluginManager) Close() error {
 	for _, p := range pm.plugins {
@@ -0,0 +1,73 @@
+package redisproxy
+
+import (
+	"log"
+
+	"github.com/if-nil/proxyx/audit"
+	"github.com/if-nil/proxyx/rotate"
+)
+
+// AuditPluginConfig 审计日志插件配置
+type AuditPluginConfig struct {
+	Enabled       bool   `yaml:"enabled"`  // 是否启用
+	HMACKey       string `yaml:"hmac_key"` // 哈希链的 HMAC 密钥，为空时使用 SHA-256；校验时需要相同的密钥
+	rotate.Config `yaml:",inline"`
+}
+
+// AuditPlugin 审计日志插件 - 将命令和连接事件以 JSON Lines 写入本地文件，
+// 每行带有链接上一行的哈希，删改可以通过 proxyx verify-audit 发现
+type AuditPlugin struct {
+	writer *audit.Writer
+}
+
+// NewAuditPlugin 创建审计日志插件
+func NewAuditPlugin(config AuditPluginConfig) (*AuditPlugin, error) {
+	writer, err := audit.NewWriter(config.Config, []byte(config.HMACKey))
+	if err != nil {
+		return nil, err
+	}
+	return &AuditPlugin{writer: writer}, nil
+}
+
+func (p *AuditPlugin) Name() string {
+	return "RedisAuditPlugin"
+}
+
+func (p *AuditPlugin) OnCommand(event *CommandEvent) {}
+
+// OnCommandComplete 认证命令的参数含有密码，写入时隐去用户名、密码和原始命令
+func (p *AuditPlugin) OnCommandComplete(event *CommandEvent) {
+	if args, ok := redactAuth(event.Command, event.Args); ok {
+		redacted := *event
+		redacted.Args = args
+		redacted.Raw = ""
+		event = &redacted
+	}
+	p.write("command", event)
+}
+
+func (p *AuditPlugin) OnConnect(event *ConnEvent) {
+	p.write(event.Type, event)
+}
+
+func (p *AuditPlugin) OnAuthFailure(event *ConnEvent) {
+	p.write(event.Type, event)
+}
+
+func (p *AuditPlugin) OnDialFailure(event *ConnEvent) {
+	p.write(event.Type, event)
+}
+
+func (p *AuditPlugin) OnDisconnect(event *ConnEvent) {
+	p.write(event.Type, event)
+}
+
+func (p *AuditPlugin) write(eventType string, event interface{}) {
+	if err := p.writer.Write(eventType, event); err != nil {
+		log.Printf("[RedisAuditPlugin] Write error: %v", err)
+	}
+}
+
+func (p *AuditPlugin) Close() error {
+	return p.writer.Close()
+}
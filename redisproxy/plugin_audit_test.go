@@ -0,0 +1,50 @@
+package redisproxy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/if-nil/proxyx/rotate"
+)
+
+func TestAuditRedactsAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	p, err := NewAuditPlugin(AuditPluginConfig{Enabled: true, Config: rotate.Config{Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []*CommandEvent{
+		{Command: "AUTH", Args: []string{"app", "secret"}, Raw: "AUTH app secret"},
+		{Command: "HELLO", Args: []string{"3", "SETNAME", "worker", "AUTH", "app", "secret"}, Raw: "HELLO 3 SETNAME worker AUTH app secret"},
+		{Command: "HELLO", Args: []string{"3", "SETNAME", "auth"}, Raw: "HELLO 3 SETNAME auth"},
+	}
+	for _, event := range events {
+		event.Timestamp = time.Now()
+		p.OnCommandComplete(event)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(events) {
+		t.Fatalf("%d audit lines, want %d", len(lines), len(events))
+	}
+	wants := []string{
+		`"args":["***","***"],"raw":""`,
+		`"args":["3","SETNAME","worker","AUTH","***","***"],"raw":""`,
+		`"args":["3","SETNAME","auth"],"raw":"HELLO 3 SETNAME auth"`,
+	}
+	for i, want := range wants {
+		if !strings.Contains(lines[i], want) || strings.Contains(lines[i], "secret") {
+			t.Errorf("line %d = %s, want %s", i+1, lines[i], want)
+		}
+	}
+}
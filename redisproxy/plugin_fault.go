@@ -0,0 +1,137 @@
+package redisproxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/if-nil/proxyx/fault"
+)
+
+// FaultPluginConfig 故障注入插件配置
+type FaultPluginConfig struct {
+	Enabled      bool `yaml:"enabled"` // 是否启用
+	fault.Config `yaml:",inline"`
+}
+
+// FaultPlugin 故障注入插件 - 按命令及参数、客户端、概率和时间窗口匹配规则，
+// 对命中的命令增加延迟、返回错误、在返回响应的中途断开客户端连接或截断数组响应
+type FaultPlugin struct {
+	injector *fault.Injector
+	pending  sync.Map // *CommandEvent -> *fault.Rule，执行后截断响应
+}
+
+// NewFaultPlugin 创建故障注入插件
+func NewFaultPlugin(config FaultPluginConfig) (*FaultPlugin, error) {
+	injector, err := fault.New(config.Config, false)
+	if err != nil {
+		return nil, err
+	}
+	return &FaultPlugin{injector: injector}, nil
+}
+
+func (p *FaultPlugin) Name() string {
+	return "RedisFaultPlugin"
+}
+
+// Intercept 在命令转发前匹配规则，命令名和参数以空格连接后匹配 pattern
+func (p *FaultPlugin) Intercept(event *CommandEvent) []byte {
+	text := event.Command
+	if len(event.Args) > 0 {
+		text += " " + strings.Join(event.Args, " ")
+	}
+	rule := p.injector.Match(fault.Target{
+		Text:       text,
+		Database:   strconv.Itoa(event.DB),
+		ClientAddr: event.ClientAddr,
+		User:       event.User,
+	})
+	if rule == nil {
+		return nil
+	}
+	event.Fault = rule.Action
+	event.FaultRule = rule.Name
+	log.Printf("[RedisFaultPlugin] Injecting %s (rule %s) into conn %d: %s", rule.Action, rule.Name, event.ConnID, event.Command)
+
+	switch rule.Action {
+	case fault.Latency:
+		time.Sleep(rule.Latency)
+	case fault.Error:
+		// 错误信息中的换行会破坏协议
+		message := strings.NewReplacer("\r", " ", "\n", " ").Replace(rule.ErrorMessage)
+		return []byte("-" + message + "\r\n")
+	case fault.Truncate:
+		p.pending.Store(event, rule)
+	}
+	// drop 由 Handler 在写回响应时处理
+	return nil
+}
+
+func (p *FaultPlugin) OnCommand(event *CommandEvent) {}
+
+// OnCommandComplete 截断数组、集合响应，保留 keep_rows 个元素（为 0 时保留一半），其他类型的响应不变
+func (p *FaultPlugin) OnCommandComplete(event *CommandEvent) {
+	v, ok := p.pending.LoadAndDelete(event)
+	if !ok || len(event.rawResponse) == 0 {
+		return
+	}
+	raw, count, err := truncateResponse(event.rawResponse, v.(*fault.Rule).KeepRows)
+	if err != nil {
+		log.Printf("[RedisFaultPlugin] Truncate response error: %v", err)
+		return
+	}
+	if raw != nil {
+		event.rawResponse = raw
+		event.Response = fmt.Sprintf("(%d elements)", count)
+	}
+}
+
+// truncateResponse 截断聚合类型的响应，不需要截断时返回 nil
+func truncateResponse(raw []byte, keep int) ([]byte, int, error) {
+	switch raw[0] {
+	case '*', '~', '>':
+	default:
+		return nil, 0, nil
+	}
+	reader := bufio.NewReader(bytes.NewReader(raw[1:]))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return nil, 0, err
+	}
+	if keep == 0 {
+		keep = count / 2
+	}
+	if count <= keep {
+		return nil, 0, nil
+	}
+
+	truncated := append([]byte{raw[0]}, strconv.Itoa(keep)...)
+	truncated = append(truncated, '\r', '\n')
+	for i := 0; i < keep; i++ {
+		_, elem, err := ReadResponse(reader)
+		if err != nil {
+			return nil, 0, err
+		}
+		truncated = append(truncated, elem...)
+	}
+	return truncated, keep, nil
+}
+
+// ServeHTTP 查询规则的注入次数，暂停或恢复注入，开关单条规则
+func (p *FaultPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.injector.ServeHTTP(w, r)
+}
+
+func (p *FaultPlugin) Close() error {
+	return nil
+}
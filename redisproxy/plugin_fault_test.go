@@ -0,0 +1,81 @@
+package redisproxy
+
+import (
+	"testing"
+
+	"github.com/if-nil/proxyx/fault"
+)
+
+func TestTruncateResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		keep  int
+		want  string // 截断后的响应，空表示不截断
+		count int
+	}{
+		{name: "array", raw: "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", keep: 1, want: "*1\r\n$1\r\na\r\n", count: 1},
+		{name: "half by default", raw: "*4\r\n:1\r\n:2\r\n:3\r\n:4\r\n", want: "*2\r\n:1\r\n:2\r\n", count: 2},
+		{name: "nested elements", raw: "*2\r\n*2\r\n:1\r\n:2\r\n$-1\r\n", keep: 1, want: "*1\r\n*2\r\n:1\r\n:2\r\n", count: 1},
+		{name: "set", raw: "~2\r\n+a\r\n+b\r\n", keep: 1, want: "~1\r\n+a\r\n", count: 1},
+		{name: "keep all", raw: "*2\r\n:1\r\n:2\r\n", keep: 5},
+		{name: "bulk string", raw: "$3\r\nabc\r\n", keep: 1},
+		{name: "integer", raw: ":10\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, count, err := truncateResponse([]byte(tt.raw), tt.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want || count != tt.count {
+				t.Errorf("truncateResponse() = %q, %d, want %q, %d", got, count, tt.want, tt.count)
+			}
+		})
+	}
+}
+
+func TestFaultPlugin(t *testing.T) {
+	p, err := NewFaultPlugin(FaultPluginConfig{Enabled: true, Config: fault.Config{Rules: []fault.Rule{
+		{Name: "deny-flush", Pattern: `^FLUSHALL`, Action: fault.Error, ErrorMessage: "ERR not\r\nnow"},
+		{Name: "short-lists", Pattern: `^LRANGE queue:`, Action: fault.Truncate, KeepRows: 1},
+		{Name: "drop-app", Pattern: `^GET session:`, Client: "10.0.0.0/8", Action: fault.Drop},
+		{Name: "deny-db1", Pattern: `^DEL `, Database: "1", Action: fault.Error},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command      string
+		args         []string
+		clientAddr   string
+		db           int
+		response     string // 后端的响应
+		wantReply    string // Intercept 直接返回的响应
+		wantFault    string
+		wantResponse string // 写回客户端的响应
+	}{
+		{command: "GET", args: []string{"k"}, response: "$1\r\nv\r\n", wantResponse: "$1\r\nv\r\n"},
+		{command: "FLUSHALL", wantReply: "-ERR not  now\r\n", wantFault: fault.Error},
+		{command: "LRANGE", args: []string{"queue:a", "0", "-1"}, response: "*2\r\n$1\r\na\r\n$1\r\nb\r\n", wantFault: fault.Truncate, wantResponse: "*1\r\n$1\r\na\r\n"},
+		{command: "LRANGE", args: []string{"other", "0", "-1"}, response: "*2\r\n$1\r\na\r\n$1\r\nb\r\n", wantResponse: "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{command: "GET", args: []string{"session:1"}, clientAddr: "10.1.2.3:5000", response: "$1\r\nv\r\n", wantFault: fault.Drop, wantResponse: "$1\r\nv\r\n"},
+		{command: "GET", args: []string{"session:1"}, clientAddr: "192.168.0.1:5000", response: "$1\r\nv\r\n", wantResponse: "$1\r\nv\r\n"},
+	}
+	for _, tt := range tests {
+		event := &CommandEvent{Command: tt.command, Args: tt.args, ClientAddr: tt.clientAddr, DB: tt.db}
+		reply := p.Intercept(event)
+		if string(reply) != tt.wantReply || event.Fault != tt.wantFault {
+			t.Errorf("%s %v: reply %q fault %q, want %q %q", tt.command, tt.args, reply, event.Fault, tt.wantReply, tt.wantFault)
+		}
+		if reply != nil {
+			continue
+		}
+		event.rawResponse = []byte(tt.response)
+		p.OnCommandComplete(event)
+		if string(event.rawResponse) != tt.wantResponse {
+			t.Errorf("%s %v: response %q, want %q", tt.command, tt.args, event.rawResponse, tt.wantResponse)
+		}
+	}
+}
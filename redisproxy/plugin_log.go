@@ -33,6 +33,23 @@ func (p *LogPlugin) OnCommandComplete(event *CommandEvent) {
 	}
 }
 
+func (p *LogPlugin) OnConnect(event *ConnEvent) {
+	log.Printf("[Redis] Connect: conn %d, %s -> %s", event.ConnID, event.ClientAddr, event.Backend)
+}
+
+func (p *LogPlugin) OnAuthFailure(event *ConnEvent) {
+	log.Printf("[Redis] Auth failure: conn %d, %s@%s: %s", event.ConnID, event.User, event.ClientAddr, event.Error)
+}
+
+func (p *LogPlugin) OnDialFailure(event *ConnEvent) {
+	log.Printf("[Redis] Dial failure: %s: %s", event.Backend, event.Error)
+}
+
+func (p *LogPlugin) OnDisconnect(event *ConnEvent) {
+	log.Printf("[Redis] Disconnect: conn %d, %s (duration: %v, commands: %d, in: %d bytes, out: %d bytes)",
+		event.ConnID, event.ClientAddr, event.Duration, event.Commands, event.BytesIn, event.BytesOut)
+}
+
 func (p *LogPlugin) Close() error {
 	return nil
 }
@@ -0,0 +1,90 @@
+package redisproxy
+
+import (
+	"log"
+
+	"github.com/if-nil/proxyx/capture"
+	"github.com/if-nil/proxyx/rotate"
+)
+
+// RecorderPluginConfig 流量录制插件配置
+type RecorderPluginConfig struct {
+	Enabled       bool `yaml:"enabled"` // 是否启用
+	rotate.Config `yaml:",inline"`
+}
+
+// RecorderPlugin 流量录制插件 - 将客户端的命令和连接按会话写入录制文件，供 proxyx replay 回放
+type RecorderPlugin struct {
+	writer *capture.Writer
+}
+
+// NewRecorderPlugin 创建流量录制插件
+func NewRecorderPlugin(config RecorderPluginConfig) (*RecorderPlugin, error) {
+	writer, err := capture.NewWriter(config.Config, capture.SourceRedis)
+	if err != nil {
+		return nil, err
+	}
+	return &RecorderPlugin{writer: writer}, nil
+}
+
+func (p *RecorderPlugin) Name() string {
+	return "RedisRecorderPlugin"
+}
+
+func (p *RecorderPlugin) OnCommand(event *CommandEvent) {}
+
+// OnCommandComplete 认证信息不写入录制文件：AUTH 的参数和 HELLO 的 AUTH 选项中的用户名、密码替换为 ***
+func (p *RecorderPlugin) OnCommandComplete(event *CommandEvent) {
+	redacted, _ := redactAuth(event.Command, event.Args)
+	args := make([]interface{}, len(redacted))
+	for i, arg := range redacted {
+		args[i] = arg
+	}
+	rec := &capture.Record{
+		Kind:     capture.KindCommand,
+		Session:  event.ConnID,
+		Time:     event.Timestamp,
+		Duration: event.Duration,
+		Name:     event.Command,
+		User:     event.User,
+		Args:     args,
+		Digest:   ResponseDigest(event.rawResponse),
+		Error:    event.Error,
+	}
+	p.write(rec)
+}
+
+func (p *RecorderPlugin) OnConnect(event *ConnEvent) {
+	p.write(&capture.Record{
+		Kind:    capture.KindConnect,
+		Session: event.ConnID,
+		Time:    event.Timestamp,
+		Text:    event.ClientAddr,
+	})
+}
+
+func (p *RecorderPlugin) OnAuthFailure(event *ConnEvent) {}
+
+func (p *RecorderPlugin) OnDialFailure(event *ConnEvent) {}
+
+func (p *RecorderPlugin) OnDisconnect(event *ConnEvent) {
+	p.write(&capture.Record{
+		Kind:     capture.KindDisconnect,
+		Session:  event.ConnID,
+		Time:     event.Timestamp,
+		Duration: event.Duration,
+		User:     event.User,
+		Text:     event.ClientAddr,
+		Error:    event.Error,
+	})
+}
+
+func (p *RecorderPlugin) write(rec *capture.Record) {
+	if err := p.writer.Write(rec); err != nil {
+		log.Printf("[RedisRecorderPlugin] Write error: %v", err)
+	}
+}
+
+func (p *RecorderPlugin) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,55 @@
+package redisproxy
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/if-nil/proxyx/capture"
+	"github.com/if-nil/proxyx/rotate"
+)
+
+func TestRecorderRedactsAuth(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		want    []interface{}
+	}{
+		{command: "GET", args: []string{"k"}, want: []interface{}{"k"}},
+		{command: "AUTH", args: []string{"secret"}, want: []interface{}{"***"}},
+		{command: "AUTH", args: []string{"app", "secret"}, want: []interface{}{"***", "***"}},
+		{command: "HELLO", args: []string{"3"}, want: []interface{}{"3"}},
+		{command: "HELLO", args: []string{"3", "AUTH", "app", "secret"}, want: []interface{}{"3", "AUTH", "***", "***"}},
+		{command: "HELLO", args: []string{"3", "auth", "app", "secret", "SETNAME", "worker"}, want: []interface{}{"3", "auth", "***", "***", "SETNAME", "worker"}},
+		{command: "HELLO", args: []string{"3", "SETNAME", "auth", "AUTH", "app", "secret"}, want: []interface{}{"3", "SETNAME", "auth", "AUTH", "***", "***"}},
+		{command: "SET", args: []string{"AUTH", "secret"}, want: []interface{}{"AUTH", "secret"}},
+	}
+
+	path := filepath.Join(t.TempDir(), "redis.pxcap")
+	p, err := NewRecorderPlugin(RecorderPluginConfig{Enabled: true, Config: rotate.Config{Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		p.OnCommandComplete(&CommandEvent{Command: tt.command, Args: tt.args, Timestamp: time.Now(), ConnID: 1})
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, closer, err := capture.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	for _, tt := range tests {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Name != tt.command || !reflect.DeepEqual(rec.Args, tt.want) {
+			t.Errorf("%s %v recorded as %s %v, want %v", tt.command, tt.args, rec.Name, rec.Args, tt.want)
+		}
+	}
+}
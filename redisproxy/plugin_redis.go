@@ -66,6 +66,27 @@ func (p *RedisPlugin) OnCommand(event *CommandEvent) {
 }
 
 func (p *RedisPlugin) OnCommandComplete(event *CommandEvent) {
+	p.push(event)
+}
+
+func (p *RedisPlugin) OnConnect(event *ConnEvent) {
+	p.push(event)
+}
+
+func (p *RedisPlugin) OnAuthFailure(event *ConnEvent) {
+	p.push(event)
+}
+
+func (p *RedisPlugin) OnDialFailure(event *ConnEvent) {
+	p.push(event)
+}
+
+func (p *RedisPlugin) OnDisconnect(event *ConnEvent) {
+	p.push(event)
+}
+
+// push 将事件以JSON推送到Redis
+func (p *RedisPlugin) push(event interface{}) {
 	data, jsonErr := json.Marshal(event)
 	if jsonErr != nil {
 		log.Printf("[Redis RedisPlugin] JSON marshal error: %v", jsonErr)
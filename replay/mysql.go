@@ -0,0 +1,184 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/if-nil/proxyx/capture"
+	"github.com/if-nil/proxyx/mysql"
+)
+
+// 只读回放时仍然执行的会话级 SET（字符集、会话变量等），修改全局变量的除外
+var (
+	sessionSet = regexp.MustCompile(`(?i)^\s*set\s`)
+	globalSet  = regexp.MustCompile(`(?i)\b(global|persist|persist_only)\b|@@(global|persist)\.`)
+)
+
+// mysqlTarget 回放到 MySQL
+type mysqlTarget struct {
+	opts *Options
+}
+
+// replayable 回放语句、预处理语句和切换数据库等命令，其他协议命令（如 reset_connection、statistics）不回放
+func (t *mysqlTarget) replayable(rec *capture.Record, readOnly bool) bool {
+	if rec.Kind != capture.KindQuery {
+		return false
+	}
+	switch rec.Name {
+	case "query", "prepare", "execute":
+		if !readOnly || mysql.IsReadOnly(rec.Text) {
+			return true
+		}
+		return sessionSet.MatchString(rec.Text) && !globalSet.MatchString(rec.Text)
+	case "use_db", "field_list", "ping":
+		return true
+	}
+	return false
+}
+
+func (t *mysqlTarget) key(rec *capture.Record) string {
+	switch rec.Name {
+	case "query", "prepare", "execute":
+		return mysql.Fingerprint(rec.Text)
+	}
+	return rec.Name
+}
+
+func (t *mysqlTarget) describe(rec *capture.Record) string {
+	if len(rec.Args) > 0 {
+		return fmt.Sprintf("%s: %s %v", rec.Name, rec.Text, rec.Args)
+	}
+	return rec.Name + ": " + rec.Text
+}
+
+func (t *mysqlTarget) newSession(user string) session {
+	if t.opts.User != "" {
+		user = t.opts.User
+	}
+	return &mysqlSession{opts: t.opts, user: user}
+}
+
+// mysqlSession 一个录制会话的回放连接，连接断开后在下一条记录时重连
+type mysqlSession struct {
+	opts  *Options
+	user  string
+	conn  *client.Conn
+	db    string
+	stmts map[string]*client.Stmt // 按 SQL 缓存的预处理语句
+}
+
+func (s *mysqlSession) do(rec *capture.Record) result {
+	if s.conn == nil {
+		conn, err := client.Connect(s.opts.Addr, s.user, s.opts.Password, rec.Database)
+		if err != nil {
+			return result{err: "connect: " + err.Error()}
+		}
+		s.conn = conn
+		s.db = rec.Database
+		s.stmts = make(map[string]*client.Stmt)
+	}
+	// 按录制时的当前数据库切换，重连或录制从会话中途开始时保持一致
+	if rec.Name != "use_db" && rec.Database != "" && rec.Database != s.db {
+		if err := s.conn.UseDB(rec.Database); err != nil {
+			return s.fail(result{}, err)
+		}
+		s.db = rec.Database
+	}
+
+	start := time.Now()
+	stop := s.watch()
+	r, err := s.execute(rec)
+	res := result{duration: time.Since(start)}
+	if stop() {
+		err = fmt.Errorf("timed out after %s", s.opts.Timeout)
+	}
+	if err != nil {
+		return s.fail(res, err)
+	}
+	if r != nil {
+		res.rows = r.AffectedRows
+		if r.Resultset != nil {
+			res.rows = uint64(len(r.RowDatas))
+			res.digest = mysql.ResultDigest(r.Resultset)
+		}
+	}
+	return res
+}
+
+func (s *mysqlSession) execute(rec *capture.Record) (*gomysql.Result, error) {
+	switch rec.Name {
+	case "query":
+		return s.conn.Execute(rec.Text)
+	case "prepare":
+		_, err := s.prepare(rec.Text)
+		return nil, err
+	case "execute":
+		stmt, err := s.prepare(rec.Text)
+		if err != nil {
+			return nil, err
+		}
+		return stmt.Execute(rec.Args...)
+	case "use_db":
+		err := s.conn.UseDB(rec.Text)
+		if err == nil {
+			s.db = rec.Text
+		}
+		return nil, err
+	case "field_list":
+		table, wildcard, _ := strings.Cut(rec.Text, " ")
+		_, err := s.conn.FieldList(table, wildcard)
+		return nil, err
+	case "ping":
+		return nil, s.conn.Ping()
+	}
+	return nil, fmt.Errorf("unsupported record %s", rec.Name)
+}
+
+// prepare 返回 SQL 对应的预处理语句，连接上尚未预处理时先预处理
+func (s *mysqlSession) prepare(query string) (*client.Stmt, error) {
+	if stmt, ok := s.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	s.stmts[query] = stmt
+	return stmt, nil
+}
+
+// watch 超过超时时间后关闭连接中断执行，返回的函数停止计时并报告是否已超时
+func (s *mysqlSession) watch() func() bool {
+	if s.opts.Timeout <= 0 {
+		return func() bool { return false }
+	}
+	conn := s.conn
+	timer := time.AfterFunc(s.opts.Timeout, func() { conn.Close() })
+	return func() bool { return !timer.Stop() }
+}
+
+// fail 记录错误，连接异常时关闭连接，下一条记录重新连接
+func (s *mysqlSession) fail(res result, err error) result {
+	res.err = err.Error()
+	var myErr *gomysql.MyError
+	if !errors.As(err, &myErr) {
+		s.close()
+	}
+	return res
+}
+
+func (s *mysqlSession) close() {
+	if s.conn == nil {
+		return
+	}
+	for _, stmt := range s.stmts {
+		stmt.Close()
+	}
+	s.conn.Close()
+	s.conn = nil
+}
@@ -0,0 +1,193 @@
+package replay
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/if-nil/proxyx/capture"
+	"github.com/if-nil/proxyx/redisproxy"
+)
+
+// dialTimeout 连接目标的超时
+const dialTimeout = 5 * time.Second
+
+// 不回放的命令：订阅和复制会改变连接的响应方式，QUIT / SHUTDOWN 会断开连接或停止目标
+var unreplayableCommands = map[string]bool{
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "SSUBSCRIBE": true,
+	"UNSUBSCRIBE": true, "PUNSUBSCRIBE": true, "SUNSUBSCRIBE": true,
+	"MONITOR": true, "SYNC": true, "PSYNC": true, "REPLICAOF": true, "SLAVEOF": true,
+	"QUIT": true, "SHUTDOWN": true,
+}
+
+// redisTarget 回放到 Redis
+type redisTarget struct {
+	opts *Options
+}
+
+// replayable 录制的 AUTH 不回放，回放连接按 -user / -password 认证
+func (t *redisTarget) replayable(rec *capture.Record, readOnly bool) bool {
+	if rec.Kind != capture.KindCommand || unreplayableCommands[rec.Name] || rec.Name == "AUTH" {
+		return false
+	}
+	return !readOnly || redisproxy.IsReadOnlyCommand(rec.Name)
+}
+
+func (t *redisTarget) key(rec *capture.Record) string {
+	return rec.Name
+}
+
+// describe 返回命令和参数，认证命令不输出参数，HELLO 按回放时去掉 AUTH 选项的参数输出
+func (t *redisTarget) describe(rec *capture.Record) string {
+	if rec.Name == "AUTH" {
+		return rec.Name + " ***"
+	}
+	parts := make([]string, 0, len(rec.Args)+1)
+	parts = append(parts, rec.Name)
+	for _, arg := range replayArgs(rec) {
+		s := argString(arg)
+		if s == "" || strings.ContainsAny(s, " \t\r\n\"") {
+			s = strconv.Quote(s)
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (t *redisTarget) newSession(user string) session {
+	return &redisSession{opts: t.opts}
+}
+
+// redisSession 一个录制会话的回放连接，连接断开后在下一条记录时重连
+type redisSession struct {
+	opts   *Options
+	conn   net.Conn
+	reader *bufio.Reader
+	buf    []byte
+}
+
+func (s *redisSession) do(rec *capture.Record) result {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return result{err: "connect: " + err.Error()}
+		}
+	}
+
+	start := time.Now()
+	summary, raw, err := s.roundTrip(rec.Name, replayArgs(rec))
+	res := result{duration: time.Since(start)}
+	if err != nil {
+		res.err = err.Error()
+		s.close()
+		return res
+	}
+	res.digest = redisproxy.ResponseDigest(raw)
+	if redisproxy.IsErrorResponse(summary) {
+		res.err = summary
+	}
+	return res
+}
+
+// replayArgs 返回回放时发送的参数：HELLO 去掉 AUTH 选项（录制文件中认证信息已隐去，回放连接已按 -password 认证），
+// 保留协议版本和 SETNAME
+func replayArgs(rec *capture.Record) []interface{} {
+	if rec.Name != "HELLO" {
+		return rec.Args
+	}
+	args := make([]interface{}, 0, len(rec.Args))
+	for i := 0; i < len(rec.Args); i++ {
+		switch strings.ToUpper(argString(rec.Args[i])) {
+		case "AUTH":
+			i += 2
+			continue
+		case "SETNAME":
+			if i+1 < len(rec.Args) {
+				args = append(args, rec.Args[i])
+				i++
+			}
+		}
+		args = append(args, rec.Args[i])
+	}
+	return args
+}
+
+// connect 连接目标，设置了密码时先认证
+func (s *redisSession) connect() error {
+	conn, err := net.DialTimeout("tcp", s.opts.Addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+	if s.opts.Password == "" {
+		return nil
+	}
+
+	args := []interface{}{s.opts.Password}
+	if s.opts.User != "" {
+		args = []interface{}{s.opts.User, s.opts.Password}
+	}
+	summary, _, err := s.roundTrip("AUTH", args)
+	if err == nil && redisproxy.IsErrorResponse(summary) {
+		err = fmt.Errorf("AUTH: %s", summary)
+	}
+	if err != nil {
+		s.close()
+	}
+	return err
+}
+
+// roundTrip 发送一条命令并读取完整的响应
+func (s *redisSession) roundTrip(command string, args []interface{}) (string, []byte, error) {
+	if s.opts.Timeout > 0 {
+		s.conn.SetDeadline(time.Now().Add(s.opts.Timeout))
+	}
+	s.buf = appendCommand(s.buf[:0], command, args)
+	if _, err := s.conn.Write(s.buf); err != nil {
+		return "", nil, err
+	}
+	return redisproxy.ReadResponse(s.reader)
+}
+
+// appendCommand 将命令编码为 RESP 数组
+func appendCommand(b []byte, command string, args []interface{}) []byte {
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)+1), 10)
+	b = append(b, '\r', '\n')
+	b = appendBulk(b, command)
+	for _, arg := range args {
+		b = appendBulk(b, argString(arg))
+	}
+	return b
+}
+
+func appendBulk(b []byte, s string) []byte {
+	b = append(b, '$')
+	b = strconv.AppendInt(b, int64(len(s)), 10)
+	b = append(b, '\r', '\n')
+	b = append(b, s...)
+	return append(b, '\r', '\n')
+}
+
+// argString 录制的 Redis 参数均为字符串，其他类型按 fmt 格式化
+func argString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(arg)
+}
+
+func (s *redisSession) close() {
+	if s.conn == nil {
+		return
+	}
+	s.conn.Close()
+	s.conn = nil
+	s.reader = nil
+}
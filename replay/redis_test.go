@@ -0,0 +1,51 @@
+package replay
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/if-nil/proxyx/capture"
+)
+
+func TestRedisAuthReplay(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []interface{}
+		replayable bool
+		wantArgs   []interface{} // 回放时发送的参数
+		describe   string
+	}{
+		{name: "AUTH", args: []interface{}{"***"}, describe: "AUTH ***"},
+		{name: "AUTH", args: []interface{}{"app", "secret"}, describe: "AUTH ***"},
+		{name: "HELLO", args: []interface{}{"3"}, replayable: true, wantArgs: []interface{}{"3"}, describe: "HELLO 3"},
+		{
+			name: "HELLO", args: []interface{}{"3", "AUTH", "***", "***"},
+			replayable: true, wantArgs: []interface{}{"3"}, describe: "HELLO 3",
+		},
+		{
+			name: "HELLO", args: []interface{}{"3", "AUTH", "app", "secret", "SETNAME", "worker"},
+			replayable: true, wantArgs: []interface{}{"3", "SETNAME", "worker"}, describe: "HELLO 3 SETNAME worker",
+		},
+		{
+			name: "HELLO", args: []interface{}{"2", "SETNAME", "auth"},
+			replayable: true, wantArgs: []interface{}{"2", "SETNAME", "auth"}, describe: "HELLO 2 SETNAME auth",
+		},
+		{name: "GET", args: []interface{}{"AUTH"}, replayable: true, wantArgs: []interface{}{"AUTH"}, describe: "GET AUTH"},
+	}
+	target := &redisTarget{opts: &Options{}}
+	for _, tt := range tests {
+		rec := &capture.Record{Kind: capture.KindCommand, Name: tt.name, Args: tt.args}
+		if got := target.replayable(rec, false); got != tt.replayable {
+			t.Errorf("replayable(%s %v) = %v, want %v", tt.name, tt.args, got, tt.replayable)
+		}
+		if got := target.describe(rec); got != tt.describe {
+			t.Errorf("describe(%s %v) = %q, want %q", tt.name, tt.args, got, tt.describe)
+		}
+		if !tt.replayable {
+			continue
+		}
+		if got := replayArgs(rec); !reflect.DeepEqual(got, tt.wantArgs) {
+			t.Errorf("replayArgs(%s %v) = %v, want %v", tt.name, tt.args, got, tt.wantArgs)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package replay 将录制文件中的流量重新发往目标 MySQL / Redis，并与录制时的耗时、结果和错误对比
+//
+// 每个录制会话使用一个独立的连接，会话内按录制顺序执行，会话之间并发执行；
+// 每条记录按录制时相对第一条记录的时间（除以回放速度）开始执行。
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/if-nil/proxyx/capture"
+)
+
+// queueSize 每个会话待执行记录的缓冲，会话执行落后太多时读取文件会等待
+const queueSize = 1024
+
+// Options 回放选项
+type Options struct {
+	Addr     string        // 目标地址
+	User     string        // MySQL 连接用户名，为空时使用录制的用户名；Redis 设置了密码时 AUTH 使用的用户名
+	Password string        // 连接密码
+	Speed    float64       // 回放速度：1 按录制时的节奏，N 为 N 倍速，0 尽快回放
+	ReadOnly bool          // 只回放只读语句 / 命令
+	DryRun   bool          // 只列出将要回放的记录，不连接目标
+	Timeout  time.Duration // 单条语句 / 命令的超时，0 表示不限制
+	MaxDiffs int           // 报告中保留的差异条数
+	Output   io.Writer     // dry-run 时列出记录的输出
+}
+
+// target 回放目标
+type target interface {
+	// replayable 判断记录是否回放
+	replayable(rec *capture.Record, readOnly bool) bool
+	// key 返回统计耗时时记录所属的分组
+	key(rec *capture.Record) string
+	// describe 返回记录的可读描述
+	describe(rec *capture.Record) string
+	// newSession 创建回放会话，连接在执行第一条记录时建立
+	newSession(user string) session
+}
+
+// session 一个录制会话对应的回放连接，只在会话自己的 goroutine 中使用
+type session interface {
+	do(rec *capture.Record) result
+	close()
+}
+
+// result 回放一条记录的结果
+type result struct {
+	duration time.Duration
+	rows     uint64
+	digest   uint64
+	err      string
+}
+
+// sessionKey 录制会话，不同进程录制的文件中会话ID会重复，用录制开始时间区分
+type sessionKey struct {
+	start int64
+	id    uint32
+}
+
+// player 按会话分发记录并控制回放节奏
+type player struct {
+	opts   Options
+	source byte
+	target target
+	report *Report
+
+	started  time.Time // 回放开始时间
+	base     time.Time // 第一条记录的时间
+	sessions map[sessionKey]chan *capture.Record
+	wg       sync.WaitGroup
+}
+
+// Run 依次读取录制文件并回放，等待所有会话执行完毕后返回报告；读取文件出错时返回已回放部分的报告和错误
+func Run(paths []string, opts Options) (*Report, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("replay: no capture file")
+	}
+	if opts.Output == nil {
+		opts.Output = io.Discard
+	}
+	p := &player{
+		opts:     opts,
+		sessions: make(map[sessionKey]chan *capture.Record),
+	}
+
+	var err error
+	for _, path := range paths {
+		if err = p.playFile(path); err != nil {
+			break
+		}
+	}
+	for key, queue := range p.sessions {
+		close(queue)
+		delete(p.sessions, key)
+	}
+	p.wg.Wait()
+
+	if p.report == nil {
+		return nil, err
+	}
+	var elapsed time.Duration
+	if !p.started.IsZero() {
+		elapsed = time.Since(p.started)
+	}
+	p.report.finish(elapsed)
+	return p.report, err
+}
+
+// playFile 读取一个录制文件并分发其中的记录
+func (p *player) playFile(path string) error {
+	r, file, err := capture.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if p.target == nil {
+		if p.target, err = newTarget(r.Source, &p.opts); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		p.source = r.Source
+		p.report = newReport(r.Source, &p.opts)
+	} else if r.Source != p.source {
+		return fmt.Errorf("%s: capture source %q differs from previous files", path, r.Source)
+	}
+
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, capture.ErrTruncated) {
+			// 录制进程异常退出或文件仍在写入，回放已完整读取的部分
+			log.Printf("[Replay] %s: %v", path, err)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		p.dispatch(sessionKey{start: r.Start.UnixNano(), id: rec.Session}, rec)
+	}
+}
+
+func newTarget(source byte, opts *Options) (target, error) {
+	switch source {
+	case capture.SourceMySQL:
+		return &mysqlTarget{opts: opts}, nil
+	case capture.SourceRedis:
+		return &redisTarget{opts: opts}, nil
+	}
+	return nil, fmt.Errorf("unknown capture source %q", source)
+}
+
+// dispatch 将记录交给所属会话；会话在第一条回放的记录时创建，在录制的断开记录处结束
+func (p *player) dispatch(key sessionKey, rec *capture.Record) {
+	if p.started.IsZero() {
+		p.started = time.Now()
+		p.base = rec.Time
+	}
+
+	switch rec.Kind {
+	case capture.KindConnect:
+		return
+	case capture.KindDisconnect:
+		if queue, ok := p.sessions[key]; ok {
+			close(queue)
+			delete(p.sessions, key)
+		}
+		return
+	}
+
+	if !p.target.replayable(rec, p.opts.ReadOnly) {
+		p.report.skip()
+		return
+	}
+	p.report.session(key)
+	if p.opts.DryRun {
+		fmt.Fprintf(p.opts.Output, "%12s  session=%-6d %s\n",
+			rec.Time.Sub(p.base).Round(time.Millisecond), rec.Session, p.target.describe(rec))
+		p.report.plan()
+		return
+	}
+	p.queue(key, rec.User) <- rec
+}
+
+// queue 返回会话的待执行队列，不存在时创建会话
+func (p *player) queue(key sessionKey, user string) chan<- *capture.Record {
+	queue, ok := p.sessions[key]
+	if !ok {
+		queue = make(chan *capture.Record, queueSize)
+		p.sessions[key] = queue
+		p.wg.Add(1)
+		go p.play(p.target.newSession(user), queue)
+	}
+	return queue
+}
+
+// play 在会话自己的连接上按顺序执行记录
+func (p *player) play(s session, queue <-chan *capture.Record) {
+	defer p.wg.Done()
+	defer s.close()
+
+	for rec := range queue {
+		p.wait(rec.Time)
+		p.report.add(p.target, rec, rec.Time.Sub(p.base), s.do(rec))
+	}
+}
+
+// wait 等待到记录按回放速度换算后的开始时间，已经落后时立即执行
+func (p *player) wait(t time.Time) {
+	if p.opts.Speed <= 0 {
+		return
+	}
+	due := p.started.Add(time.Duration(float64(t.Sub(p.base)) / p.opts.Speed))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
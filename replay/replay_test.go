@@ -0,0 +1,208 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+	"github.com/if-nil/proxyx/capture"
+	"github.com/if-nil/proxyx/mysql"
+	"github.com/if-nil/proxyx/rotate"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name     string
+		rec      capture.Record
+		res      result
+		wantType string
+	}{
+		{name: "same result", rec: capture.Record{Rows: 2, Digest: 7}, res: result{rows: 2, digest: 7}},
+		{name: "same error", rec: capture.Record{Error: "denied"}, res: result{err: "denied"}},
+		{name: "new error", res: result{err: "denied"}, wantType: DiffNewError},
+		{name: "fixed error", rec: capture.Record{Error: "denied"}, wantType: DiffFixedError},
+		{name: "changed error", rec: capture.Record{Error: "denied"}, res: result{err: "timeout"}, wantType: DiffChangedError},
+		{name: "rows differ", rec: capture.Record{Rows: 2, Digest: 7}, res: result{rows: 3, digest: 7}, wantType: DiffMismatch},
+		{name: "digest differs", rec: capture.Record{Rows: 2, Digest: 7}, res: result{rows: 2, digest: 8}, wantType: DiffMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, _ := compare(&tt.rec, tt.res); got != tt.wantType {
+				t.Errorf("compare() = %q, want %q", got, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestMySQLReplayable(t *testing.T) {
+	tests := []struct {
+		kind     byte
+		name     string
+		text     string
+		readOnly bool
+		want     bool
+	}{
+		{kind: capture.KindQuery, name: "query", text: "SELECT 1", readOnly: true, want: true},
+		{kind: capture.KindQuery, name: "query", text: "UPDATE t SET a = 1", want: true},
+		{kind: capture.KindQuery, name: "query", text: "UPDATE t SET a = 1", readOnly: true},
+		{kind: capture.KindQuery, name: "execute", text: "DELETE FROM t WHERE id = ?", readOnly: true},
+		{kind: capture.KindQuery, name: "query", text: "SET NAMES utf8mb4", readOnly: true, want: true},
+		{kind: capture.KindQuery, name: "query", text: "SET GLOBAL max_connections = 10", readOnly: true},
+		{kind: capture.KindQuery, name: "query", text: "SET @@global.max_connections = 10", readOnly: true},
+		{kind: capture.KindQuery, name: "use_db", text: "shop", readOnly: true, want: true},
+		{kind: capture.KindQuery, name: "reset_connection"},
+		{kind: capture.KindConnect, name: "connect"},
+	}
+	target := &mysqlTarget{opts: &Options{}}
+	for _, tt := range tests {
+		rec := &capture.Record{Kind: tt.kind, Name: tt.name, Text: tt.text}
+		if got := target.replayable(rec, tt.readOnly); got != tt.want {
+			t.Errorf("replayable(%s %q, readOnly=%v) = %v, want %v", tt.name, tt.text, tt.readOnly, got, tt.want)
+		}
+	}
+}
+
+// backend 回放目标：SELECT 返回 id 列的一行，SELECT missing 返回错误，其他语句返回 OK
+type backend struct {
+	server.EmptyHandler
+}
+
+func (backend) HandleQuery(query string) (*gomysql.Result, error) {
+	switch {
+	case query == "SELECT missing":
+		return nil, errMissing
+	case strings.HasPrefix(query, "SELECT"):
+		return selectResult(1), nil
+	}
+	return nil, nil
+}
+
+var errMissing = gomysql.NewError(gomysql.ER_BAD_FIELD_ERROR, "Unknown column 'missing'")
+
+func selectResult(id int64) *gomysql.Result {
+	rs, _ := gomysql.BuildSimpleTextResultset([]string{"id"}, [][]interface{}{{id}})
+	return &gomysql.Result{Resultset: rs}
+}
+
+// serveBackend 启动回放目标，用户 app 没有密码
+func serveBackend(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	credentials := server.NewInMemoryProvider()
+	credentials.AddUser("app", "")
+	srv := server.NewDefaultServer()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn, err := server.NewCustomizedConn(c, srv, credentials, backend{})
+				if err != nil {
+					c.Close()
+					return
+				}
+				for conn.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// writeCapture 写入一个 MySQL 录制文件，返回文件路径
+func writeCapture(t *testing.T, records []*capture.Record) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mysql.pxcap")
+	w, err := capture.NewWriter(rotate.Config{Path: path}, capture.SourceMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	start := time.Now()
+	query := func(session uint32, offset time.Duration, text string, rows, digest uint64, err string) *capture.Record {
+		return &capture.Record{
+			Kind: capture.KindQuery, Session: session, Time: start.Add(offset), Duration: time.Millisecond,
+			Name: "query", User: "app", Text: text, Rows: rows, Digest: digest, Error: err,
+		}
+	}
+	digest := mysql.ResultDigest(selectResult(1).Resultset)
+	path := writeCapture(t, []*capture.Record{
+		{Kind: capture.KindConnect, Session: 1, Time: start, User: "app"},
+		query(1, 0, "SELECT id FROM t", 1, digest, ""),
+		query(2, time.Millisecond, "SELECT id FROM t WHERE id = 2", 1, digest+1, ""),
+		query(1, 2*time.Millisecond, "UPDATE t SET a = 1", 0, 0, ""),
+		query(2, 3*time.Millisecond, "SELECT missing", 0, 0, errMissing.Error()),
+		query(1, 4*time.Millisecond, "SELECT now_missing", 0, 0, "Unknown column 'now_missing'"),
+		{Kind: capture.KindQuery, Session: 1, Time: start.Add(5 * time.Millisecond), Name: "reset_connection", User: "app"},
+		{Kind: capture.KindDisconnect, Session: 1, Time: start.Add(6 * time.Millisecond)},
+	})
+	addr := serveBackend(t)
+
+	tests := []struct {
+		name          string
+		opts          Options
+		wantReplayed  int
+		wantSkipped   int
+		wantSessions  int
+		wantMismatch  int
+		wantFixed     int
+		wantDiffs     int
+		wantDryRunOut int // dry-run 时列出的记录数
+	}{
+		{name: "replay", opts: Options{MaxDiffs: 10}, wantReplayed: 5, wantSkipped: 1, wantSessions: 2, wantMismatch: 1, wantFixed: 1, wantDiffs: 2},
+		{name: "read only", opts: Options{ReadOnly: true, MaxDiffs: 10}, wantReplayed: 4, wantSkipped: 2, wantSessions: 2, wantMismatch: 1, wantFixed: 1, wantDiffs: 2},
+		{name: "max diffs", opts: Options{Speed: 1, MaxDiffs: 1}, wantReplayed: 5, wantSkipped: 1, wantSessions: 2, wantMismatch: 1, wantFixed: 1, wantDiffs: 1},
+		{name: "dry run", opts: Options{DryRun: true}, wantReplayed: 5, wantSkipped: 1, wantSessions: 2, wantDryRunOut: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			opts := tt.opts
+			opts.Addr = addr
+			opts.Output = &out
+			report, err := Run([]string{path}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := fmt.Sprintf("replayed=%d skipped=%d sessions=%d mismatches=%d fixed=%d new=%d diffs=%d",
+				report.Replayed, report.Skipped, report.Sessions, report.Mismatches, report.FixedErrors, report.NewErrors, len(report.Diffs))
+			want := fmt.Sprintf("replayed=%d skipped=%d sessions=%d mismatches=%d fixed=%d new=%d diffs=%d",
+				tt.wantReplayed, tt.wantSkipped, tt.wantSessions, tt.wantMismatch, tt.wantFixed, 0, tt.wantDiffs)
+			if got != want {
+				t.Errorf("report %s, want %s", got, want)
+			}
+			if report.Records != report.Replayed+report.Skipped {
+				t.Errorf("records = %d, want replayed + skipped", report.Records)
+			}
+			if lines := strings.Count(out.String(), "\n"); lines != tt.wantDryRunOut {
+				t.Errorf("dry run listed %d records, want %d:\n%s", lines, tt.wantDryRunOut, out.String())
+			}
+			if !tt.opts.DryRun && len(report.Statements) != tt.wantReplayed {
+				t.Errorf("%d statement groups, want %d", len(report.Statements), tt.wantReplayed)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package replay
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/if-nil/proxyx/capture"
+)
+
+// 差异类型
+const (
+	DiffNewError     = "new_error"     // 录制时成功、回放时失败
+	DiffFixedError   = "fixed_error"   // 录制时失败、回放时成功
+	DiffChangedError = "changed_error" // 两次都失败但错误不同
+	DiffMismatch     = "mismatch"      // 两次都成功但行数或结果摘要不同
+)
+
+// topStatements 文本报告中列出的耗时变化最大的分组数
+const topStatements = 10
+
+// Report 回放报告
+type Report struct {
+	Source   string        `json:"source"`   // mysql / redis
+	Target   string        `json:"target"`   // 回放目标地址
+	DryRun   bool          `json:"dry_run"`  // 只列出了记录，没有实际执行
+	Sessions int           `json:"sessions"` // 回放的会话数
+	Records  int           `json:"records"`  // 读取的语句 / 命令数
+	Replayed int           `json:"replayed"` // 回放（dry-run 时为将要回放）的记录数
+	Skipped  int           `json:"skipped"`  // 被只读过滤或不支持回放的记录数
+	Elapsed  time.Duration `json:"elapsed"`  // 回放耗时
+
+	Recorded Latency `json:"recorded"` // 录制时的耗时分布
+	Replay   Latency `json:"replay"`   // 回放时的耗时分布
+
+	NewErrors     int         `json:"new_errors"`
+	FixedErrors   int         `json:"fixed_errors"`
+	ChangedErrors int         `json:"changed_errors"`
+	Mismatches    int         `json:"mismatches"`
+	Diffs         []Diff      `json:"diffs"`      // 前 MaxDiffs 条差异
+	Statements    []Statement `json:"statements"` // 按耗时增加的总量从大到小排序
+
+	mu       sync.Mutex
+	maxDiffs int
+	seen     map[sessionKey]bool
+	recorded []time.Duration
+	replayed []time.Duration
+	byKey    map[string]*Statement
+}
+
+// Latency 耗时分布
+type Latency struct {
+	Total time.Duration `json:"total"`
+	Avg   time.Duration `json:"avg"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// Diff 一条记录录制和回放时的差异
+type Diff struct {
+	Type      string        `json:"type"`
+	Session   uint32        `json:"session"`
+	Offset    time.Duration `json:"offset"` // 相对第一条记录的时间
+	Statement string        `json:"statement"`
+	Recorded  string        `json:"recorded"`
+	Replayed  string        `json:"replayed"`
+}
+
+// Statement 一组语句（MySQL 按指纹，Redis 按命令名）的耗时对比
+type Statement struct {
+	Key      string        `json:"key"`
+	Count    int           `json:"count"`
+	Errors   int           `json:"errors"`   // 回放时失败的次数
+	Recorded time.Duration `json:"recorded"` // 录制时的平均耗时
+	Replayed time.Duration `json:"replayed"` // 回放时的平均耗时
+	Delta    time.Duration `json:"delta"`    // 耗时变化的总量
+
+	recordedTotal time.Duration
+	replayedTotal time.Duration
+}
+
+func newReport(source byte, opts *Options) *Report {
+	r := &Report{
+		Target:   opts.Addr,
+		DryRun:   opts.DryRun,
+		maxDiffs: opts.MaxDiffs,
+		seen:     make(map[sessionKey]bool),
+		byKey:    make(map[string]*Statement),
+	}
+	switch source {
+	case capture.SourceMySQL:
+		r.Source = "mysql"
+	case capture.SourceRedis:
+		r.Source = "redis"
+	}
+	return r
+}
+
+// session 记录回放的会话
+func (r *Report) session(key sessionKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.seen[key] {
+		r.seen[key] = true
+		r.Sessions++
+	}
+}
+
+// skip 记录一条不回放的记录
+func (r *Report) skip() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Records++
+	r.Skipped++
+}
+
+// plan 记录一条 dry-run 时将要回放的记录
+func (r *Report) plan() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Records++
+	r.Replayed++
+}
+
+// add 记录一条回放结果，与录制时对比
+func (r *Report) add(t target, rec *capture.Record, offset time.Duration, res result) {
+	key := t.key(rec)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Records++
+	r.Replayed++
+	r.recorded = append(r.recorded, rec.Duration)
+	r.replayed = append(r.replayed, res.duration)
+
+	st, ok := r.byKey[key]
+	if !ok {
+		st = &Statement{Key: key}
+		r.byKey[key] = st
+	}
+	st.Count++
+	st.recordedTotal += rec.Duration
+	st.replayedTotal += res.duration
+	if res.err != "" {
+		st.Errors++
+	}
+
+	diffType, recorded, replayed := compare(rec, res)
+	switch diffType {
+	case "":
+		return
+	case DiffNewError:
+		r.NewErrors++
+	case DiffFixedError:
+		r.FixedErrors++
+	case DiffChangedError:
+		r.ChangedErrors++
+	case DiffMismatch:
+		r.Mismatches++
+	}
+	if len(r.Diffs) < r.maxDiffs {
+		r.Diffs = append(r.Diffs, Diff{
+			Type:      diffType,
+			Session:   rec.Session,
+			Offset:    offset,
+			Statement: t.describe(rec),
+			Recorded:  recorded,
+			Replayed:  replayed,
+		})
+	}
+}
+
+// compare 对比录制和回放的错误与结果，没有差异时返回空的类型
+func compare(rec *capture.Record, res result) (diffType, recorded, replayed string) {
+	switch {
+	case rec.Error == "" && res.err != "":
+		return DiffNewError, "ok", res.err
+	case rec.Error != "" && res.err == "":
+		return DiffFixedError, rec.Error, "ok"
+	case rec.Error != res.err:
+		return DiffChangedError, rec.Error, res.err
+	case rec.Error != "":
+		return "", "", ""
+	case rec.Rows != res.rows || rec.Digest != res.digest:
+		return DiffMismatch,
+			fmt.Sprintf("rows=%d digest=%016x", rec.Rows, rec.Digest),
+			fmt.Sprintf("rows=%d digest=%016x", res.rows, res.digest)
+	}
+	return "", "", ""
+}
+
+// finish 汇总耗时分布和各分组的耗时变化
+func (r *Report) finish(elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Elapsed = elapsed
+	r.Recorded = latency(r.recorded)
+	r.Replay = latency(r.replayed)
+
+	r.Statements = r.Statements[:0]
+	for _, st := range r.byKey {
+		st.Recorded = st.recordedTotal / time.Duration(st.Count)
+		st.Replayed = st.replayedTotal / time.Duration(st.Count)
+		st.Delta = st.replayedTotal - st.recordedTotal
+		r.Statements = append(r.Statements, *st)
+	}
+	sort.Slice(r.Statements, func(i, j int) bool {
+		return r.Statements[i].Delta > r.Statements[j].Delta
+	})
+}
+
+// latency 计算耗时分布
+func latency(durations []time.Duration) Latency {
+	var l Latency
+	if len(durations) == 0 {
+		return l
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, d := range sorted {
+		l.Total += d
+	}
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	l.Avg = l.Total / time.Duration(len(sorted))
+	l.P50 = percentile(0.50)
+	l.P95 = percentile(0.95)
+	l.P99 = percentile(0.99)
+	l.Max = sorted[len(sorted)-1]
+	return l
+}
+
+// Print 输出文本格式的报告
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Source:    %s capture\n", r.Source)
+	if r.DryRun {
+		fmt.Fprintf(w, "Records:   %d read, %d to replay, %d skipped, %d sessions (dry run)\n",
+			r.Records, r.Replayed, r.Skipped, r.Sessions)
+		return
+	}
+	fmt.Fprintf(w, "Target:    %s\n", r.Target)
+	fmt.Fprintf(w, "Records:   %d read, %d replayed, %d skipped, %d sessions in %s\n",
+		r.Records, r.Replayed, r.Skipped, r.Sessions, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Errors:    %d new, %d fixed, %d changed\n", r.NewErrors, r.FixedErrors, r.ChangedErrors)
+	fmt.Fprintf(w, "Results:   %d mismatched\n", r.Mismatches)
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "latency\tavg\tp50\tp95\tp99\tmax\t")
+	for _, row := range []struct {
+		name string
+		l    Latency
+	}{{"recorded", r.Recorded}, {"replay", r.Replay}} {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t\n", row.name,
+			round(row.l.Avg), round(row.l.P50), round(row.l.P95), round(row.l.P99), round(row.l.Max))
+	}
+	tw.Flush()
+
+	if len(r.Statements) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Largest latency increases:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  count\trecorded\treplay\ttotal delta\terrors\tstatement")
+		for i, st := range r.Statements {
+			if i == topStatements {
+				break
+			}
+			fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\t%d\t%s\n",
+				st.Count, round(st.Recorded), round(st.Replayed), round(st.Delta), st.Errors, truncate(st.Key, 120))
+		}
+		tw.Flush()
+	}
+
+	if len(r.Diffs) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Differences (first %d):\n", len(r.Diffs))
+		for _, d := range r.Diffs {
+			fmt.Fprintf(w, "  [%s] session=%d at %s: %s\n", d.Type, d.Session, round(d.Offset), truncate(d.Statement, 200))
+			fmt.Fprintf(w, "      recorded: %s\n", d.Recorded)
+			fmt.Fprintf(w, "      replayed: %s\n", d.Replayed)
+		}
+	}
+}
+
+// round 按量级保留精度，便于阅读
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second || d <= -time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond || d <= -time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
@@ -0,0 +1,370 @@
+// Package rotate 按大小和时间轮转的追加写文件，轮转后的文件可以 gzip 压缩并按数量保留
+package rotate
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeFormat 轮转后文件名中的时间，按字典序即按时间排序
+const timeFormat = "20060102T150405.000"
+
+// flushInterval 缓冲数据写入文件的最长间隔
+const flushInterval = time.Second
+
+// Config 文件轮转配置
+type Config struct {
+	Path           string        `yaml:"path"`            // 当前写入的文件，轮转后的文件名在扩展名前加上时间，如 mysql-20260102T150405.000.pxcap
+	MaxSizeMB      int64         `yaml:"max_size_mb"`     // 文件超过该大小（MB）后轮转，0表示不按大小轮转
+	RotateInterval time.Duration `yaml:"rotate_interval"` // 文件打开超过该时长后轮转，0表示不按时间轮转
+	Compress       bool          `yaml:"compress"`        // gzip 压缩轮转后的文件
+	MaxFiles       int           `yaml:"max_files"`       // 保留的轮转文件数，0表示全部保留
+	Sync           bool          `yaml:"sync"`            // 每次 Write 后立即写入文件并 fsync，不经过缓冲
+}
+
+// Writer 轮转文件，Write 线程安全，一次 Write 的内容不会被拆分到两个文件，也不会被拆成两次写入
+type Writer struct {
+	config Config
+	header []byte // 每个新文件开头写入的内容
+
+	mu     sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	size   int64
+	opened time.Time
+	closed bool
+
+	lastArchive time.Time // 最近一次轮转文件名中的时间
+
+	compressing sync.WaitGroup // 进行中的压缩
+	done        chan struct{}
+}
+
+// New 打开文件，已有内容的文件先轮转，header 写在每个新文件的开头
+func New(config Config, header []byte) (*Writer, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("rotate: path is empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0o755); err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		config: config,
+		header: header,
+		done:   make(chan struct{}),
+	}
+	if info, err := os.Stat(config.Path); err == nil && info.Size() > 0 {
+		if err := w.archive(); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	go w.flushLoop()
+	return w, nil
+}
+
+// Write 写入一条记录，超过大小或时长时先轮转
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			log.Printf("[Rotate] Failed to rotate %s: %v", w.config.Path, err)
+			if w.file == nil {
+				return 0, err
+			}
+		}
+	}
+	// 缓冲区放不下时先写入已缓冲的记录，一条记录总是一次写入文件，不会在缓冲区写满时被拆开，
+	// 进程崩溃时文件末尾最多缺少整条记录
+	if w.buf.Buffered() > 0 && len(p) > w.buf.Available() {
+		if err := w.buf.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.buf.Write(p)
+	w.size += int64(n)
+	if err == nil && w.config.Sync {
+		err = w.sync()
+	}
+	return n, err
+}
+
+// sync 写入缓冲的数据并 fsync，调用时持有锁
+func (w *Writer) sync() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// shouldRotate 判断写入 n 字节前是否需要轮转，只有文件头的文件不轮转
+func (w *Writer) shouldRotate(n int64) bool {
+	if w.size <= int64(len(w.header)) {
+		return false
+	}
+	if w.config.MaxSizeMB > 0 && w.size+n > w.config.MaxSizeMB<<20 {
+		return true
+	}
+	return w.config.RotateInterval > 0 && time.Since(w.opened) >= w.config.RotateInterval
+}
+
+// Flush 将缓冲的数据写入文件
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.file == nil {
+		return nil
+	}
+	return w.buf.Flush()
+}
+
+// Rotate 立即轮转
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	return w.rotate()
+}
+
+// rotate 关闭当前文件、改名并打开新文件，调用时持有锁；改名失败时继续追加写原文件
+func (w *Writer) rotate() error {
+	if err := w.closeFile(); err != nil {
+		log.Printf("[Rotate] Failed to close %s: %v", w.config.Path, err)
+	}
+	archiveErr := w.archive()
+	if err := w.open(); err != nil {
+		return err
+	}
+	return archiveErr
+}
+
+// open 打开文件，新文件写入文件头
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.buf = bufio.NewWriterSize(file, 64<<10)
+	w.size = info.Size()
+	w.opened = time.Now()
+	if w.size == 0 && len(w.header) > 0 {
+		n, err := w.buf.Write(w.header)
+		w.size += int64(n)
+		return err
+	}
+	return nil
+}
+
+// closeFile 写入缓冲的数据并关闭当前文件，配置了 Sync 时先 fsync
+func (w *Writer) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+	var err error
+	if w.config.Sync {
+		err = w.sync()
+	} else {
+		err = w.buf.Flush()
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	return err
+}
+
+// archive 将当前文件改名为带时间的文件，并在后台压缩、清理旧文件
+func (w *Writer) archive() error {
+	name := w.archiveName(time.Now())
+	if err := os.Rename(w.config.Path, name); err != nil {
+		return err
+	}
+	w.compressing.Add(1)
+	go func() {
+		defer w.compressing.Done()
+		if w.config.Compress {
+			if err := compressFile(name); err != nil {
+				log.Printf("[Rotate] Failed to compress %s: %v", name, err)
+			}
+		}
+		w.prune()
+	}()
+	return nil
+}
+
+// archiveName 返回轮转后的文件名，调用时持有锁
+// 同一毫秒内多次轮转时文件名中的时间依次加1毫秒，文件名的顺序总是轮转的顺序，清理时不会删掉较新的文件；
+// 已有同名文件（如重启前轮转的文件）时加上序号
+func (w *Writer) archiveName(t time.Time) string {
+	t = t.Truncate(time.Millisecond)
+	if !t.After(w.lastArchive) {
+		t = w.lastArchive.Add(time.Millisecond)
+	}
+	w.lastArchive = t
+
+	ext := filepath.Ext(w.config.Path)
+	base := strings.TrimSuffix(w.config.Path, ext) + "-" + t.Format(timeFormat)
+	name := base + ext
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	return name
+}
+
+// Archives 返回轮转后的文件，按时间从旧到新排序（压缩中的文件只返回一次）
+func (w *Writer) Archives() []string {
+	return Archives(w.config.Path)
+}
+
+// Archives 返回 path 轮转后的文件，按时间从旧到新排序，时间相同时按序号排序（没有序号的文件最旧）
+func Archives(path string) []string {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext) + "-"
+	matches, _ := filepath.Glob(prefix + "*" + ext + "*")
+	seen := make(map[string]bool, len(matches))
+	var files []string
+	for _, m := range matches {
+		if strings.HasSuffix(m, ".tmp") || (!strings.HasSuffix(m, ext) && !strings.HasSuffix(m, ext+".gz")) {
+			continue
+		}
+		key := strings.TrimSuffix(m, ".gz")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		files = append(files, m)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		ti, ni := archiveOrder(files[i], prefix, ext)
+		tj, nj := archiveOrder(files[j], prefix, ext)
+		if ti != tj {
+			return ti < tj
+		}
+		return ni < nj
+	})
+	return files
+}
+
+// archiveOrder 从轮转后的文件名中取出时间和同名时加上的序号，没有序号时为 0
+func archiveOrder(name, prefix, ext string) (string, int) {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSuffix(name, ".gz"), prefix), ext)
+	if len(stamp) > len(timeFormat) && stamp[len(timeFormat)] == '.' {
+		if n, err := strconv.Atoi(stamp[len(timeFormat)+1:]); err == nil {
+			return stamp[:len(timeFormat)], n
+		}
+	}
+	return stamp, 0
+}
+
+// prune 删除超出保留数量的旧文件
+func (w *Writer) prune() {
+	if w.config.MaxFiles <= 0 {
+		return
+	}
+	files := w.Archives()
+	for len(files) > w.config.MaxFiles {
+		name := strings.TrimSuffix(files[0], ".gz")
+		os.Remove(name)
+		os.Remove(name + ".gz")
+		files = files[1:]
+	}
+}
+
+// flushLoop 定期将缓冲的数据写入文件
+func (w *Writer) flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Flush(); err != nil {
+				log.Printf("[Rotate] Failed to flush %s: %v", w.config.Path, err)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Close 写入缓冲的数据并关闭文件，等待进行中的压缩完成
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	err := w.closeFile()
+	w.mu.Unlock()
+
+	w.compressing.Wait()
+	return err
+}
+
+// compressFile 将文件压缩为 name.gz 并删除原文件
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(name)
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,265 @@
+package rotate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readFile 读取文件内容，.gz 文件解压后返回
+func readFile(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		return data
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return data
+}
+
+func TestWriter(t *testing.T) {
+	header := []byte("PXCAP\n")
+	record := bytes.Repeat([]byte("r"), 600<<10) // 1MB 的文件只放得下一条
+
+	tests := []struct {
+		name           string
+		config         Config
+		existing       string // 启动前文件中已有的内容
+		writes         int
+		rotates        int // 写入之后调用 Rotate 的次数
+		wantArchives   int
+		wantCompressed bool
+		wantRecords    int // 所有文件中的记录数
+	}{
+		{name: "no rotation", writes: 3, wantRecords: 3},
+		{name: "by size", config: Config{MaxSizeMB: 1}, writes: 4, wantArchives: 3, wantRecords: 4},
+		{name: "existing file", existing: "old", writes: 1, wantArchives: 1, wantRecords: 1},
+		{name: "explicit rotate", writes: 2, rotates: 1, wantArchives: 1, wantRecords: 2},
+		{name: "rotate header only file", rotates: 2, wantArchives: 2},
+		{name: "compress", config: Config{MaxSizeMB: 1, Compress: true}, writes: 3, wantArchives: 2, wantCompressed: true, wantRecords: 3},
+		{name: "max files", config: Config{MaxSizeMB: 1, MaxFiles: 2}, writes: 5, wantArchives: 2, wantRecords: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sub", "mysql.pxcap")
+			if tt.existing != "" {
+				os.MkdirAll(filepath.Dir(path), 0o755)
+				if err := os.WriteFile(path, []byte(tt.existing), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			config := tt.config
+			config.Path = path
+			w, err := New(config, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.writes; i++ {
+				if n, err := w.Write(record); err != nil || n != len(record) {
+					t.Fatalf("write %d: %d, %v", i, n, err)
+				}
+			}
+			for i := 0; i < tt.rotates; i++ {
+				if err := w.Rotate(); err != nil {
+					t.Fatalf("rotate %d: %v", i, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(record); err != os.ErrClosed {
+				t.Errorf("write after close = %v, want %v", err, os.ErrClosed)
+			}
+
+			archives := Archives(path)
+			if len(archives) != tt.wantArchives {
+				t.Fatalf("archives = %v, want %d", archives, tt.wantArchives)
+			}
+			records := 0
+			for _, name := range append(archives, path) {
+				if compressed := strings.HasSuffix(name, ".gz"); name != path && compressed != tt.wantCompressed {
+					t.Errorf("%s: compressed = %v, want %v", name, compressed, tt.wantCompressed)
+				}
+				data := readFile(t, name)
+				if string(data) == tt.existing {
+					continue
+				}
+				body, ok := bytes.CutPrefix(data, header)
+				if !ok {
+					t.Errorf("%s does not start with the header", name)
+				}
+				if len(body)%len(record) != 0 || bytes.Count(body, []byte("r")) != len(body) {
+					t.Errorf("%s: %d bytes after the header, want whole records", name, len(body))
+				}
+				records += len(body) / len(record)
+			}
+			if records != tt.wantRecords {
+				t.Errorf("%d records in all files, want %d", records, tt.wantRecords)
+			}
+			if tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp")); len(tmp) > 0 {
+				t.Errorf("temporary files left: %v", tmp)
+			}
+		})
+	}
+}
+
+func TestArchives(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"mysql-20260102T150405.000.pxcap.gz",
+		"mysql-20260101T000000.000.pxcap",
+		"mysql-20260103T000000.000.pxcap",
+		"mysql-20260103T000000.000.pxcap.gz", // 压缩中的文件
+		"mysql-20260102T150405.000.2.pxcap",  // 同名时加上序号，比没有序号的文件新
+		"mysql-20260102T150405.000.10.pxcap.gz",
+		"mysql-20260102T150405.000.1.pxcap",
+		"mysql-20260104T000000.000.pxcap.gz.tmp",
+		"mysql-20260105T000000.000.log",
+		"mysql.pxcap",
+		"redis-20260101T000000.000.pxcap",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"mysql-20260101T000000.000.pxcap",
+		"mysql-20260102T150405.000.pxcap.gz",
+		"mysql-20260102T150405.000.1.pxcap",
+		"mysql-20260102T150405.000.2.pxcap",
+		"mysql-20260102T150405.000.10.pxcap.gz",
+		"mysql-20260103T000000.000.pxcap",
+	}
+	var got []string
+	for _, name := range Archives(filepath.Join(dir, "mysql.pxcap")) {
+		got = append(got, filepath.Base(name))
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Archives() = %v, want %v", got, want)
+	}
+}
+
+func TestWriterSync(t *testing.T) {
+	tests := []struct {
+		sync bool
+		want string // Write 返回后文件中的内容
+	}{
+		{sync: true, want: "HDR\nrecord\n"},
+		{sync: false, want: ""},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		w, err := New(Config{Path: path, Sync: tt.sync}, []byte("HDR\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("record\n")); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(readFile(t, path)); got != tt.want {
+			t.Errorf("sync=%v: file contains %q after Write, want %q", tt.sync, got, tt.want)
+		}
+		w.Close()
+	}
+}
+
+func TestWriteWholeRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	w, err := New(Config{Path: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// 缓冲区放不下的记录不会被拆开，文件中总是整条记录
+	record := append(bytes.Repeat([]byte("x"), 9999), '\n')
+	for i := 0; i < 20; i++ {
+		if _, err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size()%int64(len(record)) != 0 {
+			t.Fatalf("after %d records the file holds %d bytes, a partial record", i+1, info.Size())
+		}
+	}
+}
+
+func TestPruneKeepsNewest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mysql.pxcap")
+	w, err := New(Config{Path: path, MaxFiles: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 连续轮转通常落在同一毫秒内，文件名靠序号区分
+	for _, record := range []string{"a", "b", "c", "d", "e"} {
+		w.Write([]byte(record))
+		if err := w.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, name := range Archives(path) {
+		kept = append(kept, string(readFile(t, name)))
+	}
+	if strings.Join(kept, ",") != "d,e" {
+		t.Errorf("kept archives %v, want [d e]", kept)
+	}
+}
+
+func TestPruneNameCollision(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mysql.pxcap")
+	w, err := New(Config{Path: path, MaxFiles: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 重启前轮转的文件与下一次轮转的时间相同，新文件加上序号
+	now := time.Now().Truncate(time.Millisecond)
+	w.lastArchive = now.Add(-time.Millisecond)
+	old := filepath.Join(dir, "mysql-"+now.Format(timeFormat)+".pxcap")
+	if err := os.WriteFile(old, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	name := w.archiveName(now)
+	if want := filepath.Join(dir, "mysql-"+now.Format(timeFormat)+".1.pxcap"); name != want {
+		t.Fatalf("archiveName() = %s, want %s", name, want)
+	}
+	if err := os.WriteFile(name, []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("current"))
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, name := range Archives(path) {
+		kept = append(kept, string(readFile(t, name)))
+	}
+	if strings.Join(kept, ",") != "new,current" {
+		t.Errorf("kept archives %v, want [new current]", kept)
+	}
+}
@@ -0,0 +1,25 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AuthorizeChange 校验修改状态的 API 请求（清空统计、切换故障注入等）携带的 Authorization: Bearer <token>，
+// 失败时写出错误并返回 false。api 为错误信息中的 API 名称
+// 带自定义请求头的跨域请求需要预检，修改请求的响应不带 CORS 头，其他网页无法借助浏览器发出；
+// 未配置 token 时拒绝所有修改
+func AuthorizeChange(w http.ResponseWriter, r *http.Request, token, api string) bool {
+	if token == "" {
+		http.Error(w, `{"error":"`+api+` api is read-only, configure api_token to enable changes"}`, http.StatusForbidden)
+		return false
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -36,6 +36,19 @@ type Server struct {
 	mux       *http.ServeMux
 }
 
+// apiHandlers 代理模块注册的API（如SQL指纹统计），请求时查找，因此可在服务启动前后注册
+var (
+	apiMu       sync.RWMutex
+	apiHandlers = make(map[string]http.Handler)
+)
+
+// HandleAPI 注册一个API，path 需以 /api/ 开头
+func HandleAPI(path string, handler http.Handler) {
+	apiMu.Lock()
+	apiHandlers[path] = handler
+	apiMu.Unlock()
+}
+
 // Message 推送给前端的消息
 type Message struct {
 	Type string          `json:"type"` // "mysql" or "redis"
@@ -88,6 +101,7 @@ func (s *Server) Start() error {
 	// 设置 API 路由
 	s.mux.HandleFunc("/ws", s.handleWebSocket)
 	s.mux.HandleFunc("/api/history", s.handleHistory)
+	s.mux.HandleFunc("/api/", s.handleRegisteredAPI)
 
 	// 设置静态文件服务（使用嵌入的文件）
 	distFS, err := fs.Sub(frontend.DistFS, "dist")
@@ -219,6 +233,19 @@ func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(history)
 }
 
+// handleRegisteredAPI 分发到通过 HandleAPI 注册的API
+func (s *Server) handleRegisteredAPI(w http.ResponseWriter, r *http.Request) {
+	apiMu.RLock()
+	handler, ok := apiHandlers[r.URL.Path]
+	apiMu.RUnlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
 // Close 关闭服务器
 func (s *Server) Close() error {
 	s.cancel()